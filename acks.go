@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ErrForgedAck means an ack came from a peer other than the message's recipient
+var ErrForgedAck = errors.New("ack not sent by the recipient")
+
+// Ack receipts: a sender sets "requestAck": true (plus "messageId" and "from") on an
+// outgoing {to, payload} message; once the recipient's tunnel answers 2xx, the recipient
+// publishes {to: from, ack: messageId, status, from: <recipient DID>} back on the topic.
+
+// AckReceipt is the acknowledgement of a delivered message
+type AckReceipt struct {
+	MessageID string        `json:"messageId"`
+	From      string        `json:"from"`
+	Status    int           `json:"status"`
+	RTT       time.Duration `json:"-"`
+}
+
+// ackWaiters routes incoming acks to the SendAndWaitAck calls waiting for them
+type ackWaiters struct {
+	mu      sync.Mutex
+	waiters map[string]ackWaiter
+}
+
+// ackWaiter is one SendAndWaitAck call: the recipient it sent to and where its ack goes
+type ackWaiter struct {
+	to string
+	ch chan AckReceipt
+}
+
+func newAckWaiters() *ackWaiters {
+	return &ackWaiters{waiters: make(map[string]ackWaiter)}
+}
+
+func (a *ackWaiters) register(msgID, to string) chan AckReceipt {
+	ch := make(chan AckReceipt, 1)
+	a.mu.Lock()
+	a.waiters[msgID] = ackWaiter{to: to, ch: ch}
+	a.mu.Unlock()
+	return ch
+}
+
+func (a *ackWaiters) remove(msgID string) {
+	a.mu.Lock()
+	delete(a.waiters, msgID)
+	a.mu.Unlock()
+}
+
+// deliver hands an ack published by sender to its waiter. Acks nobody waits for (late or
+// duplicate) are dropped; an ack from a peer other than the recipient the message was sent to
+// is refused with ErrForgedAck and the waiter keeps waiting.
+func (a *ackWaiters) deliver(ack AckReceipt, sender peer.ID) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	w, ok := a.waiters[ack.MessageID]
+	if !ok {
+		return nil
+	}
+	if !ackSenderMatches(w.to, ack.From, sender) {
+		return fmt.Errorf("%w: %s from %s (sent to %s)", ErrForgedAck, ack.MessageID, sender, w.to)
+	}
+	delete(a.waiters, ack.MessageID)
+	w.ch <- ack
+	return nil
+}
+
+// ackSenderMatches reports whether sender may ack a message sent to to: its key must be the one
+// in the recipient DID. Messages to the "gateway" alias can be acked by any gateway, so there
+// only the claimed from DID has to be the sender's own.
+func ackSenderMatches(to, from string, sender peer.ID) bool {
+	if to == GatewayAlias {
+		return didMatchesPeer(from, sender)
+	}
+	return didMatchesPeer(to, sender)
+}
+
+// SendAndWaitAck publishes payload to the recipient DID with an ack request and waits
+// until the recipient's tunnel accepted it, or ctx is done. A non-empty contentType is
+// declared in the envelope for the recipient's tunnel.
+func (s *Libp2pNodeService) SendAndWaitAck(ctx context.Context, to string, payload interface{}, contentType string) (AckReceipt, error) {
+	msgID := newMessageID()
+	ch := s.acks.register(msgID, to)
+	defer s.acks.remove(msgID)
+
+	msg := map[string]interface{}{
+		"to":         to,
+		"payload":    payload,
+		"messageId":  msgID,
+		"from":       s.did,
+		"requestAck": true,
+	}
+	if contentType != "" {
+		msg["contentType"] = contentType
+	}
+	start := time.Now()
+	_, err := s.HandleOutgoingMessage(ctx, msg)
+	if err != nil {
+		return AckReceipt{}, err
+	}
+
+	select {
+	case ack := <-ch:
+		ack.RTT = time.Since(start)
+		return ack, nil
+	case <-ctx.Done():
+		return AckReceipt{}, ctx.Err()
+	}
+}
+
+// handleAck consumes an incoming ack message published by sender; it returns false if msg is
+// not an ack. Acks that don't come from the recipient are dropped and count as a violation.
+func (s *Libp2pNodeService) handleAck(msg map[string]interface{}, sender peer.ID) bool {
+	ackID, ok := msg["ack"].(string)
+	if !ok {
+		return false
+	}
+	from, _ := msg["from"].(string)
+	status, _ := msg["status"].(float64)
+	if err := s.acks.deliver(AckReceipt{MessageID: ackID, From: from, Status: int(status)}, sender); err != nil {
+		log.Printf("[Ack] Dropped: %v", err)
+		s.ReportViolation(sender, violationForgedAck)
+	}
+	return true
+}
+
+// sendAck acknowledges a forwarded message if its sender asked for it and the tunnel answered 2xx
+func (s *Libp2pNodeService) sendAck(msg map[string]interface{}, resp *TunnelResponse) {
+	if want, _ := msg["requestAck"].(bool); !want || resp == nil {
+		return
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return
+	}
+	msgID, _ := msg["messageId"].(string)
+	from, _ := msg["from"].(string)
+	if msgID == "" || from == "" {
+		return
+	}
+
+	data, _ := json.Marshal(map[string]interface{}{
+		"to":     from,
+		"ack":    msgID,
+		"status": resp.StatusCode,
+		"from":   s.did,
+	})
+	if err := s.getTopic().Publish(context.Background(), data); err != nil {
+		log.Printf("Failed to send ack for %s: %v", msgID, err)
+	}
+}
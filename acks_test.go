@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestSendAndWaitAckRoundTrip(t *testing.T) {
+	backendA, backendB := newTestBackend(t), newTestBackend(t)
+	a := newTestService(t, testConfig(t, backendA.URL))
+	b := newTestService(t, testConfig(t, backendB.URL))
+	connectServices(t, a, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ack, err := a.SendAndWaitAck(ctx, b.did, map[string]interface{}{"hello": "ack"}, "")
+	if err != nil {
+		t.Fatalf("SendAndWaitAck: %v", err)
+	}
+	if ack.From != b.did || ack.Status != 200 || ack.MessageID == "" {
+		t.Errorf("ack = %+v, want from %s with status 200", ack, b.did)
+	}
+	if reqs := backendB.waitRequests(t, 1); len(reqs) != 1 {
+		t.Errorf("recipient backend got %d requests, want 1", len(reqs))
+	}
+}
+
+// testPeer returns a fresh peer ID with an embedded ed25519 key and its hoster DID
+func testPeer(t testing.TB) (peer.ID, string) {
+	t.Helper()
+	kp, err := generateKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := PublicKeyToPeerId(kp.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pid, ToSightDID(RoleHoster, kp.PublicKey)
+}
+
+func TestHandleAckRejectsForgedSender(t *testing.T) {
+	recipient, recipientDID := testPeer(t)
+	forger, _ := testPeer(t)
+	s := &Libp2pNodeService{acks: newAckWaiters(), reputation: newReputationStore(DefaultReputationConfig())}
+	ch := s.acks.register("m1", recipientDID)
+
+	// the forger claims to be the recipient
+	ack := map[string]interface{}{"ack": "m1", "from": recipientDID, "status": float64(200)}
+	if !s.handleAck(ack, forger) {
+		t.Fatal("handleAck did not consume the ack")
+	}
+	select {
+	case got := <-ch:
+		t.Fatalf("forged ack delivered: %+v", got)
+	default:
+	}
+	reps := s.reputation.List()
+	if len(reps) != 1 || reps[0].PeerID != forger.String() || reps[0].Violations[violationForgedAck] != 1 {
+		t.Errorf("reputation = %+v, want one forged_ack violation for %s", reps, forger)
+	}
+
+	s.handleAck(ack, recipient)
+	select {
+	case got := <-ch:
+		if got.From != recipientDID || got.Status != 200 {
+			t.Errorf("ack = %+v", got)
+		}
+	default:
+		t.Fatal("ack from the recipient was not delivered")
+	}
+}
+
+func TestAckWaitersDeliver(t *testing.T) {
+	recipient, recipientDID := testPeer(t)
+	gateway, gatewayDID := testPeer(t)
+	other, _ := testPeer(t)
+
+	a := newAckWaiters()
+	if err := a.deliver(AckReceipt{MessageID: "unknown"}, other); err != nil {
+		t.Errorf("late ack: %v, want dropped silently", err)
+	}
+
+	a.register("m1", recipientDID)
+	if err := a.deliver(AckReceipt{MessageID: "m1", From: recipientDID}, other); !errors.Is(err, ErrForgedAck) {
+		t.Errorf("ack from another peer: %v, want ErrForgedAck", err)
+	}
+	if err := a.deliver(AckReceipt{MessageID: "m1", From: recipientDID}, recipient); err != nil {
+		t.Errorf("ack from the recipient: %v", err)
+	}
+
+	// the gateway alias: any gateway may ack, but only as itself
+	a.register("m2", GatewayAlias)
+	if err := a.deliver(AckReceipt{MessageID: "m2", From: gatewayDID}, other); !errors.Is(err, ErrForgedAck) {
+		t.Errorf("alias ack claiming another DID: %v, want ErrForgedAck", err)
+	}
+	if err := a.deliver(AckReceipt{MessageID: "m2", From: ToSightDID(RoleGateway, mustPeerKey(t, gateway))}, gateway); err != nil {
+		t.Errorf("alias ack from a gateway: %v", err)
+	}
+}
+
+func mustPeerKey(t *testing.T, pid peer.ID) []byte {
+	t.Helper()
+	key, err := DecodePublicKeyFromPeerId(pid.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
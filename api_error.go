@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// APIError is the body of every error response: {"error": {"code", "message", "details"}}.
+// Code is stable and meant for programs; message is for humans and may change.
+type APIError struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// writeError writes the JSON error envelope with an HTTP status
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeErrorDetails(w, status, code, message, nil)
+}
+
+// writeErrorDetails is writeError with extra structured details (e.g. the field that failed)
+func writeErrorDetails(w http.ResponseWriter, status int, code, message string, details interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]APIError{
+		"error": {Code: code, Message: message, Details: details},
+	})
+}
+
+// writeServiceError writes a service error with the status and code from errorStatus;
+// prefix says what failed, e.g. "Send failed"
+func writeServiceError(ctx context.Context, w http.ResponseWriter, prefix string, err error) {
+	status, code := errorStatus(ctx, err)
+	writeError(w, status, code, prefix+": "+err.Error())
+}
+
+// errorStatus maps a service error to an HTTP status and a stable code, so clients can tell
+// a bad request (4xx, don't retry) from an unreachable peer (502/504) or a node that isn't
+// ready yet (503)
+func errorStatus(ctx context.Context, err error) (int, string) {
+	switch {
+	case errors.Is(err, ErrInvalidTarget):
+		return 400, "invalid_target"
+	case errors.Is(err, ErrInvalidEmbeddedKey):
+		return 400, "invalid_key"
+	case errors.Is(err, ErrInvalidTopic):
+		return 400, "invalid_topic"
+	case errors.Is(err, ErrInvalidTag):
+		return 400, "invalid_tag"
+	case errors.Is(err, ErrObserverMode):
+		return 403, "observer_mode"
+	case errors.Is(err, ErrInvalidContentType):
+		return 400, "invalid_content_type"
+	case errors.Is(err, ErrMissingPayload):
+		return 400, "missing_payload"
+	case errors.Is(err, ErrPayloadTooLarge):
+		return 413, "payload_too_large"
+	case errors.Is(err, ErrProtocolUnsupported):
+		return 422, "protocol_unsupported"
+	case errors.Is(err, ErrNoRetrievableKey):
+		return 422, "no_public_key"
+	case errors.Is(err, ErrNotSticky):
+		return 404, "not_sticky"
+	case errors.Is(err, ErrNotBlocked):
+		return 404, "not_blocked"
+	case errors.Is(err, ErrDHTNotReady):
+		return 503, "dht_not_ready"
+	case errors.Is(err, ErrDraining):
+		return 503, "draining"
+	case errors.Is(err, ErrDialTimeout):
+		return 504, "dial_timeout"
+	case errors.Is(err, ErrSendTimeout):
+		return 504, "send_timeout"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return 504, "timeout"
+	case errors.Is(err, ErrPeerNotFound):
+		return 502, "peer_not_found"
+	case errors.Is(err, ErrPeerConnectFailed):
+		return 502, "peer_unreachable"
+	case errors.Is(err, ErrStreamFailed):
+		return 502, "stream_failed"
+	default:
+		return 500, "internal"
+	}
+}
+
+// notFoundHandler and methodNotAllowedHandler answer unknown routes with the error envelope
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, 404, "not_found", "No route for "+r.Method+" "+r.URL.Path)
+}
+
+func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, 405, "method_not_allowed", "Method "+r.Method+" not allowed for "+r.URL.Path)
+}
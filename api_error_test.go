@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrorStatus(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{ErrInvalidTarget, 400, "invalid_target"},
+		{ErrInvalidEmbeddedKey, 400, "invalid_key"},
+		{ErrInvalidTopic, 400, "invalid_topic"},
+		{ErrInvalidTag, 400, "invalid_tag"},
+		{ErrObserverMode, 403, "observer_mode"},
+		{ErrInvalidContentType, 400, "invalid_content_type"},
+		{ErrMissingPayload, 400, "missing_payload"},
+		{ErrPayloadTooLarge, 413, "payload_too_large"},
+		{ErrProtocolUnsupported, 422, "protocol_unsupported"},
+		{ErrNoRetrievableKey, 422, "no_public_key"},
+		{ErrNotSticky, 404, "not_sticky"},
+		{ErrNotBlocked, 404, "not_blocked"},
+		{ErrDHTNotReady, 503, "dht_not_ready"},
+		{ErrDraining, 503, "draining"},
+		{ErrDialTimeout, 504, "dial_timeout"},
+		{ErrSendTimeout, 504, "send_timeout"},
+		{context.DeadlineExceeded, 504, "timeout"},
+		{ErrPeerNotFound, 502, "peer_not_found"},
+		{ErrPeerConnectFailed, 502, "peer_unreachable"},
+		{ErrStreamFailed, 502, "stream_failed"},
+		{errors.New("boom"), 500, "internal"},
+	} {
+		t.Run(tc.code, func(t *testing.T) {
+			// services wrap their errors with context, e.g. "%w: %v"
+			wrapped := fmt.Errorf("send to did:sight:hoster:x: %w", tc.err)
+			for _, err := range []error{tc.err, wrapped} {
+				status, code := errorStatus(context.Background(), err)
+				if status != tc.status || code != tc.code {
+					t.Errorf("errorStatus(%v) = %d %s, want %d %s", err, status, code, tc.status, tc.code)
+				}
+			}
+		})
+	}
+}
+
+func TestErrorStatusPrecedence(t *testing.T) {
+	expired, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	<-expired.Done()
+
+	for _, tc := range []struct {
+		name   string
+		ctx    context.Context
+		err    error
+		status int
+		code   string
+	}{
+		// an empty routing table fails the lookup: the node isn't ready, the peer may exist
+		{"DHT not ready over peer not found", context.Background(), fmt.Errorf("%w: %w", ErrPeerNotFound, ErrDHTNotReady), 503, "dht_not_ready"},
+		// the dial phase budget ran out: report it as such, not as a generic timeout
+		{"dial timeout over deadline", context.Background(), fmt.Errorf("%w: %w", ErrDialTimeout, context.DeadlineExceeded), 504, "dial_timeout"},
+		{"expired request ctx", expired, ErrPeerNotFound, 504, "timeout"},
+		{"bad target even if ctx expired", expired, ErrInvalidTarget, 400, "invalid_target"},
+	} {
+		status, code := errorStatus(tc.ctx, tc.err)
+		if status != tc.status || code != tc.code {
+			t.Errorf("%s: errorStatus = %d %s, want %d %s", tc.name, status, code, tc.status, tc.code)
+		}
+	}
+}
+
+func TestWriteServiceError(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeServiceError(context.Background(), w, "Send failed", fmt.Errorf("%w: no route", ErrPeerNotFound))
+	if w.Code != 502 || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("status %d, Content-Type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	var body map[string]APIError
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if e := body["error"]; e.Code != "peer_not_found" || e.Message != "Send failed: peer not found: no route" {
+		t.Errorf("error = %+v", e)
+	}
+}
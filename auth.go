@@ -0,0 +1,26 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// requireAPIToken guards sensitive endpoints with `Authorization: Bearer <API_TOKEN>`.
+// When no token is configured the guarded endpoints are disabled (403) rather than open.
+func (c *Libp2pNodeController) requireAPIToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := c.service.config.APIToken
+		if token == "" {
+			writeError(w, 403, "endpoint_disabled", "API token not configured; endpoint disabled")
+			return
+		}
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, 401, "unauthorized", "Missing or invalid bearer token")
+			return
+		}
+		next(w, r)
+	}
+}
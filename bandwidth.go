@@ -0,0 +1,43 @@
+package main
+
+import "github.com/libp2p/go-libp2p/core/metrics"
+
+// BandwidthStats is cumulative traffic in bytes plus the rolling rate in bytes/sec
+type BandwidthStats struct {
+	TotalIn  int64   `json:"totalIn"`
+	TotalOut int64   `json:"totalOut"`
+	RateIn   float64 `json:"rateIn"`
+	RateOut  float64 `json:"rateOut"`
+}
+
+// BandwidthReport breaks traffic down by protocol (direct messages, gossipsub, DHT, ...) and by peer
+type BandwidthReport struct {
+	Total      BandwidthStats            `json:"total"`
+	ByProtocol map[string]BandwidthStats `json:"byProtocol"`
+	ByPeer     map[string]BandwidthStats `json:"byPeer"`
+}
+
+func toBandwidthStats(st metrics.Stats) BandwidthStats {
+	return BandwidthStats{
+		TotalIn:  st.TotalIn,
+		TotalOut: st.TotalOut,
+		RateIn:   st.RateIn,
+		RateOut:  st.RateOut,
+	}
+}
+
+// GetBandwidth returns the bandwidth counters collected since startup
+func (s *Libp2pNodeService) GetBandwidth() BandwidthReport {
+	report := BandwidthReport{
+		Total:      toBandwidthStats(s.bandwidth.GetBandwidthTotals()),
+		ByProtocol: make(map[string]BandwidthStats),
+		ByPeer:     make(map[string]BandwidthStats),
+	}
+	for proto, st := range s.bandwidth.GetBandwidthByProtocol() {
+		report.ByProtocol[string(proto)] = toBandwidthStats(st)
+	}
+	for pid, st := range s.bandwidth.GetBandwidthByPeer() {
+		report.ByPeer[pid.String()] = toBandwidthStats(st)
+	}
+	return report
+}
@@ -3,11 +3,16 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"math/rand"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -20,6 +25,8 @@ import (
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multiaddr"
+
+	"sight-libp2p-node/p2pnode"
 )
 
 var seeds = [][]byte{
@@ -35,52 +42,212 @@ var seeds = [][]byte{
 }
 var ports = []int{15001, 15002, 15003, 15004, 15005, 15006, 15007, 15008, 15009}
 
-func randomNeighbors(n int, exclude int) []int {
+// Topology flags (env vars are used as defaults so the harness can be driven from CI scripts)
+var (
+	topology     = flag.String("topology", envString("BOOTSTRAP_TOPOLOGY", "random"), "Mesh topology: ring, star, mesh or random")
+	seed         = flag.Int64("seed", int64(envInt("BOOTSTRAP_SEED", 0)), "Seed for the random topology (0 = time-based, non-reproducible)")
+	minNeighbors = flag.Int("min-neighbors", envInt("BOOTSTRAP_MIN_NEIGHBORS", 4), "Minimum random neighbors per node")
+	maxNeighbors = flag.Int("max-neighbors", envInt("BOOTSTRAP_MAX_NEIGHBORS", 5), "Maximum random neighbors per node")
+	topicName    = flag.String("topic", envString("BOOTSTRAP_TOPIC", envString("TOPIC", p2pnode.DefaultTopic)), "Pubsub topics the nodes join, comma-separated (one mesh namespace each); the first is used for the broadcast and --check")
+	pskFile      = flag.String("psk-file", envString("BOOTSTRAP_PSK_FILE", ""), "Private network key (swarm.key); only nodes with the same key can connect")
+	maxMsgSize   = flag.Int("max-message-size", envInt("BOOTSTRAP_MAX_MESSAGE_SIZE", pubsub.DefaultMaxMessageSize), "Largest pubsub message relayed; must match the nodes' gossipSub.maxMessageSize")
+	fullMesh     = flag.Bool("full-mesh", os.Getenv("BOOTSTRAP_FULL_MESH") == "1", "Connect every node to every other node (same as --topology mesh)")
+
+	// Propagation check (smoke test) mode
+	check         = flag.Bool("check", false, "Publish tagged messages, verify every node receives them, then exit (non-zero on failure)")
+	checkMessages = flag.Int("check-messages", 1, "Number of messages to publish in --check mode")
+	checkTimeout  = flag.Duration("check-timeout", 10*time.Second, "How long to wait for delivery in --check mode")
+)
+
+func envString(key, defaultVal string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return defaultVal
+}
+
+func envInt(key string, defaultVal int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultVal
+	}
+	return v
+}
+
+// randomNeighbors picks 4-5 (--min/--max-neighbors) random neighbors for a node using rng.
+// rng is not safe for concurrent use, so callers must draw from it sequentially.
+func randomNeighbors(n int, exclude int, rng *rand.Rand) []int {
 	indices := make([]int, 0, len(ports)-1)
 	for i := range ports {
 		if i != exclude {
 			indices = append(indices, i)
 		}
 	}
-	rand.Shuffle(len(indices), func(i, j int) {
+	rng.Shuffle(len(indices), func(i, j int) {
 		indices[i], indices[j] = indices[j], indices[i]
 	})
-	count := 4 + rand.Intn(2) // 4 or 5 neighbors
+	count := *minNeighbors
+	if *maxNeighbors > *minNeighbors {
+		count += rng.Intn(*maxNeighbors - *minNeighbors + 1)
+	}
 	if count > len(indices) {
 		count = len(indices)
 	}
 	return indices[:count]
 }
 
+// newRand returns the topology rng: seeded with seed, or with the current time when seed is 0
+func newRand(seed int64) *rand.Rand {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return rand.New(rand.NewSource(seed))
+}
+
+// neighborsFor returns the nodes that node i dials for the given topology.
+// Connections are bidirectional, so each edge is only dialed from one side.
+func neighborsFor(topology string, i int, rng *rand.Rand) []int {
+	n := len(ports)
+	var out []int
+	switch topology {
+	case "ring":
+		if n > 1 {
+			out = append(out, (i+1)%n)
+		}
+	case "star":
+		if i == 0 {
+			for j := 1; j < n; j++ {
+				out = append(out, j)
+			}
+		}
+	case "mesh":
+		for j := i + 1; j < n; j++ {
+			out = append(out, j)
+		}
+	default:
+		out = randomNeighbors(n, i, rng)
+	}
+	return out
+}
+
+// connectNeighbors wires every node to its neighbors concurrently and returns the aggregated errors
+func connectNeighbors(ctx context.Context, hosts []hostCloser, topology string, rng *rand.Rand) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+		ok   int
+	)
+	for i, h := range hosts {
+		for _, n := range neighborsFor(topology, i, rng) {
+			wg.Add(1)
+			go func(i, n int, h hostCloser) {
+				defer wg.Done()
+				pi := peer.AddrInfo{
+					ID:    hosts[n].ID(),
+					Addrs: hosts[n].Addrs(),
+				}
+				err := h.Connect(ctx, pi)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					log.Printf("Node@%d failed to connect to Node@%d: %v", ports[i], ports[n], err)
+					errs = append(errs, fmt.Errorf("node@%d -> node@%d: %w", ports[i], ports[n], err))
+				} else {
+					log.Printf("Node@%d connected to Node@%d", ports[i], ports[n])
+					ok++
+				}
+			}(i, n, h)
+		}
+	}
+	wg.Wait()
+	log.Printf("Neighbor wiring done: %d connected, %d failed", ok, len(errs))
+	return errors.Join(errs...)
+}
+
 func main() {
-	rand.Seed(time.Now().UnixNano())
+	flag.Parse()
+	if *minNeighbors < 0 || *maxNeighbors < *minNeighbors {
+		log.Fatalf("Invalid neighbor range: min=%d max=%d", *minNeighbors, *maxNeighbors)
+	}
+	if *fullMesh {
+		*topology = "mesh"
+	}
+	switch *topology {
+	case "ring", "star", "mesh", "random":
+	default:
+		log.Fatalf("Invalid topology %q (use ring, star, mesh or random)", *topology)
+	}
+
+	// 拓扑只用这个独立的 rand.Rand（不碰全局 math/rand）；指定 seed 时可复现
+	rng := newRand(*seed)
+	log.Printf("Topology: %s (seed %d)", *topology, *seed)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	hosts, topics, err := CreateBootstrapNodes(ctx, seeds, ports)
+	var tracker *propagationTracker
+	var onMessage func(int, *pubsub.Message)
+	if *check {
+		tracker = newPropagationTracker(fmt.Sprintf("check-%d", time.Now().UnixNano()), len(seeds))
+		onMessage = tracker.onMessage
+	}
+
+	topicNames := splitTopics(*topicName)
+	if len(topicNames) == 0 {
+		log.Fatalf("At least one topic is required")
+	}
+	var libp2pOpts []libp2p.Option
+	if *pskFile != "" {
+		psk, err := p2pnode.LoadPSK(*pskFile)
+		if err != nil {
+			log.Fatalf("Invalid private network key: %v", err)
+		}
+		libp2pOpts = append(libp2pOpts, libp2p.PrivateNetwork(psk))
+		log.Printf("Private network: only nodes with the key in %s can connect", *pskFile)
+	}
+	log.Printf("Topics: %s", strings.Join(topicNames, ", "))
+	if *maxMsgSize <= 0 {
+		log.Fatalf("Invalid --max-message-size: %d", *maxMsgSize)
+	}
+	psOpts := []pubsub.Option{pubsub.WithMaxMessageSize(*maxMsgSize)}
+	log.Printf("Max pubsub message size: %d bytes", *maxMsgSize)
+
+	var readers sync.WaitGroup
+	nodes, err := CreateBootstrapNodes(ctx, seeds, ports, topicNames, libp2pOpts, psOpts, &readers, onMessage)
 	if err != nil {
 		log.Fatalf("Failed to create bootstrap nodes: %v", err)
 	}
+	hosts := make([]hostCloser, len(nodes))
+	topics := make([]*pubsub.Topic, len(nodes))
+	for i, n := range nodes {
+		hosts[i] = n
+		topics[i] = n.Topic
+	}
 
 	// Connect neighbors
-	for i, h := range hosts {
-		neighbors := randomNeighbors(len(ports), i)
-		for _, n := range neighbors {
-			pi := peer.AddrInfo{
-				ID:    hosts[n].ID(),
-				Addrs: hosts[n].Addrs(),
-			}
-			if err := h.Connect(ctx, pi); err != nil {
-				log.Printf("Node@%d failed to connect to Node@%d: %v", ports[i], ports[n], err)
-			} else {
-				log.Printf("Node@%d connected to Node@%d", ports[i], ports[n])
-			}
+	if err := connectNeighbors(ctx, hosts, *topology, rng); err != nil {
+		log.Printf("Some neighbor connections failed:\n%v", err)
+	}
+
+	if *check {
+		// 等 gossipsub mesh 建立后再发
+		time.Sleep(3 * time.Second)
+		checkErr := runPropagationCheck(ctx, hosts, topics, tracker, *checkMessages, *checkTimeout)
+		shutdown(cancel, &readers, hosts)
+		if checkErr != nil {
+			log.Printf("[Check] FAIL: %v", checkErr)
+			os.Exit(1)
 		}
+		return
 	}
 
 	// Node 0 broadcasts a message after a short delay to ensure connections are established
 	go func() {
-		time.Sleep(3 * time.Second)
+		select {
+		case <-time.After(3 * time.Second):
+		case <-ctx.Done():
+			return
+		}
 		msg := map[string]string{"text": "Hello from bootstrap node 0"}
 		data, _ := json.Marshal(msg)
 		if err := topics[0].Publish(ctx, data); err != nil {
@@ -96,7 +263,19 @@ func main() {
 
 	<-sigCh
 	log.Println("Received interrupt signal, shutting down nodes...")
+	shutdown(cancel, &readers, hosts)
+}
 
+// shutdown stops the harness in order: cancel the context (stops the broadcast and
+// reader goroutines), wait for the readers to return, then close the hosts.
+// Closing hosts first makes the readers race with Close() and log spurious errors.
+func shutdown(cancel context.CancelFunc, readers *sync.WaitGroup, hosts []hostCloser) {
+	cancel()
+	readers.Wait()
+	closeHosts(hosts)
+}
+
+func closeHosts(hosts []hostCloser) {
 	var wg sync.WaitGroup
 	for i, h := range hosts {
 		wg.Add(1)
@@ -121,70 +300,85 @@ type hostCloser interface {
 	Connect(ctx context.Context, pi peer.AddrInfo) error
 }
 
-func CreateBootstrapNodes(ctx context.Context, seeds [][]byte, ports []int) ([]hostCloser, []*pubsub.Topic, error) {
-	var hosts []hostCloser
-	var topics []*pubsub.Topic
+// splitTopics parses the comma-separated --topic value, dropping blanks and duplicates
+func splitTopics(v string) []string {
+	var topics []string
+	for _, t := range strings.Split(v, ",") {
+		if t = strings.TrimSpace(t); t != "" && !slices.Contains(topics, t) {
+			topics = append(topics, t)
+		}
+	}
+	return topics
+}
+
+// CreateBootstrapNodes starts one server-mode node per seed/port, joined to every topic
+// (topics[0] is Node.Topic). libp2pOpts are passed to every host (e.g. the private network key),
+// psOpts to every GossipSub router (e.g. the max message size).
+// onMessage, if non-nil, is called for every message a node receives on topics[0].
+// Each reader goroutine is tracked in readers and returns once ctx is cancelled.
+func CreateBootstrapNodes(ctx context.Context, seeds [][]byte, ports []int, topics []string, libp2pOpts []libp2p.Option, psOpts []pubsub.Option, readers *sync.WaitGroup, onMessage func(int, *pubsub.Message)) ([]*p2pnode.Node, error) {
+	var nodes []*p2pnode.Node
 
 	for i, seed := range seeds {
 		privKey := ed25519.NewKeyFromSeed(seed)
 		priv, _, _ := crypto.KeyPairFromStdKey(&privKey)
-		h, err := libp2p.New(
-			libp2p.ListenAddrStrings(fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", ports[i])),
-			libp2p.Identity(priv),
-		)
+		node, err := p2pnode.New(ctx, p2pnode.Options{
+			PrivKey:     priv,
+			ListenAddrs: []string{fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", ports[i])},
+			Topic:       topics[0],
+			Topics:      topics[1:],
+			DHTMode:     dht.ModeServer,
+			Libp2pOpts:  libp2pOpts,
+			PubSubOpts:  psOpts,
+		})
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to create host %d: %w", i, err)
+			return nil, fmt.Errorf("failed to create node %d: %w", i, err)
 		}
 
-		// DHT
-		dhtNode, err := dht.New(ctx, h, dht.Mode(dht.ModeServer))
-		if err != nil {
-			return nil, nil, fmt.Errorf("failed to create dht for host %d: %w", i, err)
-		}
 		go func() {
-			if err := dhtNode.Bootstrap(ctx); err != nil {
+			if err := node.DHT.Bootstrap(ctx); err != nil {
 				log.Printf("[Node@%d] DHT bootstrap error: %v", ports[i], err)
 			} else {
 				log.Printf("[Node@%d] DHT bootstrap done", ports[i])
 			}
 		}()
 
-		ps, err := pubsub.NewGossipSub(ctx, h)
-		if err != nil {
-			return nil, nil, fmt.Errorf("failed to create pubsub for host %d: %w", i, err)
+		for _, name := range topics {
+			sub, err := node.Topics[name].Subscribe()
+			if err != nil {
+				return nil, fmt.Errorf("failed to subscribe topic %s for host %d: %w", name, i, err)
+			}
+			readers.Add(1)
+			go readTopic(ctx, i, sub, readers, onMessage, name == topics[0])
 		}
 
-		topic, err := ps.Join("sight-message")
-		if err != nil {
-			return nil, nil, fmt.Errorf("failed to join topic for host %d: %w", i, err)
+		for _, addr := range node.Addrs() {
+			log.Printf("Bootstrap Node@%d at %s/p2p/%s", ports[i], addr, node.ID().String())
 		}
 
-		sub, err := topic.Subscribe()
-		if err != nil {
-			return nil, nil, fmt.Errorf("failed to subscribe topic for host %d: %w", i, err)
-		}
+		nodes = append(nodes, node)
+	}
 
-		go func(i int, sub *pubsub.Subscription) {
-			for {
-				msg, err := sub.Next(ctx)
-				if err != nil {
-					if ctx.Err() != nil {
-						return
-					}
-					log.Printf("[Node@%d] Error reading message: %v", ports[i], err)
-					continue
-				}
-				log.Printf("[Node@%d] received from %s: %s", ports[i], msg.GetFrom().String(), string(msg.Data))
-			}
-		}(i, sub)
+	return nodes, nil
+}
 
-		for _, addr := range h.Addrs() {
-			log.Printf("Bootstrap Node@%d at %s/p2p/%s", ports[i], addr, h.ID().String())
+// readTopic logs the messages node i receives on sub until ctx is cancelled; onMessage is
+// only called for the primary topic
+func readTopic(ctx context.Context, i int, sub *pubsub.Subscription, readers *sync.WaitGroup, onMessage func(int, *pubsub.Message), primary bool) {
+	defer readers.Done()
+	defer sub.Cancel()
+	for {
+		msg, err := sub.Next(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("[Node@%d] Error reading message: %v", ports[i], err)
+			continue
+		}
+		log.Printf("[Node@%d] received on %s from %s: %s", ports[i], sub.Topic(), msg.GetFrom().String(), string(msg.Data))
+		if onMessage != nil && primary {
+			onMessage(i, msg)
 		}
-
-		hosts = append(hosts, h)
-		topics = append(topics, topic)
 	}
-
-	return hosts, topics, nil
 }
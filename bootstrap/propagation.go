@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// checkMessage is the tagged message published in propagation-check mode
+type checkMessage struct {
+	Text    string `json:"text"`
+	CheckID string `json:"checkId"`
+	Seq     int    `json:"seq"`
+	SentAt  int64  `json:"sentAt"` // unix nanos
+}
+
+// receipt records when and from whom a node received a check message
+type receipt struct {
+	latency      time.Duration
+	receivedFrom peer.ID
+}
+
+// propagationTracker collects receipts of check messages on every node
+type propagationTracker struct {
+	checkID string
+	nodes   int
+
+	mu       sync.Mutex
+	receipts map[int]map[int]receipt // seq -> node index -> receipt
+	changed  chan struct{}
+}
+
+func newPropagationTracker(checkID string, nodes int) *propagationTracker {
+	return &propagationTracker{
+		checkID:  checkID,
+		nodes:    nodes,
+		receipts: make(map[int]map[int]receipt),
+		changed:  make(chan struct{}, 1),
+	}
+}
+
+// onMessage is passed to CreateBootstrapNodes and records check messages
+func (t *propagationTracker) onMessage(node int, msg *pubsub.Message) {
+	var m checkMessage
+	if err := json.Unmarshal(msg.Data, &m); err != nil || m.CheckID != t.checkID {
+		return
+	}
+	t.mu.Lock()
+	if t.receipts[m.Seq] == nil {
+		t.receipts[m.Seq] = make(map[int]receipt)
+	}
+	if _, seen := t.receipts[m.Seq][node]; !seen {
+		t.receipts[m.Seq][node] = receipt{
+			latency:      time.Since(time.Unix(0, m.SentAt)),
+			receivedFrom: msg.ReceivedFrom,
+		}
+	}
+	t.mu.Unlock()
+
+	select {
+	case t.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (t *propagationTracker) complete(messages int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for seq := 0; seq < messages; seq++ {
+		if len(t.receipts[seq]) < t.nodes {
+			return false
+		}
+	}
+	return true
+}
+
+// runPropagationCheck publishes `messages` tagged messages from node 0 and waits up to
+// timeout for every node to receive all of them. It logs a per-node report
+// (latency and hop count from the origin) and returns an error if delivery is incomplete.
+func runPropagationCheck(ctx context.Context, hosts []hostCloser, topics []*pubsub.Topic, tracker *propagationTracker, messages int, timeout time.Duration) error {
+	for seq := 0; seq < messages; seq++ {
+		data, _ := json.Marshal(checkMessage{
+			Text:    "propagation check",
+			CheckID: tracker.checkID,
+			Seq:     seq,
+			SentAt:  time.Now().UnixNano(),
+		})
+		if err := topics[0].Publish(ctx, data); err != nil {
+			return fmt.Errorf("node@%d failed to publish check message %d: %w", ports[0], seq, err)
+		}
+	}
+	log.Printf("[Check] Node@%d published %d check message(s), waiting up to %s", ports[0], messages, timeout)
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for !tracker.complete(messages) {
+		select {
+		case <-tracker.changed:
+		case <-deadline.C:
+			return tracker.report(hosts, messages)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return tracker.report(hosts, messages)
+}
+
+// report logs delivery per message and node, and returns an error listing missing deliveries
+func (t *propagationTracker) report(hosts []hostCloser, messages int) error {
+	index := make(map[peer.ID]int, len(hosts))
+	for i, h := range hosts {
+		index[h.ID()] = i
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	missing := 0
+	for seq := 0; seq < messages; seq++ {
+		got := t.receipts[seq]
+		log.Printf("[Check] message %d: delivered to %d/%d nodes", seq, len(got), t.nodes)
+
+		nodes := make([]int, 0, len(got))
+		for i := range got {
+			nodes = append(nodes, i)
+		}
+		sort.Ints(nodes)
+		for _, i := range nodes {
+			r := got[i]
+			log.Printf("[Check]   node@%d latency=%s hops=%d", ports[i], r.latency.Round(time.Microsecond), hops(got, index, i))
+		}
+		for i := 0; i < t.nodes; i++ {
+			if _, ok := got[i]; !ok {
+				log.Printf("[Check]   node@%d MISSING", ports[i])
+				missing++
+			}
+		}
+	}
+
+	if missing > 0 {
+		return fmt.Errorf("propagation incomplete: %d missing deliveries", missing)
+	}
+	log.Printf("[Check] PASS: all %d message(s) reached all %d nodes", messages, t.nodes)
+	return nil
+}
+
+// hops follows the ReceivedFrom chain back to the origin (node 0) to count gossip hops.
+// Returns -1 if the chain can't be resolved (e.g. relayed through an unknown peer).
+func hops(got map[int]receipt, index map[peer.ID]int, node int) int {
+	count := 0
+	for node != 0 {
+		r, ok := got[node]
+		if !ok {
+			return -1
+		}
+		next, ok := index[r.receivedFrom]
+		if !ok || count > len(index) {
+			return -1
+		}
+		node = next
+		count++
+	}
+	return count
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// BootstrapPeerResult reports the outcome of dialing or dropping a bootstrap peer
+type BootstrapPeerResult struct {
+	Addr   string `json:"addr"`
+	PeerID string `json:"peerId"`
+	OK     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`
+}
+
+// parseBootstrapAddrs parses bootstrap multiaddrs, failing on the first invalid entry
+func parseBootstrapAddrs(addrs []string) ([]peer.AddrInfo, error) {
+	infos := make([]peer.AddrInfo, 0, len(addrs))
+	for _, addr := range addrs {
+		info, err := peer.AddrInfoFromString(addr)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, *info)
+	}
+	return infos, nil
+}
+
+// dialBootstrapPeers dials the given bootstrap peers with a bounded worker pool,
+// each dial limited by the configured per-dial timeout.
+// reached is closed as soon as minConnected peers are connected (or every dial has
+// finished); done yields all results, in the same order as addrs, once every dial finished.
+func dialBootstrapPeers(ctx context.Context, h nodeHost, addrs []string, infos []peer.AddrInfo, cfg BootstrapDialConfig, minConnected int) (reached <-chan struct{}, done <-chan []BootstrapPeerResult) {
+	reachedCh := make(chan struct{})
+	doneCh := make(chan []BootstrapPeerResult, 1)
+
+	workers := cfg.Workers
+	if workers > len(infos) {
+		workers = len(infos)
+	}
+
+	results := make([]BootstrapPeerResult, len(infos))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	connected := 0
+	var reachedOnce sync.Once
+	markReached := func() { reachedOnce.Do(func() { close(reachedCh) }) }
+	if minConnected <= 0 {
+		markReached()
+	}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				info := infos[i]
+				res := BootstrapPeerResult{Addr: addrs[i], PeerID: info.ID.String(), OK: true}
+				dialCtx, cancel := context.WithTimeout(ctx, cfg.Timeout.Std())
+				err := h.Connect(dialCtx, info)
+				cancel()
+				if err != nil {
+					log.Printf("Failed to connect to %s: %v", info.ID, err)
+					res.OK = false
+					res.Error = err.Error()
+				} else {
+					log.Printf("Connected to bootstrap peer: %s", info.ID)
+				}
+				results[i] = res
+
+				if res.OK {
+					mu.Lock()
+					connected++
+					if connected >= minConnected {
+						markReached()
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	go func() {
+		for i := range infos {
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+		markReached()
+		doneCh <- results
+	}()
+	return reachedCh, doneCh
+}
+
+// logBootstrapSummary logs how many bootstrap peers connected and which failed
+func logBootstrapSummary(results []BootstrapPeerResult) {
+	ok := 0
+	for _, res := range results {
+		if res.OK {
+			ok++
+		}
+	}
+	log.Printf("[Bootstrap] Connected to %d/%d bootstrap peers", ok, len(results))
+	for _, res := range results {
+		if !res.OK {
+			log.Printf("[Bootstrap] Failed: %s (%s)", res.Addr, res.Error)
+		}
+	}
+}
+
+// ReloadBootstrap replaces the bootstrap list at runtime. Newly added peers are
+// dialed; peers no longer in the list are disconnected when disconnectRemoved is set
+// (unless the same peer ID is still present under another address).
+func (s *Libp2pNodeService) ReloadBootstrap(ctx context.Context, addrs []string, disconnectRemoved bool) (added, removed []BootstrapPeerResult, err error) {
+	infos, err := parseBootstrapAddrs(addrs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.mu.Lock()
+	old := s.bootstrap
+	s.bootstrap = addrs
+	s.mu.Unlock()
+
+	oldSet := make(map[string]bool, len(old))
+	for _, addr := range old {
+		oldSet[addr] = true
+	}
+	newSet := make(map[string]bool, len(addrs))
+	keepPeers := make(map[peer.ID]bool, len(infos))
+	var addedAddrs []string
+	var addedInfos []peer.AddrInfo
+	for i, addr := range addrs {
+		newSet[addr] = true
+		keepPeers[infos[i].ID] = true
+		if !oldSet[addr] {
+			addedAddrs = append(addedAddrs, addr)
+			addedInfos = append(addedInfos, infos[i])
+		}
+	}
+
+	_, done := dialBootstrapPeers(ctx, s.node, addedAddrs, addedInfos, s.config.BootstrapDial, len(addedInfos))
+	added = <-done
+
+	for _, addr := range old {
+		if newSet[addr] {
+			continue
+		}
+		res := BootstrapPeerResult{Addr: addr}
+		info, err := peer.AddrInfoFromString(addr)
+		if err != nil {
+			res.Error = err.Error()
+			removed = append(removed, res)
+			continue
+		}
+		res.PeerID = info.ID.String()
+		if disconnectRemoved && !keepPeers[info.ID] {
+			if err := s.node.Network().ClosePeer(info.ID); err != nil {
+				res.Error = err.Error()
+			} else {
+				res.OK = true
+				log.Printf("Disconnected removed bootstrap peer: %s", info.ID)
+			}
+		}
+		removed = append(removed, res)
+	}
+
+	log.Printf("[Bootstrap] Reloaded: %d peers (%d added, %d removed)", len(addrs), len(added), len(removed))
+	return added, removed, nil
+}
+
+// GetBootstrap returns the current bootstrap list
+func (s *Libp2pNodeService) GetBootstrap() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]string(nil), s.bootstrap...)
+}
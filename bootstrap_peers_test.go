@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDialBootstrapPeersMixed(t *testing.T) {
+	self, _ := testPeer(t)
+	h := newFakeHost(t, self)
+
+	// reachable, unreachable, reachable, too slow for the per-dial timeout
+	var addrs []string
+	var want []bool
+	for _, kind := range []string{"ok", "unreachable", "ok", "slow"} {
+		p, _ := h.addPeer(t)
+		switch kind {
+		case "unreachable":
+			p.Unreachable = true
+		case "slow":
+			p.DialDelay = time.Minute
+		}
+		addrs = append(addrs, p.Addrs[0].String()+"/p2p/"+p.ID.String())
+		want = append(want, kind == "ok")
+	}
+	infos, err := parseBootstrapAddrs(addrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := BootstrapDialConfig{Workers: 4, Timeout: Duration(500 * time.Millisecond)}
+	start := time.Now()
+	reached, done := dialBootstrapPeers(context.Background(), h, addrs, infos, cfg, 2)
+
+	// two reachable peers are enough: startup goes on while the slow dial is still pending
+	select {
+	case <-reached:
+	case <-time.After(5 * time.Second):
+		t.Fatal("minConnected never reached")
+	}
+	if elapsed := time.Since(start); elapsed >= 500*time.Millisecond {
+		t.Errorf("reached after %v, want it before the slow dial times out", elapsed)
+	}
+
+	var results []BootstrapPeerResult
+	select {
+	case results = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("dials never finished: per-dial timeout not applied")
+	}
+	if len(results) != len(addrs) {
+		t.Fatalf("%d results, want %d", len(results), len(addrs))
+	}
+	for i, res := range results {
+		if res.Addr != addrs[i] || res.PeerID != infos[i].ID.String() {
+			t.Errorf("result %d = %+v, want it for %s", i, res, addrs[i])
+		}
+		if res.OK != want[i] || (res.Error == "") != want[i] {
+			t.Errorf("result %d: ok = %v, error = %q, want ok %v", i, res.OK, res.Error, want[i])
+		}
+	}
+}
+
+func TestDialBootstrapPeersNoneReachable(t *testing.T) {
+	self, _ := testPeer(t)
+	h := newFakeHost(t, self)
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		p, _ := h.addPeer(t)
+		p.Unreachable = true
+		addrs = append(addrs, p.Addrs[0].String()+"/p2p/"+p.ID.String())
+	}
+	infos, err := parseBootstrapAddrs(addrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// minConnected can't be met: reached still closes once every dial has finished
+	reached, done := dialBootstrapPeers(context.Background(), h, addrs, infos, BootstrapDialConfig{Workers: 2, Timeout: Duration(time.Second)}, 1)
+	select {
+	case <-reached:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reached not closed after every dial failed")
+	}
+	for _, res := range <-done {
+		if res.OK {
+			t.Errorf("unreachable peer %s reported connected", res.PeerID)
+		}
+	}
+}
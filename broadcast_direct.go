@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// directBroadcastWorkers bounds how many neighbors BroadcastDirect sends to at a time
+const directBroadcastWorkers = 16
+
+// BroadcastDirect sends payload as a direct message ({"to": neighbor DID, "payload": payload},
+// like p2p-send) to every connected neighbor, bypassing pubsub, at most directBroadcastWorkers
+// at a time and bounded by ctx. It returns the SendDirectMessage error of each neighbor by
+// peer ID (nil on success); neighbors that don't speak a direct protocol (e.g. bootstrap
+// nodes) fail with ErrProtocolUnsupported.
+func (s *Libp2pNodeService) BroadcastDirect(ctx context.Context, payload json.RawMessage) map[string]error {
+	neighbors := s.GetNeighbors()
+	results := make(map[string]error, len(neighbors))
+	if len(neighbors) == 0 {
+		return results
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	jobs := make(chan string)
+	for w := 0; w < min(directBroadcastWorkers, len(neighbors)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pid := range jobs {
+				to := pid
+				if id, err := peer.Decode(pid); err == nil {
+					if did, err := s.peerDID(id); err == nil {
+						to = did
+					}
+				}
+				envelope, _ := json.Marshal(map[string]interface{}{"to": to, "payload": payload})
+				_, err := s.SendDirectMessage(ctx, pid, envelope)
+				mu.Lock()
+				results[pid] = err
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, pid := range neighbors {
+		jobs <- pid
+	}
+	close(jobs)
+	wg.Wait()
+
+	failed := 0
+	for _, err := range results {
+		if err != nil {
+			failed++
+		}
+	}
+	log.Printf("[Broadcast] Sent direct message to %d/%d neighbors", len(results)-failed, len(results))
+	return results
+}
@@ -0,0 +1,239 @@
+// Package client is a typed Go client for the node's REST API (/libp2p/...).
+//
+//	c := client.New("http://localhost:4010", os.Getenv("API_TOKEN"))
+//	res, err := c.Send(ctx, "did:sight:hoster:...", map[string]interface{}{"hello": "world"})
+//	var apiErr *client.Error
+//	if errors.As(err, &apiErr) && apiErr.Code == "peer_not_found" {
+//		// retry later
+//	}
+//
+// Every method takes a context that bounds the HTTP request; the node applies its own
+// timeouts (timeouts.dial, timeouts.request, ...) on top and answers 504 when they expire.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxErrorBody bounds how much of a non-JSON error response is kept in Error.Message
+const maxErrorBody = 4 << 10
+
+// Client calls one node's REST API. The zero HTTPClient uses http.DefaultClient.
+type Client struct {
+	// BaseURL is the node's API address, e.g. http://localhost:4010
+	BaseURL string
+	// Token is sent as "Authorization: Bearer <token>" when set (API_TOKEN on the node)
+	Token string
+	// HTTPClient performs the requests; nil means http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// New returns a client for the node at baseURL; token may be empty
+func New(baseURL, token string) *Client {
+	return &Client{BaseURL: baseURL, Token: token}
+}
+
+// Error is a non-2xx response. Code is the stable code of the node's error envelope
+// ({"error": {"code", "message", "details"}}), e.g. peer_not_found or dial_timeout; it is
+// empty when the body wasn't an envelope (a proxy error page, ...).
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Details    json.RawMessage
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("node API: HTTP %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("node API: HTTP %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// IsCode reports whether err is an *Error with the given code
+func IsCode(err error, code string) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+// SendResult is the response of Send (POST /libp2p/send)
+type SendResult struct {
+	Status    string `json:"status"`
+	MessageID string `json:"messageId"`
+}
+
+// SendDirectResult is the response of SendDirect (POST /libp2p/p2p-send/{did})
+type SendDirectResult struct {
+	Status string `json:"status"`
+	// Protocol is the negotiated direct protocol, e.g. /sight/direct/1.1.0
+	Protocol string `json:"protocol"`
+}
+
+// Neighbor is the connection manager state of a connected peer
+type Neighbor struct {
+	PeerID    string `json:"peerId"`
+	DID       string `json:"did,omitempty"`
+	Connected bool   `json:"connected"`
+	// Connection is "direct" or "relayed"; empty when not connected
+	Connection string         `json:"connection,omitempty"`
+	Protected  bool           `json:"protected"`
+	Tags       map[string]int `json:"tags"`
+	Value      int            `json:"value"`
+}
+
+// Neighbors is the response of Neighbors (GET /libp2p/neighbors)
+type Neighbors struct {
+	// PeerIDs of the connected peers
+	PeerIDs []string   `json:"neighbors"`
+	Details []Neighbor `json:"details"`
+}
+
+// PeerAddrs is the response of FindPeer (GET /libp2p/find-peer/{peerId})
+type PeerAddrs struct {
+	PeerID string   `json:"peerId"`
+	DID    string   `json:"did,omitempty"`
+	Addrs  []string `json:"addrs"`
+}
+
+// Send publishes msg to the recipient to (a DID or "gateway") over pubsub. msg is the
+// payload the recipient's tunnel receives; its "to" field is set to to.
+func (c *Client) Send(ctx context.Context, to string, msg map[string]interface{}) (SendResult, error) {
+	body := make(map[string]interface{}, len(msg)+1)
+	for k, v := range msg {
+		body[k] = v
+	}
+	body["to"] = to
+	var res SendResult
+	err := c.do(ctx, http.MethodPost, "/libp2p/send", body, &res)
+	return res, err
+}
+
+// SendDirect sends payload (any JSON value) to target (DID, multiaddr or peer ID) over a direct
+// stream, bypassing pubsub; the recipient's tunnel receives payload
+func (c *Client) SendDirect(ctx context.Context, target string, payload interface{}) (SendDirectResult, error) {
+	var res SendDirectResult
+	body := map[string]interface{}{"to": target, "payload": payload}
+	err := c.do(ctx, http.MethodPost, "/libp2p/p2p-send/"+url.PathEscape(target), body, &res)
+	return res, err
+}
+
+// Connect connects the node to target (DID, multiaddr or peer ID)
+func (c *Client) Connect(ctx context.Context, target string) error {
+	return c.do(ctx, http.MethodPost, "/libp2p/connect/"+url.PathEscape(target), nil, nil)
+}
+
+// Ping pings target (DID, multiaddr or peer ID) from the node, connecting first if needed,
+// and returns the round trip time (millisecond resolution)
+func (c *Client) Ping(ctx context.Context, target string) (time.Duration, error) {
+	var res struct {
+		RTTMs int64 `json:"rtt_ms"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/libp2p/ping/"+url.PathEscape(target), nil, &res); err != nil {
+		return 0, err
+	}
+	return time.Duration(res.RTTMs) * time.Millisecond, nil
+}
+
+// Neighbors lists the node's connected peers
+func (c *Client) Neighbors(ctx context.Context) (Neighbors, error) {
+	var res Neighbors
+	err := c.do(ctx, http.MethodGet, "/libp2p/neighbors", nil, &res)
+	return res, err
+}
+
+// FindPeer looks peerID up in the DHT and returns its addresses
+func (c *Client) FindPeer(ctx context.Context, peerID string) (PeerAddrs, error) {
+	var res PeerAddrs
+	err := c.do(ctx, http.MethodGet, "/libp2p/find-peer/"+url.PathEscape(peerID), nil, &res)
+	return res, err
+}
+
+// GetPublicKey returns the base58 Ed25519 public key of peerID
+func (c *Client) GetPublicKey(ctx context.Context, peerID string) (string, error) {
+	var res struct {
+		PublicKey string `json:"publicKey"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/libp2p/public-key/"+url.PathEscape(peerID), nil, &res); err != nil {
+		return "", err
+	}
+	return res.PublicKey, nil
+}
+
+// do sends in (JSON, if not nil) to path and decodes a 2xx response into out (if not nil);
+// other statuses are returned as *Error
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("encode request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(c.BaseURL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return decodeError(resp)
+	}
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode %s %s response: %w", method, path, err)
+	}
+	return nil
+}
+
+// decodeError reads the error envelope of a non-2xx response, falling back to the raw body
+func decodeError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	apiErr := &Error{StatusCode: resp.StatusCode}
+	var envelope struct {
+		Error struct {
+			Code    string          `json:"code"`
+			Message string          `json:"message"`
+			Details json.RawMessage `json:"details"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(data, &envelope) == nil && envelope.Error.Code != "" {
+		apiErr.Code = envelope.Error.Code
+		apiErr.Message = envelope.Error.Message
+		apiErr.Details = envelope.Error.Details
+		return apiErr
+	}
+	apiErr.Message = strings.TrimSpace(string(data))
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	return apiErr
+}
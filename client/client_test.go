@@ -0,0 +1,164 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestNode serves handler as the node API and returns a client for it
+func newTestNode(t *testing.T, token string, handler http.HandlerFunc) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return New(srv.URL+"/", token)
+}
+
+func TestSendRequest(t *testing.T) {
+	c := newTestNode(t, "secret", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/libp2p/send" {
+			t.Errorf("request = %s %s", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Authorization = %q, want Bearer secret", got)
+		}
+		if got := r.Header.Get("Content-Type"); got != "application/json" {
+			t.Errorf("Content-Type = %q", got)
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["to"] != "did:sight:hoster:x" || body["hello"] != "world" {
+			t.Errorf("body = %v", body)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok", "messageId": "m1"})
+	})
+	msg := map[string]interface{}{"hello": "world", "to": "overwritten"}
+	res, err := c.Send(context.Background(), "did:sight:hoster:x", msg)
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if res.Status != "ok" || res.MessageID != "m1" {
+		t.Errorf("result = %+v", res)
+	}
+	if msg["to"] != "overwritten" {
+		t.Error("Send modified the caller's map")
+	}
+}
+
+func TestNoTokenNoAuthorization(t *testing.T) {
+	c := newTestNode(t, "", func(w http.ResponseWriter, r *http.Request) {
+		if got, ok := r.Header["Authorization"]; ok {
+			t.Errorf("Authorization sent without a token: %q", got)
+		}
+		if r.Header.Get("Content-Type") != "" {
+			t.Error("Content-Type sent without a body")
+		}
+		w.Write([]byte(`{"neighbors": ["12D3KooW"], "details": []}`))
+	})
+	res, err := c.Neighbors(context.Background())
+	if err != nil || len(res.PeerIDs) != 1 {
+		t.Fatalf("Neighbors = %+v, %v", res, err)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		status      int
+		body        string
+		wantCode    string
+		wantMessage string
+		wantDetails string
+	}{
+		{
+			name:        "envelope",
+			status:      502,
+			body:        `{"error": {"code": "peer_not_found", "message": "Send failed: no addresses"}}`,
+			wantCode:    "peer_not_found",
+			wantMessage: "Send failed: no addresses",
+		},
+		{
+			name:        "envelope with details",
+			status:      400,
+			body:        `{"error": {"code": "invalid_request", "message": "bad to", "details": {"field": "to"}}}`,
+			wantCode:    "invalid_request",
+			wantMessage: "bad to",
+			wantDetails: `{"field": "to"}`,
+		},
+		{
+			name:        "plain text from a proxy",
+			status:      503,
+			body:        "upstream unavailable\n",
+			wantMessage: "upstream unavailable",
+		},
+		{
+			name:        "JSON without an error code",
+			status:      500,
+			body:        `{"error": "boom"}`,
+			wantMessage: `{"error": "boom"}`,
+		},
+		{
+			name:        "empty body",
+			status:      504,
+			wantMessage: "Gateway Timeout",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestNode(t, "", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				io.WriteString(w, tc.body)
+			})
+			err := c.Connect(context.Background(), "did:sight:hoster:x")
+			var apiErr *Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("err = %v, want *Error", err)
+			}
+			if apiErr.StatusCode != tc.status || apiErr.Code != tc.wantCode || apiErr.Message != tc.wantMessage {
+				t.Errorf("error = %+v, want status %d code %q message %q", apiErr, tc.status, tc.wantCode, tc.wantMessage)
+			}
+			if string(apiErr.Details) != tc.wantDetails {
+				t.Errorf("details = %s, want %s", apiErr.Details, tc.wantDetails)
+			}
+			if !IsCode(err, tc.wantCode) {
+				t.Errorf("IsCode(err, %q) = false", tc.wantCode)
+			}
+		})
+	}
+}
+
+func TestIsCode(t *testing.T) {
+	apiErr := &Error{StatusCode: 504, Code: "dial_timeout", Message: "Send failed"}
+	wrapped := errors.Join(errors.New("retrying"), apiErr)
+	for _, tc := range []struct {
+		err  error
+		code string
+		want bool
+	}{
+		{apiErr, "dial_timeout", true},
+		{wrapped, "dial_timeout", true},
+		{apiErr, "send_timeout", false},
+		{errors.New("dial_timeout"), "dial_timeout", false},
+		{nil, "dial_timeout", false},
+	} {
+		if got := IsCode(tc.err, tc.code); got != tc.want {
+			t.Errorf("IsCode(%v, %q) = %v, want %v", tc.err, tc.code, got, tc.want)
+		}
+	}
+}
+
+func TestPathEscaping(t *testing.T) {
+	c := newTestNode(t, "", func(w http.ResponseWriter, r *http.Request) {
+		if want := "/libp2p/ping/%2Fip4%2F1.2.3.4%2Ftcp%2F15050%2Fp2p%2F12D3KooW"; r.URL.EscapedPath() != want {
+			t.Errorf("path = %s, want %s", r.URL.EscapedPath(), want)
+		}
+		w.Write([]byte(`{"rtt_ms": 12}`))
+	})
+	rtt, err := c.Ping(context.Background(), "/ip4/1.2.3.4/tcp/15050/p2p/12D3KooW")
+	if err != nil || rtt.Milliseconds() != 12 {
+		t.Errorf("Ping = %s, %v, want 12ms", rtt, err)
+	}
+}
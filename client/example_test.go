@@ -0,0 +1,30 @@
+package client_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"sight-libp2p-node/client"
+)
+
+func Example() {
+	// a stand-in for a node whose recipient can't be found
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		fmt.Fprint(w, `{"error": {"code": "peer_not_found", "message": "Send failed: peer not found"}}`)
+	}))
+	defer node.Close()
+
+	c := client.New(node.URL, "api-token")
+	_, err := c.SendDirect(context.Background(), "did:sight:hoster:6Mk...", map[string]string{"hello": "world"})
+	if client.IsCode(err, "peer_not_found") {
+		fmt.Println("recipient offline, retry later")
+	}
+	fmt.Println(err)
+	// Output:
+	// recipient offline, retry later
+	// node API: HTTP 502 peer_not_found: Send failed: peer not found
+}
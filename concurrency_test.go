@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestConcurrentSendStatusAndTunnelSwap exercises the fields guarded by the service mutex
+// (tunnel, topic, bootstrap) from many goroutines at once; run it with go test -race.
+func TestConcurrentSendStatusAndTunnelSwap(t *testing.T) {
+	a := newTestService(t, testConfig(t, newTestBackend(t).URL))
+	b := newTestService(t, testConfig(t, newTestBackend(t).URL))
+	connectServices(t, a, b)
+
+	var forwarded [2]atomic.Int64
+	tunnels := [2]TunnelFunc{}
+	for i := range tunnels {
+		tunnels[i] = func(ctx context.Context, meta TunnelMeta, body []byte) (*TunnelResponse, error) {
+			forwarded[i].Add(1)
+			return &TunnelResponse{StatusCode: 200}, nil
+		}
+	}
+	b.SetTunnelForwarder(tunnels[0])
+	bootstrapA := fmt.Sprintf("%s/p2p/%s", a.node.Addrs()[0], a.node.ID())
+
+	const sends = 20
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	run := func(f func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				f(i)
+			}
+		}()
+	}
+	run(func(i int) { b.SetTunnelForwarder(tunnels[i%2]) })
+	run(func(int) { a.GetStatus(); b.GetStatus() })
+	run(func(i int) {
+		addrs := []string{bootstrapA}
+		if i%2 == 1 {
+			addrs = nil
+		}
+		if _, _, err := b.ReloadBootstrap(ctx, addrs, false); err != nil {
+			t.Errorf("ReloadBootstrap: %v", err)
+		}
+		b.GetBootstrap()
+	})
+
+	var senders sync.WaitGroup
+	for i := 0; i < sends; i++ {
+		senders.Add(2)
+		go func() {
+			defer senders.Done()
+			msg := map[string]interface{}{"to": b.did, "payload": map[string]int{"pubsub": i}}
+			if _, err := a.HandleOutgoingMessage(ctx, msg); err != nil {
+				t.Errorf("HandleOutgoingMessage: %v", err)
+			}
+		}()
+		go func() {
+			defer senders.Done()
+			body := fmt.Sprintf(`{"payload":{"direct":%d}}`, i)
+			if _, err := a.SendDirectMessage(ctx, b.did, []byte(body)); err != nil {
+				t.Errorf("SendDirectMessage: %v", err)
+			}
+		}()
+	}
+	senders.Wait()
+	// every message reaches one of the tunnels, whichever was installed at the time
+	waitFor(t, 10*time.Second, func() bool { return forwarded[0].Load()+forwarded[1].Load() == 2*sends }, "all messages forwarded")
+	close(stop)
+	wg.Wait()
+}
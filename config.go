@@ -0,0 +1,928 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	golog "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	"github.com/libp2p/go-libp2p/p2p/security/noise"
+	tls "github.com/libp2p/go-libp2p/p2p/security/tls"
+	"gopkg.in/yaml.v3"
+
+	"sight-libp2p-node/p2pnode"
+)
+
+// Config is the resolved node configuration.
+//
+// Precedence (lowest to highest): built-in defaults < config file (--config) <
+// environment variables (including .env when no config file is given) < CLI flags.
+type Config struct {
+	NodePort   int      `yaml:"nodePort" json:"nodePort"`
+	Libp2pPort int      `yaml:"libp2pPort" json:"libp2pPort"`
+	APIPort    int      `yaml:"apiPort" json:"apiPort"`
+	IsGateway  bool     `yaml:"isGateway" json:"isGateway"`
+	Bootstrap  []string `yaml:"bootstrap" json:"bootstrap"`
+	// BootstrapFile is a file of bootstrap multiaddrs, one per line (# comments and blank lines
+	// allowed), merged with Bootstrap; re-read by POST /libp2p/bootstrap/reload
+	BootstrapFile string `yaml:"bootstrapFile" json:"bootstrapFile"`
+	// GatewayRouting is "pubsub" (publish everything to the shared topic) or "direct"
+	// (gateway sends to the recipient DID directly, falling back to pubsub)
+	GatewayRouting string `yaml:"gatewayRouting" json:"gatewayRouting"`
+	// Observer runs a read-only probe: it joins the mesh and records every message it sees
+	// (GET /libp2p/observer/messages) but never publishes, sends or forwards to the tunnel
+	Observer bool `yaml:"observer" json:"observer"`
+	// GatewayLegacyKey keeps a gateway on the fixed key all gateways used to share (migration only)
+	GatewayLegacyKey bool `yaml:"gatewayLegacyKey" json:"gatewayLegacyKey"`
+	// BootstrapDial controls how bootstrap peers are dialed at startup and on reload
+	BootstrapDial BootstrapDialConfig `yaml:"bootstrapDial" json:"bootstrapDial"`
+	// Transports to listen on: "tcp" and/or "quic"
+	Transports []string `yaml:"transports" json:"transports"`
+	// EnableWebRTC also listens on /udp/<nodePort>/webrtc-direct (sharing the UDP port with QUIC)
+	// so browsers, which can't open raw TCP/QUIC, can connect directly
+	EnableWebRTC bool `yaml:"enableWebrtc" json:"enableWebrtc"`
+	// BindInterface restricts the listen addresses to one NIC, given as an interface name
+	// ("eth1") or a local IP; empty listens on all interfaces (0.0.0.0)
+	BindInterface string `yaml:"bindInterface" json:"bindInterface"`
+	// Security selects the security transports: "noise", "tls" or "both" (Noise preferred)
+	Security string `yaml:"security" json:"security"`
+	// Topic is the pubsub topic (mesh namespace) messages are published and received on
+	Topic string `yaml:"topic" json:"topic"`
+	// PrivateNetworkKeyFile is a swarm.key (/key/swarm/psk/1.0.0/) that isolates the node:
+	// only peers with the same key can connect. TCP only, QUIC doesn't support it.
+	PrivateNetworkKeyFile string `yaml:"privateNetworkKeyFile" json:"privateNetworkKeyFile"`
+	// DataDir holds the keypair and registry files. Defaults to $SIGHTAI_DATA_DIR/config, else
+	// ~/.sightai/config; DATA_DIR / --data-dir override both (one per node on a shared host).
+	DataDir string `yaml:"dataDir" json:"dataDir"`
+	// InstanceID separates the identity of nodes sharing a data dir: the keypair and registry
+	// files get a -<instanceId> suffix (e.g. device-keypair-15051.json). Empty keeps the plain names.
+	InstanceID string `yaml:"instanceId" json:"instanceId"`
+	// DHTMode is "server", "client" or "auto"; empty picks server for gateways and auto for hosters
+	DHTMode string `yaml:"dhtMode" json:"dhtMode"`
+	// LogLevel applies to the libp2p internal loggers (debug/info/warn/error)
+	LogLevel string        `yaml:"logLevel" json:"logLevel"`
+	Timeouts TimeoutConfig `yaml:"timeouts" json:"timeouts"`
+	// UptimeLogInterval controls the periodic uptime log line (0 disables it)
+	UptimeLogInterval Duration `yaml:"uptimeLogInterval" json:"uptimeLogInterval"`
+	// WaitForPeers holds startup (before the HTTP API starts) until this many peers are
+	// connected or in the DHT routing table; /readyz uses the same threshold. 0 disables the gate.
+	WaitForPeers int `yaml:"waitForPeers" json:"waitForPeers"`
+	// MaxPayloadBytes caps send (and sign/verify) request bodies and the encoded messages published/sent from them
+	MaxPayloadBytes int64 `yaml:"maxPayloadBytes" json:"maxPayloadBytes"`
+	// ForwardQueueSize bounds the pubsub messages waiting for the tunnel; newer messages are dropped when full
+	ForwardQueueSize int `yaml:"forwardQueueSize" json:"forwardQueueSize"`
+	// Tunnel selects how received messages reach the local backend
+	Tunnel TunnelConfig `yaml:"tunnel" json:"tunnel"`
+	// LatencyPing keeps a per-peer latency history, fed by API pings and the health monitor's pings
+	LatencyPing LatencyPingConfig `yaml:"latencyPing" json:"latencyPing"`
+	// HealthMonitor pings neighbors in the background and flags (or drops) dead ones
+	HealthMonitor HealthMonitorConfig `yaml:"healthMonitor" json:"healthMonitor"`
+	// ObservedAddrMinPeers is how many distinct peers must observe a public IP before we announce it
+	ObservedAddrMinPeers int `yaml:"observedAddrMinPeers" json:"observedAddrMinPeers"`
+	// ResolveCache caches DID→peerID and peerID→addrs lookups
+	ResolveCache ResolveCacheConfig `yaml:"resolveCache" json:"resolveCache"`
+	// Registry persists the DID↔peerID registry (with addresses) in the data dir
+	Registry RegistryConfig `yaml:"registry" json:"registry"`
+	// Reputation blocks misbehaving peers for a cooldown (GET /libp2p/reputation)
+	Reputation ReputationConfig `yaml:"reputation" json:"reputation"`
+
+	PeerScore PeerScoreConfig `yaml:"peerScore" json:"peerScore"`
+	GossipSub GossipSubConfig `yaml:"gossipSub" json:"gossipSub"`
+	// RecentMessages keeps the last received messages for GET /libp2p/recent
+	RecentMessages RecentMessagesConfig `yaml:"recentMessages" json:"recentMessages"`
+	// LoadScore weighs the inputs of the GET /libp2p/load score
+	LoadScore LoadScoreConfig `yaml:"loadScore" json:"loadScore"`
+	// ResourceLimits caps connections, streams and memory per peer/protocol (libp2p resource manager)
+	ResourceLimits ResourceLimitsConfig `yaml:"resourceLimits" json:"resourceLimits"`
+
+	// APIToken guards sensitive endpoints (e.g. /libp2p/sign) as a Bearer token; empty disables them
+	APIToken string `yaml:"apiToken" json:"apiToken"`
+	// AgentVersion is announced to peers by identify; defaults to sight-libp2p-node/<version>
+	AgentVersion string `yaml:"agentVersion" json:"agentVersion"`
+
+	// path of the config file this was loaded from (empty when none)
+	path string
+	// bindIP is BindInterface resolved by Validate (nil: all interfaces)
+	bindIP net.IP
+	// psk is PrivateNetworkKeyFile loaded by Validate (nil: public network)
+	psk pnet.PSK
+}
+
+// TimeoutConfig groups the timeouts used by the service
+type TimeoutConfig struct {
+	// Connect bounds peer lookups that aren't sends: public key, DID document, peer info, DHT refresh
+	Connect Duration `yaml:"connect" json:"connect"`
+	// Dial bounds DHT lookup + dial in ConnectByDIDOrMultiAddr: the connect phase of sends, pings
+	// and /libp2p/connect
+	Dial Duration `yaml:"dial" json:"dial"`
+	// Request bounds the send phase of a ping / direct send (stream open + write), after the
+	// dial, so a slow lookup doesn't leave no time to write
+	Request Duration `yaml:"request" json:"request"`
+	// Shutdown bounds the HTTP server shutdown
+	Shutdown Duration `yaml:"shutdown" json:"shutdown"`
+	// Drain bounds how long Stop waits for in-flight direct messages to be forwarded
+	Drain Duration `yaml:"drain" json:"drain"`
+	// DHTBootstrap bounds each DHT bootstrap attempt (failed attempts are retried with backoff)
+	DHTBootstrap Duration `yaml:"dhtBootstrap" json:"dhtBootstrap"`
+	// Publish bounds a pubsub publish triggered by /libp2p/send
+	Publish Duration `yaml:"publish" json:"publish"`
+	// WaitForPeers bounds the startup gate; startup continues (not ready) when it expires
+	WaitForPeers Duration `yaml:"waitForPeers" json:"waitForPeers"`
+	// FindPeer bounds the DHT lookup of /libp2p/find-peer
+	FindPeer Duration `yaml:"findPeer" json:"findPeer"`
+	// Tunnel bounds each forward of a received message to the tunnel backend, so a hung
+	// backend can't hold a forward (and a drain) forever
+	Tunnel Duration `yaml:"tunnel" json:"tunnel"`
+}
+
+// TunnelConfig selects the tunnel transport
+type TunnelConfig struct {
+	// Type is "http" (default) or "unix"
+	Type string `yaml:"type" json:"type"`
+	// URL overrides the http tunnel URL (default http://localhost:<apiPort><path>)
+	URL string `yaml:"url" json:"url"`
+	// Socket is the Unix socket path for the unix tunnel
+	Socket string `yaml:"socket" json:"socket"`
+	// Path is the HTTP path requested on the local backend (or over the Unix socket)
+	Path string `yaml:"path" json:"path"`
+	// Method is the HTTP method used to forward: POST (default), PUT or PATCH
+	Method string `yaml:"method" json:"method"`
+	// Disabled counts and logs received messages without forwarding them (relay-only nodes)
+	Disabled bool `yaml:"disabled" json:"disabled"`
+	// DryRun logs what would be forwarded instead of forwarding it
+	DryRun bool `yaml:"dryRun" json:"dryRun"`
+	// Topics maps a pubsub topic to its own http tunnel URL (the node subscribes to each);
+	// other topics and direct messages go to the default tunnel above
+	Topics map[string]string `yaml:"topics" json:"topics"`
+}
+
+// BootstrapDialConfig bounds the concurrent bootstrap dials
+type BootstrapDialConfig struct {
+	// Workers is the maximum number of concurrent dials
+	Workers int `yaml:"workers" json:"workers"`
+	// Timeout bounds each individual dial
+	Timeout Duration `yaml:"timeout" json:"timeout"`
+	// MinConnected is how many peers must connect before startup continues
+	// (fewer is fine if every dial has finished)
+	MinConnected int `yaml:"minConnected" json:"minConnected"`
+}
+
+// Duration is a time.Duration that reads/writes as a string like "15s" in YAML and JSON
+type Duration time.Duration
+
+// Std returns the value as a time.Duration
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// DefaultConfig returns the built-in defaults
+func DefaultConfig() *Config {
+	return &Config{
+		NodePort:   15050,
+		Libp2pPort: 4010,
+		APIPort:    8716,
+		DataDir:    getDataDir(),
+		BootstrapDial: BootstrapDialConfig{
+			Workers:      8,
+			Timeout:      Duration(10 * time.Second),
+			MinConnected: 1,
+		},
+		Transports: []string{"tcp"},
+		Security:   "both",
+		Topic:      p2pnode.DefaultTopic,
+		LogLevel:   "error",
+		Timeouts: TimeoutConfig{
+			Connect:      Duration(15 * time.Second),
+			Dial:         Duration(10 * time.Second),
+			Request:      Duration(5 * time.Second),
+			Shutdown:     Duration(10 * time.Second),
+			Drain:        Duration(10 * time.Second),
+			DHTBootstrap: Duration(30 * time.Second),
+			Publish:      Duration(5 * time.Second),
+			WaitForPeers: Duration(30 * time.Second),
+			FindPeer:     Duration(10 * time.Second),
+			Tunnel:       Duration(30 * time.Second),
+		},
+		UptimeLogInterval: Duration(time.Hour),
+		ForwardQueueSize:  256,
+		GatewayRouting:    "pubsub",
+
+		ObservedAddrMinPeers: 3,
+		MaxPayloadBytes:      1 << 20,
+		AgentVersion:         defaultAgentVersion(),
+		LatencyPing: LatencyPingConfig{
+			HistorySize: 60,
+		},
+		HealthMonitor: HealthMonitorConfig{
+			Interval:         Duration(30 * time.Second),
+			FailureThreshold: 3,
+			Action:           "log",
+		},
+		Tunnel: TunnelConfig{
+			Type:   "http",
+			Path:   "/libp2p/message",
+			Method: http.MethodPost,
+		},
+		ResolveCache: ResolveCacheConfig{
+			Size:            1024,
+			TTL:             Duration(10 * time.Minute),
+			MaxDialFailures: 2,
+		},
+		Registry: RegistryConfig{
+			Persist:      true,
+			TTL:          Duration(7 * 24 * time.Hour),
+			SaveInterval: Duration(5 * time.Minute),
+			MaxEntries:   10000,
+		},
+		Reputation: DefaultReputationConfig(),
+		PeerScore:  DefaultPeerScoreConfig(),
+		GossipSub:  DefaultGossipSubConfig(),
+
+		ResourceLimits: DefaultResourceLimitsConfig(),
+		LoadScore:      DefaultLoadScoreConfig(),
+		RecentMessages: RecentMessagesConfig{Size: 100},
+	}
+}
+
+// LoadConfig builds the effective config from defaults, an optional config
+// file (.yaml/.yml/.json) and the environment.
+func LoadConfig(path string) (*Config, error) {
+	cfg := DefaultConfig()
+	cfg.path = path
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, fmt.Errorf("load config file %s: %w", path, err)
+		}
+	}
+	cfg.applyEnv()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return json.Unmarshal(data, c)
+	case ".yaml", ".yml":
+		return yaml.Unmarshal(data, c)
+	default:
+		return fmt.Errorf("unsupported config file extension %q (use .yaml, .yml or .json)", filepath.Ext(path))
+	}
+}
+
+// applyEnv overrides config values with any environment variables that are set
+func (c *Config) applyEnv() {
+	c.NodePort = getEnvInt("NODE_PORT", c.NodePort)
+	c.Libp2pPort = getEnvInt("LIBP2P_REST_API", c.Libp2pPort)
+	c.APIPort = getEnvInt("API_PORT", c.APIPort)
+	if v := os.Getenv("IS_GATEWAY"); v != "" {
+		c.IsGateway = v == "1"
+	}
+	c.GatewayRouting = getEnvWithDefault("GATEWAY_ROUTING", c.GatewayRouting)
+	if v := os.Getenv("OBSERVER"); v != "" {
+		c.Observer = v == "1"
+	}
+	if v := os.Getenv("GATEWAY_LEGACY_KEY"); v != "" {
+		c.GatewayLegacyKey = v == "1"
+	}
+	if v := os.Getenv("BOOTSTRAP_ADDRS"); v != "" {
+		c.Bootstrap = strings.Split(v, ",")
+	}
+	c.BootstrapFile = getEnvWithDefault("BOOTSTRAP_FILE", c.BootstrapFile)
+	c.BootstrapDial.Workers = getEnvInt("BOOTSTRAP_DIAL_WORKERS", c.BootstrapDial.Workers)
+	c.BootstrapDial.Timeout = Duration(getEnvDuration("BOOTSTRAP_DIAL_TIMEOUT", c.BootstrapDial.Timeout.Std()))
+	c.BootstrapDial.MinConnected = getEnvInt("BOOTSTRAP_MIN_CONNECTED", c.BootstrapDial.MinConnected)
+	if v := os.Getenv("TRANSPORTS"); v != "" {
+		c.Transports = strings.Split(v, ",")
+	}
+	if v := os.Getenv("ENABLE_WEBRTC"); v != "" {
+		c.EnableWebRTC = v == "1"
+	}
+	c.BindInterface = getEnvWithDefault("BIND_INTERFACE", c.BindInterface)
+	c.DHTMode = getEnvWithDefault("DHT_MODE", c.DHTMode)
+	c.Security = getEnvWithDefault("SECURITY", c.Security)
+	c.Topic = getEnvWithDefault("TOPIC", c.Topic)
+	c.PrivateNetworkKeyFile = getEnvWithDefault("PRIVATE_NETWORK_KEY_FILE", c.PrivateNetworkKeyFile)
+	if v := os.Getenv("SIGHTAI_DATA_DIR"); v != "" {
+		c.DataDir = filepath.Join(v, "config")
+	}
+	c.DataDir = getEnvWithDefault("DATA_DIR", c.DataDir)
+	c.InstanceID = getEnvWithDefault("INSTANCE_ID", c.InstanceID)
+	c.LogLevel = getEnvWithDefault("LOG_LEVEL", c.LogLevel)
+	c.Timeouts.Connect = Duration(getEnvDuration("CONNECT_TIMEOUT", c.Timeouts.Connect.Std()))
+	c.Timeouts.Dial = Duration(getEnvDuration("DIAL_TIMEOUT", c.Timeouts.Dial.Std()))
+	c.Timeouts.Request = Duration(getEnvDuration("REQUEST_TIMEOUT", c.Timeouts.Request.Std()))
+	c.Timeouts.Shutdown = Duration(getEnvDuration("SHUTDOWN_TIMEOUT", c.Timeouts.Shutdown.Std()))
+	c.Timeouts.Drain = Duration(getEnvDuration("DRAIN_TIMEOUT", c.Timeouts.Drain.Std()))
+	c.Timeouts.DHTBootstrap = Duration(getEnvDuration("DHT_BOOTSTRAP_TIMEOUT", c.Timeouts.DHTBootstrap.Std()))
+	c.Timeouts.Publish = Duration(getEnvDuration("PUBLISH_TIMEOUT", c.Timeouts.Publish.Std()))
+	c.Timeouts.WaitForPeers = Duration(getEnvDuration("WAIT_FOR_PEERS_TIMEOUT", c.Timeouts.WaitForPeers.Std()))
+	c.Timeouts.FindPeer = Duration(getEnvDuration("FIND_PEER_TIMEOUT", c.Timeouts.FindPeer.Std()))
+	c.Timeouts.Tunnel = Duration(getEnvDuration("TUNNEL_TIMEOUT", c.Timeouts.Tunnel.Std()))
+	c.WaitForPeers = getEnvInt("WAIT_FOR_PEERS", c.WaitForPeers)
+	if v := os.Getenv("UPTIME_LOG_INTERVAL"); v != "" {
+		// "0" disables the periodic log, so getEnvDuration can't be used here
+		if d, err := time.ParseDuration(v); err == nil {
+			c.UptimeLogInterval = Duration(d)
+		}
+	}
+	if os.Getenv("LATENCY_PING_INTERVAL") != "" {
+		log.Printf("LATENCY_PING_INTERVAL is ignored: background latency samples come from the health monitor (HEALTH_CHECK_INTERVAL)")
+	}
+	c.LatencyPing.HistorySize = getEnvInt("LATENCY_HISTORY_SIZE", c.LatencyPing.HistorySize)
+	if v := os.Getenv("HEALTH_CHECK_INTERVAL"); v != "" {
+		// "0" disables the monitor
+		if d, err := time.ParseDuration(v); err == nil {
+			c.HealthMonitor.Interval = Duration(d)
+		}
+	}
+	c.HealthMonitor.FailureThreshold = getEnvInt("HEALTH_FAILURE_THRESHOLD", c.HealthMonitor.FailureThreshold)
+	c.HealthMonitor.Action = getEnvWithDefault("HEALTH_ACTION", c.HealthMonitor.Action)
+	c.APIToken = getEnvWithDefault("API_TOKEN", c.APIToken)
+	c.AgentVersion = getEnvWithDefault("AGENT_VERSION", c.AgentVersion)
+	c.MaxPayloadBytes = int64(getEnvInt("MAX_PAYLOAD_BYTES", int(c.MaxPayloadBytes)))
+	c.ForwardQueueSize = getEnvInt("FORWARD_QUEUE_SIZE", c.ForwardQueueSize)
+	c.Tunnel.Type = getEnvWithDefault("TUNNEL_TYPE", c.Tunnel.Type)
+	c.Tunnel.URL = getEnvWithDefault("TUNNEL_URL", c.Tunnel.URL)
+	c.Tunnel.Socket = getEnvWithDefault("TUNNEL_SOCKET", c.Tunnel.Socket)
+	c.Tunnel.Path = getEnvWithDefault("TUNNEL_PATH", c.Tunnel.Path)
+	c.Tunnel.Method = getEnvWithDefault("TUNNEL_METHOD", c.Tunnel.Method)
+	if v := os.Getenv("TUNNEL_DISABLED"); v != "" {
+		c.Tunnel.Disabled = v == "1"
+	}
+	if v := os.Getenv("DRY_RUN"); v != "" {
+		c.Tunnel.DryRun = v == "1"
+	}
+	if v := os.Getenv("TUNNEL_TOPICS"); v != "" {
+		// topic=url,topic=url
+		c.Tunnel.Topics = make(map[string]string)
+		for _, pair := range strings.Split(v, ",") {
+			topic, u, _ := strings.Cut(pair, "=")
+			c.Tunnel.Topics[strings.TrimSpace(topic)] = strings.TrimSpace(u)
+		}
+	}
+	c.ObservedAddrMinPeers = getEnvInt("OBSERVED_ADDR_MIN_PEERS", c.ObservedAddrMinPeers)
+	c.ResolveCache.Size = getEnvInt("RESOLVE_CACHE_SIZE", c.ResolveCache.Size)
+	c.ResolveCache.TTL = Duration(getEnvDuration("RESOLVE_CACHE_TTL", c.ResolveCache.TTL.Std()))
+	c.ResolveCache.MaxDialFailures = getEnvInt("RESOLVE_CACHE_MAX_DIAL_FAILURES", c.ResolveCache.MaxDialFailures)
+	if v := os.Getenv("REGISTRY_PERSIST"); v != "" {
+		c.Registry.Persist = v == "1"
+	}
+	c.Registry.TTL = Duration(getEnvDuration("REGISTRY_TTL", c.Registry.TTL.Std()))
+	c.Registry.SaveInterval = Duration(getEnvDuration("REGISTRY_SAVE_INTERVAL", c.Registry.SaveInterval.Std()))
+	c.Registry.MaxEntries = getEnvInt("REGISTRY_MAX_ENTRIES", c.Registry.MaxEntries)
+	c.Reputation.Threshold = getEnvInt("REPUTATION_THRESHOLD", c.Reputation.Threshold)
+	c.Reputation.BlockTTL = Duration(getEnvDuration("REPUTATION_BLOCK_TTL", c.Reputation.BlockTTL.Std()))
+	if v := os.Getenv("REPUTATION_PERSIST"); v != "" {
+		c.Reputation.Persist = v == "1"
+	}
+	c.LoadScore.MaxStreams = getEnvInt("LOAD_MAX_STREAMS", c.LoadScore.MaxStreams)
+	c.LoadScore.MaxHandlers = getEnvInt("LOAD_MAX_HANDLERS", c.LoadScore.MaxHandlers)
+	c.LoadScore.MaxConnections = getEnvInt("LOAD_MAX_CONNECTIONS", c.LoadScore.MaxConnections)
+	c.LoadScore.StreamsWeight = getEnvFloat("LOAD_WEIGHT_STREAMS", c.LoadScore.StreamsWeight)
+	c.LoadScore.HandlersWeight = getEnvFloat("LOAD_WEIGHT_HANDLERS", c.LoadScore.HandlersWeight)
+	c.LoadScore.ForwardQueueWeight = getEnvFloat("LOAD_WEIGHT_FORWARD_QUEUE", c.LoadScore.ForwardQueueWeight)
+	c.LoadScore.ConnectionsWeight = getEnvFloat("LOAD_WEIGHT_CONNECTIONS", c.LoadScore.ConnectionsWeight)
+
+	ps := &c.PeerScore
+	if v := os.Getenv("PEER_SCORE_ENABLED"); v != "" {
+		ps.Enabled = v == "1"
+	}
+	ps.GossipThreshold = getEnvFloat("PEER_SCORE_GOSSIP_THRESHOLD", ps.GossipThreshold)
+	ps.PublishThreshold = getEnvFloat("PEER_SCORE_PUBLISH_THRESHOLD", ps.PublishThreshold)
+	ps.GraylistThreshold = getEnvFloat("PEER_SCORE_GRAYLIST_THRESHOLD", ps.GraylistThreshold)
+	ps.AcceptPXThreshold = getEnvFloat("PEER_SCORE_ACCEPT_PX_THRESHOLD", ps.AcceptPXThreshold)
+	ps.OpportunisticGraftThreshold = getEnvFloat("PEER_SCORE_OPPORTUNISTIC_GRAFT_THRESHOLD", ps.OpportunisticGraftThreshold)
+	ps.TopicWeight = getEnvFloat("PEER_SCORE_TOPIC_WEIGHT", ps.TopicWeight)
+	ps.InvalidMessageDeliveriesWeight = getEnvFloat("PEER_SCORE_INVALID_MESSAGE_WEIGHT", ps.InvalidMessageDeliveriesWeight)
+	ps.IPColocationFactorWeight = getEnvFloat("PEER_SCORE_IP_COLOCATION_WEIGHT", ps.IPColocationFactorWeight)
+	ps.IPColocationFactorThreshold = getEnvInt("PEER_SCORE_IP_COLOCATION_THRESHOLD", ps.IPColocationFactorThreshold)
+	ps.BehaviourPenaltyWeight = getEnvFloat("PEER_SCORE_BEHAVIOUR_PENALTY_WEIGHT", ps.BehaviourPenaltyWeight)
+
+	gs := &c.GossipSub
+	gs.D = getEnvInt("GOSSIPSUB_D", gs.D)
+	gs.Dlo = getEnvInt("GOSSIPSUB_DLO", gs.Dlo)
+	gs.Dhi = getEnvInt("GOSSIPSUB_DHI", gs.Dhi)
+	gs.Heartbeat = Duration(getEnvDuration("GOSSIPSUB_HEARTBEAT", gs.Heartbeat.Std()))
+	gs.HistoryLength = getEnvInt("GOSSIPSUB_HISTORY_LENGTH", gs.HistoryLength)
+	gs.HistoryGossip = getEnvInt("GOSSIPSUB_HISTORY_GOSSIP", gs.HistoryGossip)
+	gs.MaxMessageSize = getEnvInt("GOSSIPSUB_MAX_MESSAGE_SIZE", gs.MaxMessageSize)
+
+	c.RecentMessages.Size = getEnvInt("RECENT_MESSAGES_SIZE", c.RecentMessages.Size)
+	if v := os.Getenv("RECENT_MESSAGES_PAYLOADS"); v != "" {
+		c.RecentMessages.IncludePayloads = v == "1"
+	}
+
+	rl := &c.ResourceLimits
+	rl.MaxMemoryMB = getEnvInt("RCMGR_MAX_MEMORY_MB", rl.MaxMemoryMB)
+	rl.MaxFDs = getEnvInt("RCMGR_MAX_FDS", rl.MaxFDs)
+	rl.LimitsFile = getEnvWithDefault("RCMGR_LIMITS_FILE", rl.LimitsFile)
+}
+
+// Validate checks the config and normalizes list values (trims blanks, lower-cases transports)
+func (c *Config) Validate() error {
+	for name, port := range map[string]int{"nodePort": c.NodePort, "libp2pPort": c.Libp2pPort, "apiPort": c.APIPort} {
+		if port <= 0 || port > 65535 {
+			return fmt.Errorf("invalid %s: %d", name, port)
+		}
+	}
+
+	c.GatewayRouting = strings.ToLower(strings.TrimSpace(c.GatewayRouting))
+	if c.GatewayRouting != "pubsub" && c.GatewayRouting != "direct" {
+		return fmt.Errorf("invalid gatewayRouting %q (use pubsub or direct)", c.GatewayRouting)
+	}
+
+	var bootstrap []string
+	seen := make(map[string]bool)
+	for _, addr := range c.Bootstrap {
+		addr = strings.TrimSpace(addr)
+		if addr == "" || seen[addr] {
+			continue
+		}
+		if _, err := peer.AddrInfoFromString(addr); err != nil {
+			return fmt.Errorf("invalid bootstrap addr %q: %w", addr, err)
+		}
+		seen[addr] = true
+		bootstrap = append(bootstrap, addr)
+	}
+	if c.BootstrapFile != "" {
+		fromFile, err := readBootstrapFile(c.BootstrapFile)
+		if err != nil {
+			return fmt.Errorf("invalid bootstrapFile: %w", err)
+		}
+		for _, addr := range fromFile {
+			if !seen[addr] {
+				seen[addr] = true
+				bootstrap = append(bootstrap, addr)
+			}
+		}
+	}
+	c.Bootstrap = bootstrap
+
+	if c.BootstrapDial.Workers <= 0 {
+		return fmt.Errorf("invalid bootstrapDial.workers: %d", c.BootstrapDial.Workers)
+	}
+	if c.BootstrapDial.Timeout <= 0 {
+		return fmt.Errorf("invalid bootstrapDial.timeout: %s", c.BootstrapDial.Timeout.Std())
+	}
+	if c.BootstrapDial.MinConnected < 0 {
+		return fmt.Errorf("invalid bootstrapDial.minConnected: %d", c.BootstrapDial.MinConnected)
+	}
+
+	// 只开 WebRTC 时可以不配 transports（仅供浏览器连接的节点）
+	if len(c.Transports) == 0 && !c.EnableWebRTC {
+		return fmt.Errorf("at least one transport is required (or enableWebrtc)")
+	}
+	for i, t := range c.Transports {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t != "tcp" && t != "quic" {
+			return fmt.Errorf("unsupported transport %q (use tcp or quic)", t)
+		}
+		c.Transports[i] = t
+	}
+
+	c.Topic = strings.TrimSpace(c.Topic)
+	if c.Topic == "" {
+		return fmt.Errorf("topic is required")
+	}
+	c.psk = nil
+	if c.PrivateNetworkKeyFile != "" {
+		if slices.Contains(c.Transports, "quic") {
+			return fmt.Errorf("privateNetworkKeyFile requires transports [tcp]: QUIC doesn't support private networks")
+		}
+		if c.EnableWebRTC {
+			return fmt.Errorf("privateNetworkKeyFile can't be used with enableWebrtc: WebRTC doesn't support private networks")
+		}
+		psk, err := p2pnode.LoadPSK(c.PrivateNetworkKeyFile)
+		if err != nil {
+			return fmt.Errorf("invalid privateNetworkKeyFile: %w", err)
+		}
+		c.psk = psk
+	}
+
+	c.DataDir = strings.TrimSpace(c.DataDir)
+	if err := checkDataDir(c.DataDir); err != nil {
+		return fmt.Errorf("invalid dataDir: %w", err)
+	}
+
+	c.InstanceID = strings.TrimSpace(c.InstanceID)
+	if strings.ContainsFunc(c.InstanceID, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.')
+	}) {
+		return fmt.Errorf("invalid instanceId %q (use letters, digits, '-', '_' and '.')", c.InstanceID)
+	}
+
+	c.BindInterface = strings.TrimSpace(c.BindInterface)
+	c.bindIP = nil
+	if c.BindInterface != "" {
+		ip, err := resolveBindIP(c.BindInterface)
+		if err != nil {
+			return fmt.Errorf("invalid bindInterface: %w", err)
+		}
+		c.bindIP = ip
+	}
+
+	c.Security = strings.ToLower(strings.TrimSpace(c.Security))
+	if c.Security != "noise" && c.Security != "tls" && c.Security != "both" {
+		return fmt.Errorf("invalid security %q (use noise, tls or both)", c.Security)
+	}
+
+	c.DHTMode = strings.ToLower(strings.TrimSpace(c.DHTMode))
+	if c.DHTMode != "" && c.DHTMode != "server" && c.DHTMode != "client" && c.DHTMode != "auto" {
+		return fmt.Errorf("invalid dhtMode %q (use server, client or auto)", c.DHTMode)
+	}
+
+	if _, err := golog.LevelFromString(c.LogLevel); err != nil {
+		return fmt.Errorf("invalid logLevel %q: %w", c.LogLevel, err)
+	}
+
+	if c.UptimeLogInterval < 0 {
+		return fmt.Errorf("invalid uptimeLogInterval: %s", c.UptimeLogInterval.Std())
+	}
+	if c.WaitForPeers < 0 {
+		return fmt.Errorf("invalid waitForPeers: %d", c.WaitForPeers)
+	}
+
+	if c.LatencyPing.HistorySize <= 0 {
+		return fmt.Errorf("invalid latencyPing.historySize: %d", c.LatencyPing.HistorySize)
+	}
+
+	c.HealthMonitor.Action = strings.ToLower(strings.TrimSpace(c.HealthMonitor.Action))
+	if err := c.HealthMonitor.Validate(); err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(c.AgentVersion) == "" || strings.ContainsFunc(c.AgentVersion, unicode.IsControl) {
+		return fmt.Errorf("invalid agentVersion: %q", c.AgentVersion)
+	}
+	if c.MaxPayloadBytes <= 0 {
+		return fmt.Errorf("invalid maxPayloadBytes: %d", c.MaxPayloadBytes)
+	}
+	if c.ForwardQueueSize <= 0 {
+		return fmt.Errorf("invalid forwardQueueSize: %d", c.ForwardQueueSize)
+	}
+	c.Tunnel.Type = strings.ToLower(strings.TrimSpace(c.Tunnel.Type))
+	switch c.Tunnel.Type {
+	case "http":
+	case "unix":
+		if c.Tunnel.Socket == "" {
+			return fmt.Errorf("tunnel.socket is required for the unix tunnel")
+		}
+	default:
+		return fmt.Errorf("invalid tunnel.type %q (use http or unix)", c.Tunnel.Type)
+	}
+	if c.Tunnel.Disabled && c.Tunnel.DryRun {
+		return fmt.Errorf("tunnel.disabled and tunnel.dryRun are mutually exclusive")
+	}
+	c.Tunnel.Method = strings.ToUpper(strings.TrimSpace(c.Tunnel.Method))
+	switch c.Tunnel.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+	default:
+		return fmt.Errorf("invalid tunnel.method %q (use POST, PUT or PATCH)", c.Tunnel.Method)
+	}
+	if !strings.HasPrefix(c.Tunnel.Path, "/") {
+		return fmt.Errorf("invalid tunnel.path %q: must start with /", c.Tunnel.Path)
+	}
+	if c.Tunnel.Type == "http" && !c.Tunnel.Disabled {
+		if err := checkTunnelURL(c.TunnelAPI()); err != nil {
+			return fmt.Errorf("invalid tunnel URL %q: %v", c.TunnelAPI(), err)
+		}
+	}
+	for topic, u := range c.Tunnel.Topics {
+		if topic == "" {
+			return fmt.Errorf("tunnel.topics: empty topic name")
+		}
+		if err := checkTunnelURL(u); err != nil {
+			return fmt.Errorf("invalid tunnel.topics[%q] %q: %v", topic, u, err)
+		}
+	}
+
+	if c.ObservedAddrMinPeers <= 0 {
+		return fmt.Errorf("invalid observedAddrMinPeers: %d", c.ObservedAddrMinPeers)
+	}
+	if c.ResolveCache.Size <= 0 {
+		return fmt.Errorf("invalid resolveCache.size: %d", c.ResolveCache.Size)
+	}
+	if c.ResolveCache.TTL <= 0 {
+		return fmt.Errorf("invalid resolveCache.ttl: %s", c.ResolveCache.TTL.Std())
+	}
+	if c.ResolveCache.MaxDialFailures <= 0 {
+		return fmt.Errorf("invalid resolveCache.maxDialFailures: %d", c.ResolveCache.MaxDialFailures)
+	}
+	if err := c.Registry.Validate(); err != nil {
+		return err
+	}
+	if err := c.Reputation.Validate(); err != nil {
+		return err
+	}
+	if err := c.GossipSub.Validate(); err != nil {
+		return err
+	}
+	if err := c.LoadScore.Validate(); err != nil {
+		return err
+	}
+	if err := c.ResourceLimits.Validate(); err != nil {
+		return err
+	}
+	if c.RecentMessages.Size < 0 {
+		return fmt.Errorf("invalid recentMessages.size: %d", c.RecentMessages.Size)
+	}
+
+	for name, d := range map[string]Duration{"connect": c.Timeouts.Connect, "dial": c.Timeouts.Dial, "request": c.Timeouts.Request, "shutdown": c.Timeouts.Shutdown, "drain": c.Timeouts.Drain, "dhtBootstrap": c.Timeouts.DHTBootstrap, "publish": c.Timeouts.Publish, "waitForPeers": c.Timeouts.WaitForPeers, "findPeer": c.Timeouts.FindPeer, "tunnel": c.Timeouts.Tunnel} {
+		if d <= 0 {
+			return fmt.Errorf("invalid %s timeout: %s", name, d.Std())
+		}
+	}
+	return nil
+}
+
+// checkDataDir creates dir if missing and checks that files can be created in it, so a
+// read-only or mistyped data dir fails at startup rather than on the first save
+func checkDataDir(dir string) error {
+	if dir == "" {
+		return errors.New("empty path")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("not writable: %w", err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// readBootstrapFile reads a newline-delimited list of bootstrap multiaddrs. Everything after
+// a # is a comment; blank lines are skipped. Invalid entries are reported with their line.
+func readBootstrapFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []string
+	for i, line := range strings.Split(string(data), "\n") {
+		if idx := strings.IndexByte(line, '#'); idx >= 0 {
+			line = line[:idx]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if _, err := peer.AddrInfoFromString(line); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid bootstrap addr %q: %w", path, i+1, line, err)
+		}
+		addrs = append(addrs, line)
+	}
+	return addrs, nil
+}
+
+// KeypairFile is the keypair file name in the data dir; gateways don't share the hoster's device key.
+// With an InstanceID the name carries it, so instances sharing a data dir keep distinct identities.
+func (c *Config) KeypairFile() string {
+	if c.IsGateway {
+		return c.instanceFile("gateway-keypair")
+	}
+	return c.instanceFile("device-keypair")
+}
+
+// instanceFile is base.json, or base-<InstanceID>.json when an InstanceID is set
+func (c *Config) instanceFile(base string) string {
+	if c.InstanceID == "" {
+		return base + ".json"
+	}
+	return base + "-" + c.InstanceID + ".json"
+}
+
+// ListenAddrs returns the libp2p listen multiaddrs for the configured transports,
+// on BindInterface's IP if one is set
+func (c *Config) ListenAddrs() []string {
+	ip := "/ip4/0.0.0.0"
+	if c.bindIP != nil {
+		if ip4 := c.bindIP.To4(); ip4 != nil {
+			ip = "/ip4/" + ip4.String()
+		} else {
+			ip = "/ip6/" + c.bindIP.String()
+		}
+	}
+	var addrs []string
+	for _, t := range c.Transports {
+		switch t {
+		case "tcp":
+			addrs = append(addrs, fmt.Sprintf("%s/tcp/%d", ip, c.NodePort))
+		case "quic":
+			addrs = append(addrs, fmt.Sprintf("%s/udp/%d/quic-v1", ip, c.NodePort))
+		}
+	}
+	if c.EnableWebRTC {
+		// certhash 由 WebRTC 传输在监听时补上
+		addrs = append(addrs, fmt.Sprintf("%s/udp/%d/webrtc-direct", ip, c.NodePort))
+	}
+	return addrs
+}
+
+// resolveBindIP turns an interface name or IP into an IP assigned to this machine.
+// An interface's first IPv4 address wins, then its first global IPv6 address.
+func resolveBindIP(spec string) (net.IP, error) {
+	if ip := net.ParseIP(spec); ip != nil {
+		if ip.IsUnspecified() {
+			return nil, fmt.Errorf("%s is not a specific address; leave bindInterface empty to listen on all interfaces", spec)
+		}
+		addrs, err := net.InterfaceAddrs()
+		if err != nil {
+			return nil, fmt.Errorf("list interface addresses: %w", err)
+		}
+		for _, a := range addrs {
+			if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return ip, nil
+			}
+		}
+		return nil, fmt.Errorf("%s is not assigned to any local interface", spec)
+	}
+
+	iface, err := net.InterfaceByName(spec)
+	if err != nil {
+		return nil, fmt.Errorf("%q is neither a local IP nor an interface: %w", spec, err)
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return nil, fmt.Errorf("interface %s is down", spec)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("interface %s addresses: %w", spec, err)
+	}
+	var v6 net.IP
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ip4 := ipNet.IP.To4(); ip4 != nil {
+			return ip4, nil
+		}
+		if v6 == nil && !ipNet.IP.IsLinkLocalUnicast() {
+			v6 = ipNet.IP
+		}
+	}
+	if v6 == nil {
+		return nil, fmt.Errorf("interface %s has no usable IP address", spec)
+	}
+	return v6, nil
+}
+
+// SecurityOpts returns the libp2p security transports in preference order. Peers
+// negotiate the first one both support, so "both" offers Noise before TLS.
+func (c *Config) SecurityOpts() []libp2p.Option {
+	switch c.Security {
+	case "noise":
+		return []libp2p.Option{libp2p.Security(noise.ID, noise.New)}
+	case "tls":
+		return []libp2p.Option{libp2p.Security(tls.ID, tls.New)}
+	default:
+		return []libp2p.Option{libp2p.Security(noise.ID, noise.New), libp2p.Security(tls.ID, tls.New)}
+	}
+}
+
+// PrivateNetworkOpts returns libp2p.PrivateNetwork with the loaded key, or nothing on the public network
+func (c *Config) PrivateNetworkOpts() []libp2p.Option {
+	if c.psk == nil {
+		return nil
+	}
+	return []libp2p.Option{libp2p.PrivateNetwork(c.psk)}
+}
+
+// DHTModeOpt resolves the configured DHT mode. Gateways default to server mode since
+// they are publicly reachable; hosters default to auto so NAT'd nodes only serve the
+// DHT once AutoNAT reports them as publicly reachable.
+func (c *Config) DHTModeOpt() dht.ModeOpt {
+	mode := c.DHTMode
+	if mode == "" {
+		mode = "auto"
+		if c.IsGateway {
+			mode = "server"
+		}
+	}
+	switch mode {
+	case "server":
+		return dht.ModeServer
+	case "client":
+		return dht.ModeClient
+	default:
+		return dht.ModeAuto
+	}
+}
+
+// TunnelAPI returns the local backend URL that received messages are forwarded to
+func (c *Config) TunnelAPI() string {
+	if c.Tunnel.URL != "" {
+		return c.Tunnel.URL
+	}
+	return "http://localhost:" + strconv.Itoa(c.APIPort) + c.Tunnel.Path
+}
+
+// TunnelTarget describes where the default tunnel forwards to (a URL, or unix:<socket><path>)
+func (c *Config) TunnelTarget() string {
+	if c.Tunnel.Type == "http" {
+		return c.TunnelAPI()
+	}
+	return "unix:" + c.Tunnel.Socket + c.Tunnel.Path
+}
+
+// NewTunnelForwarder builds the configured tunnel, routing topics listed in tunnel.topics
+// to their own URL. In-process forwarding (TunnelFunc) can't be expressed in config; set
+// it with SetTunnelForwarder instead.
+func (c *Config) NewTunnelForwarder() TunnelForwarder {
+	fallback := c.newDefaultTunnel()
+	if len(c.Tunnel.Topics) == 0 || c.Tunnel.Disabled {
+		return fallback
+	}
+	routes := make(map[string]TunnelForwarder, len(c.Tunnel.Topics))
+	for topic, u := range c.Tunnel.Topics {
+		log.Printf("[Tunnel] Topic %q -> %s %s", topic, c.Tunnel.Method, u)
+		if c.Tunnel.DryRun {
+			routes[topic] = discardTunnel{dryRunTarget: u}
+		} else {
+			routes[topic] = NewHTTPTunnel(u).WithMethod(c.Tunnel.Method)
+		}
+	}
+	return &topicTunnel{routes: routes, fallback: fallback}
+}
+
+func (c *Config) newDefaultTunnel() TunnelForwarder {
+	target := c.TunnelTarget()
+	switch {
+	case c.Tunnel.Disabled:
+		log.Printf("[Tunnel] Disabled: received messages are counted and logged, not forwarded")
+		return discardTunnel{}
+	case c.Tunnel.DryRun:
+		log.Printf("[Tunnel] Dry run: logging what would be forwarded to %s", target)
+		return discardTunnel{dryRunTarget: target}
+	case c.Tunnel.Type == "unix":
+		return NewUnixTunnel(c.Tunnel.Socket, c.Tunnel.Path).WithMethod(c.Tunnel.Method)
+	}
+	return NewHTTPTunnel(target).WithMethod(c.Tunnel.Method)
+}
+
+// checkTunnelURL catches obviously broken tunnel URLs (no scheme, no host, ...)
+func checkTunnelURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("scheme must be http or https")
+	}
+	if u.Host == "" || u.Port() == "0" {
+		return fmt.Errorf("missing host or port")
+	}
+	return nil
+}
+
+// Path returns the config file path, or "" when running from env/flags only
+func (c *Config) Path() string {
+	return c.path
+}
+
+// String renders the effective config for logging
+func (c *Config) String() string {
+	redacted := *c
+	if redacted.APIToken != "" {
+		redacted.APIToken = "***"
+	}
+	out, _ := json.MarshalIndent(redacted, "", "  ")
+	return string(out)
+}
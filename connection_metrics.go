@@ -0,0 +1,73 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// connLogNotifiee logs and counts every connection opened and closed, with the peer's DID
+// when derivable, the direction and the remote multiaddr
+func (s *Libp2pNodeService) connLogNotifiee() network.Notifiee {
+	return &network.NotifyBundle{
+		ConnectedF: func(n network.Network, c network.Conn) {
+			dir := strings.ToLower(c.Stat().Direction.String())
+			peerConnects.WithLabelValues(dir).Inc()
+			log.Printf("[Conn] Connected %s (%s) %s security=%s", peerLabel(c), dir, c.RemoteMultiaddr(), connSecurity(c))
+		},
+		DisconnectedF: func(n network.Network, c network.Conn) {
+			dir := strings.ToLower(c.Stat().Direction.String())
+			peerDisconnects.WithLabelValues(dir).Inc()
+			log.Printf("[Conn] Disconnected %s (%s) %s after %s", peerLabel(c), dir, c.RemoteMultiaddr(),
+				time.Since(c.Stat().Opened).Round(time.Second))
+		},
+	}
+}
+
+// peerLabel is "<did> <peerId>", or just the peer ID when no DID can be derived
+func peerLabel(c network.Conn) string {
+	pid := c.RemotePeer().String()
+	if did, err := PeerIdToDID(pid); err == nil {
+		return did + " " + pid
+	}
+	return pid
+}
+
+// connSecurity is the negotiated security protocol; QUIC has TLS built in and reports none
+func connSecurity(c network.Conn) string {
+	state := c.ConnState()
+	if state.Security == "" && strings.HasPrefix(state.Transport, "quic") {
+		return "quic-tls"
+	}
+	return string(state.Security)
+}
+
+// openStreamsCollector reports the currently open streams per protocol at scrape time
+type openStreamsCollector struct {
+	network network.Network
+}
+
+var openStreamsDesc = prometheus.NewDesc(
+	"sight_open_streams",
+	"Currently open streams by protocol (streams still negotiating have an empty protocol).",
+	[]string{"protocol"}, nil,
+)
+
+func (c openStreamsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- openStreamsDesc
+}
+
+func (c openStreamsCollector) Collect(ch chan<- prometheus.Metric) {
+	counts := make(map[string]int)
+	for _, conn := range c.network.Conns() {
+		for _, st := range conn.GetStreams() {
+			counts[string(st.Protocol())]++
+		}
+	}
+	for proto, n := range counts {
+		ch <- prometheus.MustNewConstMetric(openStreamsDesc, prometheus.GaugeValue, float64(n), proto)
+	}
+}
@@ -2,12 +2,22 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/mr-tron/base58"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Libp2pNodeController struct {
@@ -18,20 +28,143 @@ func NewLibp2pNodeController(service *Libp2pNodeService) *Libp2pNodeController {
 	return &Libp2pNodeController{service}
 }
 
+// decodeLimitedJSON decodes the request body into v, reading at most MaxPayloadBytes.
+// A body over the limit returns ErrPayloadTooLarge.
+func (c *Libp2pNodeController) decodeLimitedJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	r.Body = http.MaxBytesReader(w, r.Body, c.service.config.MaxPayloadBytes)
+	err := json.NewDecoder(r.Body).Decode(v)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return fmt.Errorf("%w: request body over %d bytes", ErrPayloadTooLarge, tooLarge.Limit)
+	}
+	return err
+}
+
+// validateRecipient checks the "to" of a send request: a non-empty string, and a
+// well-formed key if it is a did:sight DID. Other forms (e.g. the "gateway" alias) pass.
+func validateRecipient(v interface{}) (string, error) {
+	to, ok := v.(string)
+	if !ok {
+		if v == nil {
+			return "", errors.New("missing")
+		}
+		return "", errors.New("must be a string")
+	}
+	to = strings.TrimSpace(to)
+	if to == "" {
+		return "", errors.New("empty")
+	}
+	if strings.HasPrefix(to, "did:") {
+		if _, err := DIDToPublicKey(to); err != nil {
+			return "", err
+		}
+	}
+	return to, nil
+}
+
+// senderContentType reads the optional X-Sight-Content-Type header, the payload type the
+// recipient's tunnel should forward with instead of JSON
+func senderContentType(w http.ResponseWriter, r *http.Request) (string, bool) {
+	contentType := r.Header.Get("X-Sight-Content-Type")
+	if contentType == "" {
+		return "", true
+	}
+	if err := checkContentType(contentType); err != nil {
+		writeErrorDetails(w, 400, "invalid_content_type", err.Error(), map[string]string{"header": "X-Sight-Content-Type"})
+		return "", false
+	}
+	return contentType, true
+}
+
+// SendHandler publishes the body to its "to" DID. With ?waitAck=<duration> (e.g. 5s) the
+// recipient is asked for an ack and the response waits for it. X-Sight-Content-Type declares
+// the type the recipient's tunnel forwards the body with.
 func (c *Libp2pNodeController) SendHandler(w http.ResponseWriter, r *http.Request) {
 	var tunnelMsg map[string]interface{}
-	err := json.NewDecoder(r.Body).Decode(&tunnelMsg)
+	var typeErr *json.UnmarshalTypeError
+	if err := c.decodeLimitedJSON(w, r, &tunnelMsg); errors.Is(err, ErrPayloadTooLarge) {
+		writeServiceError(r.Context(), w, "Send failed", err)
+		return
+	} else if errors.As(err, &typeErr) {
+		writeError(w, 400, "invalid_json", "Body must be a JSON object")
+		return
+	} else if err != nil {
+		writeError(w, 400, "invalid_json", "Invalid JSON: "+err.Error())
+		return
+	}
+	to, err := validateRecipient(tunnelMsg["to"])
 	if err != nil {
-		http.Error(w, "Invalid JSON", 400)
+		writeErrorDetails(w, 400, "invalid_request", "Invalid to: "+err.Error(), map[string]string{"field": "to"})
+		return
+	}
+	contentType, ok := senderContentType(w, r)
+	if !ok {
+		return
+	}
+	// send → publish → (对端) receive → tunnel.forward 同属一条 trace
+	ctx, span := tracer.Start(r.Context(), "send", trace.WithAttributes(attribute.String("sight.to", to)))
+	defer span.End()
+	r = r.WithContext(ctx)
+	if v := r.URL.Query().Get("waitAck"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil || timeout <= 0 {
+			writeErrorDetails(w, 400, "invalid_request", "Invalid waitAck duration", map[string]string{"field": "waitAck"})
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		ack, err := c.service.SendAndWaitAck(ctx, to, tunnelMsg, contentType)
+		if errors.Is(err, context.DeadlineExceeded) {
+			writeError(w, 504, "ack_timeout", "No ack received: "+err.Error())
+			return
+		}
+		if err != nil {
+			writeServiceError(ctx, w, "Send failed", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":    "acked",
+			"messageId": ack.MessageID,
+			"ack":       ack,
+			"rttMs":     ack.RTT.Milliseconds(),
+		})
 		return
 	}
 	libp2pMsg := map[string]interface{}{
-		"to":      tunnelMsg["to"],
+		"to":      to,
 		"payload": tunnelMsg,
 	}
-	c.service.HandleOutgoingMessage(libp2pMsg)
+	if contentType != "" {
+		libp2pMsg["contentType"] = contentType
+	}
+	msgID, err := c.service.HandleOutgoingMessage(r.Context(), libp2pMsg)
+	if err != nil {
+		writeServiceError(r.Context(), w, "Send failed", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "messageId": msgID})
+}
+
+// PublishHandler publishes the JSON body to the topic as is, without the {"to", "payload"}
+// wrapper of SendHandler; for broadcasts and tests of raw propagation
+func (c *Libp2pNodeController) PublishHandler(w http.ResponseWriter, r *http.Request) {
+	topic := mux.Vars(r)["topic"]
+	var body json.RawMessage
+	if err := c.decodeLimitedJSON(w, r, &body); errors.Is(err, ErrPayloadTooLarge) {
+		writeServiceError(r.Context(), w, "Publish failed", err)
+		return
+	} else if err != nil {
+		writeError(w, 400, "invalid_json", "Invalid JSON: "+err.Error())
+		return
+	}
+	if err := c.service.PublishRaw(r.Context(), topic, body); err != nil {
+		writeServiceError(r.Context(), w, "Publish failed", err)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "topic": topic, "bytes": len(body)})
 }
 
 // PeerId -> MultiAddr
@@ -41,16 +174,32 @@ func (c *Libp2pNodeController) FindPeerHandler(w http.ResponseWriter, r *http.Re
 
 	// println(`try to find `, peerIdStr)
 
-	addrs, err := FindPeerAddr(r.Context(), c.service.dht, peerIdStr)
+	if err := c.service.dhtReady(); err != nil {
+		writeServiceError(r.Context(), w, "Peer lookup unavailable", err)
+		return
+	}
+	addrs, err := FindPeerAddr(r.Context(), c.service.dht, peerIdStr, c.service.config.Timeouts.FindPeer.Std())
+	if errors.Is(err, ErrPeerNotFound) {
+		writeError(w, 404, "peer_not_found", "Peer not found: "+err.Error())
+		return
+	}
 	if err != nil {
-		http.Error(w, "Peer not found: "+err.Error(), 404)
+		// 400 invalid_target, or 504 timeout when the lookup didn't finish in time
+		writeServiceError(r.Context(), w, "Peer lookup failed", err)
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	resp := map[string]interface{}{
 		"peerId": peerIdStr,
 		"addrs":  addrs,
-	})
+	}
+	// 能从 peerId 反推出公钥时，顺便带上 DID（角色来自 identify）
+	if pid, err := peer.Decode(peerIdStr); err == nil {
+		if did, err := c.service.peerDID(pid); err == nil {
+			resp["did"] = did
+		}
+	}
+	json.NewEncoder(w).Encode(resp)
 }
 
 // PeerId -> PublicKey(bs58)
@@ -61,8 +210,13 @@ func (c *Libp2pNodeController) GetPublicKeyHandler(w http.ResponseWriter, r *htt
 	// println(`try to find `, peerIdStr)
 
 	pubKeyBytes, err := c.service.GetPublicKeyByPeerId(r.Context(), peerIdStr)
+	// 查找超时不算 not found，交给 writeServiceError 返回 504
+	if errors.Is(err, ErrPeerNotFound) && !errors.Is(err, ErrDHTNotReady) && !errors.Is(err, context.DeadlineExceeded) {
+		writeError(w, 404, "peer_not_found", "Failed to get public key: "+err.Error())
+		return
+	}
 	if err != nil {
-		http.Error(w, "Failed to get public key: "+err.Error(), 404)
+		writeServiceError(r.Context(), w, "Failed to get public key", err)
 		return
 	}
 
@@ -81,7 +235,22 @@ func (c *Libp2pNodeController) ConnectHandler(w http.ResponseWriter, r *http.Req
 
 	err := c.service.ConnectByDIDOrMultiAddr(r.Context(), did)
 	if err != nil {
-		http.Error(w, "Failed to connect: "+err.Error(), 400)
+		writeServiceError(r.Context(), w, "Failed to connect", err)
+		return
+	}
+
+	// ?keepalive=true: 保护连接，断开后自动重连，直到 DELETE /libp2p/sticky/{did}
+	if keepalive, _ := strconv.ParseBool(r.URL.Query().Get("keepalive")); keepalive {
+		sticky, err := c.service.KeepAlive(did)
+		if err != nil {
+			writeServiceError(r.Context(), w, "Failed to keep alive", err)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":        "connected",
+			"did/multiAddr": did,
+			"sticky":        sticky,
+		})
 		return
 	}
 
@@ -91,23 +260,88 @@ func (c *Libp2pNodeController) ConnectHandler(w http.ResponseWriter, r *http.Req
 	})
 }
 
+// ConnectedHandler reports the connectedness of a peer without dialing it
+func (c *Libp2pNodeController) ConnectedHandler(w http.ResponseWriter, r *http.Request) {
+	state, err := c.service.GetConnectedness(mux.Vars(r)["did"])
+	if err != nil {
+		writeServiceError(r.Context(), w, "Invalid peer", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+// StickyPeersHandler lists the connections kept alive by connect?keepalive=true
+func (c *Libp2pNodeController) StickyPeersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"sticky": c.service.GetStickyPeers()})
+}
+
+// ReleaseStickyHandler stops keeping a connection alive
+func (c *Libp2pNodeController) ReleaseStickyHandler(w http.ResponseWriter, r *http.Request) {
+	did := mux.Vars(r)["did"]
+	if err := c.service.ReleaseSticky(did); err != nil {
+		writeServiceError(r.Context(), w, "Release failed", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "released", "did/multiAddr": did})
+}
+
 func (c *Libp2pNodeController) GetNeighborsHandler(w http.ResponseWriter, r *http.Request) {
 	neighbors := c.service.GetNeighbors()
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"neighbors": neighbors,
+		"details":   c.service.GetNeighborDetails(),
 	})
 }
 
+// ProtectPeerHandler protects a peer from connection manager pruning (POST) or lifts the
+// protection (DELETE)
+func (c *Libp2pNodeController) ProtectPeerHandler(w http.ResponseWriter, r *http.Request) {
+	state, err := c.service.ProtectPeer(mux.Vars(r)["did"], r.Method == http.MethodPost)
+	if err != nil {
+		writeServiceError(r.Context(), w, "Protect failed", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+// TagPeerHandler sets {"tag", "weight"} on a peer; weight 0 removes the tag
+func (c *Libp2pNodeController) TagPeerHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Tag    string `json:"tag"`
+		Weight *int   `json:"weight"`
+	}
+	if err := c.decodeLimitedJSON(w, r, &req); errors.Is(err, ErrPayloadTooLarge) {
+		writeServiceError(r.Context(), w, "Tag failed", err)
+		return
+	} else if err != nil {
+		writeError(w, 400, "invalid_json", "Invalid JSON: "+err.Error())
+		return
+	}
+	if req.Weight == nil {
+		writeErrorDetails(w, 400, "invalid_request", "Missing weight", map[string]string{"field": "weight"})
+		return
+	}
+	state, err := c.service.TagPeer(mux.Vars(r)["did"], req.Tag, *req.Weight)
+	if err != nil {
+		writeServiceError(r.Context(), w, "Tag failed", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
 func (c *Libp2pNodeController) PingHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	did := vars["did"]
 
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-	defer cancel()
-
-	rtt, err := c.service.PingPeer(ctx, did)
+	// 拨号与 ping 分阶段限时：Timeouts.Dial + Timeouts.Request
+	rtt, err := c.service.PingPeer(r.Context(), did)
 	if err != nil {
-		http.Error(w, "Ping failed: "+err.Error(), 500)
+		writeServiceError(r.Context(), w, "Ping failed", err)
 		return
 	}
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -120,18 +354,575 @@ func (c *Libp2pNodeController) SendDirectHandler(w http.ResponseWriter, r *http.
 	vars := mux.Vars(r)
 	did := vars["did"]
 	var msg map[string]interface{}
-	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
-		http.Error(w, "Invalid JSON", 400)
+	if err := c.decodeLimitedJSON(w, r, &msg); errors.Is(err, ErrPayloadTooLarge) {
+		writeServiceError(r.Context(), w, "Send failed", err)
+		return
+	} else if err != nil {
+		writeError(w, 400, "invalid_json", "Invalid JSON: "+err.Error())
+		return
+	}
+	contentType, ok := senderContentType(w, r)
+	if !ok {
 		return
 	}
+	if contentType != "" {
+		msg["contentType"] = contentType
+	}
 	payload, _ := json.Marshal(msg)
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-	defer cancel()
-	err := c.service.SendDirectMessage(ctx, did, payload)
+	proto, err := c.service.SendDirectMessage(r.Context(), did, payload)
+	if err != nil {
+		writeServiceError(r.Context(), w, "Send failed", err)
+		return
+	}
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "protocol": string(proto)})
+}
+
+// SendDirectRawHandler sends the request body as-is to a peer over the raw direct protocol.
+// The request Content-Type is carried along and used when the receiver forwards it to its
+// tunnel (default application/octet-stream).
+func (c *Libp2pNodeController) SendDirectRawHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	did := vars["did"]
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, c.service.config.MaxPayloadBytes)
+	body, err := io.ReadAll(r.Body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeServiceError(r.Context(), w, "Send failed", fmt.Errorf("%w: request body over %d bytes", ErrPayloadTooLarge, tooLarge.Limit))
+		return
+	} else if err != nil {
+		writeError(w, 400, "invalid_request", "Failed to read body: "+err.Error())
+		return
+	}
+	msgID, err := c.service.SendDirectRaw(r.Context(), did, contentType, body)
 	if err != nil {
-		http.Error(w, "Send failed: "+err.Error(), 500)
+		writeServiceError(r.Context(), w, "Send failed", err)
 		return
 	}
 	w.WriteHeader(200)
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":    "ok",
+		"protocol":  string(directRawProtocol),
+		"messageId": msgID,
+	})
+}
+
+// ReputationHandler lists peers with a lowered score or a block, lowest score first
+func (c *Libp2pNodeController) ReputationHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"threshold": c.service.config.Reputation.Threshold,
+		"blockTtl":  c.service.config.Reputation.BlockTTL,
+		"peers":     c.service.GetReputation(),
+	})
+}
+
+// BlockPeerHandler blocks a peer for ?ttl (default reputation.blockTtl) and disconnects it
+func (c *Libp2pNodeController) BlockPeerHandler(w http.ResponseWriter, r *http.Request) {
+	ttl := c.service.config.Reputation.BlockTTL.Std()
+	if v := r.URL.Query().Get("ttl"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeErrorDetails(w, 400, "invalid_request", "Invalid ttl duration", map[string]string{"field": "ttl"})
+			return
+		}
+		ttl = d
+	}
+	rep, err := c.service.BlockPeer(mux.Vars(r)["did"], ttl)
+	if err != nil {
+		writeServiceError(r.Context(), w, "Block failed", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rep)
+}
+
+// UnblockPeerHandler lifts a block (404 not_blocked if there is none)
+func (c *Libp2pNodeController) UnblockPeerHandler(w http.ResponseWriter, r *http.Request) {
+	if err := c.service.UnblockPeer(mux.Vars(r)["did"]); err != nil {
+		writeServiceError(r.Context(), w, "Unblock failed", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "unblocked"})
+}
+
+// directBroadcastResult is one entry of the broadcast-direct response, sorted by peer ID
+type directBroadcastResult struct {
+	PeerID   string `json:"peerId"`
+	DID      string `json:"did,omitempty"`
+	Status   string `json:"status"` // ok or error
+	Code     string `json:"code,omitempty"`
+	HTTPCode int    `json:"httpStatus"`
+	Error    string `json:"error,omitempty"`
+}
+
+// BroadcastDirectHandler sends the JSON body as the payload of a direct message to every
+// connected neighbor within timeouts.dial + timeouts.request: 200 when all succeeded, 207 otherwise
+func (c *Libp2pNodeController) BroadcastDirectHandler(w http.ResponseWriter, r *http.Request) {
+	var payload json.RawMessage
+	if err := c.decodeLimitedJSON(w, r, &payload); errors.Is(err, ErrPayloadTooLarge) {
+		writeServiceError(r.Context(), w, "Broadcast failed", err)
+		return
+	} else if err != nil {
+		writeError(w, 400, "invalid_json", "Invalid JSON: "+err.Error())
+		return
+	}
+	if len(payload) == 0 || string(payload) == "null" {
+		writeError(w, 400, "missing_payload", "Body must be the JSON payload to broadcast")
+		return
+	}
+	if err := c.service.checkSendable(); err != nil {
+		writeServiceError(r.Context(), w, "Broadcast failed", err)
+		return
+	}
+
+	// 每个目标都是拨号 + 发送两个阶段，整批不超过两者之和
+	ctx, cancel := context.WithTimeout(r.Context(), c.service.config.Timeouts.Dial.Std()+c.service.config.Timeouts.Request.Std())
+	defer cancel()
+	outcomes := c.service.BroadcastDirect(ctx, payload)
+
+	results := make([]directBroadcastResult, 0, len(outcomes))
+	failed := 0
+	for pid, err := range outcomes {
+		res := directBroadcastResult{PeerID: pid, Status: "ok", HTTPCode: 200}
+		if id, derr := peer.Decode(pid); derr == nil {
+			if did, derr := c.service.peerDID(id); derr == nil {
+				res.DID = did
+			}
+		}
+		if err != nil {
+			failed++
+			res.Status = "error"
+			res.HTTPCode, res.Code = errorStatus(ctx, err)
+			res.Error = err.Error()
+		}
+		results = append(results, res)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].PeerID < results[j].PeerID })
+	status, code := "ok", 200
+	if failed > 0 {
+		status, code = "partial", 207
+		if failed == len(results) {
+			status = "error"
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    status,
+		"neighbors": len(results),
+		"succeeded": len(results) - failed,
+		"failed":    failed,
+		"results":   results,
+	})
+}
+
+// directBatchResult is one entry of the send-direct-batch response, in request order
+type directBatchResult struct {
+	Index    int    `json:"index"`
+	DID      string `json:"did"`
+	Status   string `json:"status"` // ok or error
+	Code     string `json:"code,omitempty"`
+	HTTPCode int    `json:"httpStatus"` // what p2p-send would have answered for this item
+	Protocol string `json:"protocol,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// SendDirectBatchHandler sends [{did, payload}, ...] concurrently under one shared request
+// timeout. Items fail independently: 200 when all succeeded, 207 with per-item results otherwise.
+func (c *Libp2pNodeController) SendDirectBatchHandler(w http.ResponseWriter, r *http.Request) {
+	var items []DirectBatchItem
+	if err := c.decodeLimitedJSON(w, r, &items); errors.Is(err, ErrPayloadTooLarge) {
+		writeServiceError(r.Context(), w, "Send failed", err)
+		return
+	} else if err != nil {
+		writeError(w, 400, "invalid_json", "Invalid JSON: expected [{\"did\": ..., \"payload\": ...}]")
+		return
+	}
+	if len(items) == 0 || len(items) > maxDirectBatchItems {
+		writeErrorDetails(w, 400, "invalid_request", fmt.Sprintf("Batch must have 1 to %d items", maxDirectBatchItems),
+			map[string]int{"items": len(items), "maxItems": maxDirectBatchItems})
+		return
+	}
+
+	// 每个目标都是拨号 + 发送两个阶段，整批不超过两者之和
+	ctx, cancel := context.WithTimeout(r.Context(), c.service.config.Timeouts.Dial.Std()+c.service.config.Timeouts.Request.Std())
+	defer cancel()
+	outcomes := c.service.SendDirectBatch(ctx, items)
+
+	results := make([]directBatchResult, len(items))
+	failed := 0
+	for i, o := range outcomes {
+		res := directBatchResult{Index: i, DID: items[i].DID, Status: "ok", HTTPCode: 200, Protocol: string(o.Protocol)}
+		if o.Err != nil {
+			failed++
+			res.Status = "error"
+			res.HTTPCode, res.Code = errorStatus(ctx, o.Err)
+			res.Error = o.Err.Error()
+		}
+		results[i] = res
+	}
+	status, code := "ok", 200
+	if failed > 0 {
+		status, code = "partial", 207
+		if failed == len(items) {
+			status = "error"
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    status,
+		"succeeded": len(items) - failed,
+		"failed":    failed,
+		"results":   results,
+	})
+}
+
+func (c *Libp2pNodeController) GetPeerScoresHandler(w http.ResponseWriter, r *http.Request) {
+	scores, updatedAt := c.service.GetPeerScores()
+	resp := map[string]interface{}{
+		"enabled": c.service.config.PeerScore.Enabled,
+		"scores":  scores,
+	}
+	if !updatedAt.IsZero() {
+		resp["updatedAt"] = updatedAt.Format(time.RFC3339)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// BootstrapReloadHandler replaces the bootstrap list at runtime.
+// Body: {"addrs": "a,b,c", "disconnectRemoved": true}; without addrs the config file and the
+// bootstrap file are re-read.
+func (c *Libp2pNodeController) BootstrapReloadHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Addrs             string `json:"addrs"`
+		DisconnectRemoved bool   `json:"disconnectRemoved"`
+	}
+	if err := c.decodeLimitedJSON(w, r, &req); errors.Is(err, ErrPayloadTooLarge) {
+		writeServiceError(r.Context(), w, "Bootstrap reload failed", err)
+		return
+	} else if err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, 400, "invalid_json", "Invalid JSON: "+err.Error())
+		return
+	}
+
+	var addrs []string
+	if req.Addrs != "" {
+		for _, addr := range strings.Split(req.Addrs, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				addrs = append(addrs, addr)
+			}
+		}
+	} else {
+		path := c.service.config.Path()
+		if path == "" && c.service.config.BootstrapFile == "" {
+			writeError(w, 400, "invalid_request", "No addrs given and no config or bootstrap file to reload")
+			return
+		}
+		cfg, err := LoadConfig(path)
+		if err != nil {
+			writeError(w, 400, "invalid_config", "Failed to reload config: "+err.Error())
+			return
+		}
+		addrs = cfg.Bootstrap
+	}
+
+	added, removed, err := c.service.ReloadBootstrap(r.Context(), addrs, req.DisconnectRemoved)
+	if err != nil {
+		writeError(w, 400, "invalid_bootstrap", "Invalid bootstrap list: "+err.Error())
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"bootstrap": c.service.GetBootstrap(),
+		"added":     added,
+		"removed":   removed,
+	})
+}
+
+func (c *Libp2pNodeController) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.service.GetStatus())
+}
+
+func (c *Libp2pNodeController) DHTHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.service.GetDHTDiagnostics())
+}
+
+// DHTQueriesHandler lists the recent timed DHT queries (find_peer, bootstrap), newest first
+func (c *Libp2pNodeController) DHTQueriesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"queries": c.service.GetDHTQueries(),
+	})
+}
+
+func (c *Libp2pNodeController) DHTRefreshHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), c.service.config.Timeouts.Connect.Std())
+	defer cancel()
+
+	if err := c.service.RefreshDHT(ctx); err != nil {
+		writeServiceError(ctx, w, "DHT refresh failed", err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":           "refreshed",
+		"routingTableSize": c.service.GetDHTStatus().RoutingTableSize,
+	})
+}
+
+func (c *Libp2pNodeController) BandwidthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.service.GetBandwidth())
+}
+
+func (c *Libp2pNodeController) DIDDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	did := mux.Vars(r)["did"]
+
+	doc, err := c.service.GetDIDDocument(r.Context(), did)
+	if errors.Is(err, ErrPeerNotFound) && !errors.Is(err, ErrDHTNotReady) {
+		writeError(w, 404, "peer_not_found", "Failed to resolve DID: "+err.Error())
+		return
+	}
+	if err != nil {
+		writeServiceError(r.Context(), w, "Failed to resolve DID", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/did+json")
+	json.NewEncoder(w).Encode(doc)
+}
+
+// SignHandler signs {"data": base64} with the device key; guarded by requireAPIToken
+func (c *Libp2pNodeController) SignHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Data string `json:"data"`
+	}
+	if err := c.decodeLimitedJSON(w, r, &req); errors.Is(err, ErrPayloadTooLarge) {
+		writeServiceError(r.Context(), w, "Sign failed", err)
+		return
+	} else if err != nil {
+		writeError(w, 400, "invalid_json", "Invalid JSON: "+err.Error())
+		return
+	}
+	data, err := base64.StdEncoding.DecodeString(req.Data)
+	if err != nil {
+		writeErrorDetails(w, 400, "invalid_request", "Invalid base64 data: "+err.Error(), map[string]string{"field": "data"})
+		return
+	}
+
+	sig := c.service.Sign(data)
+	json.NewEncoder(w).Encode(map[string]string{
+		"signature": base64.StdEncoding.EncodeToString(sig),
+		"publicKey": base64.StdEncoding.EncodeToString(c.service.keypair.PublicKey),
+		"did":       c.service.did,
+	})
+}
+
+// VerifyHandler checks {"did", "data": base64, "signature": base64}
+func (c *Libp2pNodeController) VerifyHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		DID       string `json:"did"`
+		Data      string `json:"data"`
+		Signature string `json:"signature"`
+	}
+	if err := c.decodeLimitedJSON(w, r, &req); errors.Is(err, ErrPayloadTooLarge) {
+		writeServiceError(r.Context(), w, "Verify failed", err)
+		return
+	} else if err != nil {
+		writeError(w, 400, "invalid_json", "Invalid JSON: "+err.Error())
+		return
+	}
+	data, err := base64.StdEncoding.DecodeString(req.Data)
+	if err != nil {
+		writeErrorDetails(w, 400, "invalid_request", "Invalid base64 data: "+err.Error(), map[string]string{"field": "data"})
+		return
+	}
+	sig, err := base64.StdEncoding.DecodeString(req.Signature)
+	if err != nil {
+		writeErrorDetails(w, 400, "invalid_request", "Invalid base64 signature: "+err.Error(), map[string]string{"field": "signature"})
+		return
+	}
+
+	valid, err := c.service.Verify(req.DID, data, sig)
+	if err != nil {
+		writeErrorDetails(w, 400, "invalid_request", "Verify failed: "+err.Error(), map[string]string{"field": "did"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"did":   req.DID,
+		"valid": valid,
+	})
+}
+
+func (c *Libp2pNodeController) RegistryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"peers": c.service.GetRegistry(),
+	})
+}
+
+func (c *Libp2pNodeController) PeerProtocolsHandler(w http.ResponseWriter, r *http.Request) {
+	protos, err := c.service.GetPeerProtocols(mux.Vars(r)["peerId"])
+	if err != nil {
+		writeServiceError(r.Context(), w, "Failed to get protocols", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(protos)
+}
+
+// PeerIdentifyHandler returns the agent/protocol version and addresses learned via identify
+func (c *Libp2pNodeController) PeerIdentifyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), c.service.config.Timeouts.Request.Std())
+	defer cancel()
+	info, err := c.service.GetPeerIdentify(ctx, mux.Vars(r)["peerId"])
+	if err != nil {
+		writeServiceError(ctx, w, "Failed to get identify info", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// DrainHandler puts the node into draining mode and answers once in-flight work reached the
+// tunnel or ?timeout (default timeouts.drain) hit: 200 when drained, 504 otherwise. ?leave=true
+// publishes a leave notice, ?exit=true shuts the node down after answering.
+func (c *Libp2pNodeController) DrainHandler(w http.ResponseWriter, r *http.Request) {
+	timeout := c.service.config.Timeouts.Drain.Std()
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeErrorDetails(w, 400, "invalid_request", "Invalid timeout duration", map[string]string{"field": "timeout"})
+			return
+		}
+		timeout = d
+	}
+	leave := r.URL.Query().Get("leave") == "true"
+	exit := r.URL.Query().Get("exit") == "true"
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	res := c.service.Drain(ctx, leave)
+	w.Header().Set("Content-Type", "application/json")
+	if !res.Drained {
+		w.WriteHeader(http.StatusGatewayTimeout)
+	}
+	json.NewEncoder(w).Encode(res)
+	if exit {
+		c.service.RequestExit()
+	}
+}
+
+// ReadyzHandler answers 200 once the node has enough peers, 503 before and while draining
+func (c *Libp2pNodeController) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	readiness := c.service.GetReadiness()
+	w.Header().Set("Content-Type", "application/json")
+	if !readiness.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(readiness)
+}
+
+// RecentMessagesHandler lists the last received messages with their forward result
+func (c *Libp2pNodeController) RecentMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"size":            c.service.config.RecentMessages.Size,
+		"includePayloads": c.service.config.RecentMessages.IncludePayloads,
+		"messages":        c.service.GetRecentMessages(),
+	})
+}
+
+// ForwardStatsHandler reports tunnel forward counts per recipient DID
+func (c *Libp2pNodeController) ForwardStatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"recipients": c.service.GetForwardStats(),
+	})
+}
+
+// ObserverMessagesHandler lists what an observer node has seen (404 when not an observer)
+func (c *Libp2pNodeController) ObserverMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	msgs, ok := c.service.GetObservedMessages()
+	if !ok {
+		writeError(w, 404, "not_observer", "Not in observer mode")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(msgs)
+}
+
+// LoadHandler returns the load score and the counts it is computed from
+func (c *Libp2pNodeController) LoadHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.service.GetLoad())
+}
+
+// ResourcesHandler returns the resource manager usage per system/transient/service/protocol/peer scope
+func (c *Libp2pNodeController) ResourcesHandler(w http.ResponseWriter, r *http.Request) {
+	usage, err := c.service.GetResourceUsage()
+	if err != nil {
+		writeServiceError(r.Context(), w, "Failed to get resource usage", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
+
+func (c *Libp2pNodeController) ObservedAddrsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"minPeers": c.service.config.ObservedAddrMinPeers,
+		"addrs":    c.service.GetObservedAddrs(),
+	})
+}
+
+// AddrsHandler returns the full <addr>/p2p/<peerId> multiaddrs other operators can connect to;
+// ?loopback=true also includes 127.0.0.1 / ::1
+func (c *Libp2pNodeController) AddrsHandler(w http.ResponseWriter, r *http.Request) {
+	loopback, _ := strconv.ParseBool(r.URL.Query().Get("loopback"))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"peerId": c.service.node.ID().String(),
+		"addrs":  c.service.GetListenAddrs(loopback),
+	})
+}
+
+func (c *Libp2pNodeController) TopicPeersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.service.GetTopicPeers(mux.Vars(r)["name"]))
+}
+
+func (c *Libp2pNodeController) PeerByDIDHandler(w http.ResponseWriter, r *http.Request) {
+	info, err := c.service.GetPeerInfoByDID(r.Context(), mux.Vars(r)["did"])
+	if err != nil {
+		writeServiceError(r.Context(), w, "Failed to look up peer", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+func (c *Libp2pNodeController) PeerLatencyHandler(w http.ResponseWriter, r *http.Request) {
+	stats, err := c.service.GetPeerLatency(mux.Vars(r)["peerId"])
+	if err != nil {
+		writeError(w, 400, "invalid_target", "Invalid peer ID: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+func (c *Libp2pNodeController) NeighborHealthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":   c.service.config.HealthMonitor.Interval > 0,
+		"neighbors": c.service.GetNeighborHealth(),
+	})
 }
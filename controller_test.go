@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/mr-tron/base58"
+)
+
+func TestSendHandlerValidatesBody(t *testing.T) {
+	s := newTestService(t, testConfig(t, "http://127.0.0.1:1"))
+	c := NewLibp2pNodeController(s)
+	_, did := testPeer(t)
+
+	for _, tc := range []struct {
+		name   string
+		body   string
+		status int
+		code   string
+		field  string
+	}{
+		{"missing to", `{"payload": 1}`, 400, "invalid_request", "to"},
+		{"empty to", `{"to": "", "payload": 1}`, 400, "invalid_request", "to"},
+		{"blank to", `{"to": "  ", "payload": 1}`, 400, "invalid_request", "to"},
+		{"null to", `{"to": null}`, 400, "invalid_request", "to"},
+		{"non-string to", `{"to": 42}`, 400, "invalid_request", "to"},
+		{"malformed DID", `{"to": "did:sight:hoster:not-base58!"}`, 400, "invalid_request", "to"},
+		{"array body", `[{"to": "` + did + `"}]`, 400, "invalid_json", ""},
+		{"string body", `"hello"`, 400, "invalid_json", ""},
+		{"number body", `1`, 400, "invalid_json", ""},
+		{"null body", `null`, 400, "invalid_request", "to"},
+		{"invalid JSON", `{"to": `, 400, "invalid_json", ""},
+		{"valid", `{"to": "` + did + `", "payload": 1}`, 200, "", ""},
+		{"gateway alias", `{"to": "gateway", "payload": 1}`, 200, "", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := serveHandler(c.SendHandler, "POST", "/libp2p/send", strings.NewReader(tc.body), nil)
+			if w.Code != tc.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tc.status, w.Body)
+			}
+			if tc.status == http.StatusOK {
+				return
+			}
+			e := decodeAPIError(t, w)
+			if e.Code != tc.code {
+				t.Errorf("code = %q, want %q", e.Code, tc.code)
+			}
+			details, _ := e.Details.(map[string]interface{})
+			if field, _ := details["field"].(string); field != tc.field {
+				t.Errorf("details.field = %q, want %q", field, tc.field)
+			}
+		})
+	}
+}
+
+func TestValidateRecipient(t *testing.T) {
+	_, did := testPeer(t)
+	for _, tc := range []struct {
+		in      interface{}
+		want    string
+		wantErr string
+	}{
+		{did, did, ""},
+		{"  " + did + " ", did, ""},
+		{"gateway", "gateway", ""},
+		{"12D3KooWSomePeer", "12D3KooWSomePeer", ""},
+		{nil, "", "missing"},
+		{"", "", "empty"},
+		{"\t", "", "empty"},
+		{true, "", "must be a string"},
+		{map[string]interface{}{}, "", "must be a string"},
+	} {
+		got, err := validateRecipient(tc.in)
+		if tc.wantErr != "" {
+			if err == nil || err.Error() != tc.wantErr {
+				t.Errorf("validateRecipient(%#v) error = %v, want %q", tc.in, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("validateRecipient(%#v) = %q, %v, want %q", tc.in, got, err, tc.want)
+		}
+	}
+	if _, err := validateRecipient("did:sight:hoster:abc"); err == nil {
+		t.Error("DID with a short key accepted")
+	}
+}
+
+func TestConnectHandlerUnresolvableDID(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		setup  func(d *fakeDHT)
+		status int
+		code   string
+	}{
+		{"lookup finds nothing", nil, http.StatusBadGateway, "peer_not_found"},
+		{"lookup never resolves", func(d *fakeDHT) { d.delay = time.Minute }, http.StatusGatewayTimeout, "dial_timeout"},
+		{"routing table empty", func(d *fakeDHT) { d.emptyTable() }, http.StatusServiceUnavailable, "dht_not_ready"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, _, d := newFakeService(t, func(c *Config) { c.Timeouts.Dial = Duration(200 * time.Millisecond) })
+			if tc.setup != nil {
+				tc.setup(d)
+			}
+			// a valid DID of a peer nobody can find
+			_, did := testPeer(t)
+
+			start := time.Now()
+			w := serveHandler(NewLibp2pNodeController(s).ConnectHandler, "POST", "/connect/"+did, nil, map[string]string{"did": did})
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("handler took %v, want it bounded by timeouts.dial", elapsed)
+			}
+			if w.Code != tc.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tc.status, w.Body)
+			}
+			if e := decodeAPIError(t, w); e.Code != tc.code {
+				t.Errorf("code = %q, want %q", e.Code, tc.code)
+			}
+		})
+	}
+}
+
+func TestGetPublicKeyHandler(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// setup returns the peer ID to look up and the key expected on success
+		setup  func(t *testing.T, h *fakeHost, d *fakeDHT) (string, crypto.PubKey)
+		status int
+		code   string
+		dials  int
+	}{
+		{
+			name: "identity peer ID embeds the key",
+			setup: func(t *testing.T, h *fakeHost, d *fakeDHT) (string, crypto.PubKey) {
+				p, _ := h.addPeer(t)
+				pub, _ := p.ID.ExtractPublicKey()
+				return p.ID.String(), pub
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "invalid embedded key",
+			setup: func(t *testing.T, h *fakeHost, d *fakeDHT) (string, crypto.PubKey) {
+				return identityPeerID(t, []byte{0x08, 0x01, 0x12, 0x20}), nil
+			},
+			status: http.StatusBadRequest, code: "invalid_key",
+		},
+		{
+			name: "not a peer ID",
+			setup: func(t *testing.T, h *fakeHost, d *fakeDHT) (string, crypto.PubKey) {
+				return "not-a-peer", nil
+			},
+			status: http.StatusBadRequest, code: "invalid_target",
+		},
+		{
+			name: "hashed key in the peerstore",
+			setup: func(t *testing.T, h *fakeHost, d *fakeDHT) (string, crypto.PubKey) {
+				p, pub := h.addHashedPeer(t, false)
+				h.Peerstore().AddPubKey(p.ID, pub)
+				return p.ID.String(), pub
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "hashed key from the handshake after connect",
+			setup: func(t *testing.T, h *fakeHost, d *fakeDHT) (string, crypto.PubKey) {
+				p, pub := h.addHashedPeer(t, true)
+				d.publish(p)
+				return p.ID.String(), pub
+			},
+			status: http.StatusOK, dials: 1,
+		},
+		{
+			name: "connected peer without a retrievable key",
+			setup: func(t *testing.T, h *fakeHost, d *fakeDHT) (string, crypto.PubKey) {
+				p, _ := h.addHashedPeer(t, false)
+				d.publish(p)
+				return p.ID.String(), nil
+			},
+			status: http.StatusUnprocessableEntity, code: "no_public_key", dials: 1,
+		},
+		{
+			name: "not in the DHT",
+			setup: func(t *testing.T, h *fakeHost, d *fakeDHT) (string, crypto.PubKey) {
+				p, _ := h.addHashedPeer(t, true)
+				return p.ID.String(), nil
+			},
+			status: http.StatusNotFound, code: "peer_not_found",
+		},
+		{
+			name: "found but unreachable",
+			setup: func(t *testing.T, h *fakeHost, d *fakeDHT) (string, crypto.PubKey) {
+				p, _ := h.addHashedPeer(t, true)
+				p.Unreachable = true
+				d.publish(p)
+				return p.ID.String(), nil
+			},
+			status: http.StatusBadGateway, code: "peer_unreachable", dials: 1,
+		},
+		{
+			name: "lookup hits timeouts.connect",
+			setup: func(t *testing.T, h *fakeHost, d *fakeDHT) (string, crypto.PubKey) {
+				p, _ := h.addHashedPeer(t, true)
+				d.publish(p)
+				d.delay = time.Minute
+				return p.ID.String(), nil
+			},
+			status: http.StatusGatewayTimeout, code: "timeout",
+		},
+		{
+			name: "routing table empty",
+			setup: func(t *testing.T, h *fakeHost, d *fakeDHT) (string, crypto.PubKey) {
+				p, _ := h.addHashedPeer(t, true)
+				d.emptyTable()
+				return p.ID.String(), nil
+			},
+			status: http.StatusServiceUnavailable, code: "dht_not_ready",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, h, d := newFakeService(t, func(c *Config) { c.Timeouts.Connect = Duration(200 * time.Millisecond) })
+			pid, want := tc.setup(t, h, d)
+
+			w := serveHandler(NewLibp2pNodeController(s).GetPublicKeyHandler, "GET", "/libp2p/public-key/"+pid, nil, map[string]string{"peerId": pid})
+			if w.Code != tc.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tc.status, w.Body)
+			}
+			if got := h.dialCount(); got != tc.dials {
+				t.Errorf("dials = %d, want %d", got, tc.dials)
+			}
+			if tc.status != http.StatusOK {
+				if e := decodeAPIError(t, w); e.Code != tc.code {
+					t.Errorf("code = %q, want %q", e.Code, tc.code)
+				}
+				return
+			}
+			var body map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			raw, _ := want.Raw()
+			if body["publicKey"] != base58.Encode(raw) {
+				t.Errorf("publicKey = %s, want %s", body["publicKey"], base58.Encode(raw))
+			}
+		})
+	}
+}
+
+func TestFindPeerHandlerNonexistentPeer(t *testing.T) {
+	const findPeerTimeout = 300 * time.Millisecond
+	for _, tc := range []struct {
+		name   string
+		setup  func(h *fakeHost, d *fakeDHT) string
+		status int
+		code   string
+	}{
+		{
+			name: "lookup never resolves",
+			setup: func(h *fakeHost, d *fakeDHT) string {
+				d.delay = time.Hour
+				pid, _ := testPeer(t)
+				return pid.String()
+			},
+			status: http.StatusGatewayTimeout, code: "timeout",
+		},
+		{
+			name: "lookup finishes without the peer",
+			setup: func(h *fakeHost, d *fakeDHT) string {
+				pid, _ := testPeer(t)
+				return pid.String()
+			},
+			status: http.StatusNotFound, code: "peer_not_found",
+		},
+		{
+			name: "routing table empty",
+			setup: func(h *fakeHost, d *fakeDHT) string {
+				d.emptyTable()
+				pid, _ := testPeer(t)
+				return pid.String()
+			},
+			status: http.StatusServiceUnavailable, code: "dht_not_ready",
+		},
+		{
+			name:   "not a peer ID",
+			setup:  func(h *fakeHost, d *fakeDHT) string { return "not-a-peer" },
+			status: http.StatusBadRequest, code: "invalid_target",
+		},
+		{
+			name: "found",
+			setup: func(h *fakeHost, d *fakeDHT) string {
+				p, _ := h.addPeer(t)
+				d.publish(p)
+				return p.ID.String()
+			},
+			status: http.StatusOK,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, h, d := newFakeService(t, func(c *Config) { c.Timeouts.FindPeer = Duration(findPeerTimeout) })
+			pid := tc.setup(h, d)
+
+			start := time.Now()
+			w := serveHandler(NewLibp2pNodeController(s).FindPeerHandler, "GET", "/libp2p/find-peer/"+pid, nil, map[string]string{"peerId": pid})
+			if elapsed := time.Since(start); elapsed > findPeerTimeout+time.Second {
+				t.Errorf("response took %v, want it bounded by timeouts.findPeer (%v)", elapsed, findPeerTimeout)
+			}
+			if w.Code != tc.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tc.status, w.Body)
+			}
+			if tc.status == http.StatusOK {
+				var body map[string]interface{}
+				json.NewDecoder(w.Body).Decode(&body)
+				if addrs, _ := body["addrs"].([]interface{}); len(addrs) != 1 {
+					t.Errorf("addrs = %v, want the published address", body["addrs"])
+				}
+				return
+			}
+			if e := decodeAPIError(t, w); e.Code != tc.code {
+				t.Errorf("code = %q, want %q", e.Code, tc.code)
+			}
+		})
+	}
+}
+
+func TestSignAndVerifyHandlersLimitBody(t *testing.T) {
+	s, _, _ := newFakeService(t, func(c *Config) { c.MaxPayloadBytes = 1024 })
+	c := NewLibp2pNodeController(s)
+	big := `{"did": "` + s.did + `", "data": "` + strings.Repeat("A", 2048) + `"}`
+
+	for name, h := range map[string]http.HandlerFunc{"sign": c.SignHandler, "verify": c.VerifyHandler} {
+		w := serveHandler(h, "POST", "/libp2p/"+name, strings.NewReader(big), nil)
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("%s of an oversized body: status = %d, want 413: %s", name, w.Code, w.Body)
+		}
+		if e := decodeAPIError(t, w); e.Code != "payload_too_large" {
+			t.Errorf("%s: code = %q, want payload_too_large", name, e.Code)
+		}
+	}
+	if w := serveHandler(c.SignHandler, "POST", "/libp2p/sign", strings.NewReader(`{"data": "aGVsbG8="}`), nil); w.Code != http.StatusOK {
+		t.Errorf("sign within the limit: status = %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestTagPeerHandlerLimitsBody(t *testing.T) {
+	s, _, _ := newFakeService(t, func(c *Config) { c.MaxPayloadBytes = 1024 })
+	_, did := testPeer(t)
+	body := `{"tag": "` + strings.Repeat("t", 2048) + `", "weight": 1}`
+	w := serveHandler(NewLibp2pNodeController(s).TagPeerHandler, "POST", "/libp2p/peer/"+did+"/tag", strings.NewReader(body), map[string]string{"did": did})
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413: %s", w.Code, w.Body)
+	}
+	if e := decodeAPIError(t, w); e.Code != "payload_too_large" {
+		t.Errorf("code = %q, want payload_too_large", e.Code)
+	}
+}
+
+func TestBootstrapReloadHandlerLimitsBody(t *testing.T) {
+	s, _, _ := newFakeService(t, func(c *Config) { c.MaxPayloadBytes = 1024 })
+	body := `{"addrs": "` + strings.Repeat("a", 2048) + `"}`
+	w := serveHandler(NewLibp2pNodeController(s).BootstrapReloadHandler, "POST", "/libp2p/bootstrap/reload", strings.NewReader(body), nil)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413: %s", w.Code, w.Body)
+	}
+	if e := decodeAPIError(t, w); e.Code != "payload_too_large" {
+		t.Errorf("code = %q, want payload_too_large", e.Code)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// dhtBootstrapMaxBackoff caps the delay between DHT bootstrap retries
+const dhtBootstrapMaxBackoff = time.Minute
+
+// DHTBootstrapStatus is the outcome of the most recent DHT bootstrap attempt
+type DHTBootstrapStatus struct {
+	Attempts    int       `json:"attempts"`
+	LastAttempt time.Time `json:"lastAttempt,omitempty"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+// dhtBootstrapState records bootstrap attempts for the status endpoints
+type dhtBootstrapState struct {
+	mu     sync.Mutex
+	status DHTBootstrapStatus
+}
+
+func (st *dhtBootstrapState) record(err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.status.Attempts++
+	st.status.LastAttempt = time.Now()
+	st.status.LastError = ""
+	if err != nil {
+		st.status.LastError = err.Error()
+	}
+}
+
+func (st *dhtBootstrapState) get() DHTBootstrapStatus {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.status
+}
+
+// bootstrapDHT bootstraps the DHT, each attempt bounded by Timeouts.DHTBootstrap, and
+// retries with exponential backoff until the routing table is populated or ctx is cancelled.
+func (s *Libp2pNodeService) bootstrapDHT(ctx context.Context) {
+	backoff := time.Second
+	for {
+		err := s.bootstrapDHTOnce(ctx)
+		s.dhtBootstrap.record(err)
+		if err == nil {
+			s.dhtBootstrapped.Store(true)
+			log.Printf("[DHT] Bootstrapped and ready (%d peers in routing table)", s.dht.RoutingTable().Size())
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("[DHT] Bootstrap error: %v (retrying in %s)", err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > dhtBootstrapMaxBackoff {
+			backoff = dhtBootstrapMaxBackoff
+		}
+	}
+}
+
+// bootstrapDHTOnce runs one bootstrap + routing table refresh and fails if the
+// routing table is still empty afterwards
+func (s *Libp2pNodeService) bootstrapDHTOnce(ctx context.Context) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeouts.DHTBootstrap.Std())
+	defer cancel()
+	start := time.Now()
+	defer func() { s.dhtQueries.record(newDHTQuerySample(ctx, dhtOpBootstrap, start, err)) }()
+
+	if err := s.dht.Bootstrap(ctx); err != nil {
+		return err
+	}
+	select {
+	case err := <-s.dht.RefreshRoutingTable():
+		if err != nil {
+			return err
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if s.dht.RoutingTable().Size() == 0 {
+		return errors.New("routing table is empty")
+	}
+	return nil
+}
+
+// ErrDHTNotReady is returned instead of a DHT lookup when the routing table is empty,
+// e.g. during the startup window before bootstrap succeeded
+var ErrDHTNotReady = errors.New("DHT not ready")
+
+// dhtReady fails fast with ErrDHTNotReady (and the bootstrap state) when a FindPeer
+// could only time out: no DHT or no peers in the routing table
+func (s *Libp2pNodeService) dhtReady() error {
+	if s.dht == nil {
+		return fmt.Errorf("%w: not initialized", ErrDHTNotReady)
+	}
+	if s.dht.RoutingTable().Size() > 0 {
+		return nil
+	}
+	st := s.dhtBootstrap.get()
+	if st.Attempts == 0 {
+		return fmt.Errorf("%w: routing table is empty, bootstrap in progress", ErrDHTNotReady)
+	}
+	return fmt.Errorf("%w: routing table is empty after %d bootstrap attempts (last error: %s)", ErrDHTNotReady, st.Attempts, st.LastError)
+}
+
+// findPeer is s.dht.FindPeer behind the dhtReady check
+func (s *Libp2pNodeService) findPeer(ctx context.Context, pid peer.ID) (peer.AddrInfo, error) {
+	if err := s.dhtReady(); err != nil {
+		return peer.AddrInfo{}, err
+	}
+	return s.dht.FindPeer(ctx, pid)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+)
+
+// DHTDiagnostics is a detailed view of the DHT routing state
+type DHTDiagnostics struct {
+	DHTStatus
+	// Mode is the current operating mode of this node's DHT ("server" or "client")
+	Mode string `json:"mode"`
+	// Buckets maps common-prefix-length (bucket index) to the number of peers in it
+	Buckets map[uint]int `json:"buckets"`
+	// ServerPeers / ClientPeers count connected peers that do / don't serve the DHT protocol
+	ServerPeers int `json:"serverPeers"`
+	ClientPeers int `json:"clientPeers"`
+}
+
+// GetDHTDiagnostics inspects the routing table and connected peers
+func (s *Libp2pNodeService) GetDHTDiagnostics() DHTDiagnostics {
+	diag := DHTDiagnostics{
+		DHTStatus: s.GetDHTStatus(),
+		Mode:      dhtModeName(s.dht.Mode()),
+		Buckets:   make(map[uint]int),
+	}
+
+	self := kb.ConvertPeerID(s.node.ID())
+	for _, pid := range s.dht.RoutingTable().ListPeers() {
+		diag.Buckets[uint(kb.CommonPrefixLen(self, kb.ConvertPeerID(pid)))]++
+	}
+
+	for _, pid := range s.node.Network().Peers() {
+		protos, err := s.node.Peerstore().SupportsProtocols(pid, dht.ProtocolDHT)
+		if err == nil && len(protos) > 0 {
+			diag.ServerPeers++
+		} else {
+			diag.ClientPeers++
+		}
+	}
+	return diag
+}
+
+// RefreshDHT triggers a routing table refresh and waits for it to finish
+func (s *Libp2pNodeService) RefreshDHT(ctx context.Context) error {
+	select {
+	case err := <-s.dht.RefreshRoutingTable():
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func dhtModeName(mode dht.ModeOpt) string {
+	switch mode {
+	case dht.ModeServer:
+		return "server"
+	case dht.ModeClient:
+		return "client"
+	default:
+		return "auto"
+	}
+}
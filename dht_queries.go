@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+// dhtQueryLogSize is how many recent DHT queries GET /libp2p/dht/queries keeps
+const dhtQueryLogSize = 100
+
+const (
+	dhtOpFindPeer  = "find_peer"
+	dhtOpBootstrap = "bootstrap"
+)
+
+// DHTQuerySample is one timed DHT operation
+type DHTQuerySample struct {
+	At         time.Time `json:"at"`
+	Op         string    `json:"op"`               // find_peer or bootstrap
+	Target     string    `json:"target,omitempty"` // peer ID looked up
+	DurationMs float64   `json:"durationMs"`
+	// Result is ok, not_found, timeout or error
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+	// Query counts from the DHT's query events (find_peer only): peers asked, peers that
+	// answered and failed requests. Kademlia doesn't report hops; peersQueried is the closest proxy.
+	PeersQueried int `json:"peersQueried,omitempty"`
+	Responses    int `json:"responses,omitempty"`
+	QueryErrors  int `json:"queryErrors,omitempty"`
+}
+
+// dhtQueryLog is a ring buffer of the last dhtQueryLogSize DHT queries
+type dhtQueryLog struct {
+	mu   sync.Mutex
+	ring []DHTQuerySample
+	next int // index overwritten next once the ring is full
+}
+
+func newDHTQueryLog() *dhtQueryLog {
+	return &dhtQueryLog{ring: make([]DHTQuerySample, 0, dhtQueryLogSize)}
+}
+
+// record stores a sample and observes it in the DHT query metrics
+func (l *dhtQueryLog) record(sample DHTQuerySample) {
+	dhtQueryDuration.WithLabelValues(sample.Op, sample.Result).Observe(sample.DurationMs / 1000)
+	if sample.Op == dhtOpFindPeer {
+		dhtQueryPeers.WithLabelValues(sample.Result).Observe(float64(sample.PeersQueried))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.ring) < cap(l.ring) {
+		l.ring = append(l.ring, sample)
+		return
+	}
+	l.ring[l.next] = sample
+	l.next = (l.next + 1) % len(l.ring)
+}
+
+// List returns the kept samples, newest first
+func (l *dhtQueryLog) List() []DHTQuerySample {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]DHTQuerySample, 0, len(l.ring))
+	for i := 0; i < len(l.ring); i++ {
+		idx := (l.next - 1 - i + 2*len(l.ring)) % len(l.ring)
+		out = append(out, l.ring[idx])
+	}
+	return out
+}
+
+// dhtQueryResult classifies the outcome of a DHT query
+func dhtQueryResult(ctx context.Context, err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, routing.ErrNotFound):
+		return "not_found"
+	default:
+		return "error"
+	}
+}
+
+// newDHTQuerySample builds the sample of an operation that started at start
+func newDHTQuerySample(ctx context.Context, op string, start time.Time, err error) DHTQuerySample {
+	sample := DHTQuerySample{
+		At:         start,
+		Op:         op,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+		Result:     dhtQueryResult(ctx, err),
+	}
+	if err != nil {
+		sample.Error = err.Error()
+	}
+	return sample
+}
+
+// timedDHT times every FindPeer (whichever path calls it: connect, find-peer, public-key)
+// and counts its query events into queries
+type timedDHT struct {
+	nodeDHT
+	queries *dhtQueryLog
+}
+
+func (d *timedDHT) FindPeer(ctx context.Context, id peer.ID) (peer.AddrInfo, error) {
+	qctx, cancel := context.WithCancel(ctx)
+	qctx, events := routing.RegisterForQueryEvents(qctx)
+	var queried, responses, queryErrors int
+	counted := make(chan struct{})
+	go func() {
+		defer close(counted)
+		// 事件通道在 qctx 取消后关闭
+		for ev := range events {
+			switch ev.Type {
+			case routing.SendingQuery:
+				queried++
+			case routing.PeerResponse:
+				responses++
+			case routing.QueryError:
+				queryErrors++
+			}
+		}
+	}()
+
+	start := time.Now()
+	info, err := d.nodeDHT.FindPeer(qctx, id)
+	cancel()
+	<-counted
+
+	sample := newDHTQuerySample(ctx, dhtOpFindPeer, start, err)
+	sample.Target = id.String()
+	sample.PeersQueried, sample.Responses, sample.QueryErrors = queried, responses, queryErrors
+	d.queries.record(sample)
+	return info, err
+}
+
+// GetDHTQueries returns the recent timed DHT queries, newest first
+func (s *Libp2pNodeService) GetDHTQueries() []DHTQuerySample {
+	return s.dhtQueries.List()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/mr-tron/base58"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// DIDDocument is a minimal W3C-style DID document for a did:sight DID
+type DIDDocument struct {
+	Context            []string             `json:"@context"`
+	ID                 string               `json:"id"`
+	PeerID             string               `json:"peerId"`
+	VerificationMethod []VerificationMethod `json:"verificationMethod"`
+	Authentication     []string             `json:"authentication"`
+	AssertionMethod    []string             `json:"assertionMethod"`
+	Service            []DIDService         `json:"service"`
+}
+
+// VerificationMethod is the ed25519 key of the DID
+type VerificationMethod struct {
+	ID                 string `json:"id"`
+	Type               string `json:"type"`
+	Controller         string `json:"controller"`
+	PublicKeyMultibase string `json:"publicKeyMultibase"`
+}
+
+// DIDService lists the libp2p multiaddrs the DID is reachable at
+type DIDService struct {
+	ID              string   `json:"id"`
+	Type            string   `json:"type"`
+	ServiceEndpoint []string `json:"serviceEndpoint"`
+}
+
+// GetDIDDocument builds the DID document for a did:sight DID. The service endpoints
+// are our own addrs for our DID, otherwise the peer's known addrs (peerstore, then DHT).
+// Malformed DIDs wrap ErrInvalidTarget; DIDs whose peer can't be located wrap ErrPeerNotFound.
+func (s *Libp2pNodeService) GetDIDDocument(ctx context.Context, did string) (*DIDDocument, error) {
+	var pub []byte
+	var pid peer.ID
+	var addrs []ma.Multiaddr
+	if did == s.did {
+		pub = s.keypair.PublicKey
+		pid = s.node.ID()
+		addrs = s.node.Addrs()
+	} else {
+		var err error
+		pub, err = DIDToPublicKey(did)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrInvalidTarget, did, err)
+		}
+		pid, err = s.resolve.peerID(did)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrInvalidTarget, did, err)
+		}
+		addrs = s.node.Peerstore().Addrs(pid)
+		if len(addrs) == 0 {
+			ctx, cancel := context.WithTimeout(ctx, s.config.Timeouts.Connect.Std())
+			defer cancel()
+			info, err := s.findPeer(ctx, pid)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrPeerNotFound, err)
+			}
+			s.resolve.putAddrs(pid, info.Addrs)
+			addrs = info.Addrs
+		}
+	}
+
+	keyID := did + "#key-1"
+	endpoints := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		endpoints = append(endpoints, fmt.Sprintf("%s/p2p/%s", addr, pid))
+	}
+	return &DIDDocument{
+		Context: []string{
+			"https://www.w3.org/ns/did/v1",
+			"https://w3id.org/security/suites/ed25519-2020/v1",
+		},
+		ID:     did,
+		PeerID: pid.String(),
+		VerificationMethod: []VerificationMethod{{
+			ID:         keyID,
+			Type:       "Ed25519VerificationKey2020",
+			Controller: did,
+			// multibase base58btc ("z") of the ed25519-pub multicodec key, same bytes as the DID suffix
+			PublicKeyMultibase: "z" + base58.Encode(append([]byte{0xed, 0x01}, pub...)),
+		}},
+		Authentication:  []string{keyID},
+		AssertionMethod: []string{keyID},
+		Service: []DIDService{{
+			ID:              did + "#libp2p",
+			Type:            "Libp2pNode",
+			ServiceEndpoint: endpoints,
+		}},
+	}, nil
+}
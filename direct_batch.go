@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+const (
+	// maxDirectBatchItems caps the items of one send-direct-batch request
+	maxDirectBatchItems = 100
+	// directBatchWorkers bounds how many batch items are sent concurrently
+	directBatchWorkers = 8
+)
+
+// ErrMissingPayload is the error of a batch item without a payload
+var ErrMissingPayload = errors.New("missing payload")
+
+// DirectBatchItem is one message of a batch direct send
+type DirectBatchItem struct {
+	DID     string          `json:"did"`
+	Payload json.RawMessage `json:"payload"`
+	// ContentType optionally declares the payload's type for the receiver's tunnel, like
+	// p2p-send's X-Sight-Content-Type header
+	ContentType string `json:"contentType,omitempty"`
+}
+
+// DirectBatchOutcome is the result of one batch item: the negotiated protocol or the error
+// SendDirectMessage returned for it
+type DirectBatchOutcome struct {
+	Protocol protocol.ID
+	Err      error
+}
+
+// SendDirectBatch sends every item as a {"to", "payload"} envelope with SendDirectMessage, at most directBatchWorkers at a
+// time, all bounded by ctx. Items fail independently; outcomes are in item order.
+func (s *Libp2pNodeService) SendDirectBatch(ctx context.Context, items []DirectBatchItem) []DirectBatchOutcome {
+	outcomes := make([]DirectBatchOutcome, len(items))
+	workers := min(directBatchWorkers, len(items))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if len(items[i].Payload) == 0 || string(items[i].Payload) == "null" {
+					outcomes[i] = DirectBatchOutcome{Err: ErrMissingPayload}
+					continue
+				}
+				// 和 p2p-send 一样的信封，接收方转发其中的 payload
+				msg := map[string]interface{}{"to": items[i].DID, "payload": items[i].Payload}
+				if ct := items[i].ContentType; ct != "" {
+					if err := checkContentType(ct); err != nil {
+						outcomes[i] = DirectBatchOutcome{Err: err}
+						continue
+					}
+					msg["contentType"] = ct
+				}
+				envelope, _ := json.Marshal(msg)
+				proto, err := s.SendDirectMessage(ctx, items[i].DID, envelope)
+				outcomes[i] = DirectBatchOutcome{Protocol: proto, Err: err}
+			}
+		}()
+	}
+	for i := range items {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return outcomes
+}
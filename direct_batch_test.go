@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendDirectBatchHandlerMixed(t *testing.T) {
+	s, h, d := newFakeService(t, func(c *Config) { c.Timeouts.Dial = Duration(200 * time.Millisecond) })
+	ok1, received1 := h.addPeer(t)
+	ok2, received2 := h.addPeer(t)
+	down, _ := h.addPeer(t)
+	down.Unreachable = true
+	missing, _ := h.addPeer(t) // never published: the lookup finds nothing
+	for _, p := range []*fakePeer{ok1, ok2, down} {
+		d.publish(p)
+	}
+
+	body := fmt.Sprintf(`[
+		{"did": %q, "payload": {"n": 1}},
+		{"did": %q, "payload": {"n": 2}},
+		{"did": %q, "payload": {"n": 3}},
+		{"did": %q, "payload": {"n": 4}},
+		{"did": %q},
+		{"did": "not-a-peer", "payload": {"n": 6}},
+		{"did": %q, "payload": "x", "contentType": "not a type"}
+	]`, ok1.DID, down.DID, ok2.DID, missing.DID, ok1.DID, ok2.DID)
+	w := serveHandler(NewLibp2pNodeController(s).SendDirectBatchHandler, "POST", "/libp2p/send-direct-batch", strings.NewReader(body), nil)
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("status = %d, want 207: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Status    string              `json:"status"`
+		Succeeded int                 `json:"succeeded"`
+		Failed    int                 `json:"failed"`
+		Results   []directBatchResult `json:"results"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != "partial" || resp.Succeeded != 2 || resp.Failed != 5 {
+		t.Errorf("status %q, %d succeeded, %d failed; want partial, 2, 5", resp.Status, resp.Succeeded, resp.Failed)
+	}
+	for i, want := range []struct {
+		httpStatus int
+		code       string
+	}{
+		{200, ""},
+		{502, "peer_unreachable"},
+		{200, ""},
+		{502, "peer_not_found"},
+		{400, "missing_payload"},
+		{400, "invalid_target"},
+		{400, "invalid_content_type"},
+	} {
+		res := resp.Results[i]
+		if res.Index != i || res.HTTPCode != want.httpStatus || res.Code != want.code {
+			t.Errorf("result %d = %+v, want httpStatus %d code %q", i, res, want.httpStatus, want.code)
+		}
+		if (res.Status == "ok") != (want.code == "") || (res.Protocol != "") != (want.code == "") {
+			t.Errorf("result %d: status %q, protocol %q", i, res.Status, res.Protocol)
+		}
+	}
+
+	// each successful item delivered its own payload, nothing else was sent
+	for _, tc := range []struct {
+		ch   <-chan []byte
+		want string
+	}{{received1, `"n":1`}, {received2, `"n":3`}} {
+		select {
+		case got := <-tc.ch:
+			if !bytes.Contains(got, []byte(tc.want)) {
+				t.Errorf("delivered %s, want payload %s", got, tc.want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("payload %s never delivered", tc.want)
+		}
+	}
+	select {
+	case got := <-received1:
+		t.Errorf("failed item delivered: %s", got)
+	case got := <-received2:
+		t.Errorf("failed item delivered: %s", got)
+	default:
+	}
+}
+
+func TestSendDirectBatchHandlerStatus(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		reachable []bool
+		code      int
+		status    string
+	}{
+		{"all succeed", []bool{true, true}, http.StatusOK, "ok"},
+		{"all fail", []bool{false, false}, http.StatusMultiStatus, "error"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, h, d := newFakeService(t)
+			var items []string
+			for _, reachable := range tc.reachable {
+				p, _ := h.addPeer(t)
+				p.Unreachable = !reachable
+				d.publish(p)
+				items = append(items, fmt.Sprintf(`{"did": %q, "payload": 1}`, p.DID))
+			}
+			w := serveHandler(NewLibp2pNodeController(s).SendDirectBatchHandler, "POST", "/libp2p/send-direct-batch",
+				strings.NewReader("["+strings.Join(items, ",")+"]"), nil)
+			if w.Code != tc.code {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tc.code, w.Body)
+			}
+			var resp map[string]interface{}
+			json.NewDecoder(w.Body).Decode(&resp)
+			if resp["status"] != tc.status {
+				t.Errorf("batch status = %v, want %s", resp["status"], tc.status)
+			}
+		})
+	}
+}
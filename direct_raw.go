@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// directRawProtocol carries raw bytes with a declared content type, so binary payloads
+// (model weights, media) don't have to be base64-wrapped in JSON.
+// Frame: one JSON header line (rawHeader + "\n"), then the body until EOF.
+const directRawProtocol protocol.ID = "/sight/direct-raw/1.0.0"
+
+// maxRawHeaderBytes bounds the header line (it must fit the read buffer)
+const maxRawHeaderBytes = 4 << 10
+
+type rawHeader struct {
+	ContentType string `json:"contentType"`
+	MessageID   string `json:"messageId"`
+}
+
+// ErrInvalidContentType is returned for a missing or malformed raw content type
+var ErrInvalidContentType = errors.New("invalid content type")
+
+// checkContentType requires a parseable media type, e.g. "application/octet-stream"
+func checkContentType(contentType string) error {
+	if _, _, err := mime.ParseMediaType(contentType); err != nil {
+		return fmt.Errorf("%w %q: %v", ErrInvalidContentType, contentType, err)
+	}
+	return nil
+}
+
+// SendDirectRaw sends body to a peer by its DID or multiaddr over directRawProtocol and
+// returns the generated message ID. The receiver forwards it to its tunnel as-is with
+// Content-Type set to contentType.
+func (s *Libp2pNodeService) SendDirectRaw(ctx context.Context, did, contentType string, body []byte) (string, error) {
+	if err := s.checkSendable(); err != nil {
+		return "", err
+	}
+	if err := checkContentType(contentType); err != nil {
+		return "", err
+	}
+	if err := s.checkPayloadSize(body); err != nil {
+		return "", err
+	}
+	stream, err := s.openDirectStream(ctx, did, []protocol.ID{directRawProtocol})
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+	directMessages.WithLabelValues("out", string(directRawProtocol)).Inc()
+
+	msgID := newMessageID()
+	header, _ := json.Marshal(rawHeader{ContentType: contentType, MessageID: msgID})
+	w := bufio.NewWriter(stream)
+	w.Write(append(header, '\n'))
+	w.Write(body)
+	if err := w.Flush(); err != nil {
+		return "", s.sendTimeoutError(ctx, fmt.Errorf("%w: write: %w", ErrStreamFailed, err))
+	}
+	return msgID, nil
+}
+
+func (s *Libp2pNodeService) handleDirectRawMessage(stream network.Stream) {
+	if !s.inflight.begin() {
+		stream.Reset() // 正在关闭，不再接收新的直连消息
+		return
+	}
+	directMessages.WithLabelValues("in", string(stream.Protocol())).Inc()
+	go func() {
+		defer s.inflight.done()
+		defer stream.Close()
+		r := bufio.NewReaderSize(stream, maxRawHeaderBytes)
+		line, err := r.ReadSlice('\n')
+		if err != nil {
+			log.Printf("Failed to read raw p2p message header: %v", err)
+			stream.Reset()
+			return
+		}
+		var header rawHeader
+		if err := json.Unmarshal(line, &header); err != nil {
+			log.Printf("Invalid raw p2p message header: %v", err)
+			s.ReportViolation(stream.Conn().RemotePeer(), violationInvalidMessage)
+			stream.Reset()
+			return
+		}
+		if err := checkContentType(header.ContentType); err != nil {
+			log.Printf("Rejected raw p2p message %s: %v", header.MessageID, err)
+			s.ReportViolation(stream.Conn().RemotePeer(), violationInvalidMessage)
+			stream.Reset()
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r, s.config.MaxPayloadBytes+1))
+		if err != nil {
+			log.Printf("Failed to read raw p2p message %s: %v", header.MessageID, err)
+			return
+		}
+		if err := s.checkPayloadSize(body); err != nil {
+			log.Printf("Rejected raw p2p message %s: %v", header.MessageID, err)
+			s.ReportViolation(stream.Conn().RemotePeer(), violationOversized)
+			stream.Reset()
+			return
+		}
+		if header.MessageID == "" {
+			header.MessageID = newMessageID()
+		}
+		// 发送方身份来自安全握手，已验证
+		_, err = s.forwardToTunnel(context.Background(), body, TunnelMeta{
+			From:        stream.Conn().RemotePeer(),
+			MessageID:   header.MessageID,
+			Transport:   transportDirect,
+			Protocol:    string(stream.Protocol()),
+			ContentType: header.ContentType,
+			ReceivedAt:  time.Now(),
+		})
+		if errors.Is(err, errTunnelSkipped) {
+			return
+		}
+		if err != nil {
+			log.Printf("Raw direct message %s forward error: %v", header.MessageID, err)
+		} else {
+			log.Printf("Raw direct message %s forwarded (%s, %d bytes)", header.MessageID, header.ContentType, len(body))
+		}
+	}()
+}
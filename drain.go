@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ErrDraining is returned by every send path once POST /libp2p/drain started
+var ErrDraining = errors.New("node is draining")
+
+// drainPollInterval is how often Drain checks whether the forward queue emptied
+const drainPollInterval = 100 * time.Millisecond
+
+// inflightStreams tracks direct-message handlers that are still reading or forwarding,
+// so Stop can let them finish before the host is closed
+type inflightStreams struct {
+	mu       sync.Mutex
+	draining bool
+	active   int           // running handlers, also for GET /libp2p/load
+	idle     chan struct{} // closed once draining and no handler is running
+}
+
+// begin registers a handler; it returns false once draining started and the stream must be refused
+func (f *inflightStreams) begin() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.draining {
+		return false
+	}
+	f.active++
+	return true
+}
+
+func (f *inflightStreams) done() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.active--
+	if f.draining && f.active == 0 {
+		close(f.idle)
+	}
+}
+
+// count returns how many handlers are running
+func (f *inflightStreams) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.active
+}
+
+// drain refuses new handlers and waits until the running ones finished or ctx is done.
+// It returns false if ctx was done first. Nothing is left waiting after a timeout.
+func (f *inflightStreams) drain(ctx context.Context) bool {
+	f.mu.Lock()
+	if !f.draining {
+		f.draining = true
+		f.idle = make(chan struct{})
+		if f.active == 0 {
+			close(f.idle)
+		}
+	}
+	idle := f.idle
+	f.mu.Unlock()
+
+	select {
+	case <-idle:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// drainDirectStreams stops accepting direct streams and waits (bounded by
+// Timeouts.Drain) for in-flight ones to finish their tunnel forwards
+func (s *Libp2pNodeService) drainDirectStreams() {
+	s.removeDirectHandlers()
+	timeout := s.config.Timeouts.Drain.Std()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if !s.inflight.drain(ctx) {
+		log.Printf("Drain timed out after %s, closing with direct streams still in flight", timeout)
+		return
+	}
+	log.Printf("Drained in-flight direct streams")
+}
+
+// removeDirectHandlers unregisters the direct protocols so new streams fail negotiation
+// instead of being reset after the sender wrote its message
+func (s *Libp2pNodeService) removeDirectHandlers() {
+	for _, proto := range directProtocols {
+		s.node.RemoveStreamHandler(proto)
+	}
+	s.node.RemoveStreamHandler(directRawProtocol)
+}
+
+// checkSendable is the guard of every send path: observers never send and a draining
+// node takes no new work
+func (s *Libp2pNodeService) checkSendable() error {
+	if s.observer != nil {
+		return ErrObserverMode
+	}
+	if s.draining.Load() {
+		return ErrDraining
+	}
+	return nil
+}
+
+// IsDraining reports whether Drain was called
+func (s *Libp2pNodeService) IsDraining() bool {
+	return s.draining.Load()
+}
+
+// DrainResult is the response of POST /libp2p/drain
+type DrainResult struct {
+	// Drained is false when ctx was done before in-flight work finished
+	Drained bool `json:"drained"`
+	// DirectStreams and ForwardQueue count what was still in flight when Drain returned
+	// (ForwardQueue includes the message being forwarded)
+	DirectStreams int    `json:"directStreams"`
+	ForwardQueue  int    `json:"forwardQueue"`
+	LeaveNotice   bool   `json:"leaveNotice"` // published a leave notice
+	LeaveError    string `json:"leaveError,omitempty"`
+	DurationMs    int64  `json:"durationMs"`
+}
+
+// Drain prepares the node for a rolling upgrade: send paths fail with ErrDraining, new direct
+// streams are refused, and it waits until in-flight direct messages and the queued pubsub
+// forwards reached the tunnel, or ctx is done. Pubsub messages still arriving are forwarded
+// meanwhile. With leave it then publishes {"leave": did, "from": did} on the node's topic so
+// peers know the departure is planned. The node keeps running: Stop (or RequestExit) ends it.
+// Draining can't be undone; calling Drain again just waits again.
+func (s *Libp2pNodeService) Drain(ctx context.Context, leave bool) DrainResult {
+	start := time.Now()
+	if !s.draining.Swap(true) {
+		log.Printf("[Drain] Draining: refusing new sends and direct streams")
+		s.removeDirectHandlers()
+	}
+	res := DrainResult{Drained: s.inflight.drain(ctx) && s.waitForwardQueue(ctx)}
+	res.DirectStreams = s.inflight.count()
+	res.ForwardQueue = int(s.forwardPending.Load())
+	if leave {
+		if err := s.publishLeave(ctx); err != nil {
+			res.LeaveError = err.Error()
+		} else {
+			res.LeaveNotice = true
+		}
+	}
+	res.DurationMs = time.Since(start).Milliseconds()
+	log.Printf("[Drain] drained=%v directStreams=%d forwardQueue=%d leaveNotice=%v after %s",
+		res.Drained, res.DirectStreams, res.ForwardQueue, res.LeaveNotice, time.Since(start).Round(time.Millisecond))
+	return res
+}
+
+// waitForwardQueue waits until every queued pubsub message was forwarded
+func (s *Libp2pNodeService) waitForwardQueue(ctx context.Context) bool {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for s.forwardPending.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// publishLeave announces the departure on the node's topic, bypassing checkSendable
+func (s *Libp2pNodeService) publishLeave(ctx context.Context) error {
+	if s.observer != nil {
+		return ErrObserverMode
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"leave":     s.did,
+		"from":      s.did,
+		"messageId": newMessageID(),
+	})
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeouts.Publish.Std())
+	defer cancel()
+	if err := s.getTopic().Publish(ctx, data); err != nil {
+		log.Printf("[Drain] Failed to publish leave notice: %v", err)
+		return err
+	}
+	return nil
+}
+
+// handleLeave consumes a peer's leave notice; it returns false if msg is not one
+func (s *Libp2pNodeService) handleLeave(msg map[string]interface{}, from peer.ID) bool {
+	did, ok := msg["leave"].(string)
+	if !ok {
+		return false
+	}
+	log.Printf("[Leave] %s (%s) is leaving", did, from)
+	return true
+}
+
+// RequestExit asks main to shut down as on SIGINT (e.g. after POST /libp2p/drain?exit=true)
+func (s *Libp2pNodeService) RequestExit() {
+	s.exitOnce.Do(func() { close(s.exit) })
+}
+
+// ExitRequested is closed once RequestExit was called
+func (s *Libp2pNodeService) ExitRequested() <-chan struct{} {
+	return s.exit
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestInflightStreamsDrain(t *testing.T) {
+	var f inflightStreams
+	if !f.begin() {
+		t.Fatal("begin refused before draining")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if f.drain(ctx) {
+		t.Fatal("drain reported done with a handler still running")
+	}
+	if f.begin() {
+		t.Fatal("begin accepted a handler while draining")
+	}
+	if n := f.count(); n != 1 {
+		t.Errorf("count = %d, want 1", n)
+	}
+
+	// the handler finishing releases a later drain, and every one after it
+	f.done()
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		if !f.drain(ctx) {
+			t.Errorf("drain %d timed out with no handler running", i)
+		}
+		cancel()
+	}
+}
+
+func TestStopCompletesInflightForward(t *testing.T) {
+	a := newTestService(t, testConfig(t, newTestBackend(t).URL))
+	b := newTestService(t, testConfig(t, newTestBackend(t).URL, func(c *Config) { c.Timeouts.Drain = Duration(10 * time.Second) }))
+	connectServices(t, a, b)
+
+	started := make(chan struct{})
+	var completed atomic.Bool
+	b.SetTunnelForwarder(TunnelFunc(func(ctx context.Context, meta TunnelMeta, body []byte) (*TunnelResponse, error) {
+		close(started)
+		select {
+		case <-time.After(300 * time.Millisecond):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		completed.Store(true)
+		return &TunnelResponse{StatusCode: 200}, nil
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := a.SendDirectMessage(ctx, b.did, []byte(`{"payload":{"data":"in flight"}}`)); err != nil {
+		t.Fatalf("SendDirectMessage: %v", err)
+	}
+	select {
+	case <-started:
+	case <-ctx.Done():
+		t.Fatal("forward never started")
+	}
+
+	// the forward is mid-way: Stop waits for it before closing the host
+	b.Stop()
+	if !completed.Load() {
+		t.Fatal("Stop closed the node before the in-flight forward completed")
+	}
+	if n := b.inflight.count(); n != 0 {
+		t.Errorf("%d direct streams still in flight after Stop", n)
+	}
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	kbucket "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	pstore "github.com/libp2p/go-libp2p/p2p/host/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	ma "github.com/multiformats/go-multiaddr"
+	msmux "github.com/multiformats/go-multistream"
+)
+
+// fakePeer is a remote peer simulated by fakeHost: its streams are served in-process by handlers
+type fakePeer struct {
+	ID    peer.ID
+	DID   string
+	Addrs []ma.Multiaddr
+
+	// Handlers serve the protocols the peer speaks; identify reports their keys on connect
+	Handlers map[protocol.ID]network.StreamHandler
+	// PubKey is the key shown in the security handshake (RemotePublicKey of its conns)
+	PubKey crypto.PubKey
+	// Unreachable peers fail every dial
+	Unreachable bool
+	// DialDelay and StreamDelay stall Connect and NewStream (until ctx is done if longer)
+	DialDelay   time.Duration
+	StreamDelay time.Duration
+}
+
+// fakeHost is a nodeHost without a libp2p stack: dials and streams go to fakePeers in memory
+type fakeHost struct {
+	id  peer.ID
+	ps  peerstore.Peerstore
+	bus event.Bus
+
+	mu        sync.Mutex
+	peers     map[peer.ID]*fakePeer
+	connected map[peer.ID]bool
+	handlers  map[protocol.ID]network.StreamHandler
+	dials     int // Connect calls to peers not connected yet
+}
+
+var _ nodeHost = (*fakeHost)(nil)
+
+func newFakeHost(t *testing.T, id peer.ID) *fakeHost {
+	t.Helper()
+	ps, err := pstoremem.NewPeerstore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ps.Close() })
+	return &fakeHost{
+		id:        id,
+		ps:        ps,
+		bus:       eventbus.NewBus(),
+		peers:     make(map[peer.ID]*fakePeer),
+		connected: make(map[peer.ID]bool),
+		handlers:  make(map[protocol.ID]network.StreamHandler),
+	}
+}
+
+// addPeer simulates a peer reachable at a made-up address that speaks ping and the direct
+// protocols; the payload of every direct stream is sent on the returned channel
+func (h *fakeHost) addPeer(t *testing.T) (*fakePeer, <-chan []byte) {
+	t.Helper()
+	pid, did := testPeer(t)
+	received := make(chan []byte, 16)
+	p := &fakePeer{
+		ID:       pid,
+		DID:      did,
+		Handlers: map[protocol.ID]network.StreamHandler{ping.ID: echoHandler},
+	}
+	for _, proto := range directProtocols {
+		p.Handlers[proto] = recordHandler(received)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	p.Addrs = []ma.Multiaddr{ma.StringCast(fmt.Sprintf("/ip4/10.0.0.%d/tcp/15050", len(h.peers)+1))}
+	h.peers[pid] = p
+	return p, received
+}
+
+// addHashedPeer is addPeer with an ECDSA key: its peer ID is a hash that doesn't embed the key.
+// The key is shown in the handshake only when withKey is set.
+func (h *fakeHost) addHashedPeer(t *testing.T, withKey bool) (*fakePeer, crypto.PubKey) {
+	t.Helper()
+	_, pub, err := crypto.GenerateECDSAKeyPair(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := peer.IDFromPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := h.addPeer(t)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.peers, p.ID)
+	p.ID, p.DID = pid, ""
+	if withKey {
+		p.PubKey = pub
+	}
+	h.peers[pid] = p
+	return p, pub
+}
+
+// echoHandler answers the ping protocol
+func echoHandler(s network.Stream) {
+	defer s.Close()
+	buf := make([]byte, ping.PingSize)
+	for {
+		n, err := s.Read(buf)
+		if err != nil {
+			return
+		}
+		if _, err := s.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+}
+
+// recordHandler reads a whole stream and sends it on ch
+func recordHandler(ch chan<- []byte) network.StreamHandler {
+	return func(s network.Stream) {
+		defer s.Close()
+		var body []byte
+		buf := make([]byte, 4096)
+		for {
+			n, err := s.Read(buf)
+			body = append(body, buf[:n]...)
+			if err != nil {
+				break
+			}
+		}
+		ch <- body
+	}
+}
+
+func (h *fakeHost) dialCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.dials
+}
+
+// setConnected marks pid connected without dialing, as if it had dialed us
+func (h *fakeHost) setConnected(pid peer.ID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.connected[pid] = true
+}
+
+// disconnect drops the connection to pid; the caller notifies notifiees itself
+func (h *fakeHost) disconnect(pid peer.ID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.connected, pid)
+}
+
+func (h *fakeHost) ID() peer.ID                      { return h.id }
+func (h *fakeHost) Addrs() []ma.Multiaddr            { return nil }
+func (h *fakeHost) Peerstore() peerstore.Peerstore   { return h.ps }
+func (h *fakeHost) Network() network.Network         { return fakeNetwork{h: h} }
+func (h *fakeHost) EventBus() event.Bus              { return h.bus }
+func (h *fakeHost) ConnManager() connmgr.ConnManager { return &connmgr.NullConnMgr{} }
+func (h *fakeHost) Close() error                     { return nil }
+
+func (h *fakeHost) SetStreamHandler(pid protocol.ID, handler network.StreamHandler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.handlers[pid] = handler
+}
+
+func (h *fakeHost) RemoveStreamHandler(pid protocol.ID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.handlers, pid)
+}
+
+// Connect dials pi like a host does: with pi.Addrs or the peerstore addrs, failing without any
+func (h *fakeHost) Connect(ctx context.Context, pi peer.AddrInfo) error {
+	h.ps.AddAddrs(pi.ID, pi.Addrs, peerstore.TempAddrTTL)
+	h.mu.Lock()
+	if h.connected[pi.ID] {
+		h.mu.Unlock()
+		return nil
+	}
+	p, ok := h.peers[pi.ID]
+	h.dials++
+	h.mu.Unlock()
+	if len(h.ps.Addrs(pi.ID)) == 0 {
+		return fmt.Errorf("failed to dial %s: no addresses", pi.ID)
+	}
+	if !ok || p.Unreachable {
+		return fmt.Errorf("failed to dial %s: all dials failed", pi.ID)
+	}
+	if err := sleepCtx(ctx, p.DialDelay); err != nil {
+		return fmt.Errorf("failed to dial %s: %w", pi.ID, err)
+	}
+	// identify: the peer's protocols become known once connected
+	protos := make([]protocol.ID, 0, len(p.Handlers))
+	for proto := range p.Handlers {
+		protos = append(protos, proto)
+	}
+	h.ps.SetProtocols(p.ID, protos...)
+	h.mu.Lock()
+	h.connected[pi.ID] = true
+	h.mu.Unlock()
+	return nil
+}
+
+// NewStream connects if needed, then opens a stream on the first of pids the peer speaks
+func (h *fakeHost) NewStream(ctx context.Context, pid peer.ID, pids ...protocol.ID) (network.Stream, error) {
+	if err := h.Connect(ctx, peer.AddrInfo{ID: pid}); err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	p := h.peers[pid]
+	h.mu.Unlock()
+	i := slices.IndexFunc(pids, func(proto protocol.ID) bool { return p.Handlers[proto] != nil })
+	if i < 0 {
+		return nil, msmux.ErrNotSupported[protocol.ID]{Protos: pids}
+	}
+	if err := sleepCtx(ctx, p.StreamDelay); err != nil {
+		return nil, err
+	}
+	local, remote := net.Pipe()
+	go p.Handlers[pids[i]](&fakeStream{pipe: remote, proto: pids[i], conn: &fakeConn{local: pid, remote: h.id}})
+	return &fakeStream{pipe: local, proto: pids[i], conn: &fakeConn{local: h.id, remote: pid, addr: p.Addrs[0]}}, nil
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// fakeNetwork answers the connection queries of the service; other methods aren't implemented
+type fakeNetwork struct {
+	network.Network
+	h *fakeHost
+}
+
+func (n fakeNetwork) LocalPeer() peer.ID             { return n.h.id }
+func (n fakeNetwork) Peerstore() peerstore.Peerstore { return n.h.ps }
+
+func (n fakeNetwork) Connectedness(pid peer.ID) network.Connectedness {
+	n.h.mu.Lock()
+	defer n.h.mu.Unlock()
+	if n.h.connected[pid] {
+		return network.Connected
+	}
+	return network.NotConnected
+}
+
+func (n fakeNetwork) Peers() []peer.ID {
+	n.h.mu.Lock()
+	defer n.h.mu.Unlock()
+	var out []peer.ID
+	for pid, ok := range n.h.connected {
+		if ok {
+			out = append(out, pid)
+		}
+	}
+	return out
+}
+
+func (n fakeNetwork) ConnsToPeer(pid peer.ID) []network.Conn {
+	if n.Connectedness(pid) != network.Connected {
+		return nil
+	}
+	var addr ma.Multiaddr
+	var key crypto.PubKey
+	n.h.mu.Lock()
+	if p, ok := n.h.peers[pid]; ok {
+		addr, key = p.Addrs[0], p.PubKey
+	}
+	n.h.mu.Unlock()
+	return []network.Conn{&fakeConn{local: n.h.id, remote: pid, addr: addr, key: key}}
+}
+
+func (n fakeNetwork) Conns() []network.Conn {
+	var out []network.Conn
+	for _, pid := range n.Peers() {
+		out = append(out, n.ConnsToPeer(pid)...)
+	}
+	return out
+}
+
+// fakeConn is a direct outbound connection to remote
+type fakeConn struct {
+	network.Conn
+	local, remote peer.ID
+	addr          ma.Multiaddr
+	key           crypto.PubKey
+}
+
+func (c *fakeConn) ID() string                     { return "fake-" + c.remote.String() }
+func (c *fakeConn) LocalPeer() peer.ID             { return c.local }
+func (c *fakeConn) RemotePeer() peer.ID            { return c.remote }
+func (c *fakeConn) RemoteMultiaddr() ma.Multiaddr  { return c.addr }
+func (c *fakeConn) RemotePublicKey() crypto.PubKey { return c.key }
+func (c *fakeConn) Stat() network.ConnStats {
+	return network.ConnStats{Stats: network.Stats{Direction: network.DirOutbound}}
+}
+func (c *fakeConn) ConnState() network.ConnectionState { return network.ConnectionState{} }
+
+// fakeStream is one end of an in-memory pipe. A pipe can't half-close, so CloseWrite closes
+// both directions: fine for one-way direct messages and pings, not for request/response.
+type fakeStream struct {
+	network.Stream
+	pipe  net.Conn
+	proto protocol.ID
+	conn  *fakeConn
+}
+
+func (s *fakeStream) Read(b []byte) (int, error)                   { return s.pipe.Read(b) }
+func (s *fakeStream) Write(b []byte) (int, error)                  { return s.pipe.Write(b) }
+func (s *fakeStream) Close() error                                 { return s.pipe.Close() }
+func (s *fakeStream) CloseWrite() error                            { return s.pipe.Close() }
+func (s *fakeStream) CloseRead() error                             { return nil }
+func (s *fakeStream) Reset() error                                 { return s.pipe.Close() }
+func (s *fakeStream) ResetWithError(network.StreamErrorCode) error { return s.pipe.Close() }
+func (s *fakeStream) SetDeadline(t time.Time) error                { return s.pipe.SetDeadline(t) }
+func (s *fakeStream) SetReadDeadline(t time.Time) error            { return s.pipe.SetReadDeadline(t) }
+func (s *fakeStream) SetWriteDeadline(t time.Time) error           { return s.pipe.SetWriteDeadline(t) }
+func (s *fakeStream) ID() string                                   { return "fake" }
+func (s *fakeStream) Protocol() protocol.ID                        { return s.proto }
+func (s *fakeStream) SetProtocol(id protocol.ID) error             { s.proto = id; return nil }
+func (s *fakeStream) Conn() network.Conn                           { return s.conn }
+func (s *fakeStream) Stat() network.Stats                          { return network.Stats{Direction: network.DirOutbound} }
+
+// fakeDHT answers FindPeer from a map; its routing table holds one peer so dhtReady passes
+// unless emptyTable is used
+type fakeDHT struct {
+	rt *kbucket.RoutingTable
+
+	mu      sync.Mutex
+	records map[peer.ID]peer.AddrInfo
+	lookups int
+	// delay stalls FindPeer (until ctx is done if longer)
+	delay time.Duration
+}
+
+var _ nodeDHT = (*fakeDHT)(nil)
+
+func newFakeDHT(t *testing.T, self peer.ID) *fakeDHT {
+	t.Helper()
+	rt, err := kbucket.NewRoutingTable(20, kbucket.ConvertPeerID(self), time.Minute, pstore.NewMetrics(), time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rt.Close() })
+	seed, _ := testPeer(t)
+	if _, err := rt.TryAddPeer(seed, true, false); err != nil {
+		t.Fatal(err)
+	}
+	return &fakeDHT{rt: rt, records: make(map[peer.ID]peer.AddrInfo)}
+}
+
+// emptyTable removes every routing table peer, as before the first successful bootstrap
+func (d *fakeDHT) emptyTable() {
+	for _, pid := range d.rt.ListPeers() {
+		d.rt.RemovePeer(pid)
+	}
+}
+
+// publish makes p findable
+func (d *fakeDHT) publish(p *fakePeer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.records[p.ID] = peer.AddrInfo{ID: p.ID, Addrs: p.Addrs}
+}
+
+func (d *fakeDHT) lookupCount() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.lookups
+}
+
+func (d *fakeDHT) FindPeer(ctx context.Context, id peer.ID) (peer.AddrInfo, error) {
+	d.mu.Lock()
+	d.lookups++
+	info, ok := d.records[id]
+	delay := d.delay
+	d.mu.Unlock()
+	if err := sleepCtx(ctx, delay); err != nil {
+		return peer.AddrInfo{}, err
+	}
+	if !ok {
+		return peer.AddrInfo{}, routing.ErrNotFound
+	}
+	return info, nil
+}
+
+func (d *fakeDHT) Bootstrap(context.Context) error { return nil }
+
+func (d *fakeDHT) RefreshRoutingTable() <-chan error {
+	ch := make(chan error, 1)
+	ch <- nil
+	close(ch)
+	return ch
+}
+
+func (d *fakeDHT) RoutingTable() *kbucket.RoutingTable { return d.rt }
+func (d *fakeDHT) Mode() dht.ModeOpt                   { return dht.ModeClient }
+
+// newFakeService is a service on a fakeHost and fakeDHT, without InitNode: no libp2p stack,
+// no background goroutines. Messages it receives go nowhere (the tunnel URL is unroutable).
+func newFakeService(t *testing.T, mutate ...func(*Config)) (*Libp2pNodeService, *fakeHost, *fakeDHT) {
+	t.Helper()
+	kp, err := generateKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := PublicKeyToPeerId(kp.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewLibp2pNodeService(kp, testConfig(t, "http://127.0.0.1:1", mutate...))
+	h := newFakeHost(t, id)
+	d := newFakeDHT(t, h.ID())
+	s.node, s.dht = h, d
+	return s, h, d
+}
+
+// errorIs is errors.Is for table tests where want may be nil (expecting success)
+func errorIs(err, want error) bool {
+	if want == nil {
+		return err == nil
+	}
+	return errors.Is(err, want)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// forwardJob is a pubsub message waiting to be forwarded to the tunnel
+type forwardJob struct {
+	payload map[string]interface{}
+	body    []byte
+	meta    TunnelMeta
+	span    trace.SpanContext // receive span the forward continues
+}
+
+// errForwardDropped marks a message dropped because the forward queue was full
+var errForwardDropped = errors.New("forward queue full")
+
+// enqueueForward hands a message to the forwarder without blocking the pubsub reader.
+//
+// Overflow policy: drop newest. When the queue is full (the tunnel can't keep up) the
+// incoming message is dropped and counted in sight_forward_queue_dropped_total, so
+// sub.Next keeps draining and gossipsub itself never backs up.
+func (s *Libp2pNodeService) enqueueForward(job forwardJob) {
+	select {
+	case s.forwardQueue <- job:
+		s.forwardPending.Add(1)
+		s.forwards.enqueued(s.forwardRecipient(job.meta))
+		forwardQueueLength.Set(float64(len(s.forwardQueue)))
+	default:
+		forwardQueueDropped.Inc()
+		s.forwardDropped.Add(1)
+		s.recent.record(job.meta, job.body, nil, errForwardDropped)
+		s.forwards.record(s.forwardRecipient(job.meta), nil, errForwardDropped)
+		log.Printf("Forward queue full (%d), dropping message %s", cap(s.forwardQueue), job.meta.MessageID)
+	}
+}
+
+// ForwardQueueStatus is the state of the forward queue in /libp2p/status: a Length near
+// Capacity or a growing Dropped means the tunnel can't keep up
+type ForwardQueueStatus struct {
+	Length   int `json:"length"`
+	Capacity int `json:"capacity"`
+	// Pending also counts the message being forwarded
+	Pending int64 `json:"pending"`
+	Dropped int64 `json:"dropped"`
+}
+
+// GetForwardQueueStatus returns the forward queue length, capacity, pending and dropped counts
+func (s *Libp2pNodeService) GetForwardQueueStatus() ForwardQueueStatus {
+	return ForwardQueueStatus{
+		Length:   len(s.forwardQueue),
+		Capacity: cap(s.forwardQueue),
+		Pending:  s.forwardPending.Load(),
+		Dropped:  s.forwardDropped.Load(),
+	}
+}
+
+// runForwarder forwards queued messages to the tunnel one at a time, keeping their order
+func (s *Libp2pNodeService) runForwarder(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-s.forwardQueue:
+			s.forwards.dequeued(s.forwardRecipient(job.meta))
+			forwardQueueLength.Set(float64(len(s.forwardQueue)))
+			forwardQueueLag.Set(time.Since(job.meta.ReceivedAt).Seconds())
+
+			resp, err := s.forwardToTunnel(trace.ContextWithSpanContext(ctx, job.span), job.body, job.meta)
+			s.forwardPending.Add(-1)
+			if errors.Is(err, errTunnelSkipped) {
+				continue
+			}
+			if err != nil {
+				log.Printf("Forward error for message %s: %v", job.meta.MessageID, err)
+				continue
+			}
+			in, _ := json.MarshalIndent(job.payload, "", "  ")
+			log.Printf("Received and forwarded message %s to tunnel: \n%s", job.meta.MessageID, in)
+			s.sendAck(job.payload, resp)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestForwardQueueStatusCountsDrops(t *testing.T) {
+	s, _, _ := newFakeService(t)
+	s.forwardQueue = make(chan forwardJob, 1)
+	from, _ := testPeer(t)
+
+	for _, id := range []string{"m1", "m2", "m3"} {
+		s.enqueueForward(forwardJob{body: []byte(`{}`), meta: TunnelMeta{From: from, MessageID: id, Transport: transportPubSub, ReceivedAt: time.Now()}})
+	}
+	want := ForwardQueueStatus{Length: 1, Capacity: 1, Pending: 1, Dropped: 2}
+	if got := s.GetForwardQueueStatus(); got != want {
+		t.Errorf("forward queue status = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ForwardStats counts tunnel forwards for one recipient ("to" DID), so a recipient whose
+// backend integration keeps failing stands out from a global tunnel outage
+type ForwardStats struct {
+	To string `json:"to"`
+	// Pending is how many of its messages wait in the forward queue
+	Pending   int64 `json:"pending"`
+	Forwarded int64 `json:"forwarded"`
+	// Failed counts tunnel errors and non-2xx responses
+	Failed int64 `json:"failed"`
+	// Dropped counts messages dropped because the forward queue was full
+	Dropped        int64      `json:"dropped"`
+	LastError      string     `json:"lastError,omitempty"`
+	LastStatusCode int        `json:"lastStatusCode,omitempty"`
+	LastSuccessAt  *time.Time `json:"lastSuccessAt,omitempty"`
+	LastFailureAt  *time.Time `json:"lastFailureAt,omitempty"`
+}
+
+// forwardStats keeps ForwardStats per recipient. Only messages addressed to this node are
+// forwarded, so the keys are our DID (plus the gateway alias on gateways).
+type forwardStats struct {
+	mu   sync.Mutex
+	byTo map[string]*ForwardStats
+}
+
+func newForwardStats() *forwardStats {
+	return &forwardStats{byTo: make(map[string]*ForwardStats)}
+}
+
+// get returns the entry for to; callers hold mu
+func (f *forwardStats) get(to string) *ForwardStats {
+	st, ok := f.byTo[to]
+	if !ok {
+		st = &ForwardStats{To: to}
+		f.byTo[to] = st
+	}
+	return st
+}
+
+func (f *forwardStats) enqueued(to string) {
+	f.mu.Lock()
+	f.get(to).Pending++
+	f.mu.Unlock()
+}
+
+func (f *forwardStats) dequeued(to string) {
+	f.mu.Lock()
+	if st := f.get(to); st.Pending > 0 {
+		st.Pending--
+	}
+	f.mu.Unlock()
+}
+
+// record counts the outcome of one forward (resp/err from the tunnel, or errForwardDropped)
+func (f *forwardStats) record(to string, resp *TunnelResponse, err error) {
+	if errors.Is(err, errTunnelSkipped) {
+		return
+	}
+	now := time.Now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	st := f.get(to)
+	if resp != nil {
+		st.LastStatusCode = resp.StatusCode
+	}
+	switch {
+	case errors.Is(err, errForwardDropped):
+		st.Dropped++
+		st.LastError = err.Error()
+		st.LastFailureAt = &now
+	case err != nil:
+		st.Failed++
+		st.LastError = err.Error()
+		st.LastFailureAt = &now
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		st.Failed++
+		st.LastError = fmt.Sprintf("tunnel answered %d", resp.StatusCode)
+		st.LastFailureAt = &now
+	default:
+		st.Forwarded++
+		st.LastSuccessAt = &now
+	}
+}
+
+// List returns a copy of the stats, sorted by recipient
+func (f *forwardStats) List() []ForwardStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]ForwardStats, 0, len(f.byTo))
+	for _, st := range f.byTo {
+		out = append(out, *st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].To < out[j].To })
+	return out
+}
+
+// forwardRecipient is the stats key of a forwarded message: its "to" field, or our own
+// DID for direct messages, which are always addressed to us
+func (s *Libp2pNodeService) forwardRecipient(meta TunnelMeta) string {
+	if meta.To != "" {
+		return meta.To
+	}
+	return s.did
+}
+
+// GetForwardStats returns the per-recipient forward counts
+func (s *Libp2pNodeService) GetForwardStats() []ForwardStats {
+	return s.forwards.List()
+}
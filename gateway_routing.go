@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+)
+
+// routeDirect delivers an outgoing {to, payload} message straight to the recipient over
+// the direct-message protocol instead of flooding the shared topic. It returns false when
+// the recipient isn't a DID or can't be reached directly, so the caller falls back to pubsub.
+func (s *Libp2pNodeService) routeDirect(ctx context.Context, msg map[string]interface{}) bool {
+	to, _ := msg["to"].(string)
+	if !strings.HasPrefix(to, "did:") {
+		return false
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return false
+	}
+
+	// SendDirectMessage bounds each phase itself; this caps the whole attempt at their sum
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeouts.Dial.Std()+s.config.Timeouts.Request.Std())
+	defer cancel()
+	if _, err := s.SendDirectMessage(ctx, to, data); err != nil {
+		log.Printf("[Gateway] Direct route to %s failed, falling back to pubsub: %v", to, err)
+		gatewayRouted.WithLabelValues("pubsub_fallback").Inc()
+		return false
+	}
+	gatewayRouted.WithLabelValues("direct").Inc()
+	log.Printf("[Gateway] Routed message directly to %s", to)
+	return true
+}
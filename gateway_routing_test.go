@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// stallingListener accepts TCP connections and never answers, so a libp2p dial to it
+// hangs in the handshake until its ctx is done
+func stallingListener(t *testing.T) ma.Multiaddr {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	addr, err := manet.FromNetAddr(ln.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addr
+}
+
+func TestGatewayDirectRoutingFallsBackToPubsub(t *testing.T) {
+	backend := newTestBackend(t)
+	s := newTestService(t, testConfig(t, backend.URL, func(c *Config) {
+		c.IsGateway = true
+		c.GatewayRouting = "direct"
+		// the direct attempt stalls for longer than a whole publish may take
+		c.Timeouts.Dial = Duration(time.Second)
+		c.Timeouts.Publish = Duration(500 * time.Millisecond)
+	}))
+	sub, err := s.getTopic().Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Cancel()
+
+	pid, did := testPeer(t)
+	s.node.Peerstore().AddAddrs(pid, []ma.Multiaddr{stallingListener(t)}, time.Hour)
+
+	msgID, err := s.HandleOutgoingMessage(context.Background(), map[string]interface{}{"to": did, "payload": "hello"})
+	if err != nil {
+		t.Fatalf("HandleOutgoingMessage: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg, err := sub.Next(ctx)
+	if err != nil {
+		t.Fatalf("message not published: %v", err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(msg.Data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["messageId"] != msgID || got["to"] != did {
+		t.Errorf("published %v, want messageId %s to %s", got, msgID, did)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+// GossipSubConfig overrides the GossipSub mesh parameters. Our meshes are small and
+// latency-sensitive, so a smaller D with a faster heartbeat is often preferable to the
+// library defaults (D=6, Dlo=5, Dhi=12, 1s heartbeat, 5 history windows).
+type GossipSubConfig struct {
+	// D is the target mesh degree; the mesh is kept between Dlo and Dhi
+	D   int `yaml:"d" json:"d"`
+	Dlo int `yaml:"dlo" json:"dlo"`
+	Dhi int `yaml:"dhi" json:"dhi"`
+	// Heartbeat is the mesh maintenance interval
+	Heartbeat Duration `yaml:"heartbeat" json:"heartbeat"`
+	// HistoryLength is how many heartbeats messages stay in the cache (for IWANT),
+	// HistoryGossip how many of those are advertised in IHAVE gossip
+	HistoryLength int `yaml:"historyLength" json:"historyLength"`
+	HistoryGossip int `yaml:"historyGossip" json:"historyGossip"`
+	// MaxMessageSize is the largest pubsub RPC sent or accepted. Peers drop larger ones
+	// silently, so it must match across the mesh (bootstrap nodes: --max-message-size).
+	MaxMessageSize int `yaml:"maxMessageSize" json:"maxMessageSize"`
+}
+
+// pubsubMessageOverhead is the headroom kept below MaxMessageSize for what gossipsub adds
+// to our data: sender, seqno, topic, signature and RPC framing
+const pubsubMessageOverhead = 1 << 10
+
+// minPubSubMessageSize keeps MaxMessageSize above the overhead with room for a payload
+const minPubSubMessageSize = 4 << 10
+
+// DefaultGossipSubConfig returns the library defaults
+func DefaultGossipSubConfig() GossipSubConfig {
+	p := pubsub.DefaultGossipSubParams()
+	return GossipSubConfig{
+		D:             p.D,
+		Dlo:           p.Dlo,
+		Dhi:           p.Dhi,
+		Heartbeat:     Duration(p.HeartbeatInterval),
+		HistoryLength: p.HistoryLength,
+		HistoryGossip: p.HistoryGossip,
+
+		MaxMessageSize: pubsub.DefaultMaxMessageSize,
+	}
+}
+
+// Validate checks 1 <= Dlo <= D <= Dhi, a positive heartbeat and 1 <= HistoryGossip <= HistoryLength
+func (c GossipSubConfig) Validate() error {
+	if c.Dlo < 1 || c.D < c.Dlo || c.Dhi < c.D {
+		return fmt.Errorf("invalid gossipSub degree: need 1 <= dlo <= d <= dhi (got dlo=%d d=%d dhi=%d)", c.Dlo, c.D, c.Dhi)
+	}
+	if c.Heartbeat < Duration(100*time.Millisecond) {
+		return fmt.Errorf("invalid gossipSub.heartbeat: %s (minimum 100ms)", c.Heartbeat.Std())
+	}
+	if c.HistoryGossip < 1 || c.HistoryLength < c.HistoryGossip {
+		return fmt.Errorf("invalid gossipSub history: need 1 <= historyGossip <= historyLength (got %d, %d)", c.HistoryGossip, c.HistoryLength)
+	}
+	if c.MaxMessageSize < minPubSubMessageSize {
+		return fmt.Errorf("invalid gossipSub.maxMessageSize: %d (minimum %d)", c.MaxMessageSize, minPubSubMessageSize)
+	}
+	return nil
+}
+
+// Params returns the library defaults with our overrides applied
+func (c GossipSubConfig) Params() pubsub.GossipSubParams {
+	p := pubsub.DefaultGossipSubParams()
+	p.D = c.D
+	p.Dlo = c.Dlo
+	p.Dhi = c.Dhi
+	p.HeartbeatInterval = c.Heartbeat.Std()
+	p.HistoryLength = c.HistoryLength
+	p.HistoryGossip = c.HistoryGossip
+	// Dout must stay below Dlo and at most D/2, and Dscore at most D
+	p.Dout = min(p.Dout, c.Dlo-1, c.D/2)
+	p.Dscore = min(p.Dscore, c.D)
+	return p
+}
+
+// PubSubOptions builds the GossipSub options carrying the effective parameters and message size limit
+func (c GossipSubConfig) PubSubOptions() []pubsub.Option {
+	return []pubsub.Option{pubsub.WithGossipSubParams(c.Params()), pubsub.WithMaxMessageSize(c.MaxMessageSize)}
+}
+
+// MaxPublishBytes is the largest encoded message we publish: MaxMessageSize minus the
+// gossipsub overhead
+func (c GossipSubConfig) MaxPublishBytes() int {
+	return c.MaxMessageSize - pubsubMessageOverhead
+}
+
+func (c GossipSubConfig) String() string {
+	return fmt.Sprintf("D=%d Dlo=%d Dhi=%d heartbeat=%s historyLength=%d historyGossip=%d maxMessageSize=%d",
+		c.D, c.Dlo, c.Dhi, c.Heartbeat.Std(), c.HistoryLength, c.HistoryGossip, c.MaxMessageSize)
+}
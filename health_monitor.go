@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// HealthMonitorConfig controls the background neighbor health checks
+type HealthMonitorConfig struct {
+	// Interval between health pings to every neighbor (0 disables the monitor)
+	Interval Duration `yaml:"interval" json:"interval"`
+	// FailureThreshold is how many consecutive failed pings mark a neighbor unhealthy
+	FailureThreshold int `yaml:"failureThreshold" json:"failureThreshold"`
+	// Action on an unhealthy neighbor: "log" or "disconnect"
+	Action string `yaml:"action" json:"action"`
+}
+
+// NeighborHealth is the health state of one neighbor
+type NeighborHealth struct {
+	PeerID              string    `json:"peerId"`
+	Healthy             bool      `json:"healthy"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	LastCheck           time.Time `json:"lastCheck"`
+	LastError           string    `json:"lastError,omitempty"`
+}
+
+// EvtNeighborHealthChanged is emitted on the host event bus when a neighbor becomes
+// unhealthy or recovers
+type EvtNeighborHealthChanged struct {
+	Peer         peer.ID
+	Healthy      bool
+	Failures     int
+	Disconnected bool // the monitor closed the connection (action "disconnect")
+}
+
+type healthMonitor struct {
+	mu    sync.Mutex
+	peers map[peer.ID]*NeighborHealth
+	done  chan struct{} // closed when the monitor loop exits
+}
+
+func newHealthMonitor() *healthMonitor {
+	return &healthMonitor{peers: make(map[peer.ID]*NeighborHealth)}
+}
+
+// monitorNeighbors pings every neighbor each interval until ctx is cancelled
+func (s *Libp2pNodeService) monitorNeighbors(ctx context.Context, emitter event.Emitter) {
+	defer close(s.health.done)
+	defer emitter.Close()
+	cfg := s.config.HealthMonitor
+	ticker := time.NewTicker(cfg.Interval.Std())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.checkNeighbors(ctx, emitter)
+		}
+	}
+}
+
+func (s *Libp2pNodeService) checkNeighbors(ctx context.Context, emitter event.Emitter) {
+	cfg := s.config.HealthMonitor
+	peers := s.node.Network().Peers()
+	errs := make([]error, len(peers))
+	var wg sync.WaitGroup
+	for i, pid := range peers {
+		wg.Add(1)
+		go func(i int, pid peer.ID) {
+			defer wg.Done()
+			pingCtx, cancel := context.WithTimeout(ctx, s.config.Timeouts.Request.Std())
+			defer cancel()
+			rtt, err := pingOnce(pingCtx, s.node, pid)
+			if err == nil {
+				s.latency.record(pid, rtt, "background")
+			}
+			errs[i] = err
+		}(i, pid)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return // shutting down, the failures are ours
+	}
+
+	now := time.Now()
+	connected := make(map[peer.ID]bool, len(peers))
+	var changed []EvtNeighborHealthChanged
+	s.health.mu.Lock()
+	for i, pid := range peers {
+		connected[pid] = true
+		h, ok := s.health.peers[pid]
+		if !ok {
+			h = &NeighborHealth{PeerID: pid.String(), Healthy: true}
+			s.health.peers[pid] = h
+		}
+		h.LastCheck = now
+		if errs[i] == nil {
+			neighborHealthChecks.WithLabelValues("ok").Inc()
+			if !h.Healthy {
+				changed = append(changed, EvtNeighborHealthChanged{Peer: pid, Healthy: true})
+			}
+			h.Healthy, h.ConsecutiveFailures, h.LastError = true, 0, ""
+			continue
+		}
+		neighborHealthChecks.WithLabelValues("failed").Inc()
+		h.ConsecutiveFailures++
+		h.LastError = errs[i].Error()
+		if h.Healthy && h.ConsecutiveFailures >= cfg.FailureThreshold {
+			h.Healthy = false
+			changed = append(changed, EvtNeighborHealthChanged{Peer: pid, Failures: h.ConsecutiveFailures, Disconnected: cfg.Action == "disconnect"})
+		}
+	}
+	// 已断开的邻居不再跟踪
+	unhealthy := 0
+	for pid, h := range s.health.peers {
+		if !connected[pid] {
+			delete(s.health.peers, pid)
+		} else if !h.Healthy {
+			unhealthy++
+		}
+	}
+	s.health.mu.Unlock()
+	neighborsUnhealthy.Set(float64(unhealthy))
+
+	for _, evt := range changed {
+		if evt.Healthy {
+			log.Printf("[Health] Neighbor %s recovered", evt.Peer)
+		} else {
+			log.Printf("[Health] Neighbor %s unhealthy after %d failed pings", evt.Peer, evt.Failures)
+			if evt.Disconnected {
+				if err := s.node.Network().ClosePeer(evt.Peer); err != nil {
+					log.Printf("[Health] Failed to disconnect %s: %v", evt.Peer, err)
+				}
+				neighborDisconnects.Inc()
+			}
+		}
+		if err := emitter.Emit(evt); err != nil {
+			log.Printf("[Health] Failed to emit health event: %v", err)
+		}
+	}
+}
+
+// GetNeighborHealth returns the health state of the monitored neighbors, unhealthy first
+func (s *Libp2pNodeService) GetNeighborHealth() []NeighborHealth {
+	s.health.mu.Lock()
+	out := make([]NeighborHealth, 0, len(s.health.peers))
+	for _, h := range s.health.peers {
+		out = append(out, *h)
+	}
+	s.health.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Healthy != out[j].Healthy {
+			return !out[i].Healthy
+		}
+		return out[i].PeerID < out[j].PeerID
+	})
+	return out
+}
+
+// startHealthMonitor starts the monitor if enabled; Stop waits for it to exit
+func (s *Libp2pNodeService) startHealthMonitor(ctx context.Context) {
+	if s.config.HealthMonitor.Interval <= 0 {
+		return
+	}
+	emitter, err := s.node.EventBus().Emitter(new(EvtNeighborHealthChanged))
+	if err != nil {
+		log.Printf("[Health] Monitor disabled: %v", err)
+		return
+	}
+	s.health.done = make(chan struct{})
+	go s.monitorNeighbors(ctx, emitter)
+}
+
+// waitHealthMonitor blocks until the monitor loop has exited (ctx must already be cancelled)
+func (s *Libp2pNodeService) waitHealthMonitor() {
+	if s.health.done != nil {
+		<-s.health.done
+	}
+}
+
+// Validate checks the interval, threshold and action
+func (c HealthMonitorConfig) Validate() error {
+	if c.Interval < 0 {
+		return fmt.Errorf("invalid healthMonitor.interval: %s", c.Interval.Std())
+	}
+	if c.FailureThreshold <= 0 {
+		return fmt.Errorf("invalid healthMonitor.failureThreshold: %d", c.FailureThreshold)
+	}
+	if c.Action != "log" && c.Action != "disconnect" {
+		return fmt.Errorf("invalid healthMonitor.action %q (use log or disconnect)", c.Action)
+	}
+	return nil
+}
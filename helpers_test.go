@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// recordedRequest is one request the test backend received from a tunnel
+type recordedRequest struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+// testBackend is an httptest tunnel backend that records what it receives
+type testBackend struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	reqs   []recordedRequest
+	status int
+	delay  time.Duration
+}
+
+func newTestBackend(t *testing.T) *testBackend {
+	t.Helper()
+	b := &testBackend{status: http.StatusOK}
+	b.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		b.mu.Lock()
+		b.reqs = append(b.reqs, recordedRequest{Method: r.Method, Path: r.URL.Path, Header: r.Header.Clone(), Body: body})
+		status, delay := b.status, b.delay
+		b.mu.Unlock()
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(b.Close)
+	return b
+}
+
+func (b *testBackend) requests() []recordedRequest {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.reqs)
+}
+
+// waitRequests waits until the backend received at least n requests
+func (b *testBackend) waitRequests(t *testing.T, n int) []recordedRequest {
+	t.Helper()
+	waitFor(t, 10*time.Second, func() bool { return len(b.requests()) >= n }, "backend requests")
+	return b.requests()
+}
+
+// testConfig is a validated config for an in-process node on 127.0.0.1 with free ports,
+// its own data dir and no background pings; received messages go to backendURL
+func testConfig(t *testing.T, backendURL string, mutate ...func(*Config)) *Config {
+	t.Helper()
+	nodePort, restPort, err := randomPorts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	cfg.NodePort = nodePort
+	cfg.Libp2pPort = restPort
+	cfg.BindInterface = "127.0.0.1"
+	cfg.DataDir = t.TempDir()
+	cfg.Bootstrap = nil
+	cfg.Registry.Persist = false
+	cfg.HealthMonitor.Interval = 0
+	cfg.UptimeLogInterval = 0
+	cfg.Tunnel.URL = backendURL + "/libp2p/message"
+	for _, m := range mutate {
+		m(cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("invalid test config: %v", err)
+	}
+	return cfg
+}
+
+// newTestService starts a node with cfg and stops it when the test ends
+func newTestService(t *testing.T, cfg *Config) *Libp2pNodeService {
+	t.Helper()
+	kp, err := generateKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewLibp2pNodeService(kp, cfg)
+	s.InitNode()
+	t.Cleanup(s.Stop)
+	return s
+}
+
+// connectServices connects a to b and waits until both have the other in their gossipsub
+// mesh; a message published before that can be lost
+func connectServices(t *testing.T, a, b *Libp2pNodeService) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := a.node.Connect(ctx, peer.AddrInfo{ID: b.node.ID(), Addrs: b.node.Addrs()}); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	waitFor(t, 10*time.Second, func() bool {
+		return a.mesh.inMesh(a.config.Topic, b.node.ID()) && b.mesh.inMesh(b.config.Topic, a.node.ID())
+	}, "gossipsub mesh")
+}
+
+// waitFor polls cond until it holds or timeout expires
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool, what string) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// serveHandler runs h on a request with the given mux route vars and returns the recorded response
+func serveHandler(h http.HandlerFunc, method, target string, body io.Reader, vars map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, body)
+	if vars != nil {
+		r = mux.SetURLVars(r, vars)
+	}
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+// decodeAPIError decodes the error envelope of an error response
+func decodeAPIError(t *testing.T, w *httptest.ResponseRecorder) APIError {
+	t.Helper()
+	var body map[string]APIError
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decode error body: %v", err)
+	}
+	return body["error"]
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Connection types reported in the neighbor details
+const (
+	ConnDirect  = "direct"
+	ConnRelayed = "relayed"
+)
+
+// holePunchTracer logs DCUtR attempts and counts their results
+type holePunchTracer struct{}
+
+func (holePunchTracer) Trace(evt *holepunch.Event) {
+	switch e := evt.Evt.(type) {
+	case *holepunch.DirectDialEvt:
+		if e.Success {
+			log.Printf("[HolePunch] %s: dialed directly in %s (connection reversal)", evt.Remote, e.EllapsedTime)
+		}
+	case *holepunch.EndHolePunchEvt:
+		if e.Success {
+			holePunches.WithLabelValues("success").Inc()
+			log.Printf("[HolePunch] %s: hole punched in %s", evt.Remote, e.EllapsedTime)
+		} else {
+			holePunches.WithLabelValues("failure").Inc()
+			log.Printf("[HolePunch] %s: hole punching failed after %s, staying relayed: %s", evt.Remote, e.EllapsedTime, e.Error)
+		}
+	}
+}
+
+// upgradeNotifiee counts relay→direct upgrades: a direct connection to a peer we only reached
+// through a relay, however it came about (DCUtR, connection reversal or the swarm dialing
+// addrs learned over the relayed connection)
+func (s *Libp2pNodeService) upgradeNotifiee() network.Notifiee {
+	return &network.NotifyBundle{
+		ConnectedF: func(n network.Network, c network.Conn) {
+			if isRelayed(c) {
+				return
+			}
+			relayed := 0
+			for _, other := range n.ConnsToPeer(c.RemotePeer()) {
+				if other == c {
+					continue
+				}
+				if !isRelayed(other) {
+					return
+				}
+				relayed++
+			}
+			if relayed > 0 {
+				relayUpgrades.Inc()
+				log.Printf("[HolePunch] Upgraded %s from relayed to direct (%s)", peerLabel(c), c.RemoteMultiaddr())
+			}
+		},
+	}
+}
+
+// upgradeRelayed is called when pid is only reachable through a relay: it tries a direct dial
+// (addrs learned over the relayed connection) within Timeouts.Dial, and if that fails returns
+// ctx allowing the stream over the relay, so direct sends work before, during and after a
+// hole punching upgrade
+func (s *Libp2pNodeService) upgradeRelayed(ctx context.Context, pid peer.ID) context.Context {
+	dialCtx, cancel := context.WithTimeout(ctx, s.config.Timeouts.Dial.Std())
+	defer cancel()
+	dialCtx = network.WithForceDirectDial(dialCtx, "relay upgrade")
+	err := s.node.Connect(dialCtx, peer.AddrInfo{ID: pid})
+	if err == nil {
+		return ctx
+	}
+	log.Printf("[HolePunch] %s: no direct connection (%v), sending over the relay", pid, err)
+	return network.WithAllowLimitedConn(ctx, "direct message over relay")
+}
+
+// isRelayed reports whether c goes through a circuit relay
+func isRelayed(c network.Conn) bool {
+	_, err := c.RemoteMultiaddr().ValueForProtocol(ma.P_CIRCUIT)
+	return err == nil
+}
+
+// connectionType reports whether we reach pid directly or only through a relay (/p2p-circuit);
+// empty when not connected. After an upgrade the relayed connection may linger, but new
+// streams use the direct one, so any direct connection counts as direct.
+func connectionType(n network.Network, pid peer.ID) string {
+	conns := n.ConnsToPeer(pid)
+	if len(conns) == 0 {
+		return ""
+	}
+	for _, c := range conns {
+		if !isRelayed(c) {
+			return ConnDirect
+		}
+	}
+	return ConnRelayed
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// counterValue reads a counter of the default registry, 0 if it has no samples yet
+func counterValue(t *testing.T, name string) float64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() == name && len(f.GetMetric()) > 0 {
+			return f.GetMetric()[0].GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestDirectSendAcrossRelayUpgrade(t *testing.T) {
+	relay, err := libp2p.New(
+		libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+		libp2p.EnableRelayService(),
+		libp2p.ForceReachabilityPublic(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { relay.Close() })
+	relayInfo := peer.AddrInfo{ID: relay.ID(), Addrs: relay.Addrs()}
+
+	backendB := newTestBackend(t)
+	a := newTestService(t, testConfig(t, newTestBackend(t).URL))
+	b := newTestService(t, testConfig(t, backendB.URL))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// b is only reachable through the relay: it reserves a slot and stops listening directly
+	if err := b.node.Connect(ctx, relayInfo); err != nil {
+		t.Fatalf("b connect to relay: %v", err)
+	}
+	if _, err := client.Reserve(ctx, b.node.(host.Host), relayInfo); err != nil {
+		t.Fatalf("relay reservation: %v", err)
+	}
+	var directAddrs []ma.Multiaddr
+	for _, addr := range b.node.Network().ListenAddresses() {
+		if _, err := addr.ValueForProtocol(ma.P_CIRCUIT); err != nil {
+			directAddrs = append(directAddrs, addr)
+		}
+	}
+	b.node.Network().(interface{ ListenClose(...ma.Multiaddr) }).ListenClose(directAddrs...)
+
+	circuit := ma.StringCast(relay.Addrs()[0].String() + "/p2p/" + relay.ID().String() + "/p2p-circuit")
+	if err := a.node.Connect(ctx, peer.AddrInfo{ID: b.node.ID(), Addrs: []ma.Multiaddr{circuit}}); err != nil {
+		t.Fatalf("connect through the relay: %v", err)
+	}
+	if got := connectionType(a.node.Network(), b.node.ID()); got != ConnRelayed {
+		t.Fatalf("connection type = %q, want relayed", got)
+	}
+
+	send := func(n int) {
+		t.Helper()
+		body := []byte(fmt.Sprintf(`{"payload":{"n":%d}}`, n))
+		if _, err := a.SendDirectMessage(ctx, b.did, body); err != nil {
+			t.Fatalf("send %d: %v", n, err)
+		}
+		reqs := backendB.waitRequests(t, n)
+		if !bytes.Contains(reqs[n-1].Body, []byte(fmt.Sprintf(`"n":%d`, n))) {
+			t.Errorf("send %d forwarded %s", n, reqs[n-1].Body)
+		}
+	}
+
+	// no direct address works yet: the message goes over the relay
+	send(1)
+	if got := connectionType(a.node.Network(), b.node.ID()); got != ConnRelayed {
+		t.Fatalf("after relayed send: connection type = %q, want relayed", got)
+	}
+
+	// b is reachable directly again and a learns its addresses: the next send upgrades
+	if err := b.node.Network().Listen(directAddrs...); err != nil {
+		t.Fatalf("listen again: %v", err)
+	}
+	a.node.Peerstore().AddAddrs(b.node.ID(), b.node.Network().ListenAddresses(), time.Hour)
+	upgrades := counterValue(t, "sight_relay_upgrades_total")
+	send(2)
+	if got := connectionType(a.node.Network(), b.node.ID()); got != ConnDirect {
+		t.Fatalf("after upgrade: connection type = %q, want direct", got)
+	}
+	if a.node.Network().Connectedness(b.node.ID()) != network.Connected {
+		t.Error("peer not fully connected after the upgrade")
+	}
+	waitFor(t, 5*time.Second, func() bool { return counterValue(t, "sight_relay_upgrades_total") > upgrades }, "relay upgrade counted")
+
+	// and sends keep working on the direct connection
+	send(3)
+}
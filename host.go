@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	kbucket "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/event"
+	hostlibp2p "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// nodeHost is the part of the libp2p host the service depends on. A real host.Host
+// satisfies it; tests can substitute a fake to simulate peers without a libp2p stack.
+type nodeHost interface {
+	ID() peer.ID
+	Addrs() []ma.Multiaddr
+	Peerstore() peerstore.Peerstore
+	Network() network.Network
+	EventBus() event.Bus
+	ConnManager() connmgr.ConnManager
+	Connect(ctx context.Context, pi peer.AddrInfo) error
+	NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error)
+	SetStreamHandler(pid protocol.ID, handler network.StreamHandler)
+	RemoveStreamHandler(pid protocol.ID)
+	Close() error
+}
+
+// nodeDHT is the part of the Kademlia DHT the service depends on, satisfied by *dht.IpfsDHT
+type nodeDHT interface {
+	FindPeer(ctx context.Context, id peer.ID) (peer.AddrInfo, error)
+	Bootstrap(ctx context.Context) error
+	RefreshRoutingTable() <-chan error
+	RoutingTable() *kbucket.RoutingTable
+	Mode() dht.ModeOpt
+}
+
+var (
+	_ nodeHost = hostlibp2p.Host(nil)
+	_ nodeDHT  = (*dht.IpfsDHT)(nil)
+)
+
+// pingOnce returns the RTT of a single ping over the standard ping protocol and records it
+// in the peerstore like ping.Ping does. Only NewStream is needed, so it works on a fake host.
+func pingOnce(ctx context.Context, h nodeHost, pid peer.ID) (time.Duration, error) {
+	stream, err := h.NewStream(ctx, pid, ping.ID)
+	if err != nil {
+		return 0, fmt.Errorf("ping %s: %w", pid, err)
+	}
+	defer stream.Reset()
+	stop := context.AfterFunc(ctx, func() { stream.Reset() }) // ctx 结束时中断读写
+	defer stop()
+
+	buf := make([]byte, ping.PingSize)
+	rand.Read(buf)
+	start := time.Now()
+	echo := make([]byte, ping.PingSize)
+	if _, err = stream.Write(buf); err == nil {
+		_, err = io.ReadFull(stream, echo)
+	}
+	if ctx.Err() != nil {
+		return 0, fmt.Errorf("ping %s: %w", pid, ctx.Err())
+	}
+	if err != nil {
+		return 0, fmt.Errorf("ping %s: %w", pid, err)
+	}
+	if !bytes.Equal(buf, echo) {
+		return 0, fmt.Errorf("ping %s: %w", pid, errors.New("ping packet was incorrect"))
+	}
+	rtt := time.Since(start)
+	h.Peerstore().RecordLatency(pid, rtt)
+	return rtt, nil
+}
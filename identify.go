@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
+)
+
+// PeerIdentify is what identify told us about a peer
+type PeerIdentify struct {
+	PeerID          string   `json:"peerId"`
+	Connected       bool     `json:"connected"`
+	AgentVersion    string   `json:"agentVersion"`
+	ProtocolVersion string   `json:"protocolVersion"`
+	ListenAddrs     []string `json:"listenAddrs"`
+	// ObservedAddrs are our addresses as this peer reported seeing them
+	ObservedAddrs []string `json:"observedAddrs"`
+	Protocols     []string `json:"protocols"`
+}
+
+// GetPeerIdentify returns a peer's identify info from the peerstore. If the agent version is
+// missing and the peer is connected, an identify exchange is run first (bounded by ctx).
+func (s *Libp2pNodeService) GetPeerIdentify(ctx context.Context, peerIdStr string) (PeerIdentify, error) {
+	pid, err := peer.Decode(peerIdStr)
+	if err != nil {
+		return PeerIdentify{}, fmt.Errorf("%w %q: %v", ErrInvalidTarget, peerIdStr, err)
+	}
+	connected := s.node.Network().Connectedness(pid) == network.Connected
+	if _, err := s.node.Peerstore().Get(pid, "AgentVersion"); err != nil && connected {
+		s.identifyPeer(ctx, pid)
+	}
+
+	info := PeerIdentify{
+		PeerID:        pid.String(),
+		Connected:     connected,
+		ListenAddrs:   []string{},
+		ObservedAddrs: s.observed.observedBy(pid),
+	}
+	if v, err := s.node.Peerstore().Get(pid, "AgentVersion"); err == nil {
+		info.AgentVersion, _ = v.(string)
+	}
+	if v, err := s.node.Peerstore().Get(pid, "ProtocolVersion"); err == nil {
+		info.ProtocolVersion, _ = v.(string)
+	}
+	for _, addr := range s.node.Peerstore().Addrs(pid) {
+		info.ListenAddrs = append(info.ListenAddrs, addr.String())
+	}
+	sort.Strings(info.ListenAddrs)
+	if info.ObservedAddrs == nil {
+		info.ObservedAddrs = []string{}
+	}
+	if info.Protocols, err = s.peerProtocols(pid); err != nil {
+		return PeerIdentify{}, err
+	}
+	return info, nil
+}
+
+// identifyPeer runs identify on each connection to pid (a no-op for connections that
+// were already identified) and waits for it or ctx
+func (s *Libp2pNodeService) identifyPeer(ctx context.Context, pid peer.ID) {
+	h, ok := s.node.(interface{ IDService() identify.IDService })
+	if !ok {
+		return
+	}
+	for _, conn := range s.node.Network().ConnsToPeer(pid) {
+		select {
+		case <-h.IDService().IdentifyWait(conn):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// LatencyPingConfig controls the latency history. Besides API pings it is fed by the health
+// monitor's neighbor pings (healthMonitor.interval), so no second ping loop runs for it.
+type LatencyPingConfig struct {
+	// HistorySize is how many samples are kept per peer
+	HistorySize int `yaml:"historySize" json:"historySize"`
+}
+
+// LatencySample is one RTT measurement
+type LatencySample struct {
+	At     time.Time `json:"at"`
+	RTTMs  float64   `json:"rttMs"`
+	Source string    `json:"source"` // "ping" (API request) or "background" (health monitor)
+}
+
+// LatencyStats summarizes the recent samples of a peer
+type LatencyStats struct {
+	PeerID  string          `json:"peerId"`
+	Count   int             `json:"count"`
+	MinMs   float64         `json:"minMs"`
+	AvgMs   float64         `json:"avgMs"`
+	MaxMs   float64         `json:"maxMs"`
+	P95Ms   float64         `json:"p95Ms"`
+	Samples []LatencySample `json:"samples"` // oldest first
+}
+
+// latencyHistory keeps a ring buffer of the last size samples per connected peer
+type latencyHistory struct {
+	size int
+
+	mu    sync.Mutex
+	peers map[peer.ID]*latencyRing
+}
+
+type latencyRing struct {
+	samples []LatencySample
+	next    int // index overwritten next once the ring is full
+}
+
+func newLatencyHistory(size int) *latencyHistory {
+	return &latencyHistory{size: size, peers: make(map[peer.ID]*latencyRing)}
+}
+
+func (h *latencyHistory) record(pid peer.ID, rtt time.Duration, source string) {
+	sample := LatencySample{At: time.Now(), RTTMs: float64(rtt.Microseconds()) / 1000, Source: source}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r, ok := h.peers[pid]
+	if !ok {
+		r = &latencyRing{}
+		h.peers[pid] = r
+	}
+	if len(r.samples) < h.size {
+		r.samples = append(r.samples, sample)
+		return
+	}
+	r.samples[r.next] = sample
+	r.next = (r.next + 1) % h.size
+}
+
+// forget drops the samples of a peer that disconnected
+func (h *latencyHistory) forget(pid peer.ID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.peers, pid)
+}
+
+func (h *latencyHistory) stats(pid peer.ID) LatencyStats {
+	h.mu.Lock()
+	var samples []LatencySample
+	if r, ok := h.peers[pid]; ok {
+		// 按时间顺序展开环形缓冲
+		samples = append(samples, r.samples[r.next:]...)
+		samples = append(samples, r.samples[:r.next]...)
+	}
+	h.mu.Unlock()
+
+	out := LatencyStats{PeerID: pid.String(), Count: len(samples), Samples: samples}
+	if len(samples) == 0 {
+		out.Samples = []LatencySample{}
+		return out
+	}
+	rtts := make([]float64, len(samples))
+	sum := 0.0
+	for i, s := range samples {
+		rtts[i] = s.RTTMs
+		sum += s.RTTMs
+	}
+	sort.Float64s(rtts)
+	out.MinMs = rtts[0]
+	out.MaxMs = rtts[len(rtts)-1]
+	out.AvgMs = sum / float64(len(rtts))
+	out.P95Ms = rtts[int(math.Ceil(0.95*float64(len(rtts))))-1] // nearest-rank
+	return out
+}
+
+// GetPeerLatency returns the recent latency samples of a peer with min/avg/max/p95
+func (s *Libp2pNodeService) GetPeerLatency(peerId string) (LatencyStats, error) {
+	pid, err := peer.Decode(peerId)
+	if err != nil {
+		return LatencyStats{}, fmt.Errorf("%w %q: %v", ErrInvalidTarget, peerId, err)
+	}
+	return s.latency.stats(pid), nil
+}
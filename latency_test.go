@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestHealthMonitorFeedsLatencyHistory(t *testing.T) {
+	s, h, _ := newFakeService(t)
+	p, _ := h.addPeer(t)
+	if err := h.Connect(context.Background(), peer.AddrInfo{ID: p.ID, Addrs: p.Addrs}); err != nil {
+		t.Fatal(err)
+	}
+	emitter, err := h.EventBus().Emitter(new(EvtNeighborHealthChanged))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer emitter.Close()
+
+	// one round of health checks is one background latency sample, no separate ping loop
+	for round := 1; round <= 2; round++ {
+		s.checkNeighbors(context.Background(), emitter)
+		st := s.latency.stats(p.ID)
+		if st.Count != round || st.Samples[round-1].Source != "background" {
+			t.Fatalf("after %d health checks: latency history = %+v", round, st)
+		}
+	}
+	if health := s.GetNeighborHealth(); len(health) != 1 || !health[0].Healthy {
+		t.Errorf("neighbor health = %+v", health)
+	}
+
+	// disconnecting drops the peer's samples
+	conn := h.Network().ConnsToPeer(p.ID)[0]
+	h.disconnect(p.ID)
+	s.connNotifiee().Disconnected(h.Network(), conn)
+	if st := s.latency.stats(p.ID); st.Count != 0 {
+		t.Errorf("latency history kept %d samples after disconnect", st.Count)
+	}
+	s.latency.mu.Lock()
+	defer s.latency.mu.Unlock()
+	if _, ok := s.latency.peers[p.ID]; ok {
+		t.Error("disconnected peer still in the latency history")
+	}
+}
+
+func TestLatencyHistoryForgetKeepsOtherPeers(t *testing.T) {
+	hist := newLatencyHistory(2)
+	a, _ := testPeer(t)
+	b, _ := testPeer(t)
+	hist.record(a, 1, "ping")
+	hist.record(b, 1, "ping")
+	hist.forget(a)
+	if hist.stats(a).Count != 0 || hist.stats(b).Count != 1 {
+		t.Errorf("forget(a) left a = %d, b = %d samples", hist.stats(a).Count, hist.stats(b).Count)
+	}
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -18,11 +22,13 @@ import (
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	"github.com/libp2p/go-libp2p/core/crypto"
-	hostlibp2p "github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
 	"github.com/mr-tron/base58"
 	"github.com/multiformats/go-multihash"
 	"golang.org/x/crypto/ed25519"
+
+	"sight-libp2p-node/p2pnode"
 )
 
 // Keypair struct to hold the keypair data
@@ -34,10 +40,29 @@ type Keypair struct {
 	PrivateKey []byte `json:"privateKey,omitempty"`
 }
 
-// LoadOrGenerateKeypair function for loading or generating a keypair
-func LoadOrGenerateKeypair() Keypair {
-	keyDir := getDataDir()
-	keyFile := keyDir + "/device-keypair.json"
+// LoadOrGenerateKeypair function for loading or generating a keypair stored as fileName
+// in dataDir (see Config.DataDir and Config.KeypairFile). When NODE_PRIVATE_KEY is set the keypair is built from it and nothing is read from or written to disk.
+// With EPHEMERAL_IDENTITY=1 a fresh keypair is generated in memory on every start and never persisted.
+func LoadOrGenerateKeypair(dataDir, fileName string) Keypair {
+	if v := os.Getenv("NODE_PRIVATE_KEY"); v != "" {
+		kp, err := KeypairFromPrivateKey(v)
+		if err != nil {
+			log.Fatal("Invalid NODE_PRIVATE_KEY: ", err)
+		}
+		log.Printf("[KeyPair] Loaded from NODE_PRIVATE_KEY (not persisted)")
+		return kp
+	}
+	if os.Getenv("EPHEMERAL_IDENTITY") == "1" {
+		kp, err := generateKeypair()
+		if err != nil {
+			log.Fatal("Error generating random seed: ", err)
+		}
+		log.Printf("[KeyPair] Generated ephemeral keypair (EPHEMERAL_IDENTITY, not persisted)")
+		return kp
+	}
+
+	keyDir := dataDir
+	keyFile := filepath.Join(keyDir, fileName)
 
 	// Check if the keypair file exists
 	if _, err := os.Stat(keyFile); err == nil {
@@ -80,33 +105,18 @@ func LoadOrGenerateKeypair() Keypair {
 		log.Printf("[KeyPair] Loaded from %s", keyFile)
 		return kp
 	} else {
-		// Generate a new random 32-byte seed
-		seed := make([]byte, ed25519.SeedSize) // 32 bytes
-		_, err := rand.Read(seed)
+		kp, err := generateKeypair()
 		if err != nil {
 			log.Fatal("Error generating random seed: ", err)
 		}
-
-		// Generate ed25519 keypair from seed
-		privKey := ed25519.NewKeyFromSeed(seed)
-		pubKey := privKey.Public().(ed25519.PublicKey)
-
-		now := time.Now().Format(time.RFC3339)
+		now := kp.CreatedAt
 
 		// Convert seed []byte to []int for JSON decimal array
-		seedInt := make([]int, len(seed))
-		for i, b := range seed {
+		seedInt := make([]int, len(kp.Seed))
+		for i, b := range kp.Seed {
 			seedInt[i] = int(b)
 		}
 
-		kp := Keypair{
-			Seed:       seed,
-			CreatedAt:  now,
-			LastUsed:   now,
-			PublicKey:  pubKey,
-			PrivateKey: privKey,
-		}
-
 		// Prepare JSON with seed as decimal array
 		type jsonKeypair struct {
 			Seed      []int  `json:"seed"`
@@ -134,6 +144,85 @@ func LoadOrGenerateKeypair() Keypair {
 	}
 }
 
+// generateKeypair derives a keypair from a new random 32-byte seed, the same way a
+// persisted seed is loaded (nacl.sign.keyPair.fromSeed)
+func generateKeypair() (Keypair, error) {
+	seed := make([]byte, ed25519.SeedSize) // 32 bytes
+	if _, err := rand.Read(seed); err != nil {
+		return Keypair{}, err
+	}
+	privKey := ed25519.NewKeyFromSeed(seed)
+	now := time.Now().Format(time.RFC3339)
+	return Keypair{
+		Seed:       seed,
+		CreatedAt:  now,
+		LastUsed:   now,
+		PublicKey:  privKey.Public().(ed25519.PublicKey),
+		PrivateKey: privKey,
+	}, nil
+}
+
+// LegacyGatewayKeypair returns the fixed key every gateway used to share (seed[0] = 32).
+// Only for gateways that can't change their peer ID yet (GATEWAY_LEGACY_KEY=1).
+func LegacyGatewayKeypair() Keypair {
+	seed := make([]byte, ed25519.SeedSize)
+	seed[0] = 32
+	privKey := ed25519.NewKeyFromSeed(seed)
+	return Keypair{
+		Seed:       seed,
+		PublicKey:  privKey.Public().(ed25519.PublicKey),
+		PrivateKey: privKey,
+	}
+}
+
+// KeypairFromPrivateKey builds a Keypair from a hex or base64 encoded ed25519 key:
+// either the 32-byte seed, the 64-byte seed+public key, or a libp2p protobuf-encoded
+// private key (which must be ed25519).
+func KeypairFromPrivateKey(encoded string) (Keypair, error) {
+	encoded = strings.TrimSpace(encoded)
+	raw, err := hex.DecodeString(encoded)
+	if err != nil {
+		if raw, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			if raw, err = base64.RawStdEncoding.DecodeString(encoded); err != nil {
+				return Keypair{}, fmt.Errorf("not hex or base64")
+			}
+		}
+	}
+
+	var seed []byte
+	switch len(raw) {
+	case ed25519.SeedSize:
+		seed = raw
+	case ed25519.PrivateKeySize:
+		seed = raw[:ed25519.SeedSize]
+		// the second half must be the public key of the seed, otherwise it's not an ed25519 key
+		if !bytes.Equal(ed25519.NewKeyFromSeed(seed)[ed25519.SeedSize:], raw[ed25519.SeedSize:]) {
+			return Keypair{}, fmt.Errorf("public key half does not match the seed")
+		}
+	default:
+		pk, err := crypto.UnmarshalPrivateKey(raw)
+		if err != nil {
+			return Keypair{}, fmt.Errorf("expected a %d-byte seed, %d-byte ed25519 key or libp2p private key, got %d bytes", ed25519.SeedSize, ed25519.PrivateKeySize, len(raw))
+		}
+		if pk.Type() != crypto.Ed25519 {
+			return Keypair{}, fmt.Errorf("unsupported key type %s (only ed25519)", pk.Type())
+		}
+		b, err := pk.Raw()
+		if err != nil {
+			return Keypair{}, err
+		}
+		seed = b[:ed25519.SeedSize]
+	}
+
+	privKey := ed25519.NewKeyFromSeed(seed)
+	return Keypair{
+		Seed:       seed,
+		PublicKey:  privKey.Public().(ed25519.PublicKey),
+		PrivateKey: privKey,
+	}, nil
+}
+
+// getDataDir is the default Config.DataDir.
 // 和 local backend 逻辑一致：支持 Docker 和本地
 func getDataDir() string {
 	// 首先检查是否设置了 SIGHTAI_DATA_DIR（Docker 环境）
@@ -149,29 +238,42 @@ func getDataDir() string {
 	return filepath.Join(homeDir, ".sightai", "config")
 }
 
-// CreateLibp2pNode creates a libp2p node and returns the host and pubsub service
-func CreateLibp2pNode(ctx context.Context, port int, bootstrapList []string, kp Keypair) (hostlibp2p.Host, *pubsub.PubSub, *dht.IpfsDHT) {
+// CreateLibp2pNode creates a libp2p node (host, pubsub joined to topic, DHT) and dials
+// the bootstrap peers, announcing agentVersion over identify. Extra libp2p options (bandwidth reporter, address factory, ...)
+// go in libp2pOpts, extra GossipSub options (e.g. peer scoring) in psOpts.
+// The DHT is not bootstrapped yet; the caller is responsible for that.
+func CreateLibp2pNode(ctx context.Context, listenAddrs []string, bootstrapList []string, kp Keypair, topic string, agentVersion string, dhtMode dht.ModeOpt, dialCfg BootstrapDialConfig, libp2pOpts []libp2p.Option, psOpts ...pubsub.Option) *p2pnode.Node {
 	priv, err := crypto.UnmarshalEd25519PrivateKey(kp.PrivateKey)
 	if err != nil {
 		log.Fatal("Failed to unmarshal ed25519 private key: ", err)
 	}
-	h, err := libp2p.New(
+	// DCUtR: relayed connections are upgraded to direct ones once both sides know each other's addrs
+	libp2pOpts = append([]libp2p.Option{
 		libp2p.DefaultMuxers,
-		libp2p.ListenAddrStrings(fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", port)),
-		libp2p.Identity(priv),
-	)
-	if err != nil {
-		log.Fatal("Failed to create libp2p host: ", err)
-	}
-	log.Printf("Libp2p Host created with peer ID: %s", h.ID())
-
-	pubsubService, err := pubsub.NewGossipSub(ctx, h)
+		libp2p.UserAgent(agentVersion),
+		libp2p.EnableHolePunching(holepunch.WithTracer(holePunchTracer{})),
+	}, libp2pOpts...)
+	node, err := p2pnode.New(ctx, p2pnode.Options{
+		PrivKey:     priv,
+		ListenAddrs: listenAddrs,
+		Topic:       topic,
+		DHTMode:     dhtMode,
+		Libp2pOpts:  libp2pOpts,
+		PubSubOpts:  psOpts,
+	})
 	if err != nil {
-		log.Fatal("Failed to create pubsub service: ", err)
+		if isAddrInUse(err) {
+			log.Printf("Failed to create libp2p node: node port already in use (%v): %v", listenAddrs, err)
+			os.Exit(exitPortInUse)
+		}
+		log.Fatal("Failed to create libp2p node: ", err)
 	}
+	log.Printf("Libp2p Host created with peer ID: %s", node.ID())
 
-	// Optionally add bootstrap nodes
+	// Optionally add bootstrap nodes. Dials run concurrently; startup continues once
+	// dialCfg.MinConnected peers are connected and the rest finish in the background.
 	if len(bootstrapList) > 0 {
+		var addrs []string
 		var peerAddrs []peer.AddrInfo
 		for _, addr := range bootstrapList {
 			info, err := peer.AddrInfoFromString(addr)
@@ -179,48 +281,52 @@ func CreateLibp2pNode(ctx context.Context, port int, bootstrapList []string, kp
 				log.Printf("Invalid bootstrap addr: %s (%v)", addr, err)
 				continue
 			}
+			addrs = append(addrs, addr)
 			peerAddrs = append(peerAddrs, *info)
 		}
-		for _, info := range peerAddrs {
-			if err := h.Connect(ctx, info); err != nil {
-				log.Printf("Failed to connect to %s: %v", info.ID, err)
-			} else {
-				log.Printf("Connected to bootstrap peer: %s", info.ID)
-			}
-		}
+		reached, done := dialBootstrapPeers(ctx, node.Host, addrs, peerAddrs, dialCfg, dialCfg.MinConnected)
+		<-reached
+		go func() { logBootstrapSummary(<-done) }()
 	}
+	return node
+}
 
-	// DHT
-	myDHT, err := dht.New(ctx, h, dht.Mode(dht.ModeServer))
-	if err != nil {
-		log.Fatal("Failed to create DHT: ", err)
-	}
-	go func() {
-		if err := myDHT.Bootstrap(ctx); err != nil {
-			log.Printf("[DHT] Bootstrap error: %v", err)
-		} else {
-			log.Printf("[DHT] Bootstrapped and ready")
-		}
-	}()
+// DID roles, the segment after "did:sight:"
+const (
+	RoleHoster  = "hoster"
+	RoleGateway = "gateway"
+)
 
-	return h, pubsubService, myDHT
-}
+// GatewayAlias is the legacy "to" address; messages sent to it reach every gateway
+const GatewayAlias = "gateway"
 
-// ToSightDID generates a DID for the node from the public key
-func ToSightDID(publicKey []byte) string {
+// ToSightDID generates a DID for the node from the public key, e.g. did:sight:gateway:<key> for RoleGateway.
+// Roles other than RoleHoster and RoleGateway fall back to RoleHoster so the DID stays parseable.
+func ToSightDID(role string, publicKey []byte) string {
+	if !validDIDRole(role) {
+		role = RoleHoster
+	}
 	multicodec := append([]byte{0xed, 0x01}, publicKey...)
-	return "did:sight:hoster:" + base58.Encode(multicodec)
+	return "did:sight:" + role + ":" + base58.Encode(multicodec)
 }
 
-// peerId -> MultiAddr
-func FindPeerAddr(ctx context.Context, dhtNode *dht.IpfsDHT, peerIdStr string) ([]string, error) {
+// peerId -> MultiAddr, looked up in the DHT for at most timeout.
+// Malformed peer IDs wrap ErrInvalidTarget; a lookup cut off by the timeout wraps
+// context.DeadlineExceeded, one that finished without finding the peer ErrPeerNotFound.
+func FindPeerAddr(ctx context.Context, dhtNode nodeDHT, peerIdStr string, timeout time.Duration) ([]string, error) {
 	pid, err := peer.Decode(peerIdStr)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w %q: %v", ErrInvalidTarget, peerIdStr, err)
 	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 	info, err := dhtNode.FindPeer(ctx, pid)
 	if err != nil {
-		return nil, err
+		// 超时和真正找不到要区分开：前者 504，后者 404
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w: lookup gave up after %s: %v", context.DeadlineExceeded, timeout, err)
+		}
+		return nil, fmt.Errorf("%w: %w", ErrPeerNotFound, err)
 	}
 	var addrs []string
 	for _, addr := range info.Addrs {
@@ -229,6 +335,38 @@ func FindPeerAddr(ctx context.Context, dhtNode *dht.IpfsDHT, peerIdStr string) (
 	return addrs, nil
 }
 
+// PeerIdToDID derives the sight DID of a peer whose ID embeds an ed25519 identity key.
+// Hashed (non-identity) peer IDs cannot be mapped back and return an error.
+// The peer ID doesn't carry the role, so the hoster form is returned; the service resolves
+// the role of peers it identified with peerDID.
+func PeerIdToDID(peerIdStr string) (string, error) {
+	raw, err := DecodePublicKeyFromPeerId(peerIdStr)
+	if err != nil {
+		return "", err
+	}
+	return ToSightDID(RoleHoster, raw), nil
+}
+
+// didMatchesPeer reports whether did (any role) carries the public key embedded in pid
+func didMatchesPeer(did string, pid peer.ID) bool {
+	didKey, err := DIDToPublicKey(did)
+	if err != nil {
+		return false
+	}
+	peerKey, err := DecodePublicKeyFromPeerId(pid.String())
+	return err == nil && bytes.Equal(didKey, peerKey)
+}
+
+var (
+	// ErrNoEmbeddedKey means the peer ID is a hash and doesn't carry the public key
+	ErrNoEmbeddedKey = errors.New("peerid does not embed public key")
+	// ErrInvalidEmbeddedKey means the peer ID embeds something that isn't a valid 32-byte ed25519 key
+	ErrInvalidEmbeddedKey = errors.New("peerid embeds an invalid ed25519 public key")
+)
+
+// DecodePublicKeyFromPeerId returns the raw 32-byte ed25519 public key embedded in an
+// identity-multihash peer ID. Hashed peer IDs return ErrNoEmbeddedKey; identity digests
+// that aren't a protobuf-encoded 32-byte ed25519 key return ErrInvalidEmbeddedKey.
 func DecodePublicKeyFromPeerId(peerId string) ([]byte, error) {
 	// 解码peerId
 	c, err := cid.Decode(peerId)
@@ -245,9 +383,9 @@ func DecodePublicKeyFromPeerId(peerId string) ([]byte, error) {
 		}
 		// 判断是否identity，有identity，可以反推出公钥
 		if mh.Code == multihash.IDENTITY {
-			return mh.Digest, nil
+			return ed25519KeyFromIdentityDigest(mh.Digest)
 		}
-		return nil, fmt.Errorf("peerid does not embed public key")
+		return nil, ErrNoEmbeddedKey
 	}
 
 	// 是cid格式，尝试identity反推公钥
@@ -258,25 +396,78 @@ func DecodePublicKeyFromPeerId(peerId string) ([]byte, error) {
 	}
 
 	if decodedMh.Code == multihash.IDENTITY {
-		return decodedMh.Digest, nil
+		return ed25519KeyFromIdentityDigest(decodedMh.Digest)
 	}
-	return nil, fmt.Errorf("peerid does not embed public key (not identity multihash)")
+	return nil, fmt.Errorf("%w (not identity multihash)", ErrNoEmbeddedKey)
 }
 
-func DIDToPublicKey(did string) ([]byte, error) {
-	const prefix = "did:sight:hoster:"
-	if !strings.HasPrefix(did, prefix) {
-		return nil, fmt.Errorf("not a valid sight DID")
+// ed25519KeyFromIdentityDigest unwraps the protobuf-encoded key of an identity multihash
+// and checks it is exactly a 32-byte ed25519 public key
+func ed25519KeyFromIdentityDigest(digest []byte) ([]byte, error) {
+	pk, err := crypto.UnmarshalPublicKey(digest)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEmbeddedKey, err)
+	}
+	if pk.Type() != crypto.Ed25519 {
+		return nil, fmt.Errorf("%w: key type %s", ErrInvalidEmbeddedKey, pk.Type())
+	}
+	raw, err := pk.Raw()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEmbeddedKey, err)
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrInvalidEmbeddedKey, len(raw))
+	}
+	return raw, nil
+}
+
+// ErrInvalidDID is returned for a DID that isn't did:sight:<role>:<key> with a known role
+// and a multicodec-prefixed ed25519 key
+var ErrInvalidDID = errors.New("not a valid sight DID")
+
+// ParseSightDID splits a did:sight DID into its role (RoleHoster or RoleGateway) and ed25519
+// public key. It is the inverse of ToSightDID for both roles.
+func ParseSightDID(did string) (role string, publicKey []byte, err error) {
+	role, encoded, err := splitSightDID(did)
+	if err != nil {
+		return "", nil, err
 	}
-	encoded := did[len(prefix):]
 	decoded, err := base58.Decode(encoded)
 	if err != nil {
-		return nil, err
+		return "", nil, fmt.Errorf("%w: key: %v", ErrInvalidDID, err)
 	}
 	if len(decoded) != 34 || decoded[0] != 0xed || decoded[1] != 0x01 {
-		return nil, fmt.Errorf("not a valid ed25519 encoded key")
+		return "", nil, fmt.Errorf("%w: not a valid ed25519 encoded key", ErrInvalidDID)
+	}
+	return role, decoded[2:], nil
+}
+
+// DIDToPublicKey returns the ed25519 public key of a did:sight:hoster: or did:sight:gateway: DID
+func DIDToPublicKey(did string) ([]byte, error) {
+	_, pub, err := ParseSightDID(did)
+	return pub, err
+}
+
+// SightDIDRole returns the role segment (RoleHoster or RoleGateway) of a did:sight DID
+func SightDIDRole(did string) (string, error) {
+	role, _, err := splitSightDID(did)
+	return role, err
+}
+
+// validDIDRole reports whether role is a role segment ToSightDID may emit
+func validDIDRole(role string) bool {
+	return role == RoleHoster || role == RoleGateway
+}
+
+func splitSightDID(did string) (role, encoded string, err error) {
+	parts := strings.SplitN(did, ":", 4)
+	if len(parts) != 4 || parts[0] != "did" || parts[1] != "sight" {
+		return "", "", ErrInvalidDID
+	}
+	if !validDIDRole(parts[2]) {
+		return "", "", fmt.Errorf("%w: unknown role %q (want %s or %s)", ErrInvalidDID, parts[2], RoleHoster, RoleGateway)
 	}
-	return decoded[2:], nil
+	return parts[2], parts[3], nil
 }
 
 func PublicKeyToPeerId(pub []byte) (peer.ID, error) {
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/mr-tron/base58"
+	"github.com/multiformats/go-multihash"
+)
+
+// identityPeerID is the base58 peer ID of an identity multihash over digest
+func identityPeerID(t testing.TB, digest []byte) string {
+	t.Helper()
+	mh, err := multihash.Sum(digest, multihash.IDENTITY, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base58.Encode(mh)
+}
+
+func TestDecodePublicKeyFromPeerId(t *testing.T) {
+	pid, _ := testPeer(t)
+	want := mustPeerKey(t, pid)
+
+	_, secpPub, err := crypto.GenerateSecp256k1Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secpID, err := peer.IDFromPublicKey(secpPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// protobuf PublicKey{Type: Ed25519, Data: 31 bytes}
+	shortKey := append([]byte{0x08, 0x01, 0x12, 31}, make([]byte, 31)...)
+	hashed, _ := multihash.Sum([]byte("not a key"), multihash.SHA2_256, -1)
+
+	for _, tc := range []struct {
+		name    string
+		id      string
+		want    []byte
+		wantErr error // nil with want nil: any error
+	}{
+		{"base58 peer ID", pid.String(), want, nil},
+		{"CID peer ID", peer.ToCid(pid).String(), want, nil},
+		{"hashed peer ID", base58.Encode(hashed), nil, ErrNoEmbeddedKey},
+		{"hashed CID", peer.ToCid(peer.ID(hashed)).String(), nil, ErrNoEmbeddedKey},
+		{"secp256k1 key", secpID.String(), nil, ErrInvalidEmbeddedKey},
+		{"31-byte ed25519 key", identityPeerID(t, shortKey), nil, ErrInvalidEmbeddedKey},
+		{"garbage identity digest", identityPeerID(t, []byte{0xff, 0xff, 0xff}), nil, ErrInvalidEmbeddedKey},
+		{"empty identity digest", identityPeerID(t, nil), nil, ErrInvalidEmbeddedKey},
+		{"empty", "", nil, nil},
+		{"not base58", "0OIl", nil, nil},
+		{"truncated", pid.String()[:20], nil, nil},
+		{"trailing garbage", pid.String() + "x", nil, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := DecodePublicKeyFromPeerId(tc.id)
+			if tc.want != nil {
+				if err != nil || !bytes.Equal(got, tc.want) {
+					t.Fatalf("got %x, %v, want %x", got, err, tc.want)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("got %x, want an error", got)
+			}
+			if tc.wantErr != nil && !errors.Is(err, tc.wantErr) {
+				t.Errorf("err = %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func FuzzDecodePublicKeyFromPeerId(f *testing.F) {
+	pid, _ := testPeer(f)
+	f.Add(pid.String())
+	f.Add(peer.ToCid(pid).String())
+	f.Add("QmYyQSo1c1Ym7orWxLYvCrM2EmxFTANf8wXmmE7DWjhx5N")
+	f.Add("")
+	f.Add("1")
+	f.Add(identityPeerID(f, []byte{0x08, 0x01, 0x12, 0x20}))
+	f.Fuzz(func(t *testing.T, id string) {
+		key, err := DecodePublicKeyFromPeerId(id)
+		if err != nil {
+			return
+		}
+		// whatever is accepted is a real ed25519 key that maps back to a peer ID
+		if len(key) != 32 {
+			t.Fatalf("%q: %d-byte key accepted", id, len(key))
+		}
+		if _, err := PublicKeyToPeerId(key); err != nil {
+			t.Fatalf("%q: accepted key has no peer ID: %v", id, err)
+		}
+	})
+}
+
+func TestKeypairFromPrivateKey(t *testing.T) {
+	kp, err := generateKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	full := []byte(kp.PrivateKey) // seed + public key
+	libp2pKey, err := crypto.UnmarshalEd25519PrivateKey(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	protoKey, err := crypto.MarshalPrivateKey(libp2pKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secpKey, _, err := crypto.GenerateSecp256k1Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secpProto, err := crypto.MarshalPrivateKey(secpKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatched := append(bytes.Clone(kp.Seed), make([]byte, 32)...)
+
+	for _, tc := range []struct {
+		name    string
+		encoded string
+		wantErr string
+	}{
+		{"hex seed", hex.EncodeToString(kp.Seed), ""},
+		{"hex seed, upper case and spaces", "  " + strings.ToUpper(hex.EncodeToString(kp.Seed)) + "\n", ""},
+		{"hex 64-byte key", hex.EncodeToString(full), ""},
+		{"base64 seed", base64.StdEncoding.EncodeToString(kp.Seed), ""},
+		{"base64 64-byte key", base64.StdEncoding.EncodeToString(full), ""},
+		{"raw base64 seed", base64.RawStdEncoding.EncodeToString(kp.Seed), ""},
+		{"raw base64 64-byte key", base64.RawStdEncoding.EncodeToString(full), ""},
+		{"hex protobuf key", hex.EncodeToString(protoKey), ""},
+		{"base64 protobuf key", base64.StdEncoding.EncodeToString(protoKey), ""},
+		{"secp256k1 protobuf key", base64.StdEncoding.EncodeToString(secpProto), "unsupported key type"},
+		{"public half doesn't match", hex.EncodeToString(mismatched), "does not match"},
+		{"31 bytes", hex.EncodeToString(kp.Seed[:31]), "got 31 bytes"},
+		{"33 bytes", hex.EncodeToString(append(bytes.Clone(kp.Seed), 0)), "got 33 bytes"},
+		{"63 bytes", hex.EncodeToString(full[:63]), "got 63 bytes"},
+		{"empty", "", "got 0 bytes"},
+		{"not hex or base64", "not a key!", "not hex or base64"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := KeypairFromPrivateKey(tc.encoded)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("KeypairFromPrivateKey: %v", err)
+			}
+			if !bytes.Equal(got.Seed, kp.Seed) || !bytes.Equal(got.PublicKey, kp.PublicKey) || !bytes.Equal(got.PrivateKey, kp.PrivateKey) {
+				t.Errorf("keypair differs from the original: public key %x, want %x", got.PublicKey, kp.PublicKey)
+			}
+		})
+	}
+}
+
+func TestSightDIDRoundTrip(t *testing.T) {
+	for i := 0; i < 5; i++ {
+		kp, err := generateKeypair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, role := range []string{RoleHoster, RoleGateway} {
+			did := ToSightDID(role, kp.PublicKey)
+			if !strings.HasPrefix(did, "did:sight:"+role+":") {
+				t.Fatalf("ToSightDID(%s) = %s", role, did)
+			}
+			gotRole, key, err := ParseSightDID(did)
+			if err != nil || gotRole != role || !bytes.Equal(key, kp.PublicKey) {
+				t.Fatalf("ParseSightDID(%s) = %s, %x, %v, want %s, %x", did, gotRole, key, err, role, []byte(kp.PublicKey))
+			}
+			if r, err := SightDIDRole(did); err != nil || r != role {
+				t.Errorf("SightDIDRole(%s) = %s, %v", did, r, err)
+			}
+			if key, err := DIDToPublicKey(did); err != nil || !bytes.Equal(key, kp.PublicKey) {
+				t.Errorf("DIDToPublicKey(%s) = %x, %v", did, key, err)
+			}
+			pid, err := PublicKeyToPeerId(kp.PublicKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !didMatchesPeer(did, pid) {
+				t.Errorf("didMatchesPeer(%s, %s) = false", did, pid)
+			}
+		}
+	}
+}
+
+func TestToSightDIDUnknownRole(t *testing.T) {
+	kp, err := generateKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, role := range []string{"", "admin", "Gateway"} {
+		if got, want := ToSightDID(role, kp.PublicKey), ToSightDID(RoleHoster, kp.PublicKey); got != want {
+			t.Errorf("ToSightDID(%q) = %s, want the hoster form %s", role, got, want)
+		}
+	}
+}
+
+func TestParseSightDIDRejects(t *testing.T) {
+	kp, err := generateKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := base58.Encode(append([]byte{0xed, 0x01}, kp.PublicKey...))
+	for _, did := range []string{
+		"",
+		"did:sight:hoster",
+		"did:sight:hoster:",
+		"did:sight::" + key,
+		"did:sight:admin:" + key,
+		"did:key:hoster:" + key,
+		"DID:sight:hoster:" + key,
+		"did:sight:hoster:" + key + "0OIl",
+		"did:sight:hoster:" + base58.Encode(kp.PublicKey),                                // no multicodec prefix
+		"did:sight:hoster:" + base58.Encode(append([]byte{0xe7, 0x01}, kp.PublicKey...)), // secp256k1 multicodec
+		"did:sight:hoster:" + base58.Encode(append([]byte{0xed, 0x01}, kp.PublicKey[:31]...)),
+	} {
+		if role, key, err := ParseSightDID(did); !errors.Is(err, ErrInvalidDID) {
+			t.Errorf("ParseSightDID(%q) = %s, %x, %v, want ErrInvalidDID", did, role, key, err)
+		}
+	}
+}
+
+func TestInstanceIDsGetDistinctIdentities(t *testing.T) {
+	t.Setenv("NODE_PRIVATE_KEY", "")
+	t.Setenv("EPHEMERAL_IDENTITY", "")
+	dataDir := t.TempDir() // shared by every instance, as on a benchmark host
+
+	load := func(instanceID string) (string, []byte) {
+		cfg := DefaultConfig()
+		cfg.DataDir = dataDir
+		cfg.InstanceID = instanceID
+		return cfg.KeypairFile(), LoadOrGenerateKeypair(cfg.DataDir, cfg.KeypairFile()).PublicKey
+	}
+	fileA, keyA := load("a")
+	fileB, keyB := load("b")
+	fileDefault, keyDefault := load("")
+
+	if fileA != "device-keypair-a.json" || fileB != "device-keypair-b.json" || fileDefault != "device-keypair.json" {
+		t.Errorf("keypair files = %s, %s, %s", fileA, fileB, fileDefault)
+	}
+	if bytes.Equal(keyA, keyB) || bytes.Equal(keyA, keyDefault) || bytes.Equal(keyB, keyDefault) {
+		t.Fatal("instances sharing a data dir got the same identity")
+	}
+	if cfg := (&Config{InstanceID: "a"}); cfg.RegistryFile() != "peer-registry-a.json" {
+		t.Errorf("registry file = %s, want peer-registry-a.json", cfg.RegistryFile())
+	}
+	// each instance keeps its own identity across restarts
+	if _, again := load("a"); !bytes.Equal(again, keyA) {
+		t.Error("instance a got a new identity on restart")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// LoadScoreConfig controls the load score of GET /libp2p/load. Each component is a
+// utilization (count / capacity, capped at 1); the score is their weighted average.
+type LoadScoreConfig struct {
+	// Capacities: the counts at which a component counts as fully loaded. The forward
+	// queue's capacity is forwardQueueSize.
+	MaxStreams     int `yaml:"maxStreams" json:"maxStreams"`
+	MaxHandlers    int `yaml:"maxHandlers" json:"maxHandlers"`
+	MaxConnections int `yaml:"maxConnections" json:"maxConnections"`
+
+	// Weights of the components; only their ratios matter, 0 leaves a component out
+	StreamsWeight      float64 `yaml:"streamsWeight" json:"streamsWeight"`
+	HandlersWeight     float64 `yaml:"handlersWeight" json:"handlersWeight"`
+	ForwardQueueWeight float64 `yaml:"forwardQueueWeight" json:"forwardQueueWeight"`
+	ConnectionsWeight  float64 `yaml:"connectionsWeight" json:"connectionsWeight"`
+}
+
+// DefaultLoadScoreConfig weighs the forward queue and direct handlers highest: they back up
+// when the backend is slow, which is what the scheduler should route around
+func DefaultLoadScoreConfig() LoadScoreConfig {
+	return LoadScoreConfig{
+		MaxStreams:         1024,
+		MaxHandlers:        64,
+		MaxConnections:     192, // go-libp2p's default connection manager high water
+		StreamsWeight:      1,
+		HandlersWeight:     2,
+		ForwardQueueWeight: 2,
+		ConnectionsWeight:  1,
+	}
+}
+
+func (c LoadScoreConfig) Validate() error {
+	if c.MaxStreams <= 0 || c.MaxHandlers <= 0 || c.MaxConnections <= 0 {
+		return fmt.Errorf("invalid loadScore capacities: maxStreams %d, maxHandlers %d, maxConnections %d",
+			c.MaxStreams, c.MaxHandlers, c.MaxConnections)
+	}
+	weights := []float64{c.StreamsWeight, c.HandlersWeight, c.ForwardQueueWeight, c.ConnectionsWeight}
+	sum := 0.0
+	for _, w := range weights {
+		if w < 0 || math.IsNaN(w) || math.IsInf(w, 0) {
+			return fmt.Errorf("invalid loadScore weight: %v", w)
+		}
+		sum += w
+	}
+	if sum == 0 {
+		return fmt.Errorf("invalid loadScore weights: all zero")
+	}
+	return nil
+}
+
+// LoadComponent is one input of the load score
+type LoadComponent struct {
+	Count       int     `json:"count"`
+	Capacity    int     `json:"capacity"`
+	Utilization float64 `json:"utilization"` // count / capacity, capped at 1
+	Weight      float64 `json:"weight"`
+}
+
+func newLoadComponent(count, capacity int, weight float64) LoadComponent {
+	return LoadComponent{
+		Count:       count,
+		Capacity:    capacity,
+		Utilization: min(float64(count)/float64(capacity), 1),
+		Weight:      weight,
+	}
+}
+
+// NodeLoad is the response of GET /libp2p/load
+type NodeLoad struct {
+	// Score is the weighted load in [0, 1]; 1 means saturated
+	Score float64 `json:"score"`
+	// StreamsByProtocol counts the open streams (still negotiating ones have an empty protocol)
+	StreamsByProtocol map[string]int `json:"streamsByProtocol"`
+
+	Streams      LoadComponent `json:"streams"`
+	Handlers     LoadComponent `json:"directHandlers"` // inbound direct messages being handled
+	ForwardQueue LoadComponent `json:"forwardQueue"`
+	Connections  LoadComponent `json:"connections"`
+}
+
+// GetLoad reports how busy the node is, for schedulers that route work away from
+// saturated nodes
+func (s *Libp2pNodeService) GetLoad() NodeLoad {
+	cfg := s.config.LoadScore
+	byProto := make(map[string]int)
+	streams := 0
+	conns := s.node.Network().Conns()
+	for _, conn := range conns {
+		for _, st := range conn.GetStreams() {
+			byProto[string(st.Protocol())]++
+			streams++
+		}
+	}
+	load := NodeLoad{
+		StreamsByProtocol: byProto,
+		Streams:           newLoadComponent(streams, cfg.MaxStreams, cfg.StreamsWeight),
+		Handlers:          newLoadComponent(s.inflight.count(), cfg.MaxHandlers, cfg.HandlersWeight),
+		ForwardQueue:      newLoadComponent(len(s.forwardQueue), max(cap(s.forwardQueue), 1), cfg.ForwardQueueWeight),
+		Connections:       newLoadComponent(len(conns), cfg.MaxConnections, cfg.ConnectionsWeight),
+	}
+	var sum, weights float64
+	for _, c := range []LoadComponent{load.Streams, load.Handlers, load.ForwardQueue, load.Connections} {
+		sum += c.Utilization * c.Weight
+		weights += c.Weight
+	}
+	load.Score = math.Round(sum/weights*1000) / 1000
+	return load
+}
@@ -4,18 +4,21 @@ import (
 	"context"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	golog "github.com/ipfs/go-log/v2"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 //go:embed .env
@@ -28,8 +31,15 @@ var (
 	apiPort        = flag.String("api-port", "", "API port (overrides API_PORT)")
 	isGateway      = flag.String("is-gateway", "", "Is gateway (0 or 1, overrides IS_GATEWAY)")
 	bootstrapAddrs = flag.String("bootstrap-addrs", "", "Bootstrap addresses (comma-separated, overrides BOOTSTRAP_ADDRS)")
+	bootstrapFile  = flag.String("bootstrap-file", "", "File of bootstrap addresses, one per line, merged with BOOTSTRAP_ADDRS (overrides BOOTSTRAP_FILE)")
 	dataDir        = flag.String("data-addr", "", "Data directory for configuration files (overrides SIGHTAI_DATA_DIR env var)")
+	dataDirExact   = flag.String("data-dir", "", "Directory for the keypair and registry files, used as is (overrides DATA_DIR and SIGHTAI_DATA_DIR)")
+	instanceID     = flag.String("instance-id", "", "Instance ID suffixing the keypair/registry file names (overrides INSTANCE_ID)")
+	configFile     = flag.String("config", "", "Config file (.yaml/.yml/.json); env vars and CLI flags override its values")
 	showHelp       = flag.Bool("help", false, "Show help message")
+	showVersion    = flag.Bool("version", false, "Print version and build info, then exit")
+	selfTest       = flag.Bool("selftest", false, "Start the node, check bootstrap/DHT/ping/tunnel, print a report and exit")
+	randomPort     = flag.Bool("random-port", false, "Pick free node and REST ports (ephemeral test nodes; overrides NODE_PORT and LIBP2P_REST_API)")
 )
 
 func main() {
@@ -41,54 +51,145 @@ func main() {
 		showUsage()
 		return
 	}
+	if *showVersion {
+		fmt.Println(GetBuildInfo())
+		return
+	}
 
-	// Load environment variables (embedded .env or file system)
-	err := loadEnvVars()
-	if err != nil {
-		log.Println("Warning: Failed to load environment variables:", err)
+	// Load environment variables (embedded .env or file system).
+	// 指定了配置文件时不再加载 .env，否则 .env 里的默认值会覆盖配置文件
+	if *configFile == "" {
+		err := loadEnvVars()
+		if err != nil {
+			log.Println("Warning: Failed to load environment variables:", err)
+		}
 	}
 
 	// Override with CLI flags if provided
 	overrideWithCLIFlags()
-	// Load or generate keypair
-	keypair := LoadOrGenerateKeypair()
 
-	// Get environment variables (with defaults)
-	isGatewayFlag := os.Getenv("IS_GATEWAY") == "1"
-	nodePortInt := getEnvInt("NODE_PORT", 15050)
-	libp2pPortInt := getEnvInt("LIBP2P_REST_API", 4010)
-	bootstrap := strings.Split(os.Getenv("BOOTSTRAP_ADDRS"), ",")
-	// log.Println("bootstrap nodes:", bootstrap)
-	tunnelAPI := "http://localhost:" + getEnvWithDefault("API_PORT", "8716") + "/libp2p/message"
+	// Resolve the effective config: defaults < config file < env < CLI flags
+	cfg, err := LoadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	// 端口被占用时尽早给出明确的提示，而不是在 libp2p.New 里 Fatal
+	if err := cfg.checkPorts(); err != nil {
+		log.Printf("Startup failed: %v", err)
+		if errors.As(err, new(*PortInUseError)) {
+			os.Exit(exitPortInUse)
+		}
+		os.Exit(1)
+	}
+	logLevel, _ := golog.LevelFromString(cfg.LogLevel) // already validated
+	golog.SetAllLoggers(logLevel)
+	log.Printf("%s", GetBuildInfo())
+	log.Printf("Effective configuration: \n%s", cfg)
+	shutdownTracing := initTracing(context.Background())
+
+	// Load or generate keypair (gateways keep their own key file)
+	var keypair Keypair
+	if cfg.IsGateway && cfg.GatewayLegacyKey {
+		log.Printf("WARNING: using the legacy gateway key shared by all gateways; unset GATEWAY_LEGACY_KEY once peers no longer pin its peer ID")
+		keypair = LegacyGatewayKeypair()
+	} else {
+		keypair = LoadOrGenerateKeypair(cfg.DataDir, cfg.KeypairFile())
+	}
 
 	// Create the Libp2p service
-	service := NewLibp2pNodeService(keypair, nodePortInt, tunnelAPI, isGatewayFlag, bootstrap)
+	service := NewLibp2pNodeService(keypair, cfg)
 	service.InitNode()
 
+	// --selftest: 只做一次连通性检查，不启动 REST 服务
+	if *selfTest {
+		ok := service.RunSelfTest(os.Stdout)
+		service.Stop()
+		shutdownTracing(context.Background())
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Create the controller
 	controller := NewLibp2pNodeController(service)
 
 	// Set up router
 	router := mux.NewRouter()
+	router.NotFoundHandler = http.HandlerFunc(notFoundHandler)
+	router.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowedHandler)
 	router.HandleFunc("/libp2p/send", controller.SendHandler).Methods("POST")
+	router.HandleFunc("/libp2p/publish/{topic}", controller.requireAPIToken(controller.PublishHandler)).Methods("POST")
 	router.HandleFunc("/libp2p/find-peer/{peerId}", controller.FindPeerHandler).Methods("GET")
 	router.HandleFunc("/libp2p/public-key/{peerId}", controller.GetPublicKeyHandler).Methods("GET")
 	router.HandleFunc("/libp2p/connect/{did}", controller.ConnectHandler).Methods("POST")
+	router.HandleFunc("/libp2p/connected/{did}", controller.ConnectedHandler).Methods("GET")
+	router.HandleFunc("/libp2p/sticky", controller.StickyPeersHandler).Methods("GET")
+	router.HandleFunc("/libp2p/sticky/{did}", controller.ReleaseStickyHandler).Methods("DELETE")
 	router.HandleFunc("/libp2p/neighbors", controller.GetNeighborsHandler).Methods("GET")
+	router.HandleFunc("/libp2p/neighbors/health", controller.NeighborHealthHandler).Methods("GET")
 	router.HandleFunc("/libp2p/ping/{did}", controller.PingHandler).Methods("POST")
 	router.HandleFunc("/libp2p/p2p-send/{did}", controller.SendDirectHandler).Methods("POST")
+	router.HandleFunc("/libp2p/send-direct-raw/{did}", controller.SendDirectRawHandler).Methods("POST")
+	router.HandleFunc("/libp2p/send-direct-batch", controller.SendDirectBatchHandler).Methods("POST")
+	router.HandleFunc("/libp2p/broadcast-direct", controller.BroadcastDirectHandler).Methods("POST")
+	router.HandleFunc("/libp2p/pubsub/scores", controller.GetPeerScoresHandler).Methods("GET")
+	router.HandleFunc("/libp2p/bootstrap/reload", controller.BootstrapReloadHandler).Methods("POST")
+	router.HandleFunc("/libp2p/drain", controller.requireAPIToken(controller.DrainHandler)).Methods("POST")
+	router.HandleFunc("/libp2p/status", controller.StatusHandler).Methods("GET")
+	router.HandleFunc("/libp2p/dht", controller.DHTHandler).Methods("GET")
+	router.HandleFunc("/libp2p/dht/refresh", controller.DHTRefreshHandler).Methods("POST")
+	router.HandleFunc("/libp2p/dht/queries", controller.DHTQueriesHandler).Methods("GET")
+	router.HandleFunc("/libp2p/bandwidth", controller.BandwidthHandler).Methods("GET")
+	router.HandleFunc("/libp2p/recent", controller.RecentMessagesHandler).Methods("GET")
+	router.HandleFunc("/libp2p/forward-stats", controller.ForwardStatsHandler).Methods("GET")
+	router.HandleFunc("/libp2p/observer/messages", controller.ObserverMessagesHandler).Methods("GET")
+	router.HandleFunc("/libp2p/resources", controller.ResourcesHandler).Methods("GET")
+	router.HandleFunc("/libp2p/load", controller.LoadHandler).Methods("GET")
+	router.HandleFunc("/libp2p/did/{did}/document", controller.DIDDocumentHandler).Methods("GET")
+	router.HandleFunc("/libp2p/sign", controller.requireAPIToken(controller.SignHandler)).Methods("POST")
+	router.HandleFunc("/libp2p/verify", controller.VerifyHandler).Methods("POST")
+	router.HandleFunc("/libp2p/registry", controller.RegistryHandler).Methods("GET")
+	router.HandleFunc("/libp2p/peer/{peerId}/protocols", controller.PeerProtocolsHandler).Methods("GET")
+	router.HandleFunc("/libp2p/peer/{peerId}/identify", controller.PeerIdentifyHandler).Methods("GET")
+	router.HandleFunc("/libp2p/peer/{peerId}/latency", controller.PeerLatencyHandler).Methods("GET")
+	router.HandleFunc("/libp2p/peer/{did}/protect", controller.ProtectPeerHandler).Methods("POST", "DELETE")
+	router.HandleFunc("/libp2p/peer/{did}/tag", controller.TagPeerHandler).Methods("POST")
+	router.HandleFunc("/libp2p/reputation", controller.ReputationHandler).Methods("GET")
+	router.HandleFunc("/libp2p/block/{did}", controller.requireAPIToken(controller.BlockPeerHandler)).Methods("POST")
+	router.HandleFunc("/libp2p/unblock/{did}", controller.requireAPIToken(controller.UnblockPeerHandler)).Methods("POST")
+	router.HandleFunc("/libp2p/peer-by-did/{did}", controller.PeerByDIDHandler).Methods("GET")
+	router.HandleFunc("/libp2p/topic/{name}/peers", controller.TopicPeersHandler).Methods("GET")
+	router.HandleFunc("/libp2p/observed-addrs", controller.ObservedAddrsHandler).Methods("GET")
+	router.HandleFunc("/libp2p/addrs", controller.AddrsHandler).Methods("GET")
+	router.HandleFunc("/libp2p/version", controller.VersionHandler).Methods("GET")
+	router.Handle("/metrics", promhttp.Handler()).Methods("GET")
 	router.HandleFunc("/health", healthHandler).Methods("GET")
+	router.HandleFunc("/readyz", controller.ReadyzHandler).Methods("GET")
 
 	// Start the HTTP server
 	srv := &http.Server{
 		Handler: router,
-		Addr:    ":" + strconv.Itoa(libp2pPortInt),
+		Addr:    ":" + strconv.Itoa(cfg.Libp2pPort),
+	}
+
+	// Bind first so a port taken since checkPorts gets the same clear error
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		log.Printf("Startup failed: %v", portError("REST", "tcp", cfg.Libp2pPort, err))
+		service.Stop()
+		if isAddrInUse(err) {
+			os.Exit(exitPortInUse)
+		}
+		os.Exit(1)
 	}
 
 	// Run server in a goroutine
+	serverDone := make(chan struct{})
 	go func() {
-		log.Printf("HTTP server started on :%d", libp2pPortInt)
-		if err := srv.ListenAndServe(); err != nil {
+		defer close(serverDone)
+		log.Printf("HTTP server started on :%d", cfg.Libp2pPort)
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatal(err)
 		}
 	}()
@@ -96,10 +197,23 @@ func main() {
 	// Graceful shutdown
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt)
-	<-stop
+	select {
+	case <-stop:
+	case <-service.ExitRequested():
+		log.Println("Exit requested after drain")
+	}
 	log.Println("Shutting down...")
+
+	// 先停 HTTP，避免关闭节点时还有请求进来
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.Shutdown.Std())
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down HTTP server: %v", err)
+	}
+	<-serverDone
 	service.Stop()
-	srv.Shutdown(context.Background())
+	shutdownTracing(shutdownCtx)
+	log.Println("Shutdown complete")
 }
 
 func showUsage() {
@@ -112,7 +226,14 @@ func showUsage() {
 	fmt.Println("  --api-port <port>         API port (default: 8716)")
 	fmt.Println("  --is-gateway <0|1>        Is gateway mode (default: 0)")
 	fmt.Println("  --bootstrap-addrs <addrs> Bootstrap addresses (comma-separated)")
+	fmt.Println("  --bootstrap-file <file>   Bootstrap addresses, one per line (# comments), merged with the above")
 	fmt.Println("  --data-addr <dir>  		 Data directory for config files (for Docker/custom paths)")
+	fmt.Println("  --data-dir <dir>          Keypair/registry directory used as is (one per node on a shared host)")
+	fmt.Println("  --instance-id <id>        Use device-keypair-<id>.json etc. so nodes sharing a data dir differ")
+	fmt.Println("  --config <file>           Config file (.yaml/.yml/.json), overridden by env and flags")
+	fmt.Println("  --selftest                Check bootstrap, DHT, ping and tunnel, then exit (1 on failure)")
+	fmt.Println("  --random-port             Pick free node and REST ports (ephemeral test nodes)")
+	fmt.Println("  --version                 Print version and build info")
 	fmt.Println("  --help                    Show this help message")
 	fmt.Println("")
 	fmt.Println("Examples:")
@@ -125,8 +246,18 @@ func showUsage() {
 	fmt.Println("  # Use benchmark environment")
 	fmt.Println("  ./sight-libp2p-node --node-port 25050 --libp2p-port 5010 --api-port 9716")
 	fmt.Println("")
+	fmt.Println("  # Load settings from a config file")
+	fmt.Println("  ./sight-libp2p-node --config ./node.yaml")
+	fmt.Println("")
+	fmt.Println("  # Check a deployment's config and connectivity")
+	fmt.Println("  ./sight-libp2p-node --config ./node.yaml --selftest")
+	fmt.Println("")
 	fmt.Println("  # Use custom data directory (Docker environment)")
 	fmt.Println("  ./sight-libp2p-node --data-addr /app/data")
+	fmt.Println("")
+	fmt.Println("  # Run a second node on the same host")
+	fmt.Println("  ./sight-libp2p-node --node-port 15051 --libp2p-port 4011 --data-dir /tmp/node2")
+	fmt.Println("  ./sight-libp2p-node --node-port 15052 --libp2p-port 4012 --instance-id 15052")
 }
 
 func overrideWithCLIFlags() {
@@ -150,10 +281,31 @@ func overrideWithCLIFlags() {
 		os.Setenv("BOOTSTRAP_ADDRS", *bootstrapAddrs)
 		log.Printf("CLI override: BOOTSTRAP_ADDRS = %s", *bootstrapAddrs)
 	}
+	if *bootstrapFile != "" {
+		os.Setenv("BOOTSTRAP_FILE", *bootstrapFile)
+		log.Printf("CLI override: BOOTSTRAP_FILE = %s", *bootstrapFile)
+	}
 	if *dataDir != "" {
 		os.Setenv("SIGHTAI_DATA_DIR", *dataDir)
 		log.Printf("CLI override: SIGHTAI_DATA_DIR = %s", *dataDir)
 	}
+	if *instanceID != "" {
+		os.Setenv("INSTANCE_ID", *instanceID)
+		log.Printf("CLI override: INSTANCE_ID = %s", *instanceID)
+	}
+	if *dataDirExact != "" {
+		os.Setenv("DATA_DIR", *dataDirExact)
+		log.Printf("CLI override: DATA_DIR = %s", *dataDirExact)
+	}
+	if *randomPort {
+		node, rest, err := randomPorts()
+		if err != nil {
+			log.Fatalf("--random-port: %v", err)
+		}
+		os.Setenv("NODE_PORT", strconv.Itoa(node))
+		os.Setenv("LIBP2P_REST_API", strconv.Itoa(rest))
+		log.Printf("CLI override: random ports NODE_PORT = %d, LIBP2P_REST_API = %d", node, rest)
+	}
 }
 
 func loadEnvVars() error {
@@ -204,6 +356,31 @@ func getEnvInt(key string, defaultVal int) int {
 	return intVal
 }
 
+// getEnvDuration accepts Go duration strings (e.g. "15s", "1m")
+func getEnvDuration(key string, defaultVal time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultVal
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return defaultVal
+	}
+	return d
+}
+
+func getEnvFloat(key string, defaultVal float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultVal
+	}
+	floatVal, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return defaultVal
+	}
+	return floatVal
+}
+
 // healthHandler handles the /health endpoint
 func healthHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
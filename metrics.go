@@ -0,0 +1,102 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus metrics, served at GET /metrics
+var (
+	tunnelForwardDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sight_tunnel_forward_duration_seconds",
+		Help:    "Time taken to POST a received message to the tunnel API.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"transport", "result"})
+
+	tunnelSkipped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sight_tunnel_skipped_total",
+		Help: "Received messages not forwarded because the tunnel is disabled or in dry-run mode.",
+	}, []string{"mode"})
+
+	neighborHealthChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sight_neighbor_health_checks_total",
+		Help: "Background neighbor health pings by result (ok, failed).",
+	}, []string{"result"})
+
+	neighborsUnhealthy = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sight_neighbors_unhealthy",
+		Help: "Connected neighbors currently marked unhealthy by the health monitor.",
+	})
+
+	neighborDisconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sight_neighbor_health_disconnects_total",
+		Help: "Neighbors disconnected by the health monitor (action disconnect).",
+	})
+
+	forwardQueueLength = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sight_forward_queue_length",
+		Help: "Pubsub messages waiting to be forwarded to the tunnel.",
+	})
+
+	forwardQueueLag = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sight_forward_queue_lag_seconds",
+		Help: "How long the most recently dequeued pubsub message waited in the forward queue.",
+	})
+
+	forwardQueueDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sight_forward_queue_dropped_total",
+		Help: "Pubsub messages dropped because the forward queue was full.",
+	})
+
+	gatewayRouted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sight_gateway_routed_total",
+		Help: "Outgoing gateway messages in direct routing mode, by route (direct, pubsub_fallback).",
+	}, []string{"route"})
+
+	directMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sight_direct_messages_total",
+		Help: "Direct messages by direction (in, out) and negotiated protocol version.",
+	}, []string{"direction", "protocol"})
+
+	observedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sight_observed_messages_total",
+		Help: "Messages recorded by an observer node, by transport (pubsub, direct).",
+	}, []string{"transport"})
+
+	peerConnects = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sight_peer_connects_total",
+		Help: "Connections opened, by direction (inbound, outbound).",
+	}, []string{"direction"})
+
+	peerDisconnects = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sight_peer_disconnects_total",
+		Help: "Connections closed, by direction (inbound, outbound).",
+	}, []string{"direction"})
+
+	dhtQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sight_dht_query_duration_seconds",
+		Help:    "Duration of DHT operations by op (find_peer, bootstrap) and result (ok, not_found, timeout, error).",
+		Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"op", "result"})
+
+	dhtQueryPeers = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sight_dht_query_peers",
+		Help:    "Peers queried per DHT FindPeer lookup, by result.",
+		Buckets: []float64{0, 1, 2, 5, 10, 20, 50, 100},
+	}, []string{"result"})
+
+	dhtLookupsAvoided = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sight_dht_lookups_avoided_total",
+		Help: "DHT FindPeer lookups skipped on connect, by reason (connected, peerstore).",
+	}, []string{"reason"})
+
+	relayUpgrades = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sight_relay_upgrades_total",
+		Help: "Peers reached only through a relay that got a direct connection (hole punching or direct dial).",
+	})
+
+	holePunches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sight_hole_punch_total",
+		Help: "DCUtR hole punching attempts, by result (success, failure).",
+	}, []string{"result"})
+)
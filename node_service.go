@@ -1,162 +1,480 @@
 package main
 
 import (
-	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"log"
-	"net/http"
+	"net"
+	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
-	hostlibp2p "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/metrics"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
-	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	"github.com/libp2p/go-libp2p/core/protocol"
 	ma "github.com/multiformats/go-multiaddr"
-	"golang.org/x/crypto/ed25519"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Libp2pNodeService struct {
-	did        string
-	keypair    Keypair
-	tunnelAPI  string
-	isGateway  bool
-	node       hostlibp2p.Host
-	pubsub     *pubsub.PubSub
+	// mu guards the fields that can be replaced at runtime: tunnel, topic, subscribed and
+	// bootstrap. Use the getters below instead of reading them directly.
+	mu         sync.RWMutex
+	tunnel     TunnelForwarder
 	subscribed *pubsub.Subscription
 	topic      *pubsub.Topic
 	bootstrap  []string
-	nodePort   int
-	dht        *dht.IpfsDHT
+
+	// set once (constructor / InitNode) before the API starts, read-only afterwards
+	did       string
+	keypair   Keypair
+	isGateway bool
+	node      nodeHost
+	pubsub    *pubsub.PubSub
+	dht       nodeDHT
+	config    *Config
+
+	scores    *peerScores
+	bandwidth *metrics.BandwidthCounter
+
+	forwardQueue chan forwardJob // pubsub messages waiting for the tunnel
+	resolve      *resolveCache
+	registry     *peerRegistry
+	acks         *ackWaiters
+	observed     *observedAddrs
+	mesh         *meshTracker
+	latency      *latencyHistory
+	health       *healthMonitor
+	sticky       *stickyPeers
+	recent       *recentMessages
+	forwards     *forwardStats
+	dhtQueries   *dhtQueryLog
+	reputation   *reputationStore // also the host's connection gater
+	topics       joinedTopics     // every joined topic, see joinTopic
+	inflight     inflightStreams  // direct-message handlers still running
+
+	// observer is set in observer mode and replaces the tunnel
+	observer *messageObserver
+
+	dhtBootstrapped atomic.Bool
+	dhtBootstrap    dhtBootstrapState
+
+	draining       atomic.Bool  // set by Drain, never cleared
+	forwardPending atomic.Int64 // queued or being forwarded, for Drain
+	forwardDropped atomic.Int64 // dropped because the forward queue was full
+	exit           chan struct{}
+	exitOnce       sync.Once
+
+	startedAt time.Time
+	cancel    context.CancelFunc // stops background goroutines started by InitNode
 }
 
-func NewLibp2pNodeService(kp Keypair, port int, tunnelAPI string, isGateway bool, bootstrap []string) *Libp2pNodeService {
-	did := "gateway"
-	if !isGateway {
-		did = ToSightDID(kp.PublicKey)
-		log.Printf("[Libp2p Node with this] DID: %s", did)
-	}
-	// 给Gateway一个固定的keypair
-	// TODO：Gateway切换到用did标识后，需要有自己的keypair
+var (
+	// ErrDialTimeout is returned when resolving or dialing a peer exceeds Timeouts.Dial
+	ErrDialTimeout = errors.New("dial timed out")
+	// ErrSendTimeout is returned when the peer was reached but opening the stream or writing
+	// (or a ping round trip) exceeds Timeouts.Request
+	ErrSendTimeout = errors.New("send timed out")
+	// ErrInvalidTarget is returned when a DID or multiaddr can't be parsed into a peer ID
+	ErrInvalidTarget = errors.New("invalid DID/multiaddr")
+	// ErrStreamFailed means the peer was reached but opening or writing the direct stream failed
+	ErrStreamFailed = errors.New("direct stream failed")
+	// ErrPayloadTooLarge is returned when an outgoing message exceeds MaxPayloadBytes
+	ErrPayloadTooLarge = errors.New("payload too large")
+)
+
+func NewLibp2pNodeService(kp Keypair, cfg *Config) *Libp2pNodeService {
+	isGateway := cfg.IsGateway
+	role := RoleHoster
 	if isGateway {
-		seed := make([]byte, 32)
-		seed[0] = 32
-		priv := ed25519.NewKeyFromSeed(seed)
-		pub := priv.Public().(ed25519.PublicKey)
-		kp = Keypair{
-			PrivateKey: priv,
-			PublicKey:  pub,
-		}
+		role = RoleGateway
+	}
+	did := ToSightDID(role, kp.PublicKey)
+	log.Printf("[Libp2p Node with this] DID: %s", did)
+	var observer *messageObserver
+	var tunnel TunnelForwarder
+	if cfg.Observer {
+		log.Printf("[Observer] Read-only mode: recording received messages, publishing and tunnel forwarding disabled")
+		observer = newMessageObserver(observerHistorySize)
+		tunnel = observer
+	} else {
+		tunnel = cfg.NewTunnelForwarder()
 	}
 	return &Libp2pNodeService{
 		keypair:   kp,
 		did:       did,
-		tunnelAPI: tunnelAPI,
+		tunnel:    tunnel,
+		observer:  observer,
 		isGateway: isGateway,
-		nodePort:  port,
-		bootstrap: bootstrap,
+		bootstrap: cfg.Bootstrap,
+		config:    cfg,
+
+		scores:     newPeerScores(),
+		bandwidth:  metrics.NewBandwidthCounter(),
+		resolve:    newResolveCache(cfg.ResolveCache),
+		registry:   newPeerRegistry(cfg.Registry),
+		acks:       newAckWaiters(),
+		observed:   newObservedAddrs(cfg.ObservedAddrMinPeers),
+		mesh:       newMeshTracker(),
+		latency:    newLatencyHistory(cfg.LatencyPing.HistorySize),
+		health:     newHealthMonitor(),
+		sticky:     newStickyPeers(),
+		recent:     newRecentMessages(cfg.RecentMessages),
+		forwards:   newForwardStats(),
+		dhtQueries: newDHTQueryLog(),
+		reputation: newReputationStore(cfg.Reputation),
+		exit:       make(chan struct{}),
+
+		startedAt: time.Now(),
 	}
 }
 
+// addressedToMe reports whether a message "to" field targets this node: our own DID, or
+// the legacy "gateway" alias which every gateway accepts (broadcast to gateways)
+func (s *Libp2pNodeService) addressedToMe(to interface{}) bool {
+	return to == s.did || (s.isGateway && to == GatewayAlias)
+}
+
 func (s *Libp2pNodeService) InitNode() {
-	ctx := context.Background()
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
 
 	// Create node and pubsub
-	h, ps, dht := CreateLibp2pNode(ctx, s.nodePort, s.bootstrap, s.keypair)
-	s.node = h
-
-	topic, err := ps.Join("sight-message")
+	psOpts := s.config.PeerScore.PubSubOptions(s.config.Topic, s.scores.update)
+	psOpts = append(psOpts, s.config.GossipSub.PubSubOptions()...)
+	psOpts = append(psOpts, pubsub.WithRawTracer(s.mesh))
+	log.Printf("[GossipSub] %s", s.config.GossipSub)
+	if limit := s.config.GossipSub.MaxPublishBytes(); int64(limit) < s.config.MaxPayloadBytes {
+		log.Printf("[GossipSub] Max message size %d bytes: published messages above %d bytes are rejected (413) although maxPayloadBytes is %d",
+			s.config.GossipSub.MaxMessageSize, limit, s.config.MaxPayloadBytes)
+	}
+	rm, err := s.config.ResourceLimits.NewResourceManager()
 	if err != nil {
-		log.Fatalf("Failed to join topic: %v", err)
+		log.Fatal("Failed to create resource manager: ", err)
+	}
+	log.Printf("[ResourceManager] %s", s.config.ResourceLimits)
+	libp2pOpts := append([]libp2p.Option{
+		libp2p.BandwidthReporter(s.bandwidth),
+		libp2p.AddrsFactory(s.observed.addrsFactory),
+		libp2p.ResourceManager(rm),
+		libp2p.ConnectionGater(s.reputation),
+	}, s.config.SecurityOpts()...)
+	log.Printf("[Security] %s", s.config.Security)
+	if opts := s.config.PrivateNetworkOpts(); opts != nil {
+		libp2pOpts = append(libp2pOpts, opts...)
+		log.Printf("[PrivateNetwork] Only peers with the key in %s can connect", s.config.PrivateNetworkKeyFile)
+	}
+	if s.config.BindInterface != "" {
+		log.Printf("[Bind] %s -> %v", s.config.BindInterface, s.config.ListenAddrs())
+	}
+	if s.config.Reputation.Persist {
+		s.loadReputation()
+	}
+	node := CreateLibp2pNode(ctx, s.config.ListenAddrs(), s.GetBootstrap(), s.keypair, s.config.Topic, s.config.AgentVersion, s.config.DHTModeOpt(), s.config.BootstrapDial, libp2pOpts, psOpts...)
+	s.node = node.Host
+	s.pubsub = node.PubSub
+	s.topics.reset(node.PubSub, node.Topics)
+	s.dht = &timedDHT{nodeDHT: node.DHT, queries: s.dhtQueries}
+	s.announceRole()
+	if s.config.Registry.Persist {
+		s.loadRegistry()
+		go s.persistRegistry(ctx)
 	}
-	s.topic = topic
+	s.watchPeerRoles(ctx)
+	s.watchConnections()
+	s.observed.setInterfaceAddrs(s.node.Network().InterfaceListenAddresses)
+	s.observed.setConnected(func(pid peer.ID) bool { return s.node.Network().Connectedness(pid) == network.Connected })
+	go s.watchObservedAddrs(ctx)
 
-	sub, err := topic.Subscribe()
+	sub, err := node.Topic.Subscribe()
 	if err != nil {
 		log.Fatalf("Failed to subscribe to topic: %v", err)
 	}
+	s.mu.Lock()
+	s.topic = node.Topic
 	s.subscribed = sub
+	s.mu.Unlock()
 
-	s.dht = dht
+	go s.bootstrapDHT(ctx)
 
-	// Start message handler in a goroutine
-	go s.handleIncomingMessages(ctx)
+	// Start message handler and tunnel forwarder
+	s.forwardQueue = make(chan forwardJob, s.config.ForwardQueueSize)
+	go s.runForwarder(ctx)
+	go s.handleIncomingMessages(ctx, s.getSubscription())
+	s.subscribeTunnelTopics(ctx)
 
-	// 暂时将libp2p直接消息协议设置为test/0.0.1
-	s.node.SetStreamHandler("/test/0.0.1", s.handleDirectIncomingMessage)
+	if interval := s.config.UptimeLogInterval.Std(); interval > 0 {
+		go s.logUptime(ctx, interval)
+	}
+	s.startHealthMonitor(ctx)
+	s.startStickyPeers(ctx)
+
+	// 每个支持的直连协议版本都注册同一个 handler
+	for _, proto := range directProtocols {
+		s.node.SetStreamHandler(proto, s.handleDirectIncomingMessage)
+	}
+	s.node.SetStreamHandler(directRawProtocol, s.handleDirectRawMessage)
+
+	s.waitForPeersGate(ctx)
 }
 
-func (s *Libp2pNodeService) handleIncomingMessages(ctx context.Context) {
+// handleIncomingMessages forwards the messages of sub (one loop per subscribed topic)
+func (s *Libp2pNodeService) handleIncomingMessages(ctx context.Context, sub *pubsub.Subscription) {
 	for {
-		msg, err := s.subscribed.Next(ctx)
+		msg, err := sub.Next(ctx)
 		if err != nil {
 			log.Printf("PubSub error: %v", err)
 			return
 		}
 
+		// 只有不是 JSON 的数据才扣分：/libp2p/publish 可以合法地发布数组、字符串等任意 JSON 值
+		if !json.Valid(msg.Data) {
+			log.Printf("Invalid message format from %s: not JSON", msg.GetFrom())
+			s.ReportViolation(msg.GetFrom(), violationInvalidMessage)
+			continue
+		}
 		var payload map[string]interface{}
-		if err := json.Unmarshal(msg.Data, &payload); err != nil {
-			log.Printf("Invalid message format: %v", err)
+		if err := json.Unmarshal(msg.Data, &payload); err != nil || payload == nil {
+			continue // 不是 JSON 对象，不是发给节点的消息
+		}
+
+		// 观察者记录所有消息，不管发给谁
+		if s.observer != nil {
+			s.observeMessage(msg, payload)
 			continue
 		}
 
+		if s.handleLeave(payload, msg.GetFrom()) {
+			continue
+		}
 		// Only process messages intended for this node
-		if payload["to"] != s.did {
+		if !s.addressedToMe(payload["to"]) {
+			continue
+		}
+		to, _ := payload["to"].(string)
+		if s.handleAck(payload, msg.GetFrom()) {
 			continue
 		}
 
-		buf, err := json.Marshal(payload["payload"])
+		buf, contentType, err := envelopeBody(payload)
 		if err != nil {
 			log.Printf("Error marshalling payload: %v", err)
 			continue
 		}
 
-		// Send the message to the tunnel API
-		var resp *http.Response
-		resp, err = http.Post(s.tunnelAPI, "application/json", bytes.NewBuffer(buf))
-		if err != nil {
-			log.Printf("Forward error: %v", err)
+		// 优先用发送方生成的 messageId，老版本发送方没有时退回 pubsub 的消息 ID
+		msgID, _ := payload["messageId"].(string)
+		if msgID == "" {
+			msgID = hex.EncodeToString([]byte(msg.ID))
+		}
+
+		meta := TunnelMeta{
+			From:        msg.GetFrom(),
+			FromDID:     s.senderDID(msg.GetFrom(), payload["from"]),
+			MessageID:   msgID,
+			Transport:   transportPubSub,
+			Topic:       msg.GetTopic(),
+			To:          to,
+			ContentType: contentType,
+			ReceivedAt:  time.Now(),
+		}
+		_, span := startReceiveSpan(payload, meta)
+		// Hand off to the forwarder so a slow tunnel doesn't stall sub.Next
+		s.enqueueForward(forwardJob{
+			payload: payload,
+			body:    buf,
+			meta:    meta,
+			span:    span.SpanContext(),
+		})
+		span.End()
+	}
+}
+
+// forwardToTunnel hands a received payload to the tunnel forwarder within Timeouts.Tunnel,
+// recording its latency
+func (s *Libp2pNodeService) forwardToTunnel(ctx context.Context, body []byte, meta TunnelMeta) (resp *TunnelResponse, err error) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "tunnel.forward", trace.WithAttributes(
+		attribute.String("sight.message_id", meta.MessageID),
+		attribute.String("sight.transport", meta.Transport),
+	))
+	defer func() {
+		if errors.Is(err, errTunnelSkipped) {
+			span.SetAttributes(attribute.Bool("sight.skipped", true))
+			span.End()
 		} else {
-			in, _ := json.MarshalIndent(payload, "", "  ")
-			log.Printf("Received and forwarded message to tunnel: \n%s", in)
-			if resp != nil && resp.Body != nil {
-				resp.Body.Close()
+			if resp != nil {
+				span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
 			}
+			endSpan(span, err)
 		}
+	}()
+	defer func() {
+		s.recent.record(meta, body, resp, err)
+		s.forwards.record(s.forwardRecipient(meta), resp, err)
+		result := "ok"
+		if errors.Is(err, errTunnelSkipped) {
+			return
+		}
+		if err != nil {
+			result = "error"
+		}
+		tunnelForwardDuration.WithLabelValues(meta.Transport, result).Observe(time.Since(start).Seconds())
+	}()
+	if meta.FromDID == "" {
+		meta.FromDID, _ = s.peerDID(meta.From)
 	}
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeouts.Tunnel.Std())
+	defer cancel()
+	return s.getTunnel().Forward(ctx, meta, body)
+}
+
+// SetTunnelForwarder replaces the configured tunnel, e.g. with a TunnelFunc when the
+// node is embedded in-process. Safe to call while messages are being forwarded.
+func (s *Libp2pNodeService) SetTunnelForwarder(f TunnelForwarder) {
+	s.mu.Lock()
+	s.tunnel = f
+	s.mu.Unlock()
+}
+
+func (s *Libp2pNodeService) getTunnel() TunnelForwarder {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tunnel
+}
+
+func (s *Libp2pNodeService) getTopic() *pubsub.Topic {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.topic
+}
+
+func (s *Libp2pNodeService) getSubscription() *pubsub.Subscription {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.subscribed
+}
+
+// newMessageID returns a random hex message ID
+func newMessageID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
 
-// HandleOutgoingMessage publishes outgoing messages to the topic
-func (s *Libp2pNodeService) HandleOutgoingMessage(msg map[string]interface{}) {
+// HandleOutgoingMessage publishes outgoing messages to the topic and returns the message ID.
+// The envelope's "messageId" is generated unless the caller set one; receivers log it and
+// pass it to their tunnel as X-Sight-Message-Id.
+// A gateway in direct routing mode sends to the recipient directly and only
+// publishes when the recipient isn't reachable.
+// Messages larger than MaxPayloadBytes once encoded fail with ErrPayloadTooLarge, and so do
+// published ones that gossipsub would drop (see checkPublishSize).
+// The publish is bounded by ctx and Timeouts.Publish, started after a failed direct attempt;
+// a done ctx aborts it with ctx's error.
+func (s *Libp2pNodeService) HandleOutgoingMessage(ctx context.Context, msg map[string]interface{}) (msgID string, err error) {
+	if err := s.checkSendable(); err != nil {
+		return "", err
+	}
+	msgID, _ = msg["messageId"].(string)
+	if msgID == "" {
+		msgID = newMessageID()
+		msg["messageId"] = msgID
+	}
+	to, _ := msg["to"].(string)
+	ctx, span := tracer.Start(ctx, "publish", trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(
+		attribute.String("sight.message_id", msgID),
+		attribute.String("sight.to", to),
+	))
+	defer func() { endSpan(span, err) }()
+	// 接收方据此接续同一条 trace
+	injectTraceContext(ctx, msg)
 	data, err := json.Marshal(msg)
 	if err != nil {
-		log.Printf("Error marshalling outgoing message: %v", err)
-		return
+		log.Printf("Error marshalling outgoing message %s: %v", msgID, err)
+		return msgID, err
+	}
+	if err := s.checkPayloadSize(data); err != nil {
+		return msgID, err
 	}
 
-	if err := s.topic.Publish(context.Background(), data); err != nil {
-		log.Printf("Error publishing message: %v", err)
-	} else {
-		out, _ := json.MarshalIndent(msg, "", "  ")
-		log.Printf("Published outgoing message: \n%s", out)
+	// 直连尝试有自己的 dial+request 预算，失败后 pubsub 回退仍有完整的 Timeouts.Publish
+	if s.isGateway && s.config.GatewayRouting == "direct" && s.routeDirect(ctx, msg) {
+		return msgID, nil
+	}
+	if err := s.checkPublishSize(data); err != nil {
+		return msgID, err
 	}
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeouts.Publish.Std())
+	defer cancel()
+
+	// Publish 本身只在等待路由就绪时看 ctx，这里先检查，避免请求已取消还继续发布
+	if err := ctx.Err(); err != nil {
+		log.Printf("Publish of message %s aborted: %v", msgID, err)
+		return msgID, err
+	}
+	if err := s.getTopic().Publish(ctx, data); err != nil {
+		log.Printf("Error publishing message %s: %v", msgID, err)
+		return msgID, err
+	}
+	out, _ := json.MarshalIndent(msg, "", "  ")
+	log.Printf("Published outgoing message %s: \n%s", msgID, out)
+	return msgID, nil
+}
+
+// checkPayloadSize rejects encoded messages above the configured MaxPayloadBytes
+func (s *Libp2pNodeService) checkPayloadSize(data []byte) error {
+	if limit := s.config.MaxPayloadBytes; int64(len(data)) > limit {
+		return fmt.Errorf("%w: %d bytes (limit %d)", ErrPayloadTooLarge, len(data), limit)
+	}
+	return nil
+}
+
+// checkPublishSize rejects encoded messages that exceed the pubsub max message size: peers
+// drop oversized RPCs silently, so publishing them would look like a success that never arrives
+func (s *Libp2pNodeService) checkPublishSize(data []byte) error {
+	if limit := s.config.GossipSub.MaxPublishBytes(); len(data) > limit {
+		return fmt.Errorf("%w: %d bytes exceeds the pubsub limit of %d (gossipSub.maxMessageSize %d minus %d bytes overhead); send it direct (p2p-send, send-direct-raw) or raise maxMessageSize on every node",
+			ErrPayloadTooLarge, len(data), limit, s.config.GossipSub.MaxMessageSize, pubsubMessageOverhead)
+	}
+	return nil
 }
 
 func (s *Libp2pNodeService) handleDirectIncomingMessage(stream network.Stream) {
+	if !s.inflight.begin() {
+		stream.Reset() // 正在关闭，不再接收新的直连消息
+		return
+	}
+	directMessages.WithLabelValues("in", string(stream.Protocol())).Inc()
 	go func() { // 并发处理
+		defer s.inflight.done()
 		defer stream.Close()
-		buf := new(bytes.Buffer)
-		if _, err := buf.ReadFrom(stream); err != nil {
+		body, err := io.ReadAll(io.LimitReader(stream, s.config.MaxPayloadBytes+1))
+		if err != nil {
 			log.Printf("Failed to read p2p message: %v", err)
 			return
 		}
+		if err := s.checkPayloadSize(body); err != nil {
+			log.Printf("Rejected p2p message: %v", err)
+			s.ReportViolation(stream.Conn().RemotePeer(), violationOversized)
+			stream.Reset()
+			return
+		}
 		// 解包
 		var payload map[string]interface{}
-		if err := json.Unmarshal(buf.Bytes(), &payload); err != nil {
+		if err := json.Unmarshal(body, &payload); err != nil {
 			log.Printf("Invalid p2p message format: %v", err)
+			s.ReportViolation(stream.Conn().RemotePeer(), violationInvalidMessage)
 			return
 		}
 
@@ -167,37 +485,106 @@ func (s *Libp2pNodeService) handleDirectIncomingMessage(stream network.Stream) {
 		// 	return
 		// }
 		// 发给 tunnel API
-		data, _ := json.Marshal(payload["payload"])
-		resp, err := http.Post(s.tunnelAPI, "application/json", bytes.NewBuffer(data))
+		data, contentType, _ := envelopeBody(payload)
+		// 发送方身份来自安全握手，已验证
+		msgID, _ := payload["messageId"].(string)
+		if msgID == "" {
+			msgID = newMessageID()
+		}
+		meta := TunnelMeta{
+			From:        stream.Conn().RemotePeer(),
+			FromDID:     s.senderDID(stream.Conn().RemotePeer(), payload["from"]),
+			MessageID:   msgID,
+			Transport:   transportDirect,
+			Protocol:    string(stream.Protocol()),
+			ContentType: contentType,
+			ReceivedAt:  time.Now(),
+		}
+		ctx, span := startReceiveSpan(payload, meta)
+		defer span.End()
+		resp, err := s.forwardToTunnel(ctx, data, meta)
+		if errors.Is(err, errTunnelSkipped) {
+			return
+		}
 		if err != nil {
-			log.Printf("Direct message forward error: %v", err)
+			log.Printf("Direct message %s forward error: %v", msgID, err)
 		} else {
-			log.Printf("Direct message forwarded, payload: %v", payload)
-			if resp != nil && resp.Body != nil {
-				resp.Body.Close()
-			}
+			log.Printf("Direct message %s forwarded, payload: %v", msgID, payload)
+			s.sendAck(payload, resp)
 		}
 	}()
 }
 
 // Stop gracefully stops the libp2p node
+// In-flight direct streams are drained first so a message that was already read
+// still reaches the tunnel.
 func (s *Libp2pNodeService) Stop() {
+	s.drainDirectStreams()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.waitHealthMonitor()
+	s.stopStickyPeers()
+	s.saveReputationIfPersisted()
+	if s.config.Registry.Persist {
+		if err := s.saveRegistry(); err != nil {
+			log.Printf("[Registry] Failed to save: %v", err)
+		}
+	}
 	if err := s.node.Close(); err != nil {
 		log.Printf("Error stopping node: %v", err)
 	}
 }
 
-// GetPublicKeyByPeerId returns the public key of a peer by its peer ID
+// StartedAt returns when the service was created
+func (s *Libp2pNodeService) StartedAt() time.Time {
+	return s.startedAt
+}
+
+// Uptime returns how long the service has been running
+func (s *Libp2pNodeService) Uptime() time.Duration {
+	return time.Since(s.startedAt)
+}
+
+func (s *Libp2pNodeService) logUptime(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			log.Printf("[Uptime] %s (started at %s)", s.Uptime().Round(time.Second), s.startedAt.Format(time.RFC3339))
+		}
+	}
+}
+
+var (
+	// ErrPeerNotFound means the peer is not in the peerstore and the DHT could not locate it
+	ErrPeerNotFound = errors.New("peer not found")
+	// ErrPeerConnectFailed means the peer was located but could not be dialed
+	ErrPeerConnectFailed = errors.New("failed to connect to peer")
+	// ErrNoRetrievableKey means we are connected but the peer's public key is still unknown
+	ErrNoRetrievableKey = errors.New("peer has no retrievable public key")
+)
+
+// GetPublicKeyByPeerId returns the public key of a peer by its peer ID.
+// Lookup order: identity peer ID -> peerstore -> connect (known addresses, then DHT FindPeer),
+// bounded by the connect timeout.
 func (s *Libp2pNodeService) GetPublicKeyByPeerId(ctx context.Context, peerId string) ([]byte, error) {
 	pk, err := DecodePublicKeyFromPeerId(peerId)
-	if err == nil && pk != nil {
+	if err == nil {
 		// println(`decode from peerId`)
 		return pk, nil
 	}
+	// 内嵌了公钥但不是合法的 ed25519 key，不再回退到 peerstore/DHT
+	if errors.Is(err, ErrInvalidEmbeddedKey) {
+		return nil, err
+	}
 
 	pid, err := peer.Decode(peerId)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w %q: %v", ErrInvalidTarget, peerId, err)
 	}
 	// 查找 peerstore
 	pub := s.node.Peerstore().PubKey(pid)
@@ -205,52 +592,153 @@ func (s *Libp2pNodeService) GetPublicKeyByPeerId(ctx context.Context, peerId str
 		// println(`find from peerstore`)
 		return pub.Raw()
 	}
-	// 没有就在DHT找对方地址
-	addrInfo, err := s.dht.FindPeer(ctx, pid)
-	if err != nil {
-		return nil, err
-	}
-	// 连接对端
-	err = s.node.Connect(ctx, addrInfo)
-	if err != nil {
+
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeouts.Connect.Std())
+	defer cancel()
+
+	// 没有就连接对端：已知地址优先，再走 DHT
+	if err := s.connectPeer(ctx, pid); err != nil {
 		return nil, err
 	}
-	// 连接后，再次查
+	// 连接后，再次查 peerstore；没有的话取安全握手时对方出示的公钥
 	pub = s.node.Peerstore().PubKey(pid)
 	if pub == nil {
-		// println(`find from DHT`)
-		return nil, errors.New("no public key found")
+		for _, conn := range s.node.Network().ConnsToPeer(pid) {
+			if pub = conn.RemotePublicKey(); pub != nil {
+				break
+			}
+		}
+	}
+	if pub == nil {
+		return nil, ErrNoRetrievableKey
 	}
 	return pub.Raw()
 }
 
-// ConnectByDIDOrMultiAddr connects to a peer by its DID or multiaddr
+// GetPeerScores returns the latest GossipSub peer scores and when they were taken
+func (s *Libp2pNodeService) GetPeerScores() (map[string]float64, time.Time) {
+	return s.scores.Snapshot()
+}
+
+// ConnectByDIDOrMultiAddr connects to a peer by its DID, multiaddr or peer ID (see resolveTarget).
+// Both the DHT lookup and the dial are bounded by Timeouts.Dial; exceeding it returns ErrDialTimeout.
 func (s *Libp2pNodeService) ConnectByDIDOrMultiAddr(ctx context.Context, did string) error {
-	if strings.HasPrefix(did, "/") {
-		maddr, err := ma.NewMultiaddr(did)
-		if err != nil {
-			return err
+	timeout := s.config.Timeouts.Dial.Std()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := s.connectByDIDOrMultiAddr(ctx, did)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w after %s: %v", ErrDialTimeout, timeout, err)
+	}
+	return err
+}
+
+// sendPhase bounds what follows the dial (stream open, write, ping) by Timeouts.Request,
+// so the dial can't use up the send's budget
+func (s *Libp2pNodeService) sendPhase(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, s.config.Timeouts.Request.Std())
+}
+
+// sendTimeoutError turns err into ErrSendTimeout if the send phase ctx expired or the stream
+// deadline hit; other errors are returned as is
+func (s *Libp2pNodeService) sendTimeoutError(ctx context.Context, err error) error {
+	var netErr net.Error
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) ||
+		(errors.As(err, &netErr) && netErr.Timeout()) {
+		return fmt.Errorf("%w after %s: %v", ErrSendTimeout, s.config.Timeouts.Request.Std(), err)
+	}
+	return err
+}
+
+func (s *Libp2pNodeService) connectByDIDOrMultiAddr(ctx context.Context, did string) error {
+	info, err := s.resolveTarget(did)
+	if err != nil {
+		return err
+	}
+	if len(info.Addrs) > 0 {
+		if err := s.node.Connect(ctx, info); err != nil {
+			return fmt.Errorf("%w: %v", ErrPeerConnectFailed, err)
 		}
-		info, err := peer.AddrInfoFromP2pAddr(maddr)
-		if err != nil {
-			return err
+		return nil
+	}
+	return s.connectPeer(ctx, info.ID)
+}
+
+// connectPeer connects to pid, trying the connection we already have, cached and peerstore
+// addresses before a DHT lookup. Lookup failures wrap ErrPeerNotFound (and ErrDHTNotReady
+// when the routing table is empty), dial failures ErrPeerConnectFailed.
+func (s *Libp2pNodeService) connectPeer(ctx context.Context, pid peer.ID) error {
+	// 已经连接上的直接复用，不用再查 DHT
+	if s.node.Network().Connectedness(pid) == network.Connected {
+		dhtLookupsAvoided.WithLabelValues("connected").Inc()
+		return nil
+	}
+	// 之前 FindPeer 查到过的地址先试一下，连续失败会被清掉
+	if addrs, ok := s.resolve.getAddrs(pid); ok {
+		err := s.node.Connect(ctx, peer.AddrInfo{ID: pid, Addrs: addrs})
+		s.resolve.dialResult(pid, err)
+		if err == nil {
+			dhtLookupsAvoided.WithLabelValues("cache").Inc()
+			return nil
+		}
+	}
+	// peerstore 里还有地址就先试一下，失败了再走 FindPeer
+	if addrs := s.node.Peerstore().Addrs(pid); len(addrs) > 0 {
+		if err := s.node.Connect(ctx, peer.AddrInfo{ID: pid, Addrs: addrs}); err == nil {
+			dhtLookupsAvoided.WithLabelValues("peerstore").Inc()
+			return nil
 		}
-		return s.node.Connect(ctx, *info)
 	}
 
-	pub, err := DIDToPublicKey(did)
+	addrInfo, err := s.findPeer(ctx, pid)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %w", ErrPeerNotFound, err)
 	}
-	pid, err := PublicKeyToPeerId(pub)
+	s.resolve.putAddrs(pid, addrInfo.Addrs)
+	err = s.node.Connect(ctx, addrInfo)
+	s.resolve.dialResult(pid, err)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %v", ErrPeerConnectFailed, err)
 	}
-	addrInfo, err := s.dht.FindPeer(ctx, pid)
+	return nil
+}
+
+// resolveTarget parses the three accepted target forms:
+//   - a multiaddr with a peer component, e.g. /ip4/1.2.3.4/tcp/15050/p2p/12D3KooW...
+//     (the returned AddrInfo carries its address, no lookup needed)
+//   - a DID, e.g. did:sight:hoster:6Mk... (peer ID derived from its key)
+//   - a bare peer ID, e.g. 12D3KooW... (addresses found via peerstore / DHT when connecting)
+//
+// Parse failures wrap ErrInvalidTarget.
+func (s *Libp2pNodeService) resolveTarget(target string) (peer.AddrInfo, error) {
+	switch {
+	case strings.HasPrefix(target, "/"):
+		maddr, err := ma.NewMultiaddr(target)
+		if err != nil {
+			return peer.AddrInfo{}, fmt.Errorf("%w %q: %v", ErrInvalidTarget, target, err)
+		}
+		// 没有 /p2p/<id> 时 AddrInfoFromP2pAddr 的报错看不出原因，先检查
+		if _, id := peer.SplitAddr(maddr); id == "" {
+			return peer.AddrInfo{}, fmt.Errorf("%w %q: multiaddr has no /p2p/<peerId> component", ErrInvalidTarget, target)
+		}
+		info, err := peer.AddrInfoFromP2pAddr(maddr)
+		if err != nil {
+			return peer.AddrInfo{}, fmt.Errorf("%w %q: %v", ErrInvalidTarget, target, err)
+		}
+		return *info, nil
+	case strings.HasPrefix(target, "did:"):
+		pid, err := s.resolve.peerID(target)
+		if err != nil {
+			return peer.AddrInfo{}, fmt.Errorf("%w %q: %v", ErrInvalidTarget, target, err)
+		}
+		return peer.AddrInfo{ID: pid}, nil
+	}
+	pid, err := peer.Decode(target)
 	if err != nil {
-		return err
+		return peer.AddrInfo{}, fmt.Errorf("%w %q: not a DID, /p2p multiaddr or peer ID", ErrInvalidTarget, target)
 	}
-	return s.node.Connect(ctx, addrInfo)
+	return peer.AddrInfo{ID: pid}, nil
 }
 
 // GetNeighbors returns a list of currently connected neighbor peer IDs
@@ -262,52 +750,106 @@ func (s *Libp2pNodeService) GetNeighbors() []string {
 	return neighbors
 }
 
-// PingPeer pings a peer by its DID or multiaddr
+// PeerConnectedness is the connection state of a peer as seen locally
+type PeerConnectedness struct {
+	PeerID        string `json:"peerId"`
+	Connectedness string `json:"connectedness"` // Connected, NotConnected or Limited (relayed)
+	Connected     bool   `json:"connected"`
+	// KnownAddrs is how many addresses the peerstore has for the peer (dialable without a DHT lookup)
+	KnownAddrs int `json:"knownAddrs"`
+}
+
+// GetConnectedness resolves a DID, multiaddr or peer ID locally and reports whether we are
+// connected to it, without dialing or querying the DHT
+func (s *Libp2pNodeService) GetConnectedness(target string) (PeerConnectedness, error) {
+	info, err := s.resolveTarget(target)
+	if err != nil {
+		return PeerConnectedness{}, err
+	}
+	c := s.node.Network().Connectedness(info.ID)
+	return PeerConnectedness{
+		PeerID:        info.ID.String(),
+		Connectedness: c.String(),
+		Connected:     c == network.Connected,
+		KnownAddrs:    len(s.node.Peerstore().Addrs(info.ID)),
+	}, nil
+}
+
+// PingPeer pings a peer by its DID, multiaddr or peer ID (see resolveTarget)
 func (s *Libp2pNodeService) PingPeer(ctx context.Context, did string) (int64, error) {
-	err := s.ConnectByDIDOrMultiAddr(ctx, did)
+	// 先解析 DID/multiaddr，格式不对直接返回，不去连接
+	target, err := s.resolveTarget(did)
 	if err != nil {
 		return 0, err
 	}
-	var pid peer.ID
-	if strings.HasPrefix(did, "/") {
-		maddr, _ := ma.NewMultiaddr(did)
-		info, _ := peer.AddrInfoFromP2pAddr(maddr)
-		pid = info.ID
-	} else {
-		pub, _ := DIDToPublicKey(did)
-		pid, _ = PublicKeyToPeerId(pub)
+	pid := target.ID
+	if err := s.ConnectByDIDOrMultiAddr(ctx, did); err != nil {
+		return 0, err
 	}
-	pinger := ping.NewPingService(s.node)
-	ch := pinger.Ping(ctx, pid)
-	// 只要第一个ping响应
-	res := <-ch
-	if res.Error != nil {
-		return 0, res.Error
+	ctx, cancel := s.sendPhase(ctx)
+	defer cancel()
+	rtt, err := pingOnce(ctx, s.node, pid)
+	if err != nil {
+		return 0, s.sendTimeoutError(ctx, err)
 	}
-	return res.RTT.Milliseconds(), nil
+	s.latency.record(pid, rtt, "ping")
+	return rtt.Milliseconds(), nil
 }
 
-// SendDirectMessage sends a direct message to a peer by its DID or multiaddr
-func (s *Libp2pNodeService) SendDirectMessage(ctx context.Context, did string, payload []byte) error {
-	err := s.ConnectByDIDOrMultiAddr(ctx, did)
-	if err != nil {
-		return err
+// SendDirectMessage sends a direct message to a peer by its DID, multiaddr or peer ID and returns
+// the negotiated direct protocol version
+func (s *Libp2pNodeService) SendDirectMessage(ctx context.Context, did string, payload []byte) (protocol.ID, error) {
+	if err := s.checkSendable(); err != nil {
+		return "", err
 	}
-	var pid peer.ID
-	if strings.HasPrefix(did, "/") {
-		maddr, _ := ma.NewMultiaddr(did)
-		info, _ := peer.AddrInfoFromP2pAddr(maddr)
-		pid = info.ID
-	} else {
-		pub, _ := DIDToPublicKey(did)
-		pid, _ = PublicKeyToPeerId(pub)
+	if err := s.checkPayloadSize(payload); err != nil {
+		return "", err
 	}
-	// 暂时采用 "/test/0.0.1" 的自定义 p2p 协议名
-	stream, err := s.node.NewStream(ctx, pid, "/test/0.0.1")
+	stream, err := s.openDirectStream(ctx, did, directProtocols)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer stream.Close()
-	_, err = stream.Write(payload)
-	return err
+	proto := stream.Protocol()
+	directMessages.WithLabelValues("out", string(proto)).Inc()
+	if _, err := stream.Write(payload); err != nil {
+		return proto, s.sendTimeoutError(ctx, fmt.Errorf("%w: write: %w", ErrStreamFailed, err))
+	}
+	return proto, nil
+}
+
+// openDirectStream connects to did (a DID, multiaddr or peer ID) within Timeouts.Dial, then opens
+// a stream on the highest of protos the peer supports. Opening the stream and writing to it
+// (the stream's deadline) get their own Timeouts.Request budget.
+func (s *Libp2pNodeService) openDirectStream(ctx context.Context, did string, protos []protocol.ID) (network.Stream, error) {
+	// 先解析 DID/multiaddr，格式不对直接返回，不去连接
+	target, err := s.resolveTarget(did)
+	if err != nil {
+		return nil, err
+	}
+	pid := target.ID
+	if err := s.ConnectByDIDOrMultiAddr(ctx, did); err != nil {
+		return nil, err
+	}
+	if err := s.checkProtocolSupport(pid, protos...); err != nil {
+		return nil, err
+	}
+	if s.node.Network().Connectedness(pid) == network.Limited {
+		ctx = s.upgradeRelayed(ctx, pid)
+	}
+	ctx, cancel := s.sendPhase(ctx)
+	defer cancel()
+	// 按顺序协商，选双方都支持的最高版本
+	stream, err := s.node.NewStream(ctx, pid, protos...)
+	if err != nil {
+		if err := s.wrapNegotiationError(pid, protos, err); errors.Is(err, ErrProtocolUnsupported) {
+			return nil, err
+		}
+		return nil, s.sendTimeoutError(ctx, fmt.Errorf("%w: open: %w", ErrStreamFailed, err))
+	}
+	// 写入沿用发送阶段剩余的时间
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+	return stream, nil
 }
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+)
+
+func TestSendDirectHandlerRejectsOversizedBody(t *testing.T) {
+	cfg := testConfig(t, "http://127.0.0.1:1", func(c *Config) { c.MaxPayloadBytes = 1024 })
+	c := NewLibp2pNodeController(&Libp2pNodeService{config: cfg})
+	body := `{"data":"` + strings.Repeat("x", 2048) + `"}`
+
+	w := serveHandler(c.SendDirectHandler, "POST", "/libp2p/p2p-send/x", strings.NewReader(body), map[string]string{"did": "x"})
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413", w.Code)
+	}
+	if e := decodeAPIError(t, w); e.Code != "payload_too_large" {
+		t.Errorf("code = %q, want payload_too_large", e.Code)
+	}
+}
+
+func TestDirectMessageOversizedIsRejected(t *testing.T) {
+	limit := func(c *Config) { c.MaxPayloadBytes = 1024 }
+	backendA, backendB := newTestBackend(t), newTestBackend(t)
+	a := newTestService(t, testConfig(t, backendA.URL, limit))
+	b := newTestService(t, testConfig(t, backendB.URL, limit))
+	connectServices(t, a, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := a.node.NewStream(ctx, b.node.ID(), directProtocols[0])
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	// valid JSON, but over the receiver's limit
+	body := `{"payload":"` + strings.Repeat("x", 4096) + `"}`
+	stream.Write([]byte(body))
+	stream.CloseWrite()
+
+	waitFor(t, 10*time.Second, func() bool {
+		for _, r := range b.reputation.List() {
+			if r.PeerID == a.node.ID().String() && r.Violations[violationOversized] == 1 {
+				return true
+			}
+		}
+		return false
+	}, "oversized violation")
+	if n := len(backendB.requests()); n != 0 {
+		t.Errorf("oversized message reached the tunnel (%d requests)", n)
+	}
+
+	// a message within the limit still goes through
+	if _, err := a.SendDirectMessage(ctx, b.did, []byte(`{"payload":{"data":"small"}}`)); err != nil {
+		t.Fatalf("SendDirectMessage: %v", err)
+	}
+	reqs := backendB.waitRequests(t, 1)
+	if !bytes.Contains(reqs[0].Body, []byte("small")) {
+		t.Errorf("forwarded body = %s", reqs[0].Body)
+	}
+}
+
+func TestConnectByDIDOrMultiAddr(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		setup   func(h *fakeHost, d *fakeDHT, p *fakePeer)
+		target  func(p *fakePeer) string
+		want    error
+		lookups int
+	}{
+		{
+			name:   "DID found in the DHT",
+			setup:  func(h *fakeHost, d *fakeDHT, p *fakePeer) { d.publish(p) },
+			target: func(p *fakePeer) string { return p.DID }, lookups: 1,
+		},
+		{
+			name:   "peer ID found in the DHT",
+			setup:  func(h *fakeHost, d *fakeDHT, p *fakePeer) { d.publish(p) },
+			target: func(p *fakePeer) string { return p.ID.String() }, lookups: 1,
+		},
+		{
+			name:   "multiaddr dials its address",
+			target: func(p *fakePeer) string { return p.Addrs[0].String() + "/p2p/" + p.ID.String() },
+		},
+		{
+			name:   "already connected",
+			setup:  func(h *fakeHost, d *fakeDHT, p *fakePeer) { h.setConnected(p.ID) },
+			target: func(p *fakePeer) string { return p.DID },
+		},
+		{
+			name: "peerstore addrs skip the lookup",
+			setup: func(h *fakeHost, d *fakeDHT, p *fakePeer) {
+				h.Peerstore().AddAddrs(p.ID, p.Addrs, time.Hour)
+			},
+			target: func(p *fakePeer) string { return p.DID },
+		},
+		{
+			name:   "not in the DHT",
+			target: func(p *fakePeer) string { return p.DID }, want: ErrPeerNotFound, lookups: 1,
+		},
+		{
+			name:   "empty routing table",
+			setup:  func(h *fakeHost, d *fakeDHT, p *fakePeer) { d.publish(p); d.emptyTable() },
+			target: func(p *fakePeer) string { return p.DID }, want: ErrDHTNotReady,
+		},
+		{
+			name:   "found but unreachable",
+			setup:  func(h *fakeHost, d *fakeDHT, p *fakePeer) { d.publish(p); p.Unreachable = true },
+			target: func(p *fakePeer) string { return p.DID }, want: ErrPeerConnectFailed, lookups: 1,
+		},
+		{
+			name:   "slow lookup hits timeouts.dial",
+			setup:  func(h *fakeHost, d *fakeDHT, p *fakePeer) { d.publish(p); d.delay = time.Minute },
+			target: func(p *fakePeer) string { return p.DID }, want: ErrDialTimeout, lookups: 1,
+		},
+		{
+			name:   "invalid target",
+			target: func(p *fakePeer) string { return "not-a-peer" }, want: ErrInvalidTarget,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, h, d := newFakeService(t, func(c *Config) { c.Timeouts.Dial = Duration(200 * time.Millisecond) })
+			p, _ := h.addPeer(t)
+			if tc.setup != nil {
+				tc.setup(h, d, p)
+			}
+			err := s.ConnectByDIDOrMultiAddr(context.Background(), tc.target(p))
+			if !errorIs(err, tc.want) {
+				t.Fatalf("err = %v, want %v", err, tc.want)
+			}
+			if got := d.lookupCount(); got != tc.lookups {
+				t.Errorf("DHT lookups = %d, want %d", got, tc.lookups)
+			}
+			if connected := h.Network().Connectedness(p.ID) == network.Connected; connected != (tc.want == nil) {
+				t.Errorf("connected = %v after err %v", connected, err)
+			}
+		})
+	}
+}
+
+func TestSendDirectMessageFakeHost(t *testing.T) {
+	s, h, d := newFakeService(t, func(c *Config) { c.Timeouts.Request = Duration(200 * time.Millisecond) })
+	p, received := h.addPeer(t)
+	d.publish(p)
+
+	proto, err := s.SendDirectMessage(context.Background(), p.DID, []byte(`{"payload":1}`))
+	if err != nil {
+		t.Fatalf("SendDirectMessage: %v", err)
+	}
+	if proto != directProtocols[0] {
+		t.Errorf("protocol = %s, want %s", proto, directProtocols[0])
+	}
+	if got := <-received; string(got) != `{"payload":1}` {
+		t.Errorf("peer received %s", got)
+	}
+
+	// an older peer negotiates the version it speaks
+	old, oldReceived := h.addPeer(t)
+	delete(old.Handlers, directProtocols[0])
+	d.publish(old)
+	if proto, err := s.SendDirectMessage(context.Background(), old.DID, []byte(`{}`)); err != nil || proto != directProtocols[1] {
+		t.Errorf("send to an older peer: %s, %v, want %s", proto, err, directProtocols[1])
+	}
+	<-oldReceived
+
+	// a peer without a direct protocol, e.g. a bootstrap node
+	bare, _ := h.addPeer(t)
+	for _, proto := range directProtocols {
+		delete(bare.Handlers, proto)
+	}
+	d.publish(bare)
+	if _, err := s.SendDirectMessage(context.Background(), bare.DID, []byte(`{}`)); !errors.Is(err, ErrProtocolUnsupported) {
+		t.Errorf("send to a peer without direct protocols: %v, want ErrProtocolUnsupported", err)
+	}
+
+	// a peer that never reads: the write is bounded by timeouts.request
+	stalled, _ := h.addPeer(t)
+	for _, proto := range directProtocols {
+		stalled.Handlers[proto] = func(network.Stream) {}
+	}
+	d.publish(stalled)
+	if _, err := s.SendDirectMessage(context.Background(), stalled.DID, []byte(`{}`)); !errors.Is(err, ErrSendTimeout) {
+		t.Errorf("send to a stalled peer: %v, want ErrSendTimeout", err)
+	}
+}
+
+func TestPingPeerFakeHost(t *testing.T) {
+	s, h, d := newFakeService(t, func(c *Config) { c.Timeouts.Request = Duration(200 * time.Millisecond) })
+	p, _ := h.addPeer(t)
+	d.publish(p)
+
+	if _, err := s.PingPeer(context.Background(), p.DID); err != nil {
+		t.Fatalf("PingPeer: %v", err)
+	}
+	if h.Peerstore().LatencyEWMA(p.ID) <= 0 {
+		t.Error("ping RTT not recorded in the peerstore")
+	}
+	if st := s.latency.stats(p.ID); st.Count != 1 || st.Samples[0].Source != "ping" {
+		t.Errorf("latency history = %+v, want one ping sample", st)
+	}
+
+	silent, _ := h.addPeer(t)
+	silent.Handlers[ping.ID] = func(network.Stream) {}
+	d.publish(silent)
+	if _, err := s.PingPeer(context.Background(), silent.DID); !errors.Is(err, ErrSendTimeout) {
+		t.Errorf("ping of a silent peer: %v, want ErrSendTimeout", err)
+	}
+
+	if _, err := s.PingPeer(context.Background(), "did:sight:hoster:bad"); !errors.Is(err, ErrInvalidTarget) {
+		t.Errorf("ping of a malformed DID: %v, want ErrInvalidTarget", err)
+	}
+}
+
+func TestMalformedTargetsFailBeforeDialing(t *testing.T) {
+	pid, did := testPeer(t)
+	key := strings.TrimPrefix(did, "did:sight:hoster:")
+	s, h, d := newFakeService(t)
+	c := NewLibp2pNodeController(s)
+
+	for _, target := range []string{
+		"did:sight:hoster:",
+		"did:sight:hoster:0OIl",                        // not base58
+		"did:sight:hoster:" + key[:len(key)-4],         // truncated key
+		"did:sight:admin:" + key,                       // unknown role
+		"did:key:" + key,                               // another DID method
+		"did:sight:hoster",                             // no key segment
+		"/ip4/1.2.3.4/tcp/15050",                       // no /p2p component
+		"/ip4/999.1.1.1/tcp/15050/p2p/" + pid.String(), // bad IP
+		"/ip4/1.2.3.4/tcp/port/p2p/" + pid.String(),    // bad port
+		"/ip4/1.2.3.4/tcp/15050/p2p/notapeer",
+		"/nosuchproto/1",
+		"12D3KooWnotapeer",
+		"hello",
+	} {
+		t.Run(target, func(t *testing.T) {
+			if _, err := s.PingPeer(context.Background(), target); !errors.Is(err, ErrInvalidTarget) {
+				t.Errorf("PingPeer: %v, want ErrInvalidTarget", err)
+			}
+			if _, err := s.SendDirectMessage(context.Background(), target, []byte(`{}`)); !errors.Is(err, ErrInvalidTarget) {
+				t.Errorf("SendDirectMessage: %v, want ErrInvalidTarget", err)
+			}
+			w := serveHandler(c.PingHandler, "POST", "/libp2p/ping/x", nil, map[string]string{"did": target})
+			if e := decodeAPIError(t, w); w.Code != 400 || e.Code != "invalid_target" {
+				t.Errorf("ping handler: %d %s, want 400 invalid_target", w.Code, e.Code)
+			}
+		})
+	}
+	if n, lookups := h.dialCount(), d.lookupCount(); n != 0 || lookups != 0 {
+		t.Errorf("malformed targets caused %d dials and %d DHT lookups", n, lookups)
+	}
+}
+
+func TestResolveTarget(t *testing.T) {
+	s, _, _ := newFakeService(t)
+	pid, hosterDID := testPeer(t)
+	gatewayDID := ToSightDID(RoleGateway, mustPeerKey(t, pid))
+	hashed, err := peer.Decode("QmYyQSo1c1Ym7orWxLYvCrM2EmxFTANf8wXmmE7DWjhx5N")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		target  string
+		want    peer.ID
+		addrs   []string
+		wantErr bool
+	}{
+		{"hoster DID", hosterDID, pid, nil, false},
+		{"gateway DID", gatewayDID, pid, nil, false},
+		{"tcp multiaddr", "/ip4/1.2.3.4/tcp/15050/p2p/" + pid.String(), pid, []string{"/ip4/1.2.3.4/tcp/15050"}, false},
+		{"quic multiaddr", "/ip6/::1/udp/15050/quic-v1/p2p/" + pid.String(), pid, []string{"/ip6/::1/udp/15050/quic-v1"}, false},
+		{"dns multiaddr", "/dns4/node.example.com/tcp/15050/p2p/" + pid.String(), pid, []string{"/dns4/node.example.com/tcp/15050"}, false},
+		{"p2p only", "/p2p/" + pid.String(), pid, nil, false},
+		{"peer ID", pid.String(), pid, nil, false},
+		{"CID peer ID", peer.ToCid(pid).String(), pid, nil, false},
+		{"hashed peer ID", hashed.String(), hashed, nil, false},
+		{"multiaddr without peer", "/ip4/1.2.3.4/tcp/15050", "", nil, true},
+		{"DID with a bad key", "did:sight:hoster:abc", "", nil, true},
+		{"unknown DID method", "did:web:example.com", "", nil, true},
+		{"garbage", "not a target", "", nil, true},
+		{"empty", "", "", nil, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			info, err := s.resolveTarget(tc.target)
+			if tc.wantErr {
+				if !errors.Is(err, ErrInvalidTarget) {
+					t.Fatalf("err = %v, want ErrInvalidTarget", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveTarget: %v", err)
+			}
+			if info.ID != tc.want {
+				t.Errorf("peer = %s, want %s", info.ID, tc.want)
+			}
+			var addrs []string
+			for _, a := range info.Addrs {
+				addrs = append(addrs, a.String())
+			}
+			if !slices.Equal(addrs, tc.addrs) {
+				t.Errorf("addrs = %v, want %v", addrs, tc.addrs)
+			}
+		})
+	}
+}
+
+func TestSendDirectMessagePhaseTimeouts(t *testing.T) {
+	const dial, request = 300 * time.Millisecond, 300 * time.Millisecond
+	for _, tc := range []struct {
+		name  string
+		setup func(d *fakeDHT, p *fakePeer)
+		want  error
+		code  string
+		// max is the upper bound of the send; slow phases must not borrow from each other
+		max time.Duration
+	}{
+		{
+			name: "each phase within its own budget",
+			// 400ms in total, over either budget: only passes if the phases are timed separately
+			setup: func(d *fakeDHT, p *fakePeer) {
+				d.delay, p.DialDelay, p.StreamDelay = 100*time.Millisecond, 100*time.Millisecond, 200*time.Millisecond
+			},
+			max: dial + request,
+		},
+		{
+			name:  "lookup too slow",
+			setup: func(d *fakeDHT, p *fakePeer) { d.delay = time.Minute },
+			want:  ErrDialTimeout, code: "dial_timeout", max: dial + 500*time.Millisecond,
+		},
+		{
+			name:  "dial too slow",
+			setup: func(d *fakeDHT, p *fakePeer) { p.DialDelay = time.Minute },
+			want:  ErrDialTimeout, code: "dial_timeout", max: dial + 500*time.Millisecond,
+		},
+		{
+			name: "stream open too slow after a slow dial",
+			setup: func(d *fakeDHT, p *fakePeer) {
+				p.DialDelay, p.StreamDelay = 200*time.Millisecond, time.Minute
+			},
+			want: ErrSendTimeout, code: "send_timeout", max: dial + request + 500*time.Millisecond,
+		},
+		{
+			name: "peer never reads the message",
+			setup: func(d *fakeDHT, p *fakePeer) {
+				for _, proto := range directProtocols {
+					p.Handlers[proto] = func(network.Stream) {}
+				}
+			},
+			want: ErrSendTimeout, code: "send_timeout", max: request + 500*time.Millisecond,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, h, d := newFakeService(t, func(c *Config) {
+				c.Timeouts.Dial, c.Timeouts.Request = Duration(dial), Duration(request)
+			})
+			p, _ := h.addPeer(t)
+			d.publish(p)
+			tc.setup(d, p)
+
+			start := time.Now()
+			_, err := s.SendDirectMessage(context.Background(), p.DID, []byte(`{"payload":"phased"}`))
+			elapsed := time.Since(start)
+			if !errorIs(err, tc.want) {
+				t.Fatalf("err = %v, want %v", err, tc.want)
+			}
+			if elapsed > tc.max {
+				t.Errorf("send took %v, want at most %v", elapsed, tc.max)
+			}
+			if tc.want == nil {
+				return
+			}
+			if status, code := errorStatus(context.Background(), err); status != http.StatusGatewayTimeout || code != tc.code {
+				t.Errorf("errorStatus = %d %s, want 504 %s", status, code, tc.code)
+			}
+			// a dial timeout is never reported as a send timeout and vice versa
+			if errors.Is(err, ErrDialTimeout) == errors.Is(err, ErrSendTimeout) {
+				t.Errorf("err %v matches both or neither phase", err)
+			}
+		})
+	}
+}
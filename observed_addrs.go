@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+const (
+	// maxObservedAddrs bounds the observed addresses kept; the least recently seen one is evicted
+	maxObservedAddrs = 64
+	// observedAddrTTL is how long an observation counts once its observer is gone: a peer
+	// reports our address once per identify, so a connected observer's view stays current
+	observedAddrTTL = 30 * time.Minute
+)
+
+// ObservedAddr is one of our addresses as reported by peers via identify
+type ObservedAddr struct {
+	Addr      string    `json:"addr"`
+	Observers int       `json:"observers"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	// Announced is set once MinPeers distinct peers observed this address's IP
+	Announced bool `json:"announced"`
+}
+
+type observedEntry struct {
+	observers map[peer.ID]time.Time // when each peer last reported the address
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+// observedAddrs counts which peers observed which of our addresses. A public IP is only
+// advertised (see addrsFactory) once minPeers distinct peers have observed it, so a single
+// peer's view (or a flapping NAT mapping) doesn't change what we announce.
+// At most maxObservedAddrs addresses are kept, and observations expire observedAddrTTL
+// after the observer disconnected.
+type observedAddrs struct {
+	minPeers int
+
+	mu             sync.Mutex
+	entries        map[string]*observedEntry // by observed multiaddr
+	interfaceAddrs func() ([]ma.Multiaddr, error)
+	connected      func(peer.ID) bool // nil: observations expire observedAddrTTL after they were made
+}
+
+func newObservedAddrs(minPeers int) *observedAddrs {
+	return &observedAddrs{minPeers: minPeers, entries: make(map[string]*observedEntry)}
+}
+
+func (o *observedAddrs) observe(addr ma.Multiaddr, from peer.ID) {
+	now := time.Now()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.prune(now)
+	e, ok := o.entries[addr.String()]
+	if !ok {
+		e = &observedEntry{observers: make(map[peer.ID]time.Time), firstSeen: now}
+		o.entries[addr.String()] = e
+	}
+	e.observers[from] = now
+	e.lastSeen = now
+	for len(o.entries) > maxObservedAddrs {
+		o.evictOldest()
+	}
+}
+
+// prune drops expired observations and the addresses left without observers. Caller holds o.mu.
+func (o *observedAddrs) prune(now time.Time) {
+	for addr, e := range o.entries {
+		for p, seen := range e.observers {
+			if now.Sub(seen) > observedAddrTTL && (o.connected == nil || !o.connected(p)) {
+				delete(e.observers, p)
+			}
+		}
+		if len(e.observers) == 0 {
+			delete(o.entries, addr)
+		}
+	}
+}
+
+// evictOldest drops the least recently seen address. Caller holds o.mu.
+func (o *observedAddrs) evictOldest() {
+	var oldest string
+	for addr, e := range o.entries {
+		if oldest == "" || e.lastSeen.Before(o.entries[oldest].lastSeen) {
+			oldest = addr
+		}
+	}
+	delete(o.entries, oldest)
+}
+
+// ipObservers counts distinct observers per IP across all observed ports. Caller holds o.mu.
+func (o *observedAddrs) ipObservers() map[string]int {
+	peersByIP := make(map[string]map[peer.ID]struct{})
+	for addr, e := range o.entries {
+		ip := addrIP(addr)
+		if peersByIP[ip] == nil {
+			peersByIP[ip] = make(map[peer.ID]struct{})
+		}
+		for p := range e.observers {
+			peersByIP[ip][p] = struct{}{}
+		}
+	}
+	counts := make(map[string]int, len(peersByIP))
+	for ip, peers := range peersByIP {
+		counts[ip] = len(peers)
+	}
+	return counts
+}
+
+// List returns the observed addresses, most observed first
+func (o *observedAddrs) List() []ObservedAddr {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.prune(time.Now())
+	byIP := o.ipObservers()
+	out := make([]ObservedAddr, 0, len(o.entries))
+	for addr, e := range o.entries {
+		out = append(out, ObservedAddr{
+			Addr:      addr,
+			Observers: len(e.observers),
+			FirstSeen: e.firstSeen,
+			LastSeen:  e.lastSeen,
+			Announced: byIP[addrIP(addr)] >= o.minPeers,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Observers != out[j].Observers {
+			return out[i].Observers > out[j].Observers
+		}
+		return out[i].Addr < out[j].Addr
+	})
+	return out
+}
+
+func (o *observedAddrs) setInterfaceAddrs(f func() ([]ma.Multiaddr, error)) {
+	o.mu.Lock()
+	o.interfaceAddrs = f
+	o.mu.Unlock()
+}
+
+// setConnected tells which observers are still connected, keeping their observations alive
+func (o *observedAddrs) setConnected(f func(peer.ID) bool) {
+	o.mu.Lock()
+	o.connected = f
+	o.mu.Unlock()
+}
+
+// addrsFactory drops public addresses whose IP hasn't been observed by minPeers distinct
+// peers yet. Addresses of our own interfaces and private/loopback addresses always pass.
+func (o *observedAddrs) addrsFactory(addrs []ma.Multiaddr) []ma.Multiaddr {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.interfaceAddrs == nil {
+		return addrs // host not fully up yet
+	}
+	o.prune(time.Now())
+	ifaceIPs := make(map[string]bool)
+	if ifaces, err := o.interfaceAddrs(); err == nil {
+		for _, a := range ifaces {
+			ifaceIPs[addrIP(a.String())] = true
+		}
+	}
+	byIP := o.ipObservers()
+
+	out := make([]ma.Multiaddr, 0, len(addrs))
+	for _, a := range addrs {
+		ip := addrIP(a.String())
+		if manet.IsPublicAddr(a) && !ifaceIPs[ip] && byIP[ip] < o.minPeers {
+			continue
+		}
+		out = append(out, a)
+	}
+	return out
+}
+
+// addrIP returns the IP component of a multiaddr string ("" if it has none)
+func addrIP(addr string) string {
+	m, err := ma.NewMultiaddr(addr)
+	if err != nil {
+		return ""
+	}
+	for _, code := range []int{ma.P_IP4, ma.P_IP6} {
+		if v, err := m.ValueForProtocol(code); err == nil {
+			return v
+		}
+	}
+	return ""
+}
+
+// watchObservedAddrs records the observed address reported by every completed identify
+func (s *Libp2pNodeService) watchObservedAddrs(ctx context.Context) {
+	sub, err := s.node.EventBus().Subscribe(new(event.EvtPeerIdentificationCompleted))
+	if err != nil {
+		log.Printf("Failed to subscribe to identify events: %v", err)
+		return
+	}
+	defer sub.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-sub.Out():
+			if !ok {
+				return
+			}
+			evt := e.(event.EvtPeerIdentificationCompleted)
+			if evt.ObservedAddr != nil {
+				s.observed.observe(evt.ObservedAddr, evt.Peer)
+			}
+		}
+	}
+}
+
+// GetObservedAddrs returns our addresses as observed by peers
+func (s *Libp2pNodeService) GetObservedAddrs() []ObservedAddr {
+	return s.observed.List()
+}
+
+// observedBy returns the addresses of ours that pid reported
+func (o *observedAddrs) observedBy(pid peer.ID) []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.prune(time.Now())
+	var addrs []string
+	for addr, e := range o.entries {
+		if _, ok := e.observers[pid]; ok {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Strings(addrs)
+	return addrs
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// backdate makes pid's observation of addr (and the address's lastSeen) age by d
+func (o *observedAddrs) backdate(addr string, pid peer.ID, d time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	e := o.entries[addr]
+	e.observers[pid] = e.observers[pid].Add(-d)
+	e.lastSeen = e.lastSeen.Add(-d)
+}
+
+func TestObservedAddrsCapped(t *testing.T) {
+	o := newObservedAddrs(1)
+	from, _ := testPeer(t)
+	addr := func(i int) string { return fmt.Sprintf("/ip4/93.184.216.%d/tcp/15050", i) }
+
+	for i := 0; i < maxObservedAddrs; i++ {
+		o.observe(ma.StringCast(addr(i)), from)
+	}
+	o.backdate(addr(0), from, time.Minute) // least recently seen
+	for i := maxObservedAddrs; i < maxObservedAddrs+10; i++ {
+		o.observe(ma.StringCast(addr(i)), from)
+	}
+
+	list := o.List()
+	if len(list) != maxObservedAddrs {
+		t.Fatalf("%d observed addrs kept, want %d", len(list), maxObservedAddrs)
+	}
+	kept := make(map[string]bool)
+	for _, a := range list {
+		kept[a.Addr] = true
+	}
+	if kept[addr(0)] {
+		t.Error("least recently seen address was not evicted")
+	}
+	if !kept[addr(maxObservedAddrs+9)] {
+		t.Error("newest address was evicted")
+	}
+}
+
+func TestObservedAddrsExpire(t *testing.T) {
+	o := newObservedAddrs(2)
+	gone, _ := testPeer(t)
+	stays, _ := testPeer(t)
+	connected := map[peer.ID]bool{stays: true}
+	o.setConnected(func(pid peer.ID) bool { return connected[pid] })
+	o.setInterfaceAddrs(func() ([]ma.Multiaddr, error) { return nil, nil })
+
+	public := "/ip4/93.184.216.7/tcp/15050"
+	o.observe(ma.StringCast(public), gone)
+	o.observe(ma.StringCast(public), stays)
+	if list := o.List(); len(list) != 1 || list[0].Observers != 2 || !list[0].Announced {
+		t.Fatalf("observed addrs = %+v, want one announced address seen by 2 peers", list)
+	}
+
+	// past the TTL only the observer that is still connected counts: no longer announced
+	o.backdate(public, gone, observedAddrTTL+time.Minute)
+	o.backdate(public, stays, observedAddrTTL+time.Minute)
+	if list := o.List(); len(list) != 1 || list[0].Observers != 1 || list[0].Announced {
+		t.Fatalf("after the TTL: observed addrs = %+v, want one unannounced address seen by 1 peer", list)
+	}
+	if got := o.addrsFactory([]ma.Multiaddr{ma.StringCast(public)}); len(got) != 0 {
+		t.Errorf("expired public address still advertised: %v", got)
+	}
+
+	// once its last observer disconnected too, the address is dropped
+	connected[stays] = false
+	if list := o.List(); len(list) != 0 {
+		t.Errorf("address without live observations kept: %+v", list)
+	}
+	if got := o.observedBy(stays); len(got) != 0 {
+		t.Errorf("observedBy = %v after expiry", got)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+// ErrObserverMode is returned by every publish/send path when the node runs as an observer
+var ErrObserverMode = errors.New("node is in observer mode (read-only)")
+
+// observerHistorySize is how many received messages an observer keeps for the API
+const observerHistorySize = 500
+
+// ObservedMessage is one message seen by an observer node. Only metadata is kept.
+type ObservedMessage struct {
+	ReceivedAt  time.Time `json:"receivedAt"`
+	From        string    `json:"from"`              // peer ID that published / sent it
+	FromDID     string    `json:"fromDid,omitempty"` // derived from the peer ID when possible
+	To          string    `json:"to,omitempty"`      // envelope "to" of pubsub messages
+	MessageID   string    `json:"messageId"`
+	Transport   string    `json:"transport"`
+	Topic       string    `json:"topic,omitempty"`
+	Protocol    string    `json:"protocol,omitempty"`
+	ContentType string    `json:"contentType,omitempty"`
+	Ack         bool      `json:"ack,omitempty"`
+	Bytes       int       `json:"bytes"`
+}
+
+// messageObserver replaces the tunnel in observer mode: received messages are logged,
+// counted and kept in a ring buffer instead of being forwarded
+type messageObserver struct {
+	mu     sync.Mutex
+	ring   []ObservedMessage
+	next   int // index overwritten next once the ring is full
+	totals map[string]int
+}
+
+func newMessageObserver(size int) *messageObserver {
+	return &messageObserver{ring: make([]ObservedMessage, 0, size), totals: make(map[string]int)}
+}
+
+// Forward records direct messages; it never forwards, so callers skip it like a disabled tunnel
+func (o *messageObserver) Forward(ctx context.Context, meta TunnelMeta, body []byte) (*TunnelResponse, error) {
+	o.record(meta, "", false, len(body))
+	return nil, errTunnelSkipped
+}
+
+func (o *messageObserver) record(meta TunnelMeta, to string, ack bool, size int) {
+	m := ObservedMessage{
+		ReceivedAt:  meta.ReceivedAt,
+		From:        meta.From.String(),
+		FromDID:     meta.FromDID,
+		To:          to,
+		MessageID:   meta.MessageID,
+		Transport:   meta.Transport,
+		Topic:       meta.Topic,
+		Protocol:    meta.Protocol,
+		ContentType: meta.ContentType,
+		Ack:         ack,
+		Bytes:       size,
+	}
+	observedMessages.WithLabelValues(meta.Transport).Inc()
+	log.Printf("[Observer] %s message %s from %s to %q (%d bytes)", m.Transport, m.MessageID, m.From, m.To, m.Bytes)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.totals[meta.Transport]++
+	if len(o.ring) < cap(o.ring) {
+		o.ring = append(o.ring, m)
+		return
+	}
+	o.ring[o.next] = m
+	o.next = (o.next + 1) % len(o.ring)
+}
+
+// ObservedMessages is the observer API response
+type ObservedMessages struct {
+	Totals   map[string]int    `json:"totals"`   // by transport, since startup
+	Messages []ObservedMessage `json:"messages"` // newest first, at most observerHistorySize
+}
+
+// List returns the recent messages, newest first
+func (o *messageObserver) List() ObservedMessages {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	out := ObservedMessages{
+		Totals:   make(map[string]int, len(o.totals)),
+		Messages: make([]ObservedMessage, 0, len(o.ring)),
+	}
+	for k, v := range o.totals {
+		out.Totals[k] = v
+	}
+	// 从最新的往回读
+	for i := 0; i < len(o.ring); i++ {
+		idx := (o.next - 1 - i + 2*len(o.ring)) % len(o.ring)
+		out.Messages = append(out.Messages, o.ring[idx])
+	}
+	return out
+}
+
+// observeMessage records a pubsub message in observer mode
+func (s *Libp2pNodeService) observeMessage(msg *pubsub.Message, payload map[string]interface{}) {
+	to, _ := payload["to"].(string)
+	msgID, _ := payload["messageId"].(string)
+	if msgID == "" {
+		msgID = hex.EncodeToString([]byte(msg.ID))
+	}
+	_, ack := payload["ack"].(string)
+	s.observer.record(TunnelMeta{
+		From:       msg.GetFrom(),
+		FromDID:    s.senderDID(msg.GetFrom(), payload["from"]),
+		MessageID:  msgID,
+		Transport:  transportPubSub,
+		Topic:      msg.GetTopic(),
+		ReceivedAt: time.Now(),
+	}, to, ack, len(msg.Data))
+}
+
+// GetObservedMessages returns what an observer node has seen; ok is false outside observer mode
+func (s *Libp2pNodeService) GetObservedMessages() (ObservedMessages, bool) {
+	if s.observer == nil {
+		return ObservedMessages{}, false
+	}
+	return s.observer.List(), true
+}
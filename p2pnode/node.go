@@ -0,0 +1,113 @@
+// Package p2pnode creates the libp2p host + DHT + GossipSub stack shared by the
+// node service and the bootstrap harness.
+package p2pnode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/pnet"
+)
+
+// DefaultTopic is the pubsub topic (mesh namespace) of the node service and the bootstrap
+// harness when TOPIC isn't set; both must agree or messages don't cross between them
+const DefaultTopic = "sight-message"
+
+// Options configures a node created with New
+type Options struct {
+	// PrivKey is the node identity
+	PrivKey crypto.PrivKey
+	// ListenAddrs are the multiaddrs to listen on
+	ListenAddrs []string
+	// Topic is joined right after pubsub is created; empty skips joining
+	Topic string
+	// Topics are further topics joined after Topic, e.g. other namespaces hosted by the
+	// same bootstrap cluster
+	Topics []string
+	// DHTMode is the kademlia DHT mode (dht.ModeServer, dht.ModeClient, dht.ModeAuto, ...)
+	DHTMode dht.ModeOpt
+	// Libp2pOpts are appended to the libp2p.New options
+	Libp2pOpts []libp2p.Option
+	// PubSubOpts are passed to pubsub.NewGossipSub
+	PubSubOpts []pubsub.Option
+}
+
+// Node bundles the handles of a running libp2p node. The embedded Host gives
+// Node the usual host methods (ID, Addrs, Connect, ...).
+type Node struct {
+	host.Host
+	DHT    *dht.IpfsDHT
+	PubSub *pubsub.PubSub
+	Topic  *pubsub.Topic
+	// Topics holds every joined topic by name, Topic included
+	Topics map[string]*pubsub.Topic
+}
+
+// New creates the host, GossipSub (joining opts.Topic and opts.Topics) and the DHT.
+// The DHT is not bootstrapped; callers do that once they have connected to peers.
+func New(ctx context.Context, opts Options) (*Node, error) {
+	libp2pOpts := []libp2p.Option{
+		libp2p.ListenAddrStrings(opts.ListenAddrs...),
+		libp2p.Identity(opts.PrivKey),
+	}
+	libp2pOpts = append(libp2pOpts, opts.Libp2pOpts...)
+	h, err := libp2p.New(libp2pOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("create libp2p host: %w", err)
+	}
+
+	ps, err := pubsub.NewGossipSub(ctx, h, opts.PubSubOpts...)
+	if err != nil {
+		h.Close()
+		return nil, fmt.Errorf("create pubsub: %w", err)
+	}
+
+	topics := make(map[string]*pubsub.Topic)
+	for _, name := range append([]string{opts.Topic}, opts.Topics...) {
+		if name == "" || topics[name] != nil {
+			continue
+		}
+		t, err := ps.Join(name)
+		if err != nil {
+			h.Close()
+			return nil, fmt.Errorf("join topic %s: %w", name, err)
+		}
+		topics[name] = t
+	}
+
+	d, err := dht.New(ctx, h, dht.Mode(opts.DHTMode))
+	if err != nil {
+		h.Close()
+		return nil, fmt.Errorf("create dht: %w", err)
+	}
+
+	return &Node{Host: h, DHT: d, PubSub: ps, Topic: topics[opts.Topic], Topics: topics}, nil
+}
+
+// Close shuts down the DHT and then the host
+func (n *Node) Close() error {
+	return errors.Join(n.DHT.Close(), n.Host.Close())
+}
+
+// LoadPSK reads a private network key in the swarm.key format (/key/swarm/psk/1.0.0/).
+// Passed to libp2p.PrivateNetwork it keeps the node from connecting to peers that don't
+// share the key, so unrelated deployments can share bootstrap infrastructure.
+func LoadPSK(path string) (pnet.PSK, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	psk, err := pnet.DecodeV1PSK(f)
+	if err != nil {
+		return nil, fmt.Errorf("decode private network key %s: %w", path, err)
+	}
+	return psk, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// PeerInfo describes what we know about the peer behind a DID
+type PeerInfo struct {
+	DID           string   `json:"did"`
+	PeerID        string   `json:"peerId"`
+	Connected     bool     `json:"connected"` // a direct connection exists right now
+	Connectedness string   `json:"connectedness"`
+	Addrs         []string `json:"addrs"`
+	// AddrsSource is where Addrs came from: "peerstore", "cache", "dht" or "" when none are known
+	AddrsSource string `json:"addrsSource"`
+	// LatencyMs is a fresh ping RTT when connected, otherwise the last recorded one (omitted when unknown)
+	LatencyMs *int64 `json:"latencyMs,omitempty"`
+}
+
+// GetPeerInfoByDID resolves a DID to its peer ID and reports connectedness, known addresses
+// (peerstore, then resolve cache, then DHT FindPeer) and latency. It never dials the peer;
+// malformed DIDs wrap ErrInvalidTarget.
+func (s *Libp2pNodeService) GetPeerInfoByDID(ctx context.Context, did string) (*PeerInfo, error) {
+	pid, err := s.resolve.peerID(did)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %v", ErrInvalidTarget, did, err)
+	}
+
+	info := &PeerInfo{DID: did, PeerID: pid.String(), Addrs: []string{}}
+	connectedness := s.node.Network().Connectedness(pid)
+	info.Connected = connectedness == network.Connected
+	info.Connectedness = connectedness.String()
+
+	var addrs []ma.Multiaddr
+	if addrs = s.node.Peerstore().Addrs(pid); len(addrs) > 0 {
+		info.AddrsSource = "peerstore"
+	} else if cached, ok := s.resolve.getAddrs(pid); ok {
+		addrs = cached
+		info.AddrsSource = "cache"
+	} else {
+		lookupCtx, cancel := context.WithTimeout(ctx, s.config.Timeouts.Connect.Std())
+		found, err := s.findPeer(lookupCtx, pid)
+		cancel()
+		if err != nil {
+			log.Printf("[PeerInfo] DHT lookup for %s failed: %v", pid, err)
+		} else {
+			addrs = found.Addrs
+			info.AddrsSource = "dht"
+			s.resolve.putAddrs(pid, addrs)
+		}
+	}
+	for _, addr := range addrs {
+		info.Addrs = append(info.Addrs, addr.String())
+	}
+
+	if info.Connected {
+		pingCtx, cancel := context.WithTimeout(ctx, s.config.Timeouts.Request.Std())
+		rtt, err := pingOnce(pingCtx, s.node, pid)
+		cancel()
+		if err == nil {
+			ms := rtt.Milliseconds()
+			info.LatencyMs = &ms
+		}
+	}
+	if info.LatencyMs == nil {
+		if rtt := s.node.Peerstore().LatencyEWMA(pid); rtt > 0 {
+			ms := rtt.Milliseconds()
+			info.LatencyMs = &ms
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// protectTag is the connection manager protection tag used by the protect endpoints, so
+// API protection doesn't interfere with protections set by libp2p subsystems
+const protectTag = "sight-api"
+
+// ErrInvalidTag is returned for an empty peer tag name
+var ErrInvalidTag = errors.New("invalid tag")
+
+// PeerConnState is the connection manager state of one peer: whether the API protected it
+// from pruning and its tags (the API's and those of libp2p subsystems such as the DHT)
+type PeerConnState struct {
+	PeerID    string `json:"peerId"`
+	DID       string `json:"did,omitempty"`
+	Connected bool   `json:"connected"`
+	// Connection is direct or relayed (only /p2p-circuit connections); empty when not connected
+	Connection string         `json:"connection,omitempty"`
+	Protected  bool           `json:"protected"`
+	Tags       map[string]int `json:"tags"`
+	// Value is the sum of the tag weights; the connection manager prunes low values first
+	Value int `json:"value"`
+}
+
+// peerConnState reads the connection manager state of pid
+func (s *Libp2pNodeService) peerConnState(pid peer.ID) PeerConnState {
+	cm := s.node.ConnManager()
+	st := PeerConnState{
+		PeerID:     pid.String(),
+		Connected:  s.node.Network().Connectedness(pid) == network.Connected,
+		Connection: connectionType(s.node.Network(), pid),
+		Protected:  cm.IsProtected(pid, protectTag),
+		Tags:       map[string]int{},
+	}
+	if did, err := s.peerDID(pid); err == nil {
+		st.DID = did
+	}
+	if info := cm.GetTagInfo(pid); info != nil {
+		for tag, weight := range info.Tags {
+			st.Tags[tag] = weight
+		}
+		st.Value = info.Value
+	}
+	return st
+}
+
+// ProtectPeer protects (or unprotects) a peer by DID or peer ID from connection manager
+// pruning. Protection is kept for peers that aren't connected yet.
+func (s *Libp2pNodeService) ProtectPeer(target string, protect bool) (PeerConnState, error) {
+	info, err := s.resolveTarget(target)
+	if err != nil {
+		return PeerConnState{}, err
+	}
+	if protect {
+		s.node.ConnManager().Protect(info.ID, protectTag)
+	} else {
+		s.node.ConnManager().Unprotect(info.ID, protectTag)
+	}
+	return s.peerConnState(info.ID), nil
+}
+
+// TagPeer sets the weight of a tag on a peer for soft prioritization: higher-value peers are
+// pruned last. Weight 0 removes the tag.
+func (s *Libp2pNodeService) TagPeer(target, tag string, weight int) (PeerConnState, error) {
+	tag = strings.TrimSpace(tag)
+	if tag == "" || tag == protectTag {
+		return PeerConnState{}, fmt.Errorf("%w %q", ErrInvalidTag, tag)
+	}
+	info, err := s.resolveTarget(target)
+	if err != nil {
+		return PeerConnState{}, err
+	}
+	if weight == 0 {
+		s.node.ConnManager().UntagPeer(info.ID, tag)
+	} else {
+		s.node.ConnManager().TagPeer(info.ID, tag, weight)
+	}
+	return s.peerConnState(info.ID), nil
+}
+
+// GetNeighborDetails returns the connection manager state of every connected neighbor,
+// sorted by peer ID
+func (s *Libp2pNodeService) GetNeighborDetails() []PeerConnState {
+	peers := s.node.Network().Peers()
+	out := make([]PeerConnState, 0, len(peers))
+	for _, pid := range peers {
+		out = append(out, s.peerConnState(pid))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].PeerID < out[j].PeerID })
+	return out
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"syscall"
+)
+
+// exitPortInUse is the exit code when the node or REST port is taken, so supervisors can
+// tell it from other startup failures (exit 1)
+const exitPortInUse = 3
+
+// PortInUseError names the port another process already holds
+type PortInUseError struct {
+	Role  string // "node" (libp2p) or "REST"
+	Proto string // tcp or udp
+	Port  int
+	Err   error
+}
+
+func (e *PortInUseError) Error() string {
+	return fmt.Sprintf("%s port %d/%s already in use (%v); stop the other process or pick another port",
+		e.Role, e.Port, e.Proto, e.Err)
+}
+
+func (e *PortInUseError) Unwrap() error { return e.Err }
+
+// isAddrInUse reports whether err is a bind failure on an address that is already taken
+func isAddrInUse(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE)
+}
+
+// checkPorts makes sure the node port (per transport, on the bind address) and the REST port
+// are free before anything is started, instead of failing deep inside libp2p.New
+func (c *Config) checkPorts() error {
+	host := ""
+	if c.bindIP != nil {
+		host = c.bindIP.String()
+	}
+	nodeAddr := net.JoinHostPort(host, strconv.Itoa(c.NodePort))
+	if slices.Contains(c.Transports, "tcp") {
+		if err := probeTCP(nodeAddr); err != nil {
+			return portError("node", "tcp", c.NodePort, err)
+		}
+	}
+	if slices.Contains(c.Transports, "quic") || c.EnableWebRTC {
+		if err := probeUDP(nodeAddr); err != nil {
+			return portError("node", "udp", c.NodePort, err)
+		}
+	}
+	if err := probeTCP(":" + strconv.Itoa(c.Libp2pPort)); err != nil {
+		return portError("REST", "tcp", c.Libp2pPort, err)
+	}
+	return nil
+}
+
+// portError wraps a bind failure in a PortInUseError when the port is taken
+func portError(role, proto string, port int, err error) error {
+	if isAddrInUse(err) {
+		return &PortInUseError{Role: role, Proto: proto, Port: port, Err: err}
+	}
+	return fmt.Errorf("%s port %d/%s: %w", role, port, proto, err)
+}
+
+func probeTCP(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return ln.Close()
+}
+
+func probeUDP(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// randomPorts picks a node port free for both TCP and UDP and a free REST port, for
+// --random-port test nodes. Another process could still take them before the node binds;
+// checkPorts reports that as usual.
+func randomPorts() (nodePort, restPort int, err error) {
+	for i := 0; i < 20 && nodePort == 0; i++ {
+		port, err := freeTCPPort()
+		if err != nil {
+			return 0, 0, err
+		}
+		if probeUDP(":"+strconv.Itoa(port)) == nil {
+			nodePort = port
+		}
+	}
+	if nodePort == 0 {
+		return 0, 0, errors.New("no port free for both tcp and udp")
+	}
+	for restPort == 0 || restPort == nodePort {
+		if restPort, err = freeTCPPort(); err != nil {
+			return 0, 0, err
+		}
+	}
+	return nodePort, restPort, nil
+}
+
+func freeTCPPort() (int, error) {
+	ln, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return 0, err
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// writeSwarmKey writes a fresh private network key in the swarm.key format and returns its path
+func writeSwarmKey(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "swarm.key")
+	if err := os.WriteFile(path, []byte("/key/swarm/psk/1.0.0/\n/base16/\n"+hex.EncodeToString(key)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// privateNetwork is a test config mutator joining the private network of keyFile ("" for public)
+func privateNetwork(keyFile string) func(*Config) {
+	return func(c *Config) {
+		c.Transports = []string{"tcp"}
+		c.PrivateNetworkKeyFile = keyFile
+	}
+}
+
+func TestPrivateNetworkKeysIsolateNodes(t *testing.T) {
+	keyA, keyB := writeSwarmKey(t), writeSwarmKey(t)
+	for _, tc := range []struct {
+		name       string
+		keyA, keyB string
+		connect    bool
+	}{
+		{"same key", keyA, keyA, true},
+		{"different keys", keyA, keyB, false},
+		{"keyed and public", keyA, "", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a := newTestService(t, testConfig(t, "http://127.0.0.1:1", privateNetwork(tc.keyA)))
+			b := newTestService(t, testConfig(t, "http://127.0.0.1:1", privateNetwork(tc.keyB)))
+
+			// a key mismatch can stall the handshake instead of failing it: don't wait long for it
+			timeout := time.Second
+			if tc.connect {
+				timeout = 10 * time.Second
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			err := a.node.Connect(ctx, peer.AddrInfo{ID: b.node.ID(), Addrs: b.node.Addrs()})
+			if tc.connect && err != nil {
+				t.Fatalf("nodes sharing a key failed to connect: %v", err)
+			}
+			if !tc.connect {
+				if err == nil {
+					t.Fatal("nodes on different private networks connected")
+				}
+				sendCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+				if _, err := a.SendDirectMessage(sendCtx, b.did, []byte(`{"payload":1}`)); err == nil {
+					t.Error("direct send crossed private networks")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	msmux "github.com/multiformats/go-multistream"
+)
+
+// directProtocols are the supported direct-message protocol versions, highest first.
+// A handler is registered for each and senders negotiate the highest one both sides
+// speak, so old and new nodes interoperate during rolling upgrades.
+// "/test/0.0.1" is the original protocol name, kept for nodes that predate versioning.
+var directProtocols = []protocol.ID{
+	"/sight/direct/1.1.0",
+	"/sight/direct/1.0.0",
+	"/test/0.0.1",
+}
+
+// ErrProtocolUnsupported is returned when the remote peer doesn't speak the direct-message protocol
+var ErrProtocolUnsupported = errors.New("protocol unsupported by peer")
+
+// PeerProtocols lists the protocols a peer advertised via identify
+type PeerProtocols struct {
+	PeerID    string   `json:"peerId"`
+	Connected bool     `json:"connected"`
+	Protocols []string `json:"protocols"`
+}
+
+// GetPeerProtocols returns the protocols the peerstore knows for a peer
+// (populated by identify once connected)
+func (s *Libp2pNodeService) GetPeerProtocols(peerIdStr string) (PeerProtocols, error) {
+	pid, err := peer.Decode(peerIdStr)
+	if err != nil {
+		return PeerProtocols{}, fmt.Errorf("%w %q: %v", ErrInvalidTarget, peerIdStr, err)
+	}
+	protos, err := s.peerProtocols(pid)
+	if err != nil {
+		return PeerProtocols{}, err
+	}
+	return PeerProtocols{
+		PeerID:    pid.String(),
+		Connected: s.node.Network().Connectedness(pid) == network.Connected,
+		Protocols: protos,
+	}, nil
+}
+
+func (s *Libp2pNodeService) peerProtocols(pid peer.ID) ([]string, error) {
+	ids, err := s.node.Peerstore().GetProtocols(pid)
+	if err != nil {
+		return nil, err
+	}
+	protos := make([]string, 0, len(ids))
+	for _, id := range ids {
+		protos = append(protos, string(id))
+	}
+	sort.Strings(protos)
+	return protos, nil
+}
+
+// checkProtocolSupport fails fast with ErrProtocolUnsupported when identify already told us
+// the peer speaks none of protos. Unknown protocol lists (identify not done yet) pass.
+func (s *Libp2pNodeService) checkProtocolSupport(pid peer.ID, protos ...protocol.ID) error {
+	known, err := s.peerProtocols(pid)
+	if err != nil || len(known) == 0 {
+		return nil
+	}
+	supported, err := s.node.Peerstore().SupportsProtocols(pid, protos...)
+	if err == nil && len(supported) == 0 {
+		return fmt.Errorf("%w: %s supports none of %v (supports %v)", ErrProtocolUnsupported, pid, protos, known)
+	}
+	return nil
+}
+
+// wrapNegotiationError turns a multistream "not supported" failure into ErrProtocolUnsupported
+func (s *Libp2pNodeService) wrapNegotiationError(pid peer.ID, protos []protocol.ID, err error) error {
+	var notSupported msmux.ErrNotSupported[protocol.ID]
+	if !errors.As(err, &notSupported) {
+		return err
+	}
+	known, _ := s.peerProtocols(pid)
+	return fmt.Errorf("%w: %s supports none of %v (supports %v)", ErrProtocolUnsupported, pid, protos, known)
+}
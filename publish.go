@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+)
+
+// ErrInvalidTopic is returned for an empty topic name
+var ErrInvalidTopic = errors.New("invalid topic")
+
+// PublishRaw publishes data to topic as is, without the {"to", "payload"} envelope of
+// HandleOutgoingMessage, joining topic first if needed. Receivers only forward messages
+// addressed to them, so on the node's own topic a raw message is seen by subscribers
+// (e.g. observers) but not tunneled.
+// Bounded by ctx and Timeouts.Publish like HandleOutgoingMessage.
+func (s *Libp2pNodeService) PublishRaw(ctx context.Context, topic string, data []byte) error {
+	if err := s.checkSendable(); err != nil {
+		return err
+	}
+	if err := s.checkPayloadSize(data); err != nil {
+		return err
+	}
+	if err := s.checkPublishSize(data); err != nil {
+		return err
+	}
+	t, err := s.joinTopic(topic)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeouts.Publish.Std())
+	defer cancel()
+	if err := t.Publish(ctx, data); err != nil {
+		log.Printf("Error publishing raw message to %s: %v", topic, err)
+		return err
+	}
+	log.Printf("Published raw message to %s (%d bytes)", topic, len(data))
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleOutgoingMessageCancelled(t *testing.T) {
+	backendB := newTestBackend(t)
+	a := newTestService(t, testConfig(t, newTestBackend(t).URL))
+	b := newTestService(t, testConfig(t, backendB.URL))
+	connectServices(t, a, b)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := a.HandleOutgoingMessage(ctx, map[string]interface{}{"to": b.did, "payload": "cancelled"}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("publish with a cancelled context: %v, want context.Canceled", err)
+	}
+
+	// a later message arrives first and alone: the cancelled one was never published
+	live, stop := context.WithTimeout(context.Background(), 10*time.Second)
+	defer stop()
+	if _, err := a.HandleOutgoingMessage(live, map[string]interface{}{"to": b.did, "payload": "live"}); err != nil {
+		t.Fatalf("HandleOutgoingMessage: %v", err)
+	}
+	reqs := backendB.waitRequests(t, 1)
+	time.Sleep(200 * time.Millisecond)
+	if reqs = backendB.requests(); len(reqs) != 1 || !bytes.Contains(reqs[0].Body, []byte("live")) {
+		t.Errorf("backend got %d requests, first %s; want only the live message", len(reqs), reqs[0].Body)
+	}
+}
+
+func TestSendHandlerRequestDeadline(t *testing.T) {
+	s := newTestService(t, testConfig(t, "http://127.0.0.1:1"))
+	_, did := testPeer(t)
+
+	// the request context is already past its deadline when the handler publishes
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	r := httptest.NewRequest("POST", "/libp2p/send", strings.NewReader(`{"to": "`+did+`", "payload": 1}`)).WithContext(ctx)
+	w := httptest.NewRecorder()
+	NewLibp2pNodeController(s).SendHandler(w, r)
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want 504: %s", w.Code, w.Body)
+	}
+	if e := decodeAPIError(t, w); e.Code != "timeout" {
+		t.Errorf("code = %q, want timeout", e.Code)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// PeerScoreConfig holds the tunable knobs for GossipSub peer scoring.
+// Defaults are tuned for a single low-volume topic (sight-message): peers
+// earn a small positive score for staying in the mesh and delivering first,
+// and are heavily penalised for invalid messages and protocol misbehaviour.
+type PeerScoreConfig struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+
+	// Thresholds (see pubsub.PeerScoreThresholds)
+	GossipThreshold             float64 `yaml:"gossipThreshold" json:"gossipThreshold"`
+	PublishThreshold            float64 `yaml:"publishThreshold" json:"publishThreshold"`
+	GraylistThreshold           float64 `yaml:"graylistThreshold" json:"graylistThreshold"`
+	AcceptPXThreshold           float64 `yaml:"acceptPXThreshold" json:"acceptPXThreshold"`
+	OpportunisticGraftThreshold float64 `yaml:"opportunisticGraftThreshold" json:"opportunisticGraftThreshold"`
+
+	// Topic-level weights
+	TopicWeight                    float64 `yaml:"topicWeight" json:"topicWeight"`
+	TimeInMeshWeight               float64 `yaml:"timeInMeshWeight" json:"timeInMeshWeight"`
+	FirstMessageDeliveriesWeight   float64 `yaml:"firstMessageDeliveriesWeight" json:"firstMessageDeliveriesWeight"`
+	InvalidMessageDeliveriesWeight float64 `yaml:"invalidMessageDeliveriesWeight" json:"invalidMessageDeliveriesWeight"`
+
+	// Peer-level penalties
+	IPColocationFactorWeight    float64 `yaml:"ipColocationFactorWeight" json:"ipColocationFactorWeight"`
+	IPColocationFactorThreshold int     `yaml:"ipColocationFactorThreshold" json:"ipColocationFactorThreshold"`
+	BehaviourPenaltyWeight      float64 `yaml:"behaviourPenaltyWeight" json:"behaviourPenaltyWeight"`
+
+	// How often the current scores are snapshotted for the API
+	InspectInterval Duration `yaml:"inspectInterval" json:"inspectInterval"`
+}
+
+// DefaultPeerScoreConfig returns the default scoring configuration
+func DefaultPeerScoreConfig() PeerScoreConfig {
+	return PeerScoreConfig{
+		Enabled: true,
+
+		GossipThreshold:             -500,
+		PublishThreshold:            -1000,
+		GraylistThreshold:           -2500,
+		AcceptPXThreshold:           100,
+		OpportunisticGraftThreshold: 3.5,
+
+		TopicWeight:                    1,
+		TimeInMeshWeight:               0.0027, // ~1 point per hour in mesh
+		FirstMessageDeliveriesWeight:   1,
+		InvalidMessageDeliveriesWeight: -100,
+
+		// 本地会在同一台机器上起多个节点（bootstrap + client），阈值不能太低
+		IPColocationFactorWeight:    -10,
+		IPColocationFactorThreshold: 10,
+		BehaviourPenaltyWeight:      -10,
+
+		InspectInterval: Duration(10 * time.Second),
+	}
+}
+
+// PubSubOptions builds the GossipSub options for peer scoring on the given topic.
+// inspect is called periodically with the current per-peer scores.
+func (c PeerScoreConfig) PubSubOptions(topic string, inspect pubsub.PeerScoreInspectFn) []pubsub.Option {
+	if !c.Enabled {
+		return nil
+	}
+
+	params := &pubsub.PeerScoreParams{
+		Topics: map[string]*pubsub.TopicScoreParams{
+			topic: {
+				TopicWeight: c.TopicWeight,
+
+				TimeInMeshWeight:  c.TimeInMeshWeight,
+				TimeInMeshQuantum: time.Second,
+				TimeInMeshCap:     3600,
+
+				FirstMessageDeliveriesWeight: c.FirstMessageDeliveriesWeight,
+				FirstMessageDeliveriesDecay:  pubsub.ScoreParameterDecay(time.Hour),
+				FirstMessageDeliveriesCap:    100,
+
+				// 消息量很小，mesh delivery 惩罚会误伤正常节点，所以关闭
+				MeshMessageDeliveriesWeight: 0,
+				MeshFailurePenaltyWeight:    0,
+
+				InvalidMessageDeliveriesWeight: c.InvalidMessageDeliveriesWeight,
+				InvalidMessageDeliveriesDecay:  pubsub.ScoreParameterDecay(time.Hour),
+			},
+		},
+		TopicScoreCap: 100,
+
+		AppSpecificScore:  func(peer.ID) float64 { return 0 },
+		AppSpecificWeight: 1,
+
+		IPColocationFactorWeight:    c.IPColocationFactorWeight,
+		IPColocationFactorThreshold: c.IPColocationFactorThreshold,
+
+		BehaviourPenaltyWeight:    c.BehaviourPenaltyWeight,
+		BehaviourPenaltyThreshold: 6,
+		BehaviourPenaltyDecay:     pubsub.ScoreParameterDecay(10 * time.Minute),
+
+		DecayInterval: time.Second,
+		DecayToZero:   0.01,
+		RetainScore:   time.Hour,
+	}
+
+	thresholds := &pubsub.PeerScoreThresholds{
+		GossipThreshold:             c.GossipThreshold,
+		PublishThreshold:            c.PublishThreshold,
+		GraylistThreshold:           c.GraylistThreshold,
+		AcceptPXThreshold:           c.AcceptPXThreshold,
+		OpportunisticGraftThreshold: c.OpportunisticGraftThreshold,
+	}
+
+	return []pubsub.Option{
+		pubsub.WithPeerScore(params, thresholds),
+		pubsub.WithPeerScoreInspect(inspect, c.InspectInterval.Std()),
+	}
+}
+
+// peerScores keeps the latest score snapshot reported by GossipSub
+type peerScores struct {
+	mu        sync.RWMutex
+	scores    map[peer.ID]float64
+	updatedAt time.Time
+}
+
+func newPeerScores() *peerScores {
+	return &peerScores{scores: make(map[peer.ID]float64)}
+}
+
+// update is used as the pubsub.PeerScoreInspectFn
+func (p *peerScores) update(scores map[peer.ID]float64) {
+	snapshot := make(map[peer.ID]float64, len(scores))
+	for pid, score := range scores {
+		snapshot[pid] = score
+	}
+	p.mu.Lock()
+	p.scores = snapshot
+	p.updatedAt = time.Now()
+	p.mu.Unlock()
+}
+
+// Snapshot returns a copy of the latest scores keyed by peer ID string
+func (p *peerScores) Snapshot() (map[string]float64, time.Time) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	out := make(map[string]float64, len(p.scores))
+	for pid, score := range p.scores {
+		out[pid.String()] = score
+	}
+	return out, p.updatedAt
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+)
+
+// Readiness reports whether the node has enough peers to carry traffic
+type Readiness struct {
+	Ready            bool `json:"ready"`
+	Neighbors        int  `json:"neighbors"`
+	RoutingTableSize int  `json:"routingTableSize"`
+	Required         int  `json:"required"`
+	// Draining is set once POST /libp2p/drain was called; a draining node is never ready
+	Draining bool `json:"draining,omitempty"`
+}
+
+// readyPeers is the peer threshold for readiness: WaitForPeers, at least 1
+func (s *Libp2pNodeService) readyPeers() int {
+	return max(s.config.WaitForPeers, 1)
+}
+
+// peerReadiness checks n against both the connected neighbors and the DHT routing table,
+// so a node whose neighbors churn but whose routing table is populated still counts
+func (s *Libp2pNodeService) peerReadiness(n int) Readiness {
+	r := Readiness{
+		Neighbors:        len(s.node.Network().Peers()),
+		RoutingTableSize: s.dht.RoutingTable().Size(),
+		Required:         n,
+	}
+	r.Ready = r.Neighbors >= n || r.RoutingTableSize >= n
+	return r
+}
+
+// GetReadiness reports readiness against the configured threshold
+func (s *Libp2pNodeService) GetReadiness() Readiness {
+	r := s.peerReadiness(s.readyPeers())
+	if s.IsDraining() {
+		r.Draining = true
+		r.Ready = false
+	}
+	return r
+}
+
+// WaitForPeers blocks until n peers are connected (or in the DHT routing table) or ctx is done
+func (s *Libp2pNodeService) WaitForPeers(ctx context.Context, n int) error {
+	sub, err := s.node.EventBus().Subscribe(new(event.EvtPeerConnectednessChanged))
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+	// 路由表变化没有事件，定时再查一次
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for !s.peerReadiness(n).Ready {
+		select {
+		case <-sub.Out():
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// waitForPeersGate is the optional startup gate of InitNode (WaitForPeers > 0)
+func (s *Libp2pNodeService) waitForPeersGate(ctx context.Context) {
+	n := s.config.WaitForPeers
+	if n <= 0 {
+		return
+	}
+	timeout := s.config.Timeouts.WaitForPeers.Std()
+	log.Printf("Waiting up to %s for %d peers before serving", timeout, n)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	if err := s.WaitForPeers(ctx, n); err != nil {
+		r := s.peerReadiness(n)
+		log.Printf("WARNING: only %d neighbors / %d routing table peers after %s (want %d), starting anyway: %v",
+			r.Neighbors, r.RoutingTableSize, timeout, n, err)
+		return
+	}
+	log.Printf("Reached %d peers after %s", n, time.Since(start).Round(time.Millisecond))
+}
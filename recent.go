@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// RecentMessagesConfig controls the buffer of recently received messages (GET /libp2p/recent)
+type RecentMessagesConfig struct {
+	// Size is how many messages are kept (0 disables the buffer)
+	Size int `yaml:"size" json:"size"`
+	// IncludePayloads keeps message bodies (up to maxRecentPayloadBytes each); off by
+	// default so the endpoint doesn't expose message contents
+	IncludePayloads bool `yaml:"includePayloads" json:"includePayloads"`
+}
+
+// maxRecentPayloadBytes bounds a kept payload; larger bodies are listed without it
+const maxRecentPayloadBytes = 16 << 10
+
+// RecentMessage is a received message and what happened when it was forwarded
+type RecentMessage struct {
+	ReceivedAt  time.Time `json:"receivedAt"`
+	From        string    `json:"from"`
+	FromDID     string    `json:"fromDid,omitempty"`
+	To          string    `json:"to,omitempty"`
+	MessageID   string    `json:"messageId"`
+	Transport   string    `json:"transport"`
+	Topic       string    `json:"topic,omitempty"`
+	Protocol    string    `json:"protocol,omitempty"`
+	ContentType string    `json:"contentType,omitempty"`
+	Bytes       int       `json:"bytes"`
+	// Result is forwarded, error, skipped (tunnel disabled / dry run / observer) or dropped (forward queue full)
+	Result     string `json:"result"`
+	StatusCode int    `json:"statusCode,omitempty"` // tunnel response status
+	Error      string `json:"error,omitempty"`
+	// Payload is only set with includePayloads: the JSON body, or a base64 string for raw messages
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// recentMessages is a ring buffer of the last size received messages
+type recentMessages struct {
+	includePayloads bool
+	size            int // capacity of ring, read without mu
+
+	mu   sync.Mutex
+	ring []RecentMessage
+	next int // index overwritten next once the ring is full
+}
+
+func newRecentMessages(cfg RecentMessagesConfig) *recentMessages {
+	return &recentMessages{includePayloads: cfg.IncludePayloads, size: cfg.Size, ring: make([]RecentMessage, 0, cfg.Size)}
+}
+
+// record adds a message with the outcome of its forward (resp/err from the tunnel)
+func (r *recentMessages) record(meta TunnelMeta, body []byte, resp *TunnelResponse, err error) {
+	if r.size == 0 {
+		return
+	}
+	m := RecentMessage{
+		ReceivedAt:  meta.ReceivedAt,
+		From:        meta.From.String(),
+		FromDID:     meta.FromDID,
+		To:          meta.To,
+		MessageID:   meta.MessageID,
+		Transport:   meta.Transport,
+		Topic:       meta.Topic,
+		Protocol:    meta.Protocol,
+		ContentType: meta.ContentType,
+		Bytes:       len(body),
+		Result:      "forwarded",
+	}
+	switch {
+	case errors.Is(err, errTunnelSkipped):
+		m.Result = "skipped"
+	case errors.Is(err, errForwardDropped):
+		m.Result = "dropped"
+	case err != nil:
+		m.Result = "error"
+		m.Error = err.Error()
+	}
+	if resp != nil {
+		m.StatusCode = resp.StatusCode
+	}
+	if r.includePayloads && len(body) <= maxRecentPayloadBytes {
+		if isJSONMediaType(meta.contentType()) && json.Valid(body) {
+			m.Payload = append(json.RawMessage(nil), body...)
+		} else {
+			m.Payload, _ = json.Marshal(body) // base64
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.ring) < cap(r.ring) {
+		r.ring = append(r.ring, m)
+		return
+	}
+	r.ring[r.next] = m
+	r.next = (r.next + 1) % len(r.ring)
+}
+
+// List returns the buffered messages, newest first
+func (r *recentMessages) List() []RecentMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]RecentMessage, 0, len(r.ring))
+	for i := 0; i < len(r.ring); i++ {
+		idx := (r.next - 1 - i + 2*len(r.ring)) % len(r.ring)
+		out = append(out, r.ring[idx])
+	}
+	return out
+}
+
+// GetRecentMessages returns the recently received messages, newest first
+func (s *Libp2pNodeService) GetRecentMessages() []RecentMessage {
+	return s.recent.List()
+}
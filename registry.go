@@ -0,0 +1,201 @@
+package main
+
+import (
+	"log"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxRegistryAddrs bounds the addresses kept per registry entry (most recent last)
+const maxRegistryAddrs = 4
+
+// RegistryEntry is a DID↔peerID mapping learned from a connection
+type RegistryEntry struct {
+	DID       string    `json:"did"`
+	PeerID    string    `json:"peerId"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Connected bool      `json:"connected"`
+	// Addrs are the last dialable addresses (from outbound connections), kept across restarts
+	Addrs []string `json:"addrs,omitempty"`
+}
+
+// peerRegistry records the DIDs of peers that have connected to us. Disconnected peers are
+// forgotten after ttl, and at most maxEntries are kept (connected peers are never evicted;
+// the connection manager bounds those).
+type peerRegistry struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*RegistryEntry // by DID
+}
+
+func newPeerRegistry(cfg RegistryConfig) *peerRegistry {
+	return &peerRegistry{ttl: cfg.TTL.Std(), maxEntries: cfg.MaxEntries, entries: make(map[string]*RegistryEntry)}
+}
+
+// prune drops disconnected entries older than ttl, then the least recently seen disconnected
+// ones while over maxEntries. Caller holds r.mu.
+func (r *peerRegistry) prune(now time.Time) {
+	for did, e := range r.entries {
+		if !e.Connected && now.Sub(e.LastSeen) > r.ttl {
+			delete(r.entries, did)
+		}
+	}
+	for len(r.entries) > r.maxEntries {
+		var oldest *RegistryEntry
+		for _, e := range r.entries {
+			if !e.Connected && (oldest == nil || e.LastSeen.Before(oldest.LastSeen)) {
+				oldest = e
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		delete(r.entries, oldest.DID)
+	}
+}
+
+// markConnected records a connection; addr is the remote address of an outbound
+// connection (nil for inbound ones, whose port isn't dialable)
+func (r *peerRegistry) markConnected(did string, pid peer.ID, addr ma.Multiaddr) {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.entries[did]
+	if !ok {
+		e = &RegistryEntry{DID: did, FirstSeen: now}
+		r.entries[did] = e
+	}
+	e.PeerID = pid.String()
+	e.LastSeen = now
+	e.Connected = true
+	if !ok {
+		r.prune(now)
+	}
+	if addr != nil && !slices.Contains(e.Addrs, addr.String()) {
+		e.Addrs = append(e.Addrs, addr.String())
+		if len(e.Addrs) > maxRegistryAddrs {
+			e.Addrs = e.Addrs[len(e.Addrs)-maxRegistryAddrs:]
+		}
+	}
+}
+
+func (r *peerRegistry) markDisconnected(did string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok := r.entries[did]; ok {
+		e.Connected = false
+		e.LastSeen = time.Now()
+	}
+}
+
+// rename moves the entry recorded under oldDID to did, merging it into an existing entry
+// (e.g. one loaded from disk); used once identify shows a peer is a gateway
+func (r *peerRegistry) rename(oldDID, did string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old, ok := r.entries[oldDID]
+	if !ok || oldDID == did {
+		return
+	}
+	delete(r.entries, oldDID)
+	e, ok := r.entries[did]
+	if !ok {
+		old.DID = did
+		r.entries[did] = old
+		return
+	}
+	e.PeerID = old.PeerID
+	if old.FirstSeen.Before(e.FirstSeen) {
+		e.FirstSeen = old.FirstSeen
+	}
+	if old.LastSeen.After(e.LastSeen) {
+		e.LastSeen = old.LastSeen
+	}
+	e.Connected = old.Connected
+	for _, addr := range old.Addrs {
+		if !slices.Contains(e.Addrs, addr) {
+			e.Addrs = append(e.Addrs, addr)
+		}
+	}
+	if len(e.Addrs) > maxRegistryAddrs {
+		e.Addrs = e.Addrs[len(e.Addrs)-maxRegistryAddrs:]
+	}
+}
+
+// List returns all entries sorted by DID
+func (r *peerRegistry) List() []RegistryEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prune(time.Now())
+	out := make([]RegistryEntry, 0, len(r.entries))
+	for _, e := range r.entries {
+		entry := *e
+		entry.Addrs = slices.Clone(e.Addrs)
+		out = append(out, entry)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].DID < out[j].DID })
+	return out
+}
+
+// connNotifiee learns the DID of every peer that connects (when its peer ID embeds an
+// ed25519 key) and records it in the registry and the resolve cache, so later direct
+// sends to that DID skip the key derivation and DHT lookup. The role comes from identify,
+// which usually finishes after the connect; watchPeerRoles then fixes a gateway's entry.
+func (s *Libp2pNodeService) connNotifiee() network.Notifiee {
+	return &network.NotifyBundle{
+		ConnectedF: func(n network.Network, c network.Conn) {
+			pid := c.RemotePeer()
+			did, err := s.peerDID(pid)
+			if err != nil {
+				return
+			}
+			s.resolve.putPeerID(did, pid)
+			// inbound conns come from an ephemeral port, only outbound addrs are dialable
+			var addr ma.Multiaddr
+			if c.Stat().Direction == network.DirOutbound {
+				addr = c.RemoteMultiaddr()
+				s.resolve.putAddrs(pid, []ma.Multiaddr{addr})
+			}
+			s.registry.markConnected(did, pid, addr)
+		},
+		DisconnectedF: func(n network.Network, c network.Conn) {
+			pid := c.RemotePeer()
+			if n.Connectedness(pid) == network.Connected {
+				return // still connected over another conn
+			}
+			s.latency.forget(pid)
+			if did, err := s.peerDID(pid); err == nil {
+				s.registry.markDisconnected(did)
+			}
+		},
+	}
+}
+
+// watchConnections registers connNotifiee, connLogNotifiee and stickyNotifiee and replays the connections
+// that already exist (e.g. bootstrap peers dialed while the node was created)
+func (s *Libp2pNodeService) watchConnections() {
+	for _, notifiee := range []network.Notifiee{s.connNotifiee(), s.connLogNotifiee(), s.stickyNotifiee(), s.upgradeNotifiee()} {
+		s.node.Network().Notify(notifiee)
+		for _, c := range s.node.Network().Conns() {
+			notifiee.Connected(s.node.Network(), c)
+		}
+	}
+	if err := prometheus.Register(openStreamsCollector{network: s.node.Network()}); err != nil {
+		log.Printf("Failed to register open streams metric: %v", err)
+	}
+}
+
+// GetRegistry returns the DID↔peerID mappings learned from connections
+func (s *Libp2pNodeService) GetRegistry() []RegistryEntry {
+	return s.registry.List()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// RegistryConfig controls persisting the DID↔peerID registry across restarts
+type RegistryConfig struct {
+	// Persist saves the registry in the data dir and reloads it at startup
+	Persist bool `yaml:"persist" json:"persist"`
+	// TTL drops entries of disconnected peers not seen for this long, in memory and on load
+	TTL Duration `yaml:"ttl" json:"ttl"`
+	// MaxEntries bounds the registry; past it the least recently seen disconnected peers go first
+	MaxEntries int `yaml:"maxEntries" json:"maxEntries"`
+	// SaveInterval is how often the registry is written (it is also written on shutdown)
+	SaveInterval Duration `yaml:"saveInterval" json:"saveInterval"`
+}
+
+func (c RegistryConfig) Validate() error {
+	if c.TTL <= 0 {
+		return fmt.Errorf("invalid registry.ttl: %s", c.TTL.Std())
+	}
+	if c.SaveInterval <= 0 {
+		return fmt.Errorf("invalid registry.saveInterval: %s", c.SaveInterval.Std())
+	}
+	if c.MaxEntries <= 0 {
+		return fmt.Errorf("invalid registry.maxEntries: %d", c.MaxEntries)
+	}
+	return nil
+}
+
+// RegistryFile is the registry file name in the data dir, next to the keypair (with the
+// same InstanceID suffix)
+func (c *Config) RegistryFile() string {
+	if c.IsGateway {
+		return c.instanceFile("gateway-peer-registry")
+	}
+	return c.instanceFile("peer-registry")
+}
+
+func (s *Libp2pNodeService) registryPath() string {
+	return filepath.Join(s.config.DataDir, s.config.RegistryFile())
+}
+
+// snapshot returns the entries for saving; connection state isn't meaningful after a restart
+func (r *peerRegistry) snapshot() []RegistryEntry {
+	entries := r.List()
+	for i := range entries {
+		entries[i].Connected = false
+	}
+	return entries
+}
+
+// restore adds loaded entries that aren't known yet (a live connection wins)
+func (r *peerRegistry) restore(e RegistryEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.entries[e.DID]; !ok {
+		e.Connected = false
+		r.entries[e.DID] = &e
+		r.prune(time.Now())
+	}
+}
+
+// loadRegistry reloads the saved registry, skipping entries older than the TTL, and seeds
+// the resolve cache and the peerstore so the first message to a known peer skips the DHT
+func (s *Libp2pNodeService) loadRegistry() {
+	path := s.registryPath()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Printf("[Registry] Failed to read %s: %v", path, err)
+		return
+	}
+	var entries []RegistryEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		log.Printf("[Registry] Ignoring invalid %s: %v", path, err)
+		return
+	}
+
+	cutoff := time.Now().Add(-s.config.Registry.TTL.Std())
+	loaded, expired := 0, 0
+	for _, e := range entries {
+		if e.LastSeen.Before(cutoff) {
+			expired++
+			continue
+		}
+		pid, err := peer.Decode(e.PeerID)
+		if err != nil {
+			continue
+		}
+		var addrs []ma.Multiaddr
+		for _, a := range e.Addrs {
+			if addr, err := ma.NewMultiaddr(a); err == nil {
+				addrs = append(addrs, addr)
+			}
+		}
+		s.registry.restore(e)
+		s.resolve.putPeerID(e.DID, pid)
+		if len(addrs) > 0 {
+			s.resolve.putAddrs(pid, addrs)
+			s.node.Peerstore().AddAddrs(pid, addrs, peerstore.AddressTTL)
+		}
+		loaded++
+	}
+	log.Printf("[Registry] Loaded %d peers from %s (%d expired)", loaded, path, expired)
+}
+
+// saveRegistry writes the registry atomically (temp file + rename)
+func (s *Libp2pNodeService) saveRegistry() error {
+	data, err := json.MarshalIndent(s.registry.snapshot(), "", "  ")
+	if err != nil {
+		return err
+	}
+	path := s.registryPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// persistRegistry saves the registry every SaveInterval until ctx is cancelled
+func (s *Libp2pNodeService) persistRegistry(ctx context.Context) {
+	ticker := time.NewTicker(s.config.Registry.SaveInterval.Std())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.saveRegistry(); err != nil {
+				log.Printf("[Registry] Failed to save: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func testRegistry(ttl time.Duration, maxEntries int) *peerRegistry {
+	return newPeerRegistry(RegistryConfig{TTL: Duration(ttl), SaveInterval: Duration(time.Minute), MaxEntries: maxEntries})
+}
+
+func testPeerID(t *testing.T) peer.ID {
+	pid, _ := testPeer(t)
+	return pid
+}
+
+func registryDIDs(r *peerRegistry) []string {
+	var dids []string
+	for _, e := range r.List() {
+		dids = append(dids, e.DID)
+	}
+	return dids
+}
+
+func TestRegistryEvictsDisconnectedAfterTTL(t *testing.T) {
+	r := testRegistry(time.Hour, 100)
+	r.markConnected("did:a", testPeerID(t), nil)
+	r.markConnected("did:b", testPeerID(t), nil)
+	r.markDisconnected("did:a")
+	r.markConnected("did:c", testPeerID(t), nil)
+	r.markDisconnected("did:c")
+
+	// a disconnected long ago, c recently; b is still connected but idle as long
+	r.mu.Lock()
+	r.entries["did:a"].LastSeen = time.Now().Add(-2 * time.Hour)
+	r.entries["did:b"].LastSeen = time.Now().Add(-2 * time.Hour)
+	r.mu.Unlock()
+
+	if got := fmt.Sprint(registryDIDs(r)); got != "[did:b did:c]" {
+		t.Fatalf("entries = %s, want [did:b did:c]", got)
+	}
+}
+
+func TestRegistryCapEvictsOldestDisconnected(t *testing.T) {
+	r := testRegistry(time.Hour, 3)
+	for i, did := range []string{"did:a", "did:b", "did:c"} {
+		r.markConnected(did, testPeerID(t), nil)
+		r.markDisconnected(did)
+		r.mu.Lock()
+		r.entries[did].LastSeen = time.Now().Add(-time.Duration(3-i) * time.Minute)
+		r.mu.Unlock()
+	}
+	r.markConnected("did:d", testPeerID(t), nil)
+	if got := fmt.Sprint(registryDIDs(r)); got != "[did:b did:c did:d]" {
+		t.Fatalf("entries = %s, want the oldest (did:a) evicted", got)
+	}
+
+	// restored entries count against the cap too
+	r.restore(RegistryEntry{DID: "did:e", LastSeen: time.Now()})
+	if got := fmt.Sprint(registryDIDs(r)); got != "[did:c did:d did:e]" {
+		t.Fatalf("entries = %s, want did:b evicted", got)
+	}
+}
+
+func TestRegistryNeverEvictsConnected(t *testing.T) {
+	r := testRegistry(time.Hour, 2)
+	for _, did := range []string{"did:a", "did:b", "did:c"} {
+		r.markConnected(did, testPeerID(t), nil)
+	}
+	if got := fmt.Sprint(registryDIDs(r)); got != "[did:a did:b did:c]" {
+		t.Fatalf("entries = %s, want all connected peers kept", got)
+	}
+	r.markDisconnected("did:b")
+	if got := fmt.Sprint(registryDIDs(r)); got != "[did:a did:c]" {
+		t.Fatalf("entries = %s, want did:b evicted once disconnected", got)
+	}
+}
+
+func TestRegistryConfigRejectsNonPositiveMaxEntries(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Registry.MaxEntries = 0
+	if err := cfg.Registry.Validate(); err == nil {
+		t.Fatal("maxEntries 0 accepted")
+	}
+}
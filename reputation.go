@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ReputationConfig controls the peer reputation store: violations lower a peer's score and
+// at Threshold the peer is blocked (no dials, no inbound connections) for BlockTTL
+type ReputationConfig struct {
+	// Threshold is the (negative) score at or below which a peer gets blocked
+	Threshold int `yaml:"threshold" json:"threshold"`
+	// BlockTTL is how long a threshold block lasts; violations older than this are forgotten
+	BlockTTL Duration `yaml:"blockTtl" json:"blockTtl"`
+	// Persist saves scores and blocks in the data dir so they survive restarts
+	Persist bool `yaml:"persist" json:"persist"`
+}
+
+func DefaultReputationConfig() ReputationConfig {
+	return ReputationConfig{Threshold: -100, BlockTTL: Duration(10 * time.Minute)}
+}
+
+func (c ReputationConfig) Validate() error {
+	if c.Threshold >= 0 {
+		return fmt.Errorf("invalid reputation.threshold: %d (must be negative)", c.Threshold)
+	}
+	if c.BlockTTL <= 0 {
+		return fmt.Errorf("invalid reputation.blockTtl: %s", c.BlockTTL.Std())
+	}
+	return nil
+}
+
+// Violations reported by the message handlers, with the score they cost
+const (
+	violationInvalidMessage = "invalid_message" // pubsub data that is not JSON, undecodable direct message
+	violationOversized      = "oversized"       // direct message above maxPayloadBytes
+	violationForgedAck      = "forged_ack"      // ack for a message sent to another peer
+)
+
+var violationPenalties = map[string]int{
+	violationInvalidMessage: 10,
+	violationOversized:      25,
+	violationForgedAck:      25,
+}
+
+// defaultViolationPenalty applies to reasons missing from violationPenalties
+const defaultViolationPenalty = 10
+
+// ErrNotBlocked is returned when unblocking a peer that isn't blocked
+var ErrNotBlocked = errors.New("peer is not blocked")
+
+// PeerReputation is the reputation of one peer (GET /libp2p/reputation)
+type PeerReputation struct {
+	PeerID        string         `json:"peerId"`
+	DID           string         `json:"did,omitempty"`
+	Score         int            `json:"score"`
+	Violations    map[string]int `json:"violations,omitempty"`
+	LastViolation *time.Time     `json:"lastViolation,omitempty"`
+	BlockedUntil  *time.Time     `json:"blockedUntil,omitempty"`
+	// Manual marks a block set with POST /libp2p/block
+	Manual bool `json:"manual,omitempty"`
+}
+
+func (p *PeerReputation) blocked(now time.Time) bool {
+	return p.BlockedUntil != nil && now.Before(*p.BlockedUntil)
+}
+
+// reputationStore keeps peer scores and blocks; it is the host's ConnectionGater
+type reputationStore struct {
+	cfg ReputationConfig
+
+	mu    sync.Mutex
+	peers map[peer.ID]*PeerReputation
+}
+
+func newReputationStore(cfg ReputationConfig) *reputationStore {
+	return &reputationStore{cfg: cfg, peers: make(map[peer.ID]*PeerReputation)}
+}
+
+// entry returns the live entry of pid, dropping expired state; the caller holds mu
+func (r *reputationStore) entry(pid peer.ID, now time.Time, create bool) *PeerReputation {
+	e, ok := r.peers[pid]
+	if ok && !e.blocked(now) && (e.LastViolation == nil || now.Sub(*e.LastViolation) > r.cfg.BlockTTL.Std()) {
+		// 封禁到期或长时间无违规：清零
+		delete(r.peers, pid)
+		ok = false
+	}
+	if !ok && create {
+		e = &PeerReputation{PeerID: pid.String(), Violations: map[string]int{}}
+		if did, err := PeerIdToDID(pid.String()); err == nil {
+			e.DID = did
+		}
+		r.peers[pid] = e
+	}
+	if !ok && !create {
+		return nil
+	}
+	return e
+}
+
+// violation lowers pid's score; it returns true if this pushed the peer over the threshold
+func (r *reputationStore) violation(pid peer.ID, reason string) bool {
+	penalty, ok := violationPenalties[reason]
+	if !ok {
+		penalty = defaultViolationPenalty
+	}
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := r.entry(pid, now, true)
+	e.Score -= penalty
+	e.Violations[reason]++
+	e.LastViolation = &now
+	if e.Score > r.cfg.Threshold || e.blocked(now) {
+		return false
+	}
+	until := now.Add(r.cfg.BlockTTL.Std())
+	e.BlockedUntil = &until
+	return true
+}
+
+// block blocks pid for ttl regardless of its score
+func (r *reputationStore) block(pid peer.ID, ttl time.Duration) PeerReputation {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := r.entry(pid, now, true)
+	until := now.Add(ttl)
+	e.BlockedUntil = &until
+	e.Manual = true
+	return *e
+}
+
+// unblock lifts the block of pid and resets its score
+func (r *reputationStore) unblock(pid peer.ID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := r.entry(pid, time.Now(), false)
+	if e == nil || !e.blocked(time.Now()) {
+		return ErrNotBlocked
+	}
+	delete(r.peers, pid)
+	return nil
+}
+
+func (r *reputationStore) isBlocked(pid peer.ID) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := r.entry(pid, time.Now(), false)
+	return e != nil && e.blocked(time.Now())
+}
+
+// List returns the peers with a score or block, lowest score first
+func (r *reputationStore) List() []PeerReputation {
+	now := time.Now()
+	r.mu.Lock()
+	out := make([]PeerReputation, 0, len(r.peers))
+	for pid := range r.peers {
+		if e := r.entry(pid, now, false); e != nil {
+			out = append(out, *e)
+		}
+	}
+	r.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score < out[j].Score
+		}
+		return out[i].PeerID < out[j].PeerID
+	})
+	return out
+}
+
+// ConnectionGater: blocked peers are neither dialed nor accepted once their identity is
+// known (after the security handshake)
+
+func (r *reputationStore) InterceptPeerDial(p peer.ID) bool { return !r.isBlocked(p) }
+
+func (r *reputationStore) InterceptAddrDial(p peer.ID, _ ma.Multiaddr) bool { return !r.isBlocked(p) }
+
+func (r *reputationStore) InterceptAccept(network.ConnMultiaddrs) bool { return true }
+
+func (r *reputationStore) InterceptSecured(_ network.Direction, p peer.ID, _ network.ConnMultiaddrs) bool {
+	return !r.isBlocked(p)
+}
+
+func (r *reputationStore) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
+
+// ReportViolation lowers a peer's reputation; past reputation.threshold the peer is blocked
+// for reputation.blockTtl and disconnected
+func (s *Libp2pNodeService) ReportViolation(pid peer.ID, reason string) {
+	if !s.reputation.violation(pid, reason) {
+		return
+	}
+	log.Printf("[Reputation] Blocked %s for %s: score at threshold %d (last violation: %s)",
+		pid, s.config.Reputation.BlockTTL.Std(), s.config.Reputation.Threshold, reason)
+	s.node.Network().ClosePeer(pid)
+	s.saveReputationIfPersisted()
+}
+
+// BlockPeer blocks a peer (DID or peer ID) for ttl and closes its connections
+func (s *Libp2pNodeService) BlockPeer(target string, ttl time.Duration) (PeerReputation, error) {
+	info, err := s.resolveTarget(target)
+	if err != nil {
+		return PeerReputation{}, err
+	}
+	rep := s.reputation.block(info.ID, ttl)
+	s.node.Network().ClosePeer(info.ID)
+	log.Printf("[Reputation] Blocked %s for %s (manual)", info.ID, ttl)
+	s.saveReputationIfPersisted()
+	return rep, nil
+}
+
+// UnblockPeer lifts a block and resets the peer's score
+func (s *Libp2pNodeService) UnblockPeer(target string) error {
+	info, err := s.resolveTarget(target)
+	if err != nil {
+		return err
+	}
+	if err := s.reputation.unblock(info.ID); err != nil {
+		return err
+	}
+	log.Printf("[Reputation] Unblocked %s", info.ID)
+	s.saveReputationIfPersisted()
+	return nil
+}
+
+// GetReputation lists the peers with a lowered score or a block
+func (s *Libp2pNodeService) GetReputation() []PeerReputation {
+	return s.reputation.List()
+}
+
+func (s *Libp2pNodeService) reputationPath() string {
+	return filepath.Join(s.config.DataDir, s.config.instanceFile("peer-reputation"))
+}
+
+// loadReputation restores the saved scores and blocks, skipping expired ones
+func (s *Libp2pNodeService) loadReputation() {
+	path := s.reputationPath()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Printf("[Reputation] Failed to read %s: %v", path, err)
+		return
+	}
+	var entries []PeerReputation
+	if err := json.Unmarshal(data, &entries); err != nil {
+		log.Printf("[Reputation] Ignoring invalid %s: %v", path, err)
+		return
+	}
+	now := time.Now()
+	s.reputation.mu.Lock()
+	for i := range entries {
+		pid, err := peer.Decode(entries[i].PeerID)
+		if err != nil {
+			continue
+		}
+		if entries[i].Violations == nil {
+			entries[i].Violations = map[string]int{}
+		}
+		s.reputation.peers[pid] = &entries[i]
+		s.reputation.entry(pid, now, false) // drops it if expired
+	}
+	loaded := len(s.reputation.peers)
+	s.reputation.mu.Unlock()
+	log.Printf("[Reputation] Loaded %d peers from %s", loaded, path)
+}
+
+func (s *Libp2pNodeService) saveReputationIfPersisted() {
+	if !s.config.Reputation.Persist {
+		return
+	}
+	if err := s.saveReputation(); err != nil {
+		log.Printf("[Reputation] Failed to save: %v", err)
+	}
+}
+
+// saveReputation writes the store atomically (temp file + rename)
+func (s *Libp2pNodeService) saveReputation() error {
+	data, err := json.MarshalIndent(s.reputation.List(), "", "  ")
+	if err != nil {
+		return err
+	}
+	path := s.reputationPath()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
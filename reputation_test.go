@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestReputationBlocksAtThreshold(t *testing.T) {
+	r := newReputationStore(ReputationConfig{Threshold: -50, BlockTTL: Duration(time.Hour)})
+	pid, _ := testPeer(t)
+	other, _ := testPeer(t)
+
+	// oversized costs 25: the second one reaches -50
+	if r.violation(pid, violationOversized) {
+		t.Fatal("blocked above the threshold")
+	}
+	if r.isBlocked(pid) || !r.InterceptPeerDial(pid) {
+		t.Fatal("peer at -25 is gated")
+	}
+	if !r.violation(pid, violationOversized) {
+		t.Fatal("reaching the threshold didn't block")
+	}
+	if !r.isBlocked(pid) || r.InterceptPeerDial(pid) || r.InterceptAddrDial(pid, nil) || r.InterceptSecured(network.DirInbound, pid, nil) {
+		t.Error("blocked peer still passes the connection gater")
+	}
+	// further violations don't report a new block
+	if r.violation(pid, violationInvalidMessage) {
+		t.Error("violation of a blocked peer reported a new block")
+	}
+	if r.isBlocked(other) || !r.InterceptSecured(network.DirInbound, other, nil) {
+		t.Error("block leaked to another peer")
+	}
+
+	list := r.List()
+	if len(list) != 1 || list[0].Score != -60 || list[0].Violations[violationOversized] != 2 || list[0].BlockedUntil == nil || list[0].Manual {
+		t.Errorf("reputation = %+v", list)
+	}
+}
+
+func TestReputationBlockExpires(t *testing.T) {
+	const ttl = 100 * time.Millisecond
+	r := newReputationStore(ReputationConfig{Threshold: -25, BlockTTL: Duration(ttl)})
+	pid, _ := testPeer(t)
+
+	if !r.violation(pid, violationOversized) {
+		t.Fatal("reaching the threshold didn't block")
+	}
+	time.Sleep(ttl + 50*time.Millisecond)
+	if r.isBlocked(pid) || !r.InterceptPeerDial(pid) {
+		t.Fatal("block outlived reputation.blockTtl")
+	}
+	if list := r.List(); len(list) != 0 {
+		t.Errorf("expired block still listed: %+v", list)
+	}
+	// the score was reset with the block: the next violation starts from zero
+	r.violation(pid, violationInvalidMessage)
+	if list := r.List(); len(list) != 1 || list[0].Score != -10 || list[0].BlockedUntil != nil {
+		t.Errorf("after expiry = %+v, want a fresh -10 score", list)
+	}
+}
+
+func TestReputationManualBlock(t *testing.T) {
+	r := newReputationStore(DefaultReputationConfig())
+	pid, _ := testPeer(t)
+
+	if err := r.unblock(pid); !errors.Is(err, ErrNotBlocked) {
+		t.Errorf("unblock of an unblocked peer: %v, want ErrNotBlocked", err)
+	}
+	if rep := r.block(pid, time.Hour); !rep.Manual || rep.Score != 0 {
+		t.Errorf("manual block = %+v", rep)
+	}
+	if !r.isBlocked(pid) {
+		t.Fatal("manual block not applied")
+	}
+	if err := r.unblock(pid); err != nil {
+		t.Fatalf("unblock: %v", err)
+	}
+	if r.isBlocked(pid) || len(r.List()) != 0 {
+		t.Error("unblock left the peer blocked or listed")
+	}
+}
+
+func TestBlockedPeerCannotConnect(t *testing.T) {
+	a := newTestService(t, testConfig(t, "http://127.0.0.1:1"))
+	b := newTestService(t, testConfig(t, "http://127.0.0.1:1", func(c *Config) {
+		c.Reputation = ReputationConfig{Threshold: -25, BlockTTL: Duration(time.Second)}
+	}))
+	connectServices(t, a, b)
+
+	b.ReportViolation(a.node.ID(), violationOversized)
+	waitFor(t, 5*time.Second, func() bool { return b.node.Network().Connectedness(a.node.ID()) != network.Connected }, "blocked peer disconnected")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := a.node.Connect(ctx, peer.AddrInfo{ID: b.node.ID(), Addrs: b.node.Addrs()}); err == nil {
+		// the dial can complete on a's side before b's gater closes it
+		waitFor(t, 5*time.Second, func() bool { return a.node.Network().Connectedness(b.node.ID()) != network.Connected }, "gated connection closed")
+	}
+	if err := b.node.Connect(ctx, peer.AddrInfo{ID: a.node.ID(), Addrs: a.node.Addrs()}); err == nil {
+		t.Error("blocked peer dialed")
+	}
+
+	// once the block expires the peers can connect again
+	time.Sleep(time.Second)
+	if err := a.node.Connect(ctx, peer.AddrInfo{ID: b.node.ID(), Addrs: b.node.Addrs()}); err != nil {
+		t.Fatalf("connect after the block expired: %v", err)
+	}
+}
+
+func TestRawPublishOfNonObjectJSONIsNotAViolation(t *testing.T) {
+	a := newTestService(t, testConfig(t, "http://127.0.0.1:1"))
+	b := newTestService(t, testConfig(t, "http://127.0.0.1:1"))
+	connectServices(t, a, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, body := range []string{`[1,2]`, `"hello"`, `42`, `null`} {
+		if err := a.PublishRaw(ctx, a.config.Topic, []byte(body)); err != nil {
+			t.Fatalf("publish %s: %v", body, err)
+		}
+	}
+	// bytes that aren't JSON at all still cost the publisher; published last, so once it
+	// is counted the valid messages before it were handled too
+	if err := a.getTopic().Publish(ctx, []byte("{not json")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, func() bool { return len(b.GetReputation()) > 0 }, "invalid message reported")
+	list := b.GetReputation()
+	if len(list) != 1 || list[0].PeerID != a.node.ID().String() || list[0].Score != -10 || list[0].Violations[violationInvalidMessage] != 1 {
+		t.Errorf("reputation = %+v, want a single invalid_message violation", list)
+	}
+}
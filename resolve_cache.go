@@ -0,0 +1,149 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ResolveCacheConfig bounds the DID→peerID and peerID→addrs caches
+type ResolveCacheConfig struct {
+	// Size is the maximum number of entries in each cache
+	Size int `yaml:"size" json:"size"`
+	// TTL is how long a cached entry stays valid
+	TTL Duration `yaml:"ttl" json:"ttl"`
+	// MaxDialFailures drops cached addrs after this many consecutive failed dials
+	MaxDialFailures int `yaml:"maxDialFailures" json:"maxDialFailures"`
+}
+
+type cachedPeerID struct {
+	pid     peer.ID
+	expires time.Time
+}
+
+type cachedAddrs struct {
+	addrs    []ma.Multiaddr
+	expires  time.Time
+	failures int
+}
+
+// resolveCache caches DID→peerID derivation and DHT FindPeer results so hot paths
+// (direct send / ping to the same peers) skip the key decoding and the DHT lookup.
+type resolveCache struct {
+	cfg ResolveCacheConfig
+
+	mu    sync.Mutex
+	pids  map[string]cachedPeerID
+	addrs map[peer.ID]cachedAddrs
+}
+
+func newResolveCache(cfg ResolveCacheConfig) *resolveCache {
+	return &resolveCache{
+		cfg:   cfg,
+		pids:  make(map[string]cachedPeerID),
+		addrs: make(map[peer.ID]cachedAddrs),
+	}
+}
+
+// peerID returns the peer ID for a DID, deriving and caching it on a miss
+func (c *resolveCache) peerID(did string) (peer.ID, error) {
+	now := time.Now()
+	c.mu.Lock()
+	e, ok := c.pids[did]
+	c.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.pid, nil
+	}
+
+	pub, err := DIDToPublicKey(did)
+	if err != nil {
+		return "", err
+	}
+	pid, err := PublicKeyToPeerId(pub)
+	if err != nil {
+		return "", err
+	}
+
+	c.putPeerID(did, pid)
+	return pid, nil
+}
+
+// putPeerID records a known DID→peerID mapping (e.g. learned from a connection)
+func (c *resolveCache) putPeerID(did string, pid peer.ID) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.pids[did]; !ok && len(c.pids) >= c.cfg.Size {
+		evict(c.pids, now, func(e cachedPeerID) time.Time { return e.expires })
+	}
+	c.pids[did] = cachedPeerID{pid: pid, expires: now.Add(c.cfg.TTL.Std())}
+}
+
+// getAddrs returns the cached addrs of a peer, if any and not expired
+func (c *resolveCache) getAddrs(pid peer.ID) ([]ma.Multiaddr, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.addrs[pid]
+	if !ok || !time.Now().Before(e.expires) {
+		return nil, false
+	}
+	return e.addrs, true
+}
+
+// putAddrs caches the addrs found for a peer
+func (c *resolveCache) putAddrs(pid peer.ID, addrs []ma.Multiaddr) {
+	if len(addrs) == 0 {
+		return
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.addrs[pid]; !ok && len(c.addrs) >= c.cfg.Size {
+		evict(c.addrs, now, func(e cachedAddrs) time.Time { return e.expires })
+	}
+	c.addrs[pid] = cachedAddrs{addrs: addrs, expires: now.Add(c.cfg.TTL.Std())}
+}
+
+// dialResult records a dial to the cached addrs; repeated failures invalidate the entry
+func (c *resolveCache) dialResult(pid peer.ID, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.addrs[pid]
+	if !ok {
+		return
+	}
+	if err == nil {
+		e.failures = 0
+		c.addrs[pid] = e
+		return
+	}
+	e.failures++
+	if e.failures >= c.cfg.MaxDialFailures {
+		delete(c.addrs, pid)
+		return
+	}
+	c.addrs[pid] = e
+}
+
+// evict removes expired entries, or the one closest to expiry when none has expired
+func evict[K comparable, V any](m map[K]V, now time.Time, expires func(V) time.Time) {
+	var oldest K
+	var oldestAt time.Time
+	expired := false
+	for k, v := range m {
+		at := expires(v)
+		if !now.Before(at) {
+			delete(m, k)
+			expired = true
+			continue
+		}
+		if oldestAt.IsZero() || at.Before(oldestAt) {
+			oldest, oldestAt = k, at
+		}
+	}
+	if !expired && !oldestAt.IsZero() {
+		delete(m, oldest)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+)
+
+// ResourceLimitsConfig bounds what the libp2p resource manager lets peers consume
+// (connections, streams, memory, FDs per system/peer/protocol/service).
+// The default limits are scaled from MaxMemoryMB and MaxFDs instead of the machine's
+// total memory; LimitsFile overrides individual limits on top of that.
+type ResourceLimitsConfig struct {
+	// MaxMemoryMB is the memory the libp2p stack may use; limits scale with it
+	MaxMemoryMB int `yaml:"maxMemoryMB" json:"maxMemoryMB"`
+	// MaxFDs is the file descriptors the libp2p stack may use
+	MaxFDs int `yaml:"maxFDs" json:"maxFDs"`
+	// LimitsFile is an optional rcmgr limits JSON file (PartialLimitConfig format, e.g.
+	// {"PeerDefault": {"StreamsInbound": 64}}); unset fields keep the scaled defaults
+	LimitsFile string `yaml:"limitsFile" json:"limitsFile"`
+}
+
+// DefaultResourceLimitsConfig suits a node sharing the machine with its backend
+func DefaultResourceLimitsConfig() ResourceLimitsConfig {
+	return ResourceLimitsConfig{MaxMemoryMB: 256, MaxFDs: 512}
+}
+
+func (c ResourceLimitsConfig) Validate() error {
+	if c.MaxMemoryMB <= 0 {
+		return fmt.Errorf("invalid resourceLimits.maxMemoryMB: %d", c.MaxMemoryMB)
+	}
+	if c.MaxFDs <= 0 {
+		return fmt.Errorf("invalid resourceLimits.maxFDs: %d", c.MaxFDs)
+	}
+	if c.LimitsFile != "" {
+		if _, err := c.limiter(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c ResourceLimitsConfig) limiter() (rcmgr.Limiter, error) {
+	scaling := rcmgr.DefaultLimits
+	libp2p.SetDefaultServiceLimits(&scaling)
+	limits := scaling.Scale(int64(c.MaxMemoryMB)<<20, c.MaxFDs)
+	if c.LimitsFile == "" {
+		return rcmgr.NewFixedLimiter(limits), nil
+	}
+	f, err := os.Open(c.LimitsFile)
+	if err != nil {
+		return nil, fmt.Errorf("resourceLimits.limitsFile: %w", err)
+	}
+	defer f.Close()
+	limiter, err := rcmgr.NewLimiterFromJSON(f, limits)
+	if err != nil {
+		return nil, fmt.Errorf("resourceLimits.limitsFile %s: %w", c.LimitsFile, err)
+	}
+	return limiter, nil
+}
+
+// NewResourceManager builds the resource manager passed to libp2p.ResourceManager
+func (c ResourceLimitsConfig) NewResourceManager() (network.ResourceManager, error) {
+	limiter, err := c.limiter()
+	if err != nil {
+		return nil, err
+	}
+	return rcmgr.NewResourceManager(limiter)
+}
+
+func (c ResourceLimitsConfig) String() string {
+	file := c.LimitsFile
+	if file == "" {
+		file = "none"
+	}
+	return fmt.Sprintf("maxMemory=%dMB maxFDs=%d limitsFile=%s", c.MaxMemoryMB, c.MaxFDs, file)
+}
+
+// ResourceStat is the current usage of one resource scope
+type ResourceStat struct {
+	StreamsInbound  int   `json:"streamsInbound"`
+	StreamsOutbound int   `json:"streamsOutbound"`
+	ConnsInbound    int   `json:"connsInbound"`
+	ConnsOutbound   int   `json:"connsOutbound"`
+	FD              int   `json:"fd"`
+	Memory          int64 `json:"memory"`
+}
+
+func toResourceStat(st network.ScopeStat) ResourceStat {
+	return ResourceStat{
+		StreamsInbound:  st.NumStreamsInbound,
+		StreamsOutbound: st.NumStreamsOutbound,
+		ConnsInbound:    st.NumConnsInbound,
+		ConnsOutbound:   st.NumConnsOutbound,
+		FD:              st.NumFD,
+		Memory:          st.Memory,
+	}
+}
+
+// PeerResourceStat is the usage of one peer's scope
+type PeerResourceStat struct {
+	PeerID string `json:"peerId"`
+	ResourceStat
+}
+
+// ResourceUsage is a snapshot of the resource manager scopes
+type ResourceUsage struct {
+	Limits    string                  `json:"limits"`
+	System    ResourceStat            `json:"system"`
+	Transient ResourceStat            `json:"transient"`
+	Services  map[string]ResourceStat `json:"services"`
+	Protocols map[string]ResourceStat `json:"protocols"`
+	// Peers is sorted by open streams, busiest first
+	Peers []PeerResourceStat `json:"peers"`
+}
+
+// GetResourceUsage returns the current resource manager usage
+func (s *Libp2pNodeService) GetResourceUsage() (ResourceUsage, error) {
+	state, ok := s.node.Network().ResourceManager().(rcmgr.ResourceManagerState)
+	if !ok {
+		return ResourceUsage{}, fmt.Errorf("resource manager does not expose its state")
+	}
+	stat := state.Stat()
+	usage := ResourceUsage{
+		Limits:    s.config.ResourceLimits.String(),
+		System:    toResourceStat(stat.System),
+		Transient: toResourceStat(stat.Transient),
+		Services:  make(map[string]ResourceStat, len(stat.Services)),
+		Protocols: make(map[string]ResourceStat, len(stat.Protocols)),
+		Peers:     make([]PeerResourceStat, 0, len(stat.Peers)),
+	}
+	for svc, st := range stat.Services {
+		usage.Services[svc] = toResourceStat(st)
+	}
+	for proto, st := range stat.Protocols {
+		usage.Protocols[string(proto)] = toResourceStat(st)
+	}
+	for pid, st := range stat.Peers {
+		usage.Peers = append(usage.Peers, PeerResourceStat{PeerID: pid.String(), ResourceStat: toResourceStat(st)})
+	}
+	sort.Slice(usage.Peers, func(i, j int) bool {
+		a, b := usage.Peers[i], usage.Peers[j]
+		return a.StreamsInbound+a.StreamsOutbound > b.StreamsInbound+b.StreamsOutbound
+	})
+	return usage, nil
+}
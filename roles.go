@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// gatewayRoleProtocol is registered by gateways only, so identify tells peers their role:
+// a peer ID carries the key but not the role of a DID. No stream is ever opened on it.
+const gatewayRoleProtocol = protocol.ID("/sight/role/gateway/1.0.0")
+
+// announceRole advertises the gateway role over identify
+func (s *Libp2pNodeService) announceRole() {
+	if s.isGateway {
+		s.node.SetStreamHandler(gatewayRoleProtocol, func(stream network.Stream) { stream.Reset() })
+	}
+}
+
+// peerRole is RoleGateway when pid announced gatewayRoleProtocol over identify, RoleHoster otherwise
+// (including peers not identified yet)
+func (s *Libp2pNodeService) peerRole(pid peer.ID) string {
+	if protos, err := s.node.Peerstore().SupportsProtocols(pid, gatewayRoleProtocol); err == nil && len(protos) > 0 {
+		return RoleGateway
+	}
+	return RoleHoster
+}
+
+// peerDID is the DID of pid with the role it announced over identify.
+// Hashed (non-identity) peer IDs return an error, as with PeerIdToDID.
+func (s *Libp2pNodeService) peerDID(pid peer.ID) (string, error) {
+	raw, err := DecodePublicKeyFromPeerId(pid.String())
+	if err != nil {
+		return "", err
+	}
+	return ToSightDID(s.peerRole(pid), raw), nil
+}
+
+// senderDID is the DID a received message is attributed to: the envelope "from" when it
+// carries pid's key (only pid can sign or send as pid, so it states its own role), else peerDID
+func (s *Libp2pNodeService) senderDID(pid peer.ID, from interface{}) string {
+	if claimed, ok := from.(string); ok && didMatchesPeer(claimed, pid) {
+		return claimed
+	}
+	did, _ := s.peerDID(pid)
+	return did
+}
+
+// watchPeerRoles fixes the registry entry of a gateway once identify completes: connNotifiee
+// records a peer on connect, before its role is known. The subscription is made before
+// returning so no identify after InitNode is missed.
+func (s *Libp2pNodeService) watchPeerRoles(ctx context.Context) {
+	sub, err := s.node.EventBus().Subscribe(new(event.EvtPeerIdentificationCompleted))
+	if err != nil {
+		log.Printf("Failed to subscribe to identify events: %v", err)
+		return
+	}
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				pid := e.(event.EvtPeerIdentificationCompleted).Peer
+				did, err := s.peerDID(pid)
+				if err != nil {
+					continue
+				}
+				if hosterDID, _ := PeerIdToDID(pid.String()); hosterDID != did {
+					s.registry.rename(hosterDID, did)
+					s.resolve.putPeerID(did, pid)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPeerDIDResolvesRoleFromIdentify(t *testing.T) {
+	backendG, backendH := newTestBackend(t), newTestBackend(t)
+	gateway := newTestService(t, testConfig(t, backendG.URL, func(c *Config) { c.IsGateway = true }))
+	hoster := newTestService(t, testConfig(t, backendH.URL))
+	connectServices(t, hoster, gateway)
+
+	gatewayDID := ToSightDID(RoleGateway, gateway.keypair.PublicKey)
+	if gateway.did != gatewayDID {
+		t.Fatalf("gateway DID = %s, want %s", gateway.did, gatewayDID)
+	}
+	waitFor(t, 10*time.Second, func() bool {
+		did, _ := hoster.peerDID(gateway.node.ID())
+		return did == gatewayDID
+	}, "gateway role over identify")
+	if did, err := gateway.peerDID(hoster.node.ID()); err != nil || did != hoster.did {
+		t.Errorf("gateway.peerDID(hoster) = %q, %v, want %s", did, err, hoster.did)
+	}
+
+	// the registry entry recorded on connect is moved to the gateway DID
+	waitFor(t, 10*time.Second, func() bool {
+		for _, e := range hoster.GetRegistry() {
+			if e.PeerID == gateway.node.ID().String() {
+				return e.DID == gatewayDID
+			}
+		}
+		return false
+	}, "gateway registry entry")
+	if n := len(hoster.GetRegistry()); n != 1 {
+		t.Errorf("registry has %d entries, want 1: %+v", n, hoster.GetRegistry())
+	}
+	if st := hoster.peerConnState(gateway.node.ID()); st.DID != gatewayDID {
+		t.Errorf("neighbor DID = %s, want %s", st.DID, gatewayDID)
+	}
+
+	// X-Sight-From carries each sender's own DID form
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := gateway.SendDirectMessage(ctx, hoster.did, []byte(`{"payload":{"n":1}}`)); err != nil {
+		t.Fatalf("gateway -> hoster: %v", err)
+	}
+	if got := backendH.waitRequests(t, 1)[0].Header.Get("X-Sight-From"); got != gatewayDID {
+		t.Errorf("X-Sight-From from the gateway = %s, want %s", got, gatewayDID)
+	}
+	if _, err := hoster.SendDirectMessage(ctx, gateway.did, []byte(`{"payload":{"n":2}}`)); err != nil {
+		t.Fatalf("hoster -> gateway: %v", err)
+	}
+	if got := backendG.waitRequests(t, 1)[0].Header.Get("X-Sight-From"); got != hoster.did {
+		t.Errorf("X-Sight-From from the hoster = %s, want %s", got, hoster.did)
+	}
+}
+
+func TestSenderDIDTrustsOnlyOwnKey(t *testing.T) {
+	s := newTestService(t, testConfig(t, "http://127.0.0.1:1"))
+	pid, hosterDID := testPeer(t)
+	_, otherDID := testPeer(t)
+	gatewayDID := ToSightDID(RoleGateway, mustPeerKey(t, pid))
+
+	for _, tc := range []struct {
+		name string
+		from interface{}
+		want string
+	}{
+		{"no from", nil, hosterDID},
+		{"own gateway DID", gatewayDID, gatewayDID},
+		{"own hoster DID", hosterDID, hosterDID},
+		{"another key", otherDID, hosterDID},
+		{"not a DID", "gateway", hosterDID},
+	} {
+		if got := s.senderDID(pid, tc.from); got != tc.want {
+			t.Errorf("%s: senderDID = %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// transportSelfTest marks the test message --selftest posts to the tunnel (X-Sight-Transport)
+const transportSelfTest = "selftest"
+
+// selfTestResult is one line of the --selftest report
+type selfTestResult struct {
+	Name     string
+	Status   string // PASS, FAIL or SKIP
+	Detail   string
+	Duration time.Duration
+}
+
+// RunSelfTest checks a freshly initialized node: bootstrap connectivity, a DHT self-lookup,
+// a ping and a tunnel round trip, each bounded by the configured timeouts. It writes a
+// report to out and returns false if any check failed.
+func (s *Libp2pNodeService) RunSelfTest(out io.Writer) bool {
+	checks := []struct {
+		name string
+		run  func(ctx context.Context) (status, detail string)
+	}{
+		{"bootstrap", s.selfTestBootstrap},
+		{"dht", s.selfTestDHT},
+		{"ping", s.selfTestPing},
+		{"tunnel", s.selfTestTunnel},
+	}
+	source := "defaults + env"
+	if path := s.config.Path(); path != "" {
+		source = path
+	}
+	results := []selfTestResult{{Name: "config", Status: "PASS", Detail: source}}
+	for _, c := range checks {
+		ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeouts.Connect.Std())
+		start := time.Now()
+		status, detail := c.run(ctx)
+		cancel()
+		results = append(results, selfTestResult{Name: c.name, Status: status, Detail: detail, Duration: time.Since(start)})
+	}
+
+	ok := true
+	fmt.Fprintf(out, "Self-test of %s (%s)\n", s.did, s.node.ID())
+	for _, r := range results {
+		if r.Status == "FAIL" {
+			ok = false
+		}
+		fmt.Fprintf(out, "  [%s] %-9s %-8s %s\n", r.Status, r.Name, r.Duration.Round(time.Millisecond), r.Detail)
+	}
+	if ok {
+		fmt.Fprintln(out, "Self-test passed")
+	} else {
+		fmt.Fprintln(out, "Self-test FAILED")
+	}
+	return ok
+}
+
+// selfTestBootstrap dials every configured bootstrap peer
+func (s *Libp2pNodeService) selfTestBootstrap(ctx context.Context) (string, string) {
+	addrs := s.GetBootstrap()
+	if len(addrs) == 0 {
+		return "SKIP", "no bootstrap peers configured"
+	}
+	reached := 0
+	var failed []string
+	for _, addr := range addrs {
+		info, err := peer.AddrInfoFromString(addr)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", addr, err))
+			continue
+		}
+		dialCtx, cancel := context.WithTimeout(ctx, s.config.BootstrapDial.Timeout.Std())
+		err = s.node.Connect(dialCtx, *info)
+		cancel()
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", info.ID, err))
+			continue
+		}
+		reached++
+	}
+	detail := fmt.Sprintf("%d/%d reachable", reached, len(addrs))
+	if len(failed) > 0 {
+		detail += fmt.Sprintf("; unreachable: %v", failed)
+	}
+	if reached == 0 {
+		return "FAIL", detail
+	}
+	return "PASS", detail
+}
+
+// selfTestDHT refreshes the routing table, which starts with a lookup of our own key
+func (s *Libp2pNodeService) selfTestDHT(ctx context.Context) (string, string) {
+	// 刚连上的 peer 要等 identify 完成才进路由表
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for s.dht.RoutingTable().Size() == 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return "FAIL", "routing table is empty"
+		}
+	}
+	select {
+	case err := <-s.dht.RefreshRoutingTable():
+		if err != nil {
+			return "FAIL", fmt.Sprintf("refresh: %v", err)
+		}
+	case <-ctx.Done():
+		return "FAIL", fmt.Sprintf("refresh: %v", ctx.Err())
+	}
+	return "PASS", fmt.Sprintf("%d peers in routing table", s.dht.RoutingTable().Size())
+}
+
+// selfTestPing pings the first connected peer that answers
+func (s *Libp2pNodeService) selfTestPing(ctx context.Context) (string, string) {
+	peers := s.node.Network().Peers()
+	if len(peers) == 0 {
+		return "FAIL", "no connected peers"
+	}
+	var lastErr error
+	for _, pid := range peers {
+		if s.node.Network().Connectedness(pid) != network.Connected {
+			continue
+		}
+		rtt, err := pingOnce(ctx, s.node, pid)
+		if err == nil {
+			return "PASS", fmt.Sprintf("%s rtt=%s", pid, rtt.Round(time.Microsecond))
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return "FAIL", fmt.Sprintf("no peer answered: %v", lastErr)
+}
+
+// selfTestTunnel posts a test message to the tunnel and expects a 2xx
+func (s *Libp2pNodeService) selfTestTunnel(ctx context.Context) (string, string) {
+	body, _ := json.Marshal(map[string]interface{}{"selftest": true, "from": s.did})
+	resp, err := s.getTunnel().Forward(ctx, TunnelMeta{
+		From:       s.node.ID(),
+		MessageID:  "selftest-" + newMessageID(),
+		Transport:  transportSelfTest,
+		ReceivedAt: time.Now(),
+	}, body)
+	switch {
+	case errors.Is(err, errTunnelSkipped):
+		return "SKIP", "tunnel disabled, dry run or observer mode"
+	case err != nil:
+		return "FAIL", fmt.Sprintf("%s: %v", s.config.TunnelTarget(), err)
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return "FAIL", fmt.Sprintf("%s answered %d", s.config.TunnelTarget(), resp.StatusCode)
+	}
+	return "PASS", fmt.Sprintf("%s answered %d", s.config.TunnelTarget(), resp.StatusCode)
+}
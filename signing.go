@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// Sign signs data with this node's device key (ed25519 over the raw bytes)
+func (s *Libp2pNodeService) Sign(data []byte) []byte {
+	return ed25519.Sign(ed25519.PrivateKey(s.keypair.PrivateKey), data)
+}
+
+// Verify checks an ed25519 signature over data against the public key of a did:sight DID.
+// Malformed DIDs wrap ErrInvalidTarget.
+func (s *Libp2pNodeService) Verify(did string, data, signature []byte) (bool, error) {
+	pub := []byte(s.keypair.PublicKey)
+	if did != s.did {
+		var err error
+		pub, err = DIDToPublicKey(did)
+		if err != nil {
+			return false, fmt.Errorf("%w %q: %v", ErrInvalidTarget, did, err)
+		}
+	}
+	return ed25519.Verify(ed25519.PublicKey(pub), data, signature), nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// NodeInfo identifies this node on the network
+type NodeInfo struct {
+	DID       string   `json:"did"`
+	PeerID    string   `json:"peerId"`
+	Addrs     []string `json:"addrs"`
+	IsGateway bool     `json:"isGateway"`
+	Observer  bool     `json:"observer"`
+}
+
+// DHTStatus summarizes the DHT state
+type DHTStatus struct {
+	RoutingTableSize int                `json:"routingTableSize"`
+	Bootstrapped     bool               `json:"bootstrapped"`
+	Bootstrap        DHTBootstrapStatus `json:"bootstrap"`
+}
+
+// TunnelStatus shows where received messages are forwarded: per-topic URLs from
+// tunnel.topics, everything else to Default
+type TunnelStatus struct {
+	Method   string            `json:"method"`
+	Default  string            `json:"default"`
+	Topics   map[string]string `json:"topics"`
+	Disabled bool              `json:"disabled,omitempty"`
+	DryRun   bool              `json:"dryRun,omitempty"`
+}
+
+// NodeStatus aggregates everything an operator needs in one response
+type NodeStatus struct {
+	Node          NodeInfo           `json:"node"`
+	StartedAt     time.Time          `json:"startedAt"`
+	UptimeSeconds int64              `json:"uptimeSeconds"`
+	NeighborCount int                `json:"neighborCount"`
+	DHT           DHTStatus          `json:"dht"`
+	TopicPeers    map[string]int     `json:"topicPeers"`
+	Tunnel        TunnelStatus       `json:"tunnel"`
+	ForwardQueue  ForwardQueueStatus `json:"forwardQueue"`
+}
+
+// GetNodeInfo returns this node's DID, peer ID and listen addresses
+func (s *Libp2pNodeService) GetNodeInfo() NodeInfo {
+	var addrs []string
+	for _, addr := range s.node.Addrs() {
+		addrs = append(addrs, addr.String())
+	}
+	return NodeInfo{
+		DID:       s.did,
+		PeerID:    s.node.ID().String(),
+		Addrs:     addrs,
+		IsGateway: s.isGateway,
+		Observer:  s.observer != nil,
+	}
+}
+
+// GetListenAddrs returns our dialable addresses as <addr>/p2p/<peerId>, the form other nodes
+// pass to ConnectByDIDOrMultiAddr. Loopback addresses are left out unless includeLoopback.
+func (s *Libp2pNodeService) GetListenAddrs(includeLoopback bool) []string {
+	info := peer.AddrInfo{ID: s.node.ID()}
+	for _, addr := range s.node.Addrs() {
+		if !includeLoopback && manet.IsIPLoopback(addr) {
+			continue
+		}
+		info.Addrs = append(info.Addrs, addr)
+	}
+	// 没有地址时 AddrInfoToP2pAddrs 会返回单独的 /p2p/<id>，那不是可拨号地址
+	if len(info.Addrs) == 0 {
+		return []string{}
+	}
+	p2pAddrs, err := peer.AddrInfoToP2pAddrs(&info)
+	if err != nil {
+		return []string{}
+	}
+	addrs := make([]string, 0, len(p2pAddrs))
+	for _, addr := range p2pAddrs {
+		addrs = append(addrs, addr.String())
+	}
+	return addrs
+}
+
+// GetDHTStatus returns the routing table size and whether bootstrap completed
+func (s *Libp2pNodeService) GetDHTStatus() DHTStatus {
+	return DHTStatus{
+		RoutingTableSize: s.dht.RoutingTable().Size(),
+		Bootstrapped:     s.dhtBootstrapped.Load(),
+		Bootstrap:        s.dhtBootstrap.get(),
+	}
+}
+
+// GetTopicPeerCounts returns the number of known peers per joined topic
+func (s *Libp2pNodeService) GetTopicPeerCounts() map[string]int {
+	counts := make(map[string]int)
+	for _, name := range s.pubsub.GetTopics() {
+		counts[name] = len(s.pubsub.ListPeers(name))
+	}
+	return counts
+}
+
+// GetStatus composes the node status from the individual accessors
+func (s *Libp2pNodeService) GetStatus() NodeStatus {
+	return NodeStatus{
+		Node:          s.GetNodeInfo(),
+		StartedAt:     s.StartedAt(),
+		UptimeSeconds: int64(s.Uptime().Seconds()),
+		NeighborCount: len(s.GetNeighbors()),
+		DHT:           s.GetDHTStatus(),
+		TopicPeers:    s.GetTopicPeerCounts(),
+		Tunnel:        s.GetTunnelStatus(),
+		ForwardQueue:  s.GetForwardQueueStatus(),
+	}
+}
+
+// GetTunnelStatus returns the configured tunnel routing
+func (s *Libp2pNodeService) GetTunnelStatus() TunnelStatus {
+	topics := make(map[string]string, len(s.config.Tunnel.Topics))
+	for topic, u := range s.config.Tunnel.Topics {
+		topics[topic] = u
+	}
+	return TunnelStatus{
+		Method:   s.config.Tunnel.Method,
+		Default:  s.config.TunnelTarget(),
+		Topics:   topics,
+		Disabled: s.config.Tunnel.Disabled,
+		DryRun:   s.config.Tunnel.DryRun,
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// stickyTag protects sticky connections in the connection manager (separate from the
+	// protect endpoint's tag, so releasing one doesn't unprotect the other)
+	stickyTag = "sight-sticky"
+	// stickyCheckInterval is how often sticky peers are checked besides disconnect events
+	stickyCheckInterval = 10 * time.Second
+	// stickyMaxBackoff caps the delay between reconnect attempts to a sticky peer
+	stickyMaxBackoff = time.Minute
+)
+
+// ErrNotSticky is returned when releasing a peer that has no sticky connection
+var ErrNotSticky = errors.New("no sticky connection")
+
+// StickyPeer is a peer kept connected by connect?keepalive=true
+type StickyPeer struct {
+	// Target is the DID, multiaddr or peer ID it was connected with, used to reconnect
+	Target     string    `json:"target"`
+	PeerID     string    `json:"peerId"`
+	Connected  bool      `json:"connected"`
+	Since      time.Time `json:"since"`
+	Reconnects int       `json:"reconnects"`
+	// Failures counts reconnect attempts failed in a row (reset on success)
+	Failures    int        `json:"failures"`
+	LastError   string     `json:"lastError,omitempty"`
+	NextAttempt *time.Time `json:"nextAttempt,omitempty"`
+}
+
+type stickyPeers struct {
+	mu    sync.Mutex
+	peers map[peer.ID]*StickyPeer
+	wake  chan struct{} // a sticky peer disconnected
+	done  chan struct{} // closed when the reconnect loop exits
+}
+
+func newStickyPeers() *stickyPeers {
+	return &stickyPeers{peers: make(map[peer.ID]*StickyPeer), wake: make(chan struct{}, 1)}
+}
+
+// KeepAlive makes the connection to target (already connected by the caller) sticky: it is
+// protected from pruning and reconnected with backoff whenever it drops, until released
+func (s *Libp2pNodeService) KeepAlive(target string) (StickyPeer, error) {
+	info, err := s.resolveTarget(target)
+	if err != nil {
+		return StickyPeer{}, err
+	}
+	s.node.ConnManager().Protect(info.ID, stickyTag)
+	s.sticky.mu.Lock()
+	defer s.sticky.mu.Unlock()
+	sp, ok := s.sticky.peers[info.ID]
+	if !ok {
+		sp = &StickyPeer{PeerID: info.ID.String(), Since: time.Now()}
+		s.sticky.peers[info.ID] = sp
+		log.Printf("[Sticky] Keeping %s connected", info.ID)
+	}
+	sp.Target = target
+	entry := *sp
+	entry.Connected = s.node.Network().Connectedness(info.ID) == network.Connected
+	return entry, nil
+}
+
+// ReleaseSticky stops keeping target connected and lifts its protection; the connection
+// itself stays open until the connection manager prunes it
+func (s *Libp2pNodeService) ReleaseSticky(target string) error {
+	info, err := s.resolveTarget(target)
+	if err != nil {
+		return err
+	}
+	s.sticky.mu.Lock()
+	_, ok := s.sticky.peers[info.ID]
+	delete(s.sticky.peers, info.ID)
+	s.sticky.mu.Unlock()
+	if !ok {
+		return ErrNotSticky
+	}
+	s.node.ConnManager().Unprotect(info.ID, stickyTag)
+	log.Printf("[Sticky] Released %s", info.ID)
+	return nil
+}
+
+// GetStickyPeers returns the sticky peers sorted by peer ID
+func (s *Libp2pNodeService) GetStickyPeers() []StickyPeer {
+	s.sticky.mu.Lock()
+	out := make([]StickyPeer, 0, len(s.sticky.peers))
+	for pid, sp := range s.sticky.peers {
+		entry := *sp
+		entry.Connected = s.node.Network().Connectedness(pid) == network.Connected
+		out = append(out, entry)
+	}
+	s.sticky.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].PeerID < out[j].PeerID })
+	return out
+}
+
+// stickyNotifiee wakes the reconnect loop when a sticky peer's last connection closes
+func (s *Libp2pNodeService) stickyNotifiee() network.Notifiee {
+	return &network.NotifyBundle{
+		DisconnectedF: func(n network.Network, c network.Conn) {
+			pid := c.RemotePeer()
+			if n.Connectedness(pid) == network.Connected {
+				return
+			}
+			s.sticky.mu.Lock()
+			_, ok := s.sticky.peers[pid]
+			s.sticky.mu.Unlock()
+			if ok {
+				select {
+				case s.sticky.wake <- struct{}{}:
+				default:
+				}
+			}
+		},
+	}
+}
+
+// runStickyPeers reconnects dropped sticky peers until ctx is cancelled
+func (s *Libp2pNodeService) runStickyPeers(ctx context.Context) {
+	defer close(s.sticky.done)
+	ticker := time.NewTicker(stickyCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.sticky.wake:
+		}
+		s.reconnectSticky(ctx)
+	}
+}
+
+// reconnectSticky redials every disconnected sticky peer whose backoff has elapsed
+func (s *Libp2pNodeService) reconnectSticky(ctx context.Context) {
+	now := time.Now()
+	due := map[peer.ID]string{}
+	s.sticky.mu.Lock()
+	for pid, sp := range s.sticky.peers {
+		if s.node.Network().Connectedness(pid) != network.Connected && (sp.NextAttempt == nil || !now.Before(*sp.NextAttempt)) {
+			due[pid] = sp.Target
+		}
+	}
+	s.sticky.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for pid, target := range due {
+		wg.Add(1)
+		go func(pid peer.ID, target string) {
+			defer wg.Done()
+			err := s.ConnectByDIDOrMultiAddr(ctx, target)
+			s.sticky.mu.Lock()
+			defer s.sticky.mu.Unlock()
+			sp, ok := s.sticky.peers[pid]
+			if !ok {
+				return // released meanwhile
+			}
+			if err != nil {
+				sp.Failures++
+				sp.LastError = err.Error()
+				next := time.Now().Add(min(time.Second<<min(sp.Failures, 6), stickyMaxBackoff))
+				sp.NextAttempt = &next
+				log.Printf("[Sticky] Reconnect to %s failed (%d in a row): %v", pid, sp.Failures, err)
+				return
+			}
+			sp.Reconnects++
+			sp.Failures = 0
+			sp.LastError = ""
+			sp.NextAttempt = nil
+			log.Printf("[Sticky] Reconnected to %s", pid)
+		}(pid, target)
+	}
+	wg.Wait()
+}
+
+// startStickyPeers starts the reconnect loop; Stop waits for it via stopStickyPeers
+func (s *Libp2pNodeService) startStickyPeers(ctx context.Context) {
+	s.sticky.done = make(chan struct{})
+	go s.runStickyPeers(ctx)
+}
+
+// stopStickyPeers waits for the reconnect loop to exit (ctx must already be cancelled) and
+// releases every sticky peer
+func (s *Libp2pNodeService) stopStickyPeers() {
+	if s.sticky.done != nil {
+		<-s.sticky.done
+	}
+	s.sticky.mu.Lock()
+	defer s.sticky.mu.Unlock()
+	for pid := range s.sticky.peers {
+		s.node.ConnManager().Unprotect(pid, stickyTag)
+		delete(s.sticky.peers, pid)
+	}
+}
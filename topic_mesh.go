@@ -0,0 +1,116 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// TopicPeer is a peer subscribed to a topic
+type TopicPeer struct {
+	PeerID        string `json:"peerId"`
+	DID           string `json:"did,omitempty"` // only when the peer ID embeds the public key
+	Connectedness string `json:"connectedness"`
+	InMesh        bool   `json:"inMesh"`
+}
+
+// TopicPeers separates peers we gossip full messages with (mesh) from peers that are
+// merely subscribed and only get IHAVE gossip
+type TopicPeers struct {
+	Topic      string      `json:"topic"`
+	Subscribed int         `json:"subscribed"`
+	Mesh       int         `json:"mesh"`
+	Peers      []TopicPeer `json:"peers"`
+}
+
+// meshTracker follows GRAFT/PRUNE events to know the gossipsub mesh per topic,
+// which pubsub doesn't expose directly. Only Graft/Prune/RemovePeer matter here.
+type meshTracker struct {
+	mu   sync.Mutex
+	mesh map[string]map[peer.ID]struct{} // topic -> mesh peers
+}
+
+var _ pubsub.RawTracer = (*meshTracker)(nil)
+
+func newMeshTracker() *meshTracker {
+	return &meshTracker{mesh: make(map[string]map[peer.ID]struct{})}
+}
+
+func (t *meshTracker) Graft(p peer.ID, topic string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.mesh[topic] == nil {
+		t.mesh[topic] = make(map[peer.ID]struct{})
+	}
+	t.mesh[topic][p] = struct{}{}
+}
+
+func (t *meshTracker) Prune(p peer.ID, topic string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.mesh[topic], p)
+}
+
+func (t *meshTracker) RemovePeer(p peer.ID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, peers := range t.mesh {
+		delete(peers, p)
+	}
+}
+
+func (t *meshTracker) Leave(topic string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.mesh, topic)
+}
+
+func (t *meshTracker) inMesh(topic string, p peer.ID) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.mesh[topic][p]
+	return ok
+}
+
+func (t *meshTracker) AddPeer(peer.ID, protocol.ID)          {}
+func (t *meshTracker) Join(string)                           {}
+func (t *meshTracker) ValidateMessage(*pubsub.Message)       {}
+func (t *meshTracker) DeliverMessage(*pubsub.Message)        {}
+func (t *meshTracker) RejectMessage(*pubsub.Message, string) {}
+func (t *meshTracker) DuplicateMessage(*pubsub.Message)      {}
+func (t *meshTracker) ThrottlePeer(peer.ID)                  {}
+func (t *meshTracker) RecvRPC(*pubsub.RPC)                   {}
+func (t *meshTracker) SendRPC(*pubsub.RPC, peer.ID)          {}
+func (t *meshTracker) DropRPC(*pubsub.RPC, peer.ID)          {}
+func (t *meshTracker) UndeliverableMessage(*pubsub.Message)  {}
+
+// GetTopicPeers lists the peers subscribed to a topic with their DID, connectedness
+// and whether they are in our gossip mesh for it
+func (s *Libp2pNodeService) GetTopicPeers(topic string) TopicPeers {
+	out := TopicPeers{Topic: topic, Peers: []TopicPeer{}}
+	for _, pid := range s.pubsub.ListPeers(topic) {
+		tp := TopicPeer{
+			PeerID:        pid.String(),
+			Connectedness: s.node.Network().Connectedness(pid).String(),
+			InMesh:        s.mesh.inMesh(topic, pid),
+		}
+		if did, err := s.peerDID(pid); err == nil {
+			tp.DID = did
+		}
+		if tp.InMesh {
+			out.Mesh++
+		}
+		out.Peers = append(out.Peers, tp)
+	}
+	out.Subscribed = len(out.Peers)
+	sort.Slice(out.Peers, func(i, j int) bool {
+		if out.Peers[i].InMesh != out.Peers[j].InMesh {
+			return out.Peers[i].InMesh
+		}
+		return out.Peers[i].PeerID < out.Peers[j].PeerID
+	})
+	return out
+}
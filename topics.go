@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+// joinedTopics tracks the topics joined on the current pubsub instance. pubsub.Join fails
+// for a topic that is already joined, so joins go through join, which hands back the
+// existing handle instead.
+type joinedTopics struct {
+	mu     sync.Mutex
+	ps     *pubsub.PubSub
+	topics map[string]*pubsub.Topic
+}
+
+// reset switches to a new pubsub instance (InitNode) with the topics it already joined;
+// handles of the previous instance are forgotten
+func (j *joinedTopics) reset(ps *pubsub.PubSub, joined map[string]*pubsub.Topic) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.ps = ps
+	j.topics = make(map[string]*pubsub.Topic, len(joined))
+	for name, t := range joined {
+		j.topics[name] = t
+	}
+}
+
+// join returns the handle of topic name, joining it on first use
+func (j *joinedTopics) join(name string) (t *pubsub.Topic, joined bool, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if t, ok := j.topics[name]; ok {
+		return t, false, nil
+	}
+	if j.ps == nil {
+		return nil, false, errors.New("pubsub not started")
+	}
+	t, err = j.ps.Join(name)
+	if err != nil {
+		return nil, false, fmt.Errorf("join topic %s: %w", name, err)
+	}
+	if j.topics == nil {
+		j.topics = make(map[string]*pubsub.Topic)
+	}
+	j.topics[name] = t
+	return t, true, nil
+}
+
+// joinTopic returns the handle of topic name, joining it (without subscribing) on first
+// use; joining the same topic again returns the same handle
+func (s *Libp2pNodeService) joinTopic(name string) (*pubsub.Topic, error) {
+	if name == "" {
+		return nil, ErrInvalidTopic
+	}
+	t, joined, err := s.topics.join(name)
+	if joined {
+		log.Printf("[Topic] Joined %s", name)
+	}
+	return t, err
+}
+
+// subscribeTunnelTopics subscribes to every topic of tunnel.topics besides config.Topic, each
+// with its own handleIncomingMessages loop, so their messages reach the topic's tunnel
+func (s *Libp2pNodeService) subscribeTunnelTopics(ctx context.Context) {
+	for name := range s.config.Tunnel.Topics {
+		if name == s.config.Topic {
+			continue
+		}
+		t, err := s.joinTopic(name)
+		if err != nil {
+			log.Printf("[Topic] Failed to join tunnel topic %s: %v", name, err)
+			continue
+		}
+		sub, err := t.Subscribe()
+		if err != nil {
+			log.Printf("[Topic] Failed to subscribe to tunnel topic %s: %v", name, err)
+			continue
+		}
+		log.Printf("[Topic] Subscribed to %s for its tunnel", name)
+		go s.handleIncomingMessages(ctx, sub)
+	}
+}
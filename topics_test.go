@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+func TestJoinTopicTwice(t *testing.T) {
+	s := newTestService(t, testConfig(t, "http://127.0.0.1:1"))
+
+	// the node's own topic was joined by InitNode: joining again hands back that handle
+	own, err := s.joinTopic(s.config.Topic)
+	if err != nil {
+		t.Fatalf("join %s again: %v", s.config.Topic, err)
+	}
+	if own != s.getTopic() {
+		t.Error("joining the node topic returned a new handle")
+	}
+
+	first, err := s.joinTopic("other")
+	if err != nil {
+		t.Fatalf("join other: %v", err)
+	}
+	second, err := s.joinTopic("other")
+	if err != nil {
+		t.Fatalf("join other twice: %v", err)
+	}
+	if first != second {
+		t.Error("second join of other returned a new handle")
+	}
+	// what the tracking avoids: pubsub itself refuses a second join
+	if _, err := s.pubsub.Join("other"); err == nil {
+		t.Error("pubsub.Join accepted a joined topic; joinTopic is no longer needed")
+	}
+
+	if _, err := s.joinTopic(""); !errors.Is(err, ErrInvalidTopic) {
+		t.Errorf("join empty topic: %v, want ErrInvalidTopic", err)
+	}
+}
+
+func TestJoinTopicConcurrent(t *testing.T) {
+	s := newTestService(t, testConfig(t, "http://127.0.0.1:1"))
+
+	const joins = 16
+	handles := make([]*pubsub.Topic, joins)
+	var wg sync.WaitGroup
+	for i := range handles {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			topic, err := s.joinTopic("concurrent")
+			if err != nil {
+				t.Errorf("join %d: %v", i, err)
+			}
+			handles[i] = topic
+		}()
+	}
+	wg.Wait()
+	for i, h := range handles {
+		if h != handles[0] {
+			t.Errorf("join %d returned a different handle", i)
+		}
+	}
+}
+
+func TestJoinedTopicsWithoutPubsub(t *testing.T) {
+	var j joinedTopics
+	if _, _, err := j.join("sight-message"); err == nil {
+		t.Error("join before pubsub started succeeded")
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the node's spans
+const tracerName = "sight-libp2p-node"
+
+// traceContextField is the envelope field carrying the W3C trace context
+// ({"traceparent": ..., "tracestate": ...}) from the sender to the receiving node
+const traceContextField = "traceContext"
+
+// tracer goes through the global provider, so it stays a no-op unless initTracing installed an exporter
+var tracer = otel.Tracer(tracerName)
+
+// tracePropagator reads and writes the trace context of envelopes and tunnel requests
+var tracePropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// initTracing installs an OTLP/HTTP trace exporter configured by the standard OTEL_* env vars
+// (OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT, _HEADERS, OTEL_SERVICE_NAME, ...).
+// Without an endpoint, or with OTEL_SDK_DISABLED=true / OTEL_TRACES_EXPORTER=none, tracing stays a
+// no-op. The returned function flushes and stops the exporter.
+func initTracing(ctx context.Context) func(context.Context) {
+	noop := func(context.Context) {}
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") || os.Getenv("OTEL_TRACES_EXPORTER") == "none" {
+		return noop
+	}
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return noop
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		log.Printf("[Tracing] Failed to create OTLP exporter, tracing disabled: %v", err)
+		return noop
+	}
+	// OTEL_SERVICE_NAME / OTEL_RESOURCE_ATTRIBUTES override these defaults
+	res, err := resource.Merge(
+		resource.NewSchemaless(
+			semconv.ServiceName(tracerName),
+			semconv.ServiceVersion(version),
+		),
+		resource.Environment(),
+	)
+	if err != nil {
+		log.Printf("[Tracing] Invalid resource attributes: %v", err)
+		res = resource.Default()
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(tracePropagator)
+	log.Printf("[Tracing] Exporting spans over OTLP/HTTP")
+	return func(ctx context.Context) {
+		if err := provider.Shutdown(ctx); err != nil {
+			log.Printf("[Tracing] Failed to flush spans: %v", err)
+		}
+	}
+}
+
+// injectTraceContext stores the span context of ctx in the envelope; nothing is added when
+// ctx carries no recording span (tracing disabled)
+func injectTraceContext(ctx context.Context, msg map[string]interface{}) {
+	carrier := propagation.MapCarrier{}
+	tracePropagator.Inject(ctx, carrier)
+	if len(carrier) > 0 {
+		msg[traceContextField] = map[string]string(carrier)
+	}
+}
+
+// extractTraceContext returns ctx continuing the sender's trace from the envelope, if it has one
+func extractTraceContext(ctx context.Context, msg map[string]interface{}) context.Context {
+	raw, ok := msg[traceContextField].(map[string]interface{})
+	if !ok {
+		return ctx
+	}
+	carrier := propagation.MapCarrier{}
+	for k, v := range raw {
+		if s, ok := v.(string); ok {
+			carrier[k] = s
+		}
+	}
+	return tracePropagator.Extract(ctx, carrier)
+}
+
+// startReceiveSpan starts the span of a message received from a peer, continuing the
+// sender's trace from the envelope
+func startReceiveSpan(msg map[string]interface{}, meta TunnelMeta) (context.Context, trace.Span) {
+	ctx := extractTraceContext(context.Background(), msg)
+	return tracer.Start(ctx, "receive",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("sight.message_id", meta.MessageID),
+			attribute.String("sight.transport", meta.Transport),
+			attribute.String("sight.from_peer", meta.From.String()),
+		))
+}
+
+// endSpan records err (if any) on span and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
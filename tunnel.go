@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// TunnelMeta describes a received message; HTTP tunnels send it as X-Sight-* headers
+type TunnelMeta struct {
+	From        peer.ID
+	FromDID     string // sender DID with its announced role, see senderDID; empty when not derivable
+	MessageID   string
+	Transport   string // "pubsub" or "direct"
+	Topic       string // only set for pubsub
+	To          string // envelope recipient, only set for pubsub
+	Protocol    string // negotiated direct protocol version, only set for direct
+	ContentType string // sender-declared type (raw direct messages, envelope "contentType"); empty means JSON
+	ReceivedAt  time.Time
+}
+
+// contentType is the Content-Type the body is forwarded with
+func (m TunnelMeta) contentType() string {
+	if m.ContentType == "" {
+		return "application/json"
+	}
+	return m.ContentType
+}
+
+// isJSONMediaType reports whether contentType is application/json or a +json type
+func isJSONMediaType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// envelopeBody returns the tunnel body of a received {to, payload} envelope and the content
+// type declared in its optional "contentType" field (empty means JSON; invalid ones are
+// ignored). With a declared non-JSON type a string payload is forwarded as is for text/*
+// types and base64-decoded for binary ones (as is if it isn't base64); anything else is
+// forwarded as JSON.
+func envelopeBody(envelope map[string]interface{}) ([]byte, string, error) {
+	contentType, _ := envelope["contentType"].(string)
+	if contentType != "" {
+		if err := checkContentType(contentType); err != nil {
+			log.Printf("Ignoring envelope content type: %v", err)
+			contentType = ""
+		}
+	}
+	if text, ok := envelope["payload"].(string); ok && contentType != "" && !isJSONMediaType(contentType) {
+		if !strings.HasPrefix(contentType, "text/") {
+			if data, err := base64.StdEncoding.DecodeString(text); err == nil {
+				return data, contentType, nil
+			}
+		}
+		return []byte(text), contentType, nil
+	}
+	body, err := json.Marshal(envelope["payload"])
+	return body, contentType, err
+}
+
+const (
+	transportPubSub = "pubsub"
+	transportDirect = "direct"
+)
+
+// TunnelResponse is what the local backend answered
+type TunnelResponse struct {
+	StatusCode int
+	Body       []byte
+}
+
+// TunnelForwarder delivers received messages to the local backend
+type TunnelForwarder interface {
+	Forward(ctx context.Context, meta TunnelMeta, body []byte) (*TunnelResponse, error)
+}
+
+// TunnelFunc forwards in-process, for embedding the node in a Go program
+type TunnelFunc func(ctx context.Context, meta TunnelMeta, body []byte) (*TunnelResponse, error)
+
+func (f TunnelFunc) Forward(ctx context.Context, meta TunnelMeta, body []byte) (*TunnelResponse, error) {
+	return f(ctx, meta, body)
+}
+
+// errTunnelSkipped is returned by discardTunnel; callers treat it as "handled, nothing to ack"
+var errTunnelSkipped = errors.New("tunnel disabled")
+
+// discardTunnel is used when the tunnel is disabled (relay-only nodes) or in dry-run mode:
+// messages are counted and logged but never forwarded. dryRunTarget is set in dry-run mode.
+type discardTunnel struct {
+	dryRunTarget string
+}
+
+func (t discardTunnel) Forward(ctx context.Context, meta TunnelMeta, body []byte) (*TunnelResponse, error) {
+	if t.dryRunTarget == "" {
+		tunnelSkipped.WithLabelValues("disabled").Inc()
+		log.Printf("[Tunnel disabled] Dropped %s message %s from %s (%d bytes)", meta.Transport, meta.MessageID, meta.From, len(body))
+		return nil, errTunnelSkipped
+	}
+	tunnelSkipped.WithLabelValues("dry_run").Inc()
+	if meta.ContentType != "" {
+		// 原始二进制内容不打印
+		log.Printf("[Tunnel dry run] Would POST to %s: from=%s messageId=%s transport=%s protocol=%s contentType=%s (%d bytes)",
+			t.dryRunTarget, meta.From, meta.MessageID, meta.Transport, meta.Protocol, meta.ContentType, len(body))
+		return nil, errTunnelSkipped
+	}
+	log.Printf("[Tunnel dry run] Would POST to %s: from=%s messageId=%s transport=%s topic=%s protocol=%s body=%s",
+		t.dryRunTarget, meta.From, meta.MessageID, meta.Transport, meta.Topic, meta.Protocol, body)
+	return nil, errTunnelSkipped
+}
+
+// topicTunnel sends pubsub messages of a mapped topic to that topic's tunnel and
+// everything else (other topics, direct messages) to fallback
+type topicTunnel struct {
+	routes   map[string]TunnelForwarder
+	fallback TunnelForwarder
+}
+
+func (t *topicTunnel) Forward(ctx context.Context, meta TunnelMeta, body []byte) (*TunnelResponse, error) {
+	if f, ok := t.routes[meta.Topic]; ok && meta.Transport == transportPubSub {
+		return f.Forward(ctx, meta, body)
+	}
+	return t.fallback.Forward(ctx, meta, body)
+}
+
+// maxTunnelResponseBody limits how much of the backend response is kept
+const maxTunnelResponseBody = 1 << 20
+
+// HTTPTunnel POSTs messages to a backend URL (the default tunnel)
+type HTTPTunnel struct {
+	URL    string
+	Client *http.Client
+	// Method defaults to POST
+	Method string
+}
+
+// NewHTTPTunnel forwards to url over TCP
+func NewHTTPTunnel(url string) *HTTPTunnel {
+	return &HTTPTunnel{URL: url, Client: http.DefaultClient}
+}
+
+// NewUnixTunnel forwards over HTTP to a backend listening on a Unix socket
+func NewUnixTunnel(socket, path string) *HTTPTunnel {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	// host 部分不会被用到，连接总是走 socket
+	return &HTTPTunnel{URL: "http://unix" + path, Client: client}
+}
+
+// WithMethod sets the HTTP method used to forward (e.g. PUT) and returns t
+func (t *HTTPTunnel) WithMethod(method string) *HTTPTunnel {
+	t.Method = method
+	return t
+}
+
+// Forward POSTs body along with its metadata.
+// X-Sight-From carries the sender DID (with the role the sender announced) when derivable from
+// its peer ID, X-Sight-From-Peer the peer ID.
+// X-Sight-Content-Type repeats the sender-declared content type, if any, so backends can tell
+// it from the JSON default. With tracing on, traceparent continues the message's trace.
+func (t *HTTPTunnel) Forward(ctx context.Context, meta TunnelMeta, body []byte) (*TunnelResponse, error) {
+	method := t.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequestWithContext(ctx, method, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", meta.contentType())
+	if meta.ContentType != "" {
+		req.Header.Set("X-Sight-Content-Type", meta.ContentType)
+	}
+	req.Header.Set("X-Sight-From-Peer", meta.From.String())
+	if meta.FromDID != "" {
+		req.Header.Set("X-Sight-From", meta.FromDID)
+	}
+	req.Header.Set("X-Sight-Message-Id", meta.MessageID)
+	req.Header.Set("X-Sight-Transport", meta.Transport)
+	if meta.Topic != "" {
+		req.Header.Set("X-Sight-Topic", meta.Topic)
+	}
+	if meta.Protocol != "" {
+		req.Header.Set("X-Sight-Protocol", meta.Protocol)
+	}
+	req.Header.Set("X-Sight-Timestamp", meta.ReceivedAt.UTC().Format(time.RFC3339Nano))
+	tracePropagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxTunnelResponseBody))
+	if err != nil {
+		return nil, err
+	}
+	return &TunnelResponse{StatusCode: resp.StatusCode, Body: respBody}, nil
+}
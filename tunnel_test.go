@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestForwardToTunnelTimesOut(t *testing.T) {
+	backend := newTestBackend(t)
+	backend.delay = 5 * time.Second
+	cfg := testConfig(t, backend.URL, func(c *Config) { c.Timeouts.Tunnel = Duration(100 * time.Millisecond) })
+	s := newTestService(t, cfg)
+	from, _ := testPeer(t)
+
+	start := time.Now()
+	_, err := s.forwardToTunnel(context.Background(), []byte(`{}`), TunnelMeta{From: from, MessageID: "m1", Transport: transportDirect, ReceivedAt: start})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("forward to a hung backend: %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("forward took %s, want about the 100ms tunnel timeout", elapsed)
+	}
+}
+
+func TestTunnelTimeoutFromEnv(t *testing.T) {
+	t.Setenv("TUNNEL_TIMEOUT", "7s")
+	cfg := DefaultConfig()
+	cfg.applyEnv()
+	if got := cfg.Timeouts.Tunnel.Std(); got != 7*time.Second {
+		t.Errorf("Timeouts.Tunnel = %s, want 7s", got)
+	}
+}
+
+func TestTunnelHeadersForPubsubAndDirect(t *testing.T) {
+	backendB := newTestBackend(t)
+	a := newTestService(t, testConfig(t, newTestBackend(t).URL))
+	b := newTestService(t, testConfig(t, backendB.URL))
+	connectServices(t, a, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
+	msgID, err := a.HandleOutgoingMessage(ctx, map[string]interface{}{"to": b.did, "payload": map[string]string{"via": "pubsub"}})
+	if err != nil {
+		t.Fatalf("HandleOutgoingMessage: %v", err)
+	}
+	backendB.waitRequests(t, 1)
+	proto, err := a.SendDirectMessage(ctx, b.did, []byte(`{"messageId":"direct-1","payload":{"via":"direct"}}`))
+	if err != nil {
+		t.Fatalf("SendDirectMessage: %v", err)
+	}
+	reqs := backendB.waitRequests(t, 2)
+
+	for i, want := range []map[string]string{
+		{"X-Sight-Message-Id": msgID, "X-Sight-Transport": transportPubSub, "X-Sight-Topic": a.config.Topic},
+		{"X-Sight-Message-Id": "direct-1", "X-Sight-Transport": transportDirect, "X-Sight-Topic": "", "X-Sight-Protocol": string(proto)},
+	} {
+		h := reqs[i].Header
+		want["X-Sight-From"] = a.did
+		want["X-Sight-From-Peer"] = a.node.ID().String()
+		for name, value := range want {
+			if got := h.Get(name); got != value {
+				t.Errorf("%s request: %s = %q, want %q", want["X-Sight-Transport"], name, got, value)
+			}
+		}
+		ts, err := time.Parse(time.RFC3339Nano, h.Get("X-Sight-Timestamp"))
+		if err != nil || ts.Before(start.Add(-time.Second)) || ts.After(time.Now()) {
+			t.Errorf("%s request: X-Sight-Timestamp = %q, want the receive time", want["X-Sight-Transport"], h.Get("X-Sight-Timestamp"))
+		}
+	}
+}
+
+func TestTunnelTopicRoutesForwardTheirTopic(t *testing.T) {
+	const extra = "sight-extra"
+	backendB, extraB := newTestBackend(t), newTestBackend(t)
+	a := newTestService(t, testConfig(t, newTestBackend(t).URL))
+	b := newTestService(t, testConfig(t, backendB.URL, func(c *Config) {
+		c.Tunnel.Topics = map[string]string{extra: extraB.URL + "/extra"}
+	}))
+	connectServices(t, a, b)
+	waitFor(t, 10*time.Second, func() bool { return slices.Contains(a.pubsub.ListPeers(extra), b.node.ID()) }, "subscription to the tunnel topic")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := a.PublishRaw(ctx, extra, []byte(`{"to":"`+b.did+`","messageId":"extra-1","payload":{"via":"extra"}}`)); err != nil {
+		t.Fatalf("PublishRaw: %v", err)
+	}
+	reqs := extraB.waitRequests(t, 1)
+	if reqs[0].Path != "/extra" || reqs[0].Header.Get("X-Sight-Topic") != extra || reqs[0].Header.Get("X-Sight-Message-Id") != "extra-1" {
+		t.Errorf("topic tunnel got %s %v", reqs[0].Path, reqs[0].Header)
+	}
+	if n := len(backendB.requests()); n != 0 {
+		t.Errorf("default tunnel got %d requests, want 0", n)
+	}
+}
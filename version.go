@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set at build time, e.g.
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// BuildInfo identifies the running build
+type BuildInfo struct {
+	Version       string `json:"version"`
+	Commit        string `json:"commit"`
+	BuildDate     string `json:"buildDate"`
+	GoVersion     string `json:"goVersion"`
+	Libp2pVersion string `json:"libp2pVersion"`
+	// AgentVersion is what identify announces to peers (agentVersion / AGENT_VERSION)
+	AgentVersion string `json:"agentVersion,omitempty"`
+}
+
+// GetBuildInfo returns the -ldflags values, falling back to the VCS info Go embeds
+// (go build from a git checkout) when they weren't set
+func GetBuildInfo() BuildInfo {
+	info := BuildInfo{
+		Version:       version,
+		Commit:        commit,
+		BuildDate:     buildDate,
+		GoVersion:     runtime.Version(),
+		Libp2pVersion: "unknown",
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range bi.Deps {
+			if dep.Path == "github.com/libp2p/go-libp2p" {
+				info.Libp2pVersion = dep.Version
+			}
+		}
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = s.Value
+			case s.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = s.Value
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildDate == "" {
+		info.BuildDate = "unknown"
+	}
+	return info
+}
+
+func (b BuildInfo) String() string {
+	return fmt.Sprintf("sight-libp2p-node %s (commit %s, built %s, %s, go-libp2p %s)",
+		b.Version, b.Commit, b.BuildDate, b.GoVersion, b.Libp2pVersion)
+}
+
+// defaultAgentVersion is the identify agent version unless agentVersion is configured
+func defaultAgentVersion() string {
+	return "sight-libp2p-node/" + GetBuildInfo().Version
+}
+
+// VersionHandler handles the /libp2p/version endpoint
+func (c *Libp2pNodeController) VersionHandler(w http.ResponseWriter, r *http.Request) {
+	info := GetBuildInfo()
+	info.AgentVersion = c.service.config.AgentVersion
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// webrtcOnly is a test config mutator for a node that listens on webrtc-direct only
+func webrtcOnly(c *Config) {
+	c.Transports = nil
+	c.EnableWebRTC = true
+}
+
+func TestWebRTCOnlyDirectSendAndPing(t *testing.T) {
+	backendB := newTestBackend(t)
+	a := newTestService(t, testConfig(t, newTestBackend(t).URL, webrtcOnly))
+	b := newTestService(t, testConfig(t, backendB.URL, webrtcOnly))
+
+	for _, addr := range b.node.Addrs() {
+		if !strings.Contains(addr.String(), "/webrtc-direct/certhash/") {
+			t.Fatalf("WebRTC-only node advertises %s", addr)
+		}
+	}
+	if len(b.node.Addrs()) == 0 {
+		t.Fatal("WebRTC-only node has no listen address")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	if err := a.node.Connect(ctx, peer.AddrInfo{ID: b.node.ID(), Addrs: b.node.Addrs()}); err != nil {
+		t.Fatalf("connect over WebRTC: %v", err)
+	}
+	for _, conn := range a.node.Network().ConnsToPeer(b.node.ID()) {
+		if !strings.Contains(conn.RemoteMultiaddr().String(), "/webrtc-direct") {
+			t.Errorf("connection over %s, want webrtc-direct", conn.RemoteMultiaddr())
+		}
+	}
+
+	if _, err := a.SendDirectMessage(ctx, b.did, []byte(`{"payload":{"via":"webrtc"}}`)); err != nil {
+		t.Fatalf("SendDirectMessage over WebRTC: %v", err)
+	}
+	reqs := backendB.waitRequests(t, 1)
+	if !bytes.Contains(reqs[0].Body, []byte("webrtc")) {
+		t.Errorf("forwarded body = %s", reqs[0].Body)
+	}
+
+	if _, err := a.PingPeer(ctx, b.did); err != nil {
+		t.Fatalf("PingPeer over WebRTC: %v", err)
+	}
+}